@@ -13,11 +13,18 @@ import (
 )
 
 func TestIO(t *testing.T) {
+	defer func(engine runtime.Engine) { runtime.DefaultEngine = engine }(runtime.DefaultEngine)
+	for _, engine := range []runtime.Engine{runtime.EngineVM, runtime.EngineTree} {
+		runtime.DefaultEngine = engine
+		testExamples(t)
+	}
+}
+
+func testExamples(t *testing.T) {
 	filepath.Walk("./examples", func(path string, info os.FileInfo, err error) error {
 		if info.IsDir() || err != nil {
 			return err
 		}
-		println(path)
 		var actual strings.Builder
 		scope := runtime.DefaultNamespace(&actual)
 		tbl, _ := runtime.ToValue(scope, []runtime.Value{})
@@ -25,7 +32,7 @@ func TestIO(t *testing.T) {
 		_, err = runtime.EvalFile(scope, path)
 		assert.Nil(t, err)
 		expected, _ := ioutil.ReadFile(strings.Replace(path, "examples", "outputs", -1))
-		assert.Equal(t, string(expected), actual.String(), fmt.Sprintf("mismatch in output of %v", path))
+		assert.Equal(t, string(expected), actual.String(), fmt.Sprintf("mismatch in output of %v with the %v engine", path, runtime.DefaultEngine))
 		return nil
 	})
 }
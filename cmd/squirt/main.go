@@ -1,31 +1,73 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 
+	"github.com/tanema/squirt/src/check"
+	"github.com/tanema/squirt/src/cover"
+	"github.com/tanema/squirt/src/dap"
+	"github.com/tanema/squirt/src/debug"
 	"github.com/tanema/squirt/src/excerpt"
 	"github.com/tanema/squirt/src/lang"
+	"github.com/tanema/squirt/src/lsp"
+	"github.com/tanema/squirt/src/mod"
+	"github.com/tanema/squirt/src/profile"
 	"github.com/tanema/squirt/src/runtime"
 	"github.com/tanema/squirt/src/stdlib"
 )
 
 var astPtr = flag.Bool("ast", false, "a bool")
 var expPtr = flag.Bool("excerpt", false, "a bool")
+var treePtr = flag.Bool("tree", false, "run with the tree walking evaluator instead of the vm")
+var loadPathPtr = flag.String("loadpath", "", "directories to search for required files, separated like PATH")
 
 func main() {
 	flag.Parse()
 	args := flag.Args()
-	scope := runtime.DefaultNamespace(nil)
-	runtime.RegisterLib("os", stdlib.OSLib)
-	if len(args) > 0 {
+	engine := runtime.EngineVM
+	if *treePtr {
+		engine = runtime.EngineTree
+	}
+	interp := newInterpreter(runtime.Options{Engine: engine})
+	scope := interp.Scope()
+	if len(args) > 0 && args[0] == "fmt" {
+		format(args[1:])
+	} else if len(args) > 0 && args[0] == "check" {
+		typecheck(args[1:])
+	} else if len(args) > 0 && (args[0] == "get" || args[0] == "vendor") {
+		vendor(args[0], args[1:])
+	} else if len(args) > 0 && args[0] == "test" {
+		test(args[1:])
+	} else if len(args) > 1 && args[0] == "run" {
+		run(args[1:])
+	} else if len(args) > 1 && args[0] == "debug" {
+		debugFile(args[1], args[2:]...)
+	} else if len(args) > 0 && args[0] == "dap" {
+		err := dap.Serve(os.Stdin, os.Stdout, func(interp *runtime.Interpreter) {
+			registerLibs(interp)
+			setStrings(interp.Scope(), "LOAD_PATHS", loadPaths())
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if len(args) > 0 && args[0] == "lsp" {
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(args) > 0 {
 		if *astPtr {
 			ast(args[0])
 		} else if *expPtr {
@@ -41,14 +83,58 @@ func main() {
 func ast(path string) {
 	log.SetFlags(0)
 	block, err := lang.ParseFile(path)
-	if err != nil {
+	if _, partial := err.(lang.ParseErrors); err != nil && !partial {
 		log.Fatal(err)
 	}
-	data, err := json.Marshal(block)
+	data, jsonErr := json.Marshal(block)
+	if jsonErr != nil {
+		log.Fatal(jsonErr)
+	}
+	fmt.Println(string(data))
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println(string(data))
+}
+
+func format(args []string) {
+	log.SetFlags(0)
+	fmtFlags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fmtFlags.Bool("w", false, "write the result to the source file instead of stdout")
+	fmtFlags.Parse(args)
+	failed := false
+	for _, path := range fmtFlags.Args() {
+		out, err := lang.FormatFile(path)
+		if err != nil {
+			log.Println(err)
+			failed = true
+			continue
+		}
+		if !*write {
+			os.Stdout.Write(out)
+		} else if src, err := ioutil.ReadFile(path); err == nil && bytes.Equal(src, out) {
+			continue
+		} else if err := ioutil.WriteFile(path, out, 0644); err != nil {
+			log.Println(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func typecheck(paths []string) {
+	log.SetFlags(0)
+	failed := false
+	for _, path := range paths {
+		if err := check.File(path); err != nil {
+			log.Println(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
 func exp(path string) {
@@ -60,17 +146,230 @@ func exp(path string) {
 }
 
 func runFile(e *runtime.Scope, path string, argv ...string) {
-	targv := make([]runtime.Value, len(argv))
-	for i, arg := range argv {
-		targv[i], _ = runtime.ToValue(e, arg)
-	}
-	argvTable, _ := runtime.ToValue(e, targv)
-	e.Set("ARGV", argvTable)
-	if _, err := runtime.EvalFile(e, os.Args[1]); err != nil {
+	setArgv(e, argv)
+	if _, err := runtime.EvalFile(e, path); err != nil {
 		fmt.Println(runtime.Print(e, err))
 	}
 }
 
+func run(args []string) {
+	log.SetFlags(0)
+	runFlags := flag.NewFlagSet("run", flag.ExitOnError)
+	tree := runFlags.Bool("tree", false, "run with the tree walking evaluator instead of the vm")
+	profPath := runFlags.String("profile", "", "write a pprof profile to this file and print a summary")
+	tracePath := runFlags.String("trace", "", "write a chrome trace of every call to this file")
+	covered := runFlags.Bool("cover", false, "print the coverage of every file that ran")
+	lcovPath := runFlags.String("coverprofile", "", "write coverage to this file in the LCOV format")
+	htmlPath := runFlags.String("coverhtml", "", "write coverage to this file as an annotated HTML page")
+	runFlags.Parse(args)
+	if runFlags.NArg() == 0 {
+		log.Fatal("run needs a file to run")
+	}
+	opts := runtime.Options{Engine: runtime.EngineVM}
+	if *tree {
+		opts.Engine = runtime.EngineTree
+	}
+	if *profPath != "" || *tracePath != "" {
+		opts.Profile = runtime.NewProfile(*tracePath != "")
+	}
+	if *covered || *lcovPath != "" || *htmlPath != "" {
+		opts.Coverage = runtime.NewCoverage()
+	}
+	interp := newInterpreter(opts)
+	runFile(interp.Scope(), runFlags.Arg(0), runFlags.Args()[1:]...)
+	if opts.Profile != nil {
+		opts.Profile.Stop()
+		if *profPath != "" {
+			writeReport(*profPath, func(w io.Writer) error { return profile.WritePprof(w, opts.Profile) })
+			profile.WriteText(os.Stderr, opts.Profile)
+		}
+		if *tracePath != "" {
+			writeReport(*tracePath, func(w io.Writer) error { return profile.WriteTrace(w, opts.Profile) })
+		}
+	}
+	if opts.Coverage != nil {
+		reportCoverage(opts.Coverage, *lcovPath, *htmlPath)
+	}
+}
+
+func test(args []string) {
+	log.SetFlags(0)
+	testFlags := flag.NewFlagSet("test", flag.ExitOnError)
+	tree := testFlags.Bool("tree", false, "run with the tree walking evaluator instead of the vm")
+	pattern := testFlags.String("run", "", "only run tests with a full name that matches this regexp")
+	verbose := testFlags.Bool("v", false, "list every test that passes as well")
+	covered := testFlags.Bool("cover", false, "print the coverage of every file that ran")
+	lcovPath := testFlags.String("coverprofile", "", "write coverage to this file in the LCOV format")
+	htmlPath := testFlags.String("coverhtml", "", "write coverage to this file as an annotated HTML page")
+	testFlags.Parse(args)
+	var filter *regexp.Regexp
+	if *pattern != "" {
+		var err error
+		if filter, err = regexp.Compile(*pattern); err != nil {
+			log.Fatal(err)
+		}
+	}
+	dirs := testFlags.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	paths := []string{}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() && info.Name() == mod.ModulesDir {
+				return filepath.SkipDir
+			} else if err == nil && !info.IsDir() && strings.HasSuffix(path, "_test.sqrt") {
+				paths = append(paths, path)
+			}
+			return err
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	opts := runtime.Options{Engine: runtime.EngineVM}
+	if *tree {
+		opts.Engine = runtime.EngineTree
+	}
+	if *covered || *lcovPath != "" || *htmlPath != "" {
+		opts.Coverage = runtime.NewCoverage()
+	}
+	failed := false
+	for _, path := range paths {
+		tests := stdlib.NewTests(os.Stdout, filter)
+		tests.Verbose = *verbose
+		interp := newInterpreter(opts)
+		interp.RegisterLib("test", tests.Lib)
+		start := time.Now()
+		if _, err := runtime.EvalFile(interp.Scope(), path); err != nil {
+			tests.Error(path, err)
+		}
+		status := "ok  "
+		if tests.Failed > 0 {
+			status, failed = "FAIL", true
+		}
+		fmt.Printf("%v\t%v\t%v passed, %v failed, %v skipped (%v)\n", status, path,
+			tests.Passed, tests.Failed, tests.Skipped, time.Since(start).Round(time.Millisecond))
+	}
+	if len(paths) == 0 {
+		fmt.Println("no test files")
+	}
+	if opts.Coverage != nil {
+		reportCoverage(opts.Coverage, *lcovPath, *htmlPath)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// vendor copies the dependencies in squirt.mod into squirt_modules. get adds
+// or updates a dependency first, creating squirt.mod if there is none.
+func vendor(cmd string, args []string) {
+	log.SetFlags(0)
+	root, ok := mod.Root(".")
+	if !ok && cmd == "vendor" {
+		log.Fatalf("no %v found", mod.ManifestFile)
+	} else if !ok {
+		root, _ = filepath.Abs(".")
+	}
+	var manifest *mod.Manifest
+	if ok {
+		var err error
+		if manifest, err = mod.ReadManifest(root); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		manifest = &mod.Manifest{Dir: root, Name: filepath.Base(root)}
+	}
+	if cmd == "get" {
+		if len(args) != 2 && len(args) != 3 {
+			log.Fatal("usage: squirt get name path | squirt get name url commit")
+		}
+		dep := mod.Dependency{Name: args[0], Source: args[1]}
+		if len(args) == 3 {
+			dep.Commit = args[2]
+		}
+		manifest.Require(dep)
+		if err := manifest.Write(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := mod.Vendor(manifest); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func reportCoverage(cov *runtime.Coverage, lcovPath, htmlPath string) {
+	cover.WriteSummary(os.Stderr, cov)
+	if lcovPath != "" {
+		writeReport(lcovPath, func(w io.Writer) error { return cover.WriteLCOV(w, cov) })
+	}
+	if htmlPath != "" {
+		writeReport(htmlPath, func(w io.Writer) error { return cover.WriteHTML(w, cov) })
+	}
+}
+
+func writeReport(path string, write func(io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func debugFile(path string, argv ...string) {
+	rl, err := readline.New("(debug) ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rl.Close()
+	d := debug.New(rl, os.Stdout)
+	interp := newInterpreter(runtime.Options{Hook: d.Hook})
+	scope := interp.Scope()
+	setArgv(scope, argv)
+	if err := d.Run(scope, path); err != nil {
+		fmt.Println(runtime.Print(scope, err))
+	}
+}
+
+// newInterpreter creates an interpreter with the stdlib that searches the
+// -loadpath directories and then SQUIRT_LOAD_PATHS for required files.
+func newInterpreter(opts runtime.Options) *runtime.Interpreter {
+	opts.LoadPaths = loadPaths()
+	interp := runtime.NewInterpreter(opts)
+	registerLibs(interp)
+	return interp
+}
+
+// registerLibs registers the stdlib along with the capabilities each lib needs
+// so that sandboxed interpreters can only require what they were granted.
+func registerLibs(interp *runtime.Interpreter) {
+	interp.RegisterLib("os", stdlib.OSLib, "env")
+	interp.RegisterLib("json", stdlib.JSONLib)
+	interp.RegisterLib("io", stdlib.IOLib, "fs")
+	interp.RegisterLib("file", stdlib.FileLib, "fs")
+}
+
+func loadPaths() []string {
+	return append(filepath.SplitList(*loadPathPtr), filepath.SplitList(os.Getenv("SQUIRT_LOAD_PATHS"))...)
+}
+
+func setArgv(e *runtime.Scope, argv []string) {
+	setStrings(e, "ARGV", argv)
+}
+
+func setStrings(e *runtime.Scope, name string, strs []string) {
+	vals := make([]runtime.Value, len(strs))
+	for i, str := range strs {
+		vals[i], _ = runtime.ToValue(e, str)
+	}
+	tbl, _ := runtime.ToValue(e, vals)
+	e.Set(name, tbl)
+}
+
 func runREPL(scope *runtime.Scope) error {
 	readline.SetHistoryPath(filepath.Join(os.Getenv("HOME"), ".squirt-history"))
 	rl, err := readline.New("> ")
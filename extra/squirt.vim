@@ -10,7 +10,7 @@ syntax sync fromstart
 " Clusters
 syntax cluster squirtBase contains=squirtComment,squirtCommentLong,squirtConstant,squirtNumber,squirtString,squirtStringLong,squirtBuiltIn
 syntax cluster squirtExpr contains=@squirtBase,squirtTable,squirtParen,squirtBracket,squirtSpecialValue,squirtOperator,squirtSymbolOperator,squirtEllipsis,squirtComma,squirtFunc,squirtFuncCall,squirtError
-syntax cluster squirtStat contains=@squirtExpr,squirtIfThen,squirtBlock,squirtLoop,squirtClass,squirtStatement,squirtSemiCol,squirtErrHand
+syntax cluster squirtStat contains=@squirtExpr,squirtIfThen,squirtBlock,squirtLoop,squirtMatch,squirtClass,squirtStatement,squirtSemiCol,squirtErrHand
 syntax match squirtNoise /\%(\.\|,\|:\|\;\)/
 
 " Symbols
@@ -61,6 +61,14 @@ syntax region squirtLoop transparent matchgroup=squirtRepeat start="\<while\>" e
 syntax region squirtLoop transparent matchgroup=squirtRepeat start="\<for\>" end="\<do\>"me=e-2 contains=@squirtExpr,squirtIn nextgroup=squirtLoopBlock skipwhite skipempty
 syntax keyword squirtIn contained in
 
+" match
+syntax region squirtMatch transparent matchgroup=squirtCond start="\<match\>" end="\<do\>"me=e-2 contains=@squirtExpr nextgroup=squirtMatchBlock skipwhite skipempty
+syntax region squirtMatchBlock contained transparent matchgroup=squirtCond start="\<do\>" end="\<end\>" contains=@squirtStat,squirtCase fold
+syntax keyword squirtCase contained case
+
+" select
+syntax region squirtSelect transparent matchgroup=squirtCond start="\<select\>" end="\<end\>" contains=@squirtStat,squirtCase fold
+
 " class
 syntax region squirtClass transparent matchgroup=squirtRepeat start="\<class\>" end="\<do\>"me=e-2 contains=@squirtStat,squirtIsa nextgroup=squirtBlock skipwhite skipempty
 syntax keyword squirtIsa contained isa
@@ -69,7 +77,7 @@ syntax keyword squirtIsa contained isa
 syntax keyword squirtConstant nil true false
 syntax keyword squirtBuiltIn _ENV self attr
 syntax keyword squirtOperator and or not
-syntax keyword squirtStatement break return next
+syntax keyword squirtStatement break return next yield
 
 " Strings
 syntax match  squirtStringSpecial contained #\\[\\abfnrtvz'"]\|\\x[[:xdigit:]]\{2}\|\\[[:digit:]]\{,3}#
@@ -90,7 +98,7 @@ syntax match squirtFloat  "\<\d\+[eE][-+]\=\d\+\>"
 
 " Special names from the Standard Library
 syntax keyword squirtErrHand spill clean
-syntax keyword squirtSpecialValue require tostring tonumber typeof print new delete
+syntax keyword squirtSpecialValue require tostring tonumber typeof print new delete spawn
 
 hi def link squirtParens           Noise
 hi def link squirtBraces           Structure
@@ -101,6 +109,7 @@ hi def link squirtCommentLongTag   squirtCommentLong
 hi def link squirtCommentLong      squirtComment
 hi def link squirtCommentTodo      Todo
 hi def link squirtCond             Conditional
+hi def link squirtCase             Conditional
 hi def link squirtConstant         Constant
 hi def link squirtEllipsis         Special
 hi def link squirtElse             Conditional
@@ -0,0 +1,696 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanema/squirt/src/lang"
+	"github.com/tanema/squirt/src/runtime"
+)
+
+// anyType can be used as an annotation for values that can be anything.
+const anyType = "Any"
+
+// builtinClasses are the classes of the default namespace mapped to their
+// parent class.
+var builtinClasses = runtime.CoreClasses()
+
+// builtinFuncs are the funcs of the default namespace. They are not checked at
+// their call sites but what they return is known.
+var builtinFuncs = map[string]*funcType{
+	"new":      {name: "new", std: true},
+	"spill":    {name: "spill", std: true},
+	"eval":     {name: "eval", std: true},
+	"require":  {name: "require", std: true},
+	"print":    {name: "print", std: true, returns: "Nil"},
+	"typeof":   {name: "typeof", std: true, returns: "String"},
+	"delete":   {name: "delete", std: true, returns: "Nil"},
+	"tostring": {name: "tostring", std: true, returns: "String"},
+	"tonumber": {name: "tonumber", std: true, returns: "Number"},
+	"spawn":    {name: "spawn", std: true, returns: "Task"},
+}
+
+type (
+	// typ is what is known about a value. A value with an empty name could be
+	// anything so it is never reported.
+	typ struct {
+		name  string
+		fn    *funcType  // set when the value is a known func
+		class *classType // set when the value is a class itself
+	}
+
+	funcType struct {
+		name     string
+		params   []lang.Object
+		vararg   bool
+		std      bool
+		returns  string // the annotated return type
+		inferred string // the type every return statement agreed on
+		returned bool
+		yields   bool // calling it creates a Generator no matter what it returns
+	}
+
+	classType struct {
+		name    string
+		parent  string
+		attrs   map[string]string
+		methods map[string]*funcType
+	}
+
+	variable struct {
+		typ
+		declared string // the annotated type that assignments have to match
+	}
+
+	scope struct {
+		vars  map[string]*variable
+		outer *scope
+	}
+
+	checker struct {
+		file    bool
+		source  string
+		classes map[string]*classType
+		funcs   []*funcType // the funcs being checked, innermost last
+		errors  TypeErrors
+	}
+)
+
+// File checks the types of a source file without running it.
+func File(path string) error {
+	root, err := lang.ParseFile(path)
+	if err != nil {
+		return err
+	}
+	return check(root, true, path)
+}
+
+// Str checks the types of source code without running it.
+func Str(source string) error {
+	root, err := lang.ParseStr(source)
+	if err != nil {
+		return err
+	}
+	return check(root, false, source)
+}
+
+func check(root lang.Object, file bool, source string) error {
+	c := &checker{file: file, source: source, classes: map[string]*classType{}}
+	for name, parent := range builtinClasses {
+		c.classes[name] = &classType{name: name, parent: parent, attrs: map[string]string{}, methods: map[string]*funcType{}}
+	}
+	c.collectClasses(root.Block)
+	sc := &scope{vars: map[string]*variable{}}
+	c.block(sc, root.Block)
+	c.catches(sc, root.Catches)
+	if len(c.errors) > 0 {
+		return c.errors
+	}
+	return nil
+}
+
+func (c *checker) errorf(pos [4]int, msg string, data ...interface{}) {
+	c.errors = append(c.errors, TypeErr{
+		file:   c.file,
+		source: c.source,
+		msg:    fmt.Sprintf(msg, data...),
+		pos:    pos,
+	})
+}
+
+func (sc *scope) child() *scope {
+	return &scope{vars: map[string]*variable{}, outer: sc}
+}
+
+func (sc *scope) lookup(name string) *variable {
+	for s := sc; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// collectClasses finds every class in a program up front so that they can be
+// used as types before they are defined.
+func (c *checker) collectClasses(block []lang.Object) {
+	for _, obj := range block {
+		if obj.Kind == lang.Export {
+			obj = *obj.Value
+		}
+		if obj.Kind == lang.ClassDef {
+			cls := &classType{name: obj.Name, parent: obj.Parent, attrs: map[string]string{}, methods: map[string]*funcType{}}
+			for _, member := range obj.Block {
+				switch member.Kind {
+				case lang.AttrDef:
+					cls.attrs[member.Name] = attrType(member)
+				case lang.FuncDef:
+					fn := newFuncType(member)
+					fn.name = obj.Name + "." + fn.name
+					cls.methods[member.Value.Name] = fn
+				}
+			}
+			c.classes[obj.Name] = cls
+		}
+		c.collectClasses(obj.Block)
+	}
+}
+
+func newFuncType(obj lang.Object) *funcType {
+	fn := &funcType{name: "func", params: obj.Vars, returns: obj.Type, yields: obj.Generator}
+	if obj.Value != nil && obj.Value.Kind != "" {
+		fn.name = funcName(*obj.Value)
+	}
+	if len(obj.Vars) > 0 {
+		fn.vararg = strings.HasSuffix(obj.Vars[len(obj.Vars)-1].Name, "...")
+	}
+	return fn
+}
+
+func funcName(name lang.Object) string {
+	if name.Kind == lang.Member {
+		return funcName(name.Vals[0]) + "." + name.Vals[1].Name
+	}
+	return name.Name
+}
+
+// attrType finds the type of an attr from its annotation or from its type
+// refinement.
+func attrType(obj lang.Object) string {
+	if obj.Type != "" || obj.Cond == nil {
+		return obj.Type
+	}
+	for _, entry := range obj.Cond.Vals {
+		if entry.Kind != lang.TableKey || entry.Key.Kind != lang.Identifier || entry.Key.Name != "type" {
+			continue
+		}
+		switch entry.Value.Kind {
+		case lang.Identifier:
+			return entry.Value.Name
+		case lang.String:
+			return entry.Value.StringValue
+		}
+	}
+	return ""
+}
+
+// result is the type that calling a func gives back.
+func (fn *funcType) result() string {
+	if fn.yields {
+		return "Generator"
+	} else if fn.returns != "" {
+		return fn.returns
+	}
+	return fn.inferred
+}
+
+func (c *checker) knownType(name string, pos [4]int) {
+	if name == "" || name == anyType || name == "Func" || name == "Class" {
+		return
+	} else if _, ok := c.classes[name]; !ok {
+		c.errorf(pos, "undefined type %v", name)
+	}
+}
+
+// assignable checks if a value of the type have can be used where the type
+// want is expected. Undefined types are reported where they are used so they
+// accept anything here.
+func (c *checker) assignable(have, want string) bool {
+	if _, known := c.classes[want]; !known && want != "Func" && want != "Class" {
+		return true
+	} else if have == "" || have == anyType {
+		return true
+	}
+	for name := have; name != ""; {
+		if name == want {
+			return true
+		}
+		cls, ok := c.classes[name]
+		if !ok {
+			break
+		}
+		name = cls.parent
+	}
+	return false
+}
+
+func (c *checker) attr(cls *classType, name string) (string, bool) {
+	for cls != nil {
+		if t, ok := cls.attrs[name]; ok {
+			return t, true
+		}
+		cls = c.classes[cls.parent]
+	}
+	return "", false
+}
+
+func (c *checker) method(cls *classType, name string) *funcType {
+	for cls != nil {
+		if fn, ok := cls.methods[name]; ok {
+			return fn
+		}
+		cls = c.classes[cls.parent]
+	}
+	return nil
+}
+
+func (c *checker) block(sc *scope, block []lang.Object) {
+	for _, obj := range block {
+		if obj.Kind == lang.Export {
+			obj = *obj.Value
+		}
+		if obj.Kind == lang.FuncDef && obj.Value != nil && obj.Value.Kind == lang.Identifier {
+			sc.vars[obj.Value.Name] = &variable{typ: typ{name: "Func", fn: newFuncType(obj)}}
+		}
+	}
+	for _, obj := range block {
+		c.statement(sc, obj)
+	}
+}
+
+// body checks the block of a node in its own scope.
+func (c *checker) body(sc *scope, obj lang.Object) {
+	c.block(sc.child(), obj.Block)
+	c.catches(sc, obj.Catches)
+}
+
+func (c *checker) catches(sc *scope, catches []lang.Object) {
+	for _, catch := range catches {
+		inner := sc.child()
+		if catch.Name != "" {
+			t := typ{name: "Error"}
+			if len(catch.Vars) == 1 {
+				t.name = catch.Vars[0].Name
+			}
+			inner.vars[catch.Name] = &variable{typ: t}
+		}
+		c.block(inner, catch.Block)
+		c.catches(sc, catch.Catches)
+	}
+}
+
+func (c *checker) statement(sc *scope, obj lang.Object) {
+	switch obj.Kind {
+	case lang.Assignment:
+		c.assign(sc, obj)
+	case lang.FuncDef:
+		if obj.Value == nil || obj.Value.Kind != lang.Identifier {
+			c.expr(sc, obj)
+		} else if v, ok := sc.vars[obj.Value.Name]; ok && v.fn != nil {
+			c.funcDef(sc, obj, v.fn)
+		}
+	case lang.ClassDef:
+		c.classDef(sc, obj)
+	case lang.If:
+		for _, clause := range obj.Block {
+			if clause.Cond != nil {
+				c.expr(sc, *clause.Cond)
+			}
+			c.body(sc, clause)
+		}
+	case lang.While:
+		c.expr(sc, *obj.Cond)
+		c.body(sc, obj)
+	case lang.ForNum:
+		inner := sc.child()
+		inner.vars[obj.Name] = &variable{typ: c.expr(sc, *obj.Value)}
+		c.expr(inner, *obj.Cond)
+		c.statement(inner, *obj.Step)
+		c.body(inner, obj)
+	case lang.ForIn:
+		c.expr(sc, *obj.Value)
+		inner := sc.child()
+		for _, v := range obj.Vars {
+			inner.vars[v.Name] = &variable{}
+		}
+		c.body(inner, obj)
+	case lang.Do:
+		c.body(sc, obj)
+	case lang.Select:
+		for _, clause := range obj.Block {
+			inner := sc.child()
+			if clause.Value != nil {
+				c.expr(sc, *clause.Value)
+			}
+			if clause.Name != "" {
+				inner.vars[clause.Name] = &variable{}
+			}
+			c.block(inner, clause.Block)
+		}
+		c.catches(sc, obj.Catches)
+	case lang.Return:
+		c.returnStatement(sc, obj)
+	case lang.Export:
+		if decl := *obj.Value; decl.Kind == lang.AttrDef {
+			t := typ{name: "Nil"}
+			if decl.Value != nil {
+				t = c.expr(sc, *decl.Value)
+			}
+			c.assignTarget(sc, lang.Object{Kind: lang.Identifier, Name: decl.Name, Type: attrType(decl), Pos: decl.Pos}, t, decl.Pos)
+		} else {
+			c.statement(sc, decl)
+		}
+	case lang.Import:
+		for _, local := range obj.Vals {
+			sc.vars[local.Name] = &variable{}
+		}
+	case lang.Break, lang.Next:
+	default:
+		c.expr(sc, obj)
+	}
+}
+
+func (c *checker) returnStatement(sc *scope, obj lang.Object) {
+	t, pos := typ{name: "Nil"}, obj.Pos
+	for i, val := range obj.Vals {
+		if i == 0 {
+			t, pos = c.expr(sc, val), val.Pos
+		} else {
+			c.expr(sc, val)
+			t = typ{}
+		}
+	}
+	if len(c.funcs) == 0 {
+		return
+	}
+	fn := c.funcs[len(c.funcs)-1]
+	if fn.yields {
+		return
+	} else if fn.returns != "" {
+		if !c.assignable(t.name, fn.returns) {
+			c.errorf(pos, "cannot return %v from %v, expected %v", t.name, fn.name, fn.returns)
+		}
+	} else if !fn.returned {
+		fn.inferred, fn.returned = t.name, true
+	} else if fn.inferred != t.name {
+		fn.inferred = ""
+	}
+}
+
+func (c *checker) funcDef(sc *scope, obj lang.Object, fn *funcType) {
+	inner := sc.child()
+	for _, param := range obj.Vars {
+		c.knownType(param.Type, param.Pos)
+		name := strings.TrimSuffix(param.Name, "...")
+		t := typ{name: param.Type}
+		if name != param.Name {
+			t.name = "Table"
+		}
+		inner.vars[name] = &variable{typ: t, declared: param.Type}
+	}
+	c.knownType(obj.Type, obj.Pos)
+	c.funcs = append(c.funcs, fn)
+	c.body(inner, obj)
+	c.funcs = c.funcs[:len(c.funcs)-1]
+}
+
+func (c *checker) classDef(sc *scope, obj lang.Object) {
+	cls := c.classes[obj.Name]
+	inst, static := sc.child(), sc.child()
+	inst.vars["self"] = &variable{typ: typ{name: cls.name}}
+	static.vars["self"] = &variable{typ: typ{name: "Class", class: cls}}
+	for _, member := range obj.Block {
+		switch member.Kind {
+		case lang.AttrDef:
+			c.knownType(member.Type, member.Pos)
+			if member.Value != nil {
+				t := c.expr(sc, *member.Value)
+				if want := cls.attrs[member.Name]; !c.assignable(t.name, want) {
+					c.errorf(member.Value.Pos, "cannot assign %v to attribute %v of type %v", t.name, member.Name, want)
+				}
+			}
+		case lang.FuncDef:
+			if member.Static {
+				c.funcDef(static, member, cls.methods[member.Value.Name])
+			} else {
+				c.funcDef(inst, member, cls.methods[member.Value.Name])
+			}
+		case lang.ClassDef:
+			c.classDef(sc, member)
+		}
+	}
+}
+
+func (c *checker) assign(sc *scope, obj lang.Object) {
+	types := make([]typ, len(obj.Vals))
+	for i, val := range obj.Vals {
+		types[i] = c.expr(sc, val)
+	}
+	for i, target := range obj.Vars {
+		t, pos := typ{}, target.Pos
+		if i == len(obj.Vars)-1 && len(obj.Vals) > len(obj.Vars) {
+			t = typ{name: "Table"} // the last target gets the rest of the values
+		} else if i < len(obj.Vals) {
+			t, pos = types[i], obj.Vals[i].Pos
+		}
+		c.assignTarget(sc, target, t, pos)
+	}
+}
+
+func (c *checker) assignTarget(sc *scope, target lang.Object, t typ, pos [4]int) {
+	switch target.Kind {
+	case lang.Identifier:
+		if target.Type != "" {
+			c.knownType(target.Type, target.Pos)
+			if !c.assignable(t.name, target.Type) {
+				c.errorf(pos, "cannot assign %v to %v of type %v", t.name, target.Name, target.Type)
+			}
+			if t.name != target.Type {
+				t = typ{name: target.Type}
+			}
+			sc.vars[target.Name] = &variable{typ: t, declared: target.Type}
+		} else if v := sc.lookup(target.Name); v == nil {
+			sc.vars[target.Name] = &variable{typ: t}
+		} else if v.declared != "" {
+			if !c.assignable(t.name, v.declared) {
+				c.errorf(pos, "cannot assign %v to %v of type %v", t.name, target.Name, v.declared)
+			}
+		} else if v.name == t.name {
+			v.typ = t
+		} else {
+			v.typ = typ{} // it changes type so anything goes from here on
+		}
+	case lang.Member:
+		base := c.expr(sc, target.Vals[0])
+		name := target.Vals[1].Name
+		if cls := c.classOf(base); cls != nil {
+			if want, _ := c.attr(cls, name); !c.assignable(t.name, want) {
+				c.errorf(pos, "cannot assign %v to attribute %v of type %v", t.name, name, want)
+			}
+		}
+	case lang.Index:
+		c.expr(sc, target.Vals[0])
+		c.expr(sc, target.Vals[1])
+	}
+}
+
+// classOf finds the class that the members of a value are looked up on.
+func (c *checker) classOf(t typ) *classType {
+	if t.class != nil {
+		return t.class
+	} else if t.name == "Class" {
+		return nil
+	}
+	return c.classes[t.name]
+}
+
+func (c *checker) exprs(sc *scope, objs []lang.Object) []typ {
+	types := make([]typ, len(objs))
+	for i, obj := range objs {
+		types[i] = c.expr(sc, obj)
+	}
+	return types
+}
+
+func (c *checker) expr(sc *scope, obj lang.Object) typ {
+	switch obj.Kind {
+	case lang.Number:
+		return typ{name: "Number"}
+	case lang.String:
+		return typ{name: "String"}
+	case lang.Bool:
+		return typ{name: "Boolean"}
+	case lang.Nil:
+		return typ{name: "Nil"}
+	case lang.Table:
+		for _, entry := range obj.Vals {
+			if entry.Key != nil {
+				c.expr(sc, *entry.Key)
+			}
+			c.expr(sc, *entry.Value)
+		}
+		return typ{name: "Table"}
+	case lang.FuncDef:
+		fn := newFuncType(obj)
+		c.funcDef(sc, obj, fn)
+		return typ{name: "Func", fn: fn}
+	case lang.Identifier:
+		if v := sc.lookup(obj.Name); v != nil {
+			return v.typ
+		} else if fn, ok := builtinFuncs[obj.Name]; ok {
+			return typ{name: "Func", fn: fn}
+		} else if cls, ok := c.classes[obj.Name]; ok {
+			return typ{name: "Class", class: cls}
+		}
+	case lang.Member:
+		return c.member(c.expr(sc, obj.Vals[0]), obj.Vals[1].Name)
+	case lang.Index:
+		base := c.expr(sc, obj.Vals[0])
+		c.expr(sc, obj.Vals[1])
+		if base.name == "String" {
+			return base
+		}
+	case lang.Range:
+		c.exprs(sc, obj.Vals)
+	case lang.Spread:
+		c.expr(sc, *obj.Value)
+	case lang.FuncCall:
+		return c.call(sc, obj)
+	case lang.Unary:
+		arg := c.expr(sc, *obj.Value)
+		switch obj.Name {
+		case "!":
+			return typ{name: "Boolean"}
+		case "#":
+			return typ{name: "Number"}
+		case "-", "~":
+			if arg.name == "Number" {
+				return arg
+			}
+		}
+	case lang.Binary:
+		return c.binary(obj.Name, c.expr(sc, obj.Vals[0]), c.expr(sc, obj.Vals[1]))
+	case lang.Ternary:
+		types := c.exprs(sc, obj.Vals)
+		if types[1].name == types[2].name {
+			return typ{name: types[1].name}
+		}
+	case lang.Match:
+		c.match(sc, obj)
+	case lang.Yield:
+		c.exprs(sc, obj.Vals)
+	}
+	return typ{}
+}
+
+func (c *checker) match(sc *scope, obj lang.Object) {
+	subject := c.expr(sc, *obj.Value)
+	for _, clause := range obj.Block {
+		inner := sc.child()
+		c.pattern(inner, *clause.Key, subject)
+		if clause.Cond != nil {
+			c.expr(inner, *clause.Cond)
+		}
+		c.block(inner, clause.Block)
+	}
+	c.catches(sc, obj.Catches)
+}
+
+// pattern declares the names that a case binds. A name is known to be of the
+// type it is annotated with or otherwise the type of the value it matches.
+func (c *checker) pattern(sc *scope, pattern lang.Object, t typ) {
+	switch pattern.Kind {
+	case lang.Identifier:
+		if pattern.Type != "" {
+			c.knownType(pattern.Type, pattern.Pos)
+			t = typ{name: pattern.Type}
+		}
+		if pattern.Name != "_" {
+			sc.vars[pattern.Name] = &variable{typ: t}
+		}
+	case lang.Table:
+		for _, entry := range pattern.Vals {
+			if entry.Value.Kind == lang.Spread {
+				c.pattern(sc, *entry.Value.Value, typ{name: "Table"})
+			} else {
+				c.pattern(sc, *entry.Value, typ{})
+			}
+		}
+	}
+}
+
+func (c *checker) binary(op string, left, right typ) typ {
+	switch op {
+	case "<", ">", "<=", ">=", "==", "!=":
+		return typ{name: "Boolean"}
+	case "+":
+		if left.name == "String" || (left.name == "Number" && right.name == "Number") {
+			return typ{name: left.name}
+		}
+	case "-", "*", "/", "%", "^", "&", "|", "~", ">>":
+		if left.name == "Number" && right.name == "Number" {
+			return left
+		}
+	case "<<":
+		if left.name == "Table" {
+			return left
+		}
+	case "and", "or":
+		if left.name == right.name {
+			return typ{name: left.name}
+		}
+	}
+	return typ{}
+}
+
+func (c *checker) member(base typ, name string) typ {
+	cls := c.classOf(base)
+	if cls == nil {
+		return typ{}
+	} else if fn := c.method(cls, name); fn != nil {
+		return typ{name: "Func", fn: fn}
+	} else if t, _ := c.attr(cls, name); t != "" {
+		return typ{name: t}
+	}
+	return typ{}
+}
+
+func (c *checker) call(sc *scope, obj lang.Object) typ {
+	callee := c.expr(sc, *obj.Value)
+	args := c.exprs(sc, obj.Vals)
+	fn := callee.fn
+	if fn == nil {
+		return typ{}
+	} else if fn == builtinFuncs["new"] {
+		if len(args) == 0 || args[0].class == nil {
+			return typ{}
+		}
+		if ctor := c.method(args[0].class, "new"); ctor != nil {
+			c.checkArgs(obj, ctor, obj.Vals[1:], args[1:])
+		}
+		return typ{name: args[0].class.name}
+	} else if !fn.std {
+		c.checkArgs(obj, fn, obj.Vals, args)
+	}
+	return typ{name: fn.result()}
+}
+
+// checkArgs checks the arguments of a call against the parameters of the func
+// being called. Missing arguments are only reported for annotated parameters
+// because they are otherwise left nil on purpose.
+func (c *checker) checkArgs(call lang.Object, fn *funcType, args []lang.Object, types []typ) {
+	for _, arg := range args {
+		if arg.Kind == lang.Spread {
+			return
+		}
+	}
+	params := fn.params
+	if fn.vararg {
+		params = params[:len(params)-1]
+	}
+	if !fn.vararg && len(args) > len(params) {
+		c.errorf(call.Pos, "too many arguments in call to %v, expected %v but got %v", fn.name, len(params), len(args))
+	} else if len(args) < len(params) && (len(args) == 0 || args[len(args)-1].Kind != lang.FuncCall) {
+		for _, param := range params[len(args):] {
+			if param.Type != "" && param.Type != anyType {
+				c.errorf(call.Pos, "not enough arguments in call to %v, expected %v but got %v", fn.name, len(params), len(args))
+				break
+			}
+		}
+	}
+	for i := 0; i < len(params) && i < len(args); i++ {
+		if !c.assignable(types[i].name, params[i].Type) {
+			c.errorf(args[i].Pos, "cannot use %v as %v in argument %v to %v", types[i].name, params[i].Type, params[i].Name, fn.name)
+		}
+	}
+}
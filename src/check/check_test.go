@@ -0,0 +1,152 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func messages(t *testing.T, src string) []string {
+	err := Str(src)
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(TypeErrors)
+	if !assert.True(t, ok, "expected TypeErrors but got %v", err) {
+		return nil
+	}
+	msgs := []string{}
+	for _, terr := range errs {
+		msgs = append(msgs, terr.Message())
+	}
+	return msgs
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		src  string
+		msgs []string
+	}{
+		{src: `
+func add(a: Number, b: Number): Number
+  return a + b
+end
+x = add(1, 2)
+y: Number = add(x, 3)
+`},
+		{src: `
+func add(a: Number, b: Number): Number
+  return a + b
+end
+add(1, "two")
+add(1)
+add(1, 2, 3)
+`, msgs: []string{
+			"cannot use String as Number in argument b to add",
+			"not enough arguments in call to add, expected 2 but got 1",
+			"too many arguments in call to add, expected 2 but got 3",
+		}},
+		{src: `
+func greet(name)
+  return "hello " + name
+end
+greet()
+s: Number = greet("tim")
+`, msgs: []string{"cannot assign String to s of type Number"}},
+		{src: `
+func count(): Number
+  return "one"
+end
+n: Number = 1
+n = "two"
+m: Nmbr = 1
+`, msgs: []string{
+			"cannot return String from count, expected Number",
+			"cannot assign String to n of type Number",
+			"undefined type Nmbr",
+		}},
+		{src: `
+class Animal do
+  attr name: String = "dave"
+  attr age = 1, {type: Number}
+
+  func new(name: String)
+    self.name = name
+  end
+
+  func rename(name)
+    self.age = name
+  end
+end
+me = new(Animal, 12)
+me.age = "old"
+me.rename("bob")
+`, msgs: []string{
+			"cannot use Number as String in argument name to Animal.new",
+			"cannot assign String to attribute age of type Number",
+		}},
+		{src: `
+class Animal do
+  attr legs: Number = "four"
+end
+class Dog isa Animal do
+end
+func pet(a: Animal)
+end
+pet(new(Dog))
+pet(new(Error))
+pet(nil)
+`, msgs: []string{
+			"cannot assign String to attribute legs of type Number",
+			"cannot use Error as Animal in argument a to pet",
+			"cannot use Nil as Animal in argument a to pet",
+		}},
+		{src: `
+func area(shape)
+  return match shape do
+    case {w: w: Number, h: h: Number} then w * h
+    case {r: r} if r > 0 then r * r * 3.14
+    case n: Numbr then n
+    case {first, rest...}
+      s: String = rest
+      first
+  end
+end
+`, msgs: []string{
+			"undefined type Numbr",
+			"cannot assign Table to s of type String",
+		}},
+		{src: `
+func report(e: RequireCycleError): Error
+  return e
+end
+report(new(RequireCycleError))
+report(new(Error))
+func missing(e: ImportError)
+end
+missing(new(ImportError))
+`, msgs: []string{"cannot use Error as RequireCycleError in argument e to report"}},
+		{src: `
+func any(a: Any, rest...)
+end
+any(1, 2, 3)
+any("a")
+`},
+		{src: `
+import {double as twice} from "./math"
+export func add(a: Number, b: Number): Number
+  return a + b
+end
+export attr total: Number = add(1, "2")
+export class Shape do
+end
+s: Shape = twice(2)
+`, msgs: []string{
+			"cannot use String as Number in argument b to add",
+		}},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.msgs, messages(t, tc.src), tc.src)
+	}
+}
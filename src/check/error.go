@@ -0,0 +1,56 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanema/squirt/src/excerpt"
+)
+
+// TypeErr is a type mismatch that was found before the program was run.
+type TypeErr struct {
+	file   bool
+	source string
+	msg    string
+	pos    [4]int
+}
+
+func (err TypeErr) Error() string {
+	var clip string
+	var filename string
+	if err.file {
+		clip = excerpt.File(err.source, err.pos)
+		filename = err.source
+	} else {
+		clip = excerpt.String(err.source, err.pos)
+		filename = "~"
+	}
+	return fmt.Sprintf(`Type Error: %v
+%v
+%v:%v:%v
+	`,
+		err.msg,
+		clip,
+		filename,
+		err.pos[0],
+		err.pos[1],
+	)
+}
+
+// Message is the type mismatch the checker found, like "cannot assign String
+// to x of type Number", without the position and excerpt that Error adds.
+func (err TypeErr) Message() string { return err.msg }
+
+// Pos is the location of the node that caused the error.
+func (err TypeErr) Pos() [4]int { return err.pos }
+
+// TypeErrors is every type error that was found in a source.
+type TypeErrors []TypeErr
+
+func (errs TypeErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,154 @@
+// Package cover writes the runtime.Coverage of a run as an LCOV file, a
+// summary for the terminal or an annotated HTML page.
+package cover
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tanema/squirt/src/excerpt"
+	"github.com/tanema/squirt/src/runtime"
+)
+
+// WriteLCOV writes coverage in the LCOV tracefile format that CI coverage
+// services read.
+func WriteLCOV(w io.Writer, cov *runtime.Coverage) error {
+	for _, file := range cov.Files() {
+		path, err := filepath.Abs(file.Path)
+		if err != nil {
+			path = file.Path
+		}
+		fmt.Fprintf(w, "TN:\nSF:%v\n", path)
+		taken := 0
+		for _, br := range file.Branches {
+			count := fmt.Sprint(br.Count)
+			if br.Count > 0 {
+				taken++
+			} else if blockCount(file, br) == 0 {
+				count = "-"
+			}
+			fmt.Fprintf(w, "BRDA:%v,%v,%v,%v\n", br.Line, br.Block, br.Branch, count)
+		}
+		fmt.Fprintf(w, "BRF:%v\nBRH:%v\n", len(file.Branches), taken)
+		counts := file.Lines()
+		lines, hit := sortedLines(file)
+		for _, line := range lines {
+			fmt.Fprintf(w, "DA:%v,%v\n", line, counts[line])
+		}
+		if _, err := fmt.Fprintf(w, "LF:%v\nLH:%v\nend_of_record\n", len(lines), hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockCount is how many times any branch of the same if or ternary was
+// taken, LCOV marks the branches of one that never ran with a dash.
+func blockCount(file *runtime.FileCoverage, br runtime.BranchCount) int64 {
+	var total int64
+	for _, other := range file.Branches {
+		if other.Block == br.Block {
+			total += other.Count
+		}
+	}
+	return total
+}
+
+func sortedLines(file *runtime.FileCoverage) ([]int, int) {
+	counts := file.Lines()
+	lines, hit := []int{}, 0
+	for line, count := range counts {
+		lines = append(lines, line)
+		if count > 0 {
+			hit++
+		}
+	}
+	sort.Ints(lines)
+	return lines, hit
+}
+
+// WriteSummary writes how many of the lines and branches of each file ran.
+func WriteSummary(w io.Writer, cov *runtime.Coverage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\tlines\tbranches\t")
+	totalLines, totalHit, totalBranches, totalTaken := 0, 0, 0, 0
+	for _, file := range cov.Files() {
+		lines, hit := sortedLines(file)
+		taken := 0
+		for _, br := range file.Branches {
+			if br.Count > 0 {
+				taken++
+			}
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t\n", file.Path, ratio(hit, len(lines)), ratio(taken, len(file.Branches)))
+		totalLines, totalHit = totalLines+len(lines), totalHit+hit
+		totalBranches, totalTaken = totalBranches+len(file.Branches), totalTaken+taken
+	}
+	fmt.Fprintf(tw, "total\t%v\t%v\t\n", ratio(totalHit, totalLines), ratio(totalTaken, totalBranches))
+	return tw.Flush()
+}
+
+func ratio(hit, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%v/%v)", 100*float64(hit)/float64(total), hit, total)
+}
+
+const htmlHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>squirt coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { line-height: 1.3; }
+.run { background: #dfd; }
+.missed { background: #fdd; }
+.partial { background: #ffd; }
+</style>
+</head>
+<body>
+`
+
+// WriteHTML writes a page with the source of every file where the lines that
+// ran are green, the ones that did not are red and lines with a branch that
+// was never taken are yellow.
+func WriteHTML(w io.Writer, cov *runtime.Coverage) error {
+	io.WriteString(w, htmlHead)
+	for _, file := range cov.Files() {
+		src, err := ioutil.ReadFile(file.Path)
+		if err != nil {
+			return err
+		}
+		lines, hit := sortedLines(file)
+		fmt.Fprintf(w, "<h2>%v</h2>\n<p>%v lines run</p>\n<pre>\n",
+			html.EscapeString(file.Path), ratio(hit, len(lines)))
+		counts := file.Lines()
+		partial := map[int]bool{}
+		for _, br := range file.Branches {
+			partial[br.Line] = partial[br.Line] || br.Count == 0
+		}
+		code := strings.Split(strings.TrimRight(string(src), "\n"), "\n")
+		for i, line := range excerpt.Numbered(code, 1) {
+			class := ""
+			if count, ok := counts[i+1]; ok && count == 0 {
+				class = "missed"
+			} else if ok && partial[i+1] {
+				class = "partial"
+			} else if ok {
+				class = "run"
+			}
+			fmt.Fprintf(w, "<span class=%q>%v</span>\n", class, html.EscapeString(line))
+		}
+		io.WriteString(w, "</pre>\n")
+	}
+	_, err := io.WriteString(w, "</body>\n</html>\n")
+	return err
+}
@@ -0,0 +1,54 @@
+package cover
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+const testSrc = `func sign(n)
+  if n > 0 then
+    return "pos"
+  end
+  return "neg"
+end
+sign(1)
+`
+
+func coverageOf(t *testing.T, path string) *runtime.Coverage {
+	cov := runtime.NewCoverage()
+	interp := runtime.NewInterpreter(runtime.Options{Coverage: cov})
+	_, err := interp.EvalFile(path)
+	assert.Nil(t, err)
+	return cov
+}
+
+func TestReports(t *testing.T) {
+	file, err := ioutil.TempFile("", "*.sqrt")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(testSrc)
+	file.Close()
+	cov := coverageOf(t, file.Name())
+
+	var lcov strings.Builder
+	assert.Nil(t, WriteLCOV(&lcov, cov))
+	assert.Equal(t, "TN:\nSF:"+file.Name()+"\n"+
+		"BRDA:2,0,0,1\nBRDA:2,0,1,0\nBRF:2\nBRH:1\n"+
+		"DA:1,1\nDA:2,1\nDA:3,1\nDA:5,0\nDA:7,1\nLF:5\nLH:4\nend_of_record\n", lcov.String())
+
+	var summary strings.Builder
+	assert.Nil(t, WriteSummary(&summary, cov))
+	assert.Contains(t, summary.String(), "80.0% (4/5)  50.0% (1/2)")
+
+	var page strings.Builder
+	assert.Nil(t, WriteHTML(&page, cov))
+	assert.Contains(t, page.String(), `<span class="partial">2    if n &gt; 0 then</span>`)
+	assert.Contains(t, page.String(), `<span class="missed">5    return &#34;neg&#34;</span>`)
+	assert.Contains(t, page.String(), `<span class="">6  end</span>`)
+}
@@ -0,0 +1,109 @@
+package dap
+
+// The subset of the Debug Adapter Protocol that the server understands.
+
+const threadID = 1
+
+type (
+	launchArguments struct {
+		Program     string   `json:"program"`
+		Args        []string `json:"args"`
+		StopOnEntry bool     `json:"stopOnEntry"`
+	}
+
+	source struct {
+		Name string `json:"name,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
+	sourceBreakpoint struct {
+		Line int `json:"line"`
+	}
+
+	setBreakpointsArguments struct {
+		Source      source             `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+
+	functionBreakpoint struct {
+		Name string `json:"name"`
+	}
+
+	setFunctionBreakpointsArguments struct {
+		Breakpoints []functionBreakpoint `json:"breakpoints"`
+	}
+
+	setExceptionBreakpointsArguments struct {
+		Filters []string `json:"filters"`
+	}
+
+	breakpoint struct {
+		Verified bool    `json:"verified"`
+		Line     int     `json:"line,omitempty"`
+		Source   *source `json:"source,omitempty"`
+	}
+
+	exceptionFilter struct {
+		Filter  string `json:"filter"`
+		Label   string `json:"label"`
+		Default bool   `json:"default"`
+	}
+
+	thread struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	stackTraceArguments struct {
+		ThreadID   int `json:"threadId"`
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+
+	stackFrame struct {
+		ID     int     `json:"id"`
+		Name   string  `json:"name"`
+		Source *source `json:"source,omitempty"`
+		Line   int     `json:"line"`
+		Column int     `json:"column"`
+	}
+
+	scopesArguments struct {
+		FrameID int `json:"frameId"`
+	}
+
+	scope struct {
+		Name               string `json:"name"`
+		VariablesReference int    `json:"variablesReference"`
+		Expensive          bool   `json:"expensive"`
+	}
+
+	variablesArguments struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+
+	variable struct {
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+		Type               string `json:"type,omitempty"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+
+	evaluateArguments struct {
+		Expression string `json:"expression"`
+		FrameID    *int   `json:"frameId"`
+	}
+
+	stoppedEvent struct {
+		Reason            string `json:"reason"`
+		Description       string `json:"description,omitempty"`
+		Text              string `json:"text,omitempty"`
+		ThreadID          int    `json:"threadId"`
+		AllThreadsStopped bool   `json:"allThreadsStopped"`
+	}
+
+	outputEvent struct {
+		Category string `json:"category"`
+		Output   string `json:"output"`
+	}
+)
@@ -0,0 +1,83 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+type (
+	// message is a request, response or event, they share one envelope.
+	message struct {
+		Seq        int             `json:"seq"`
+		Type       string          `json:"type"`
+		Command    string          `json:"command,omitempty"`
+		Arguments  json.RawMessage `json:"arguments,omitempty"`
+		RequestSeq int             `json:"request_seq,omitempty"`
+		Success    *bool           `json:"success,omitempty"`
+		Message    string          `json:"message,omitempty"`
+		Event      string          `json:"event,omitempty"`
+		Body       interface{}     `json:"body,omitempty"`
+	}
+
+	// conn reads and writes debug adapter messages framed with Content-Length
+	// headers. Events are sent from the program's goroutine so writes are
+	// locked.
+	conn struct {
+		r   *textproto.Reader
+		w   io.Writer
+		mu  sync.Mutex
+		seq int
+	}
+)
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+func (c *conn) read() (*message, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %v", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	return msg, json.Unmarshal(body, msg)
+}
+
+func (c *conn) write(msg *message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	msg.Seq = c.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %v\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (c *conn) reply(req *message, body interface{}, err error) error {
+	success := err == nil
+	msg := &message{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &success, Body: body}
+	if err != nil {
+		msg.Message = err.Error()
+	}
+	return c.write(msg)
+}
+
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(&message{Type: "event", Event: name, Body: body})
+}
@@ -0,0 +1,574 @@
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// Server is a debug adapter that runs one squirt program and pauses it
+	// where the client asks. The program runs on its own goroutine while the
+	// server keeps answering requests.
+	Server struct {
+		conn   *conn
+		setup  func(*runtime.Interpreter)
+		then   func() // runs once the current response has been sent
+		resume chan struct{}
+		gate   sync.Mutex // held by the hook so every task stops while paused
+
+		mu         sync.Mutex
+		interp     *runtime.Interpreter
+		launch     *launchArguments
+		configured bool
+		running    bool
+		quit       bool
+		files      map[string]string
+		lines      map[string]map[int]bool
+		funcs      map[string]bool
+		onSpill    bool
+		onUncaught bool
+		pausing    bool
+		step       stepKind
+		stepReason string
+		depth      int
+		calls      []frame // the last statement run at each depth of the call stack
+		raised     *runtime.Event
+		stopped    *stop
+		evaluating int32
+	}
+
+	stepKind int
+
+	frame struct {
+		name  string
+		file  string
+		line  int
+		col   int
+		scope *runtime.Scope
+	}
+
+	// stop is what the client can look at while the program is paused.
+	// Variable references index refs and are only good until it resumes.
+	stop struct {
+		frames []frame
+		depth  int
+		refs   []interface{} // a *runtime.Scope or a runtime.Value
+	}
+
+	// output sends what the program prints to the client.
+	output struct {
+		conn     *conn
+		category string
+	}
+)
+
+const (
+	stepNone stepKind = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+var errNotPaused = fmt.Errorf("the program is not paused")
+
+// Serve runs a debug adapter over r and w until the client disconnects or r
+// is closed. setup is called with the interpreter before the program is run
+// so that libs can be registered on it.
+func Serve(r io.Reader, w io.Writer, setup func(*runtime.Interpreter)) error {
+	srv := &Server{
+		conn:       newConn(r, w),
+		setup:      setup,
+		resume:     make(chan struct{}),
+		files:      map[string]string{},
+		lines:      map[string]map[int]bool{},
+		funcs:      map[string]bool{},
+		onUncaught: true,
+	}
+	for {
+		msg, err := srv.conn.read()
+		if err == io.EOF {
+			srv.disconnect()
+			if srv.then != nil {
+				srv.then()
+			}
+			return nil
+		} else if err != nil {
+			return err
+		} else if msg.Type != "request" {
+			continue
+		}
+		body, err := srv.handle(msg)
+		if err := srv.conn.reply(msg, body, err); err != nil {
+			return err
+		}
+		if then := srv.then; then != nil {
+			srv.then = nil
+			then()
+		}
+		if msg.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (srv *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Command {
+	case "initialize":
+		srv.then = func() { srv.conn.event("initialized", nil) }
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsEvaluateForHovers":        true,
+			"exceptionBreakpointFilters": []exceptionFilter{
+				{Filter: "spill", Label: "Every spill"},
+				{Filter: "uncaught", Label: "Uncaught errors", Default: true},
+			},
+		}, nil
+	case "launch":
+		var args launchArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		} else if args.Program == "" {
+			return nil, fmt.Errorf("launch needs a program to run")
+		}
+		srv.mu.Lock()
+		srv.launch = &args
+		srv.mu.Unlock()
+		srv.then = srv.start
+		return nil, nil
+	case "configurationDone":
+		srv.mu.Lock()
+		srv.configured = true
+		srv.mu.Unlock()
+		srv.then = srv.start
+		return nil, nil
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return srv.setBreakpoints(args), nil
+	case "setFunctionBreakpoints":
+		var args setFunctionBreakpointsArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return srv.setFunctionBreakpoints(args), nil
+	case "setExceptionBreakpoints":
+		var args setExceptionBreakpointsArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		srv.mu.Lock()
+		srv.onSpill, srv.onUncaught = false, false
+		for _, filter := range args.Filters {
+			srv.onSpill = srv.onSpill || filter == "spill"
+			srv.onUncaught = srv.onUncaught || filter == "uncaught" || filter == "spill"
+		}
+		srv.mu.Unlock()
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		return srv.stackTrace()
+	case "scopes":
+		var args scopesArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return srv.scopes(args.FrameID)
+	case "variables":
+		var args variablesArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return srv.variables(args.VariablesReference)
+	case "evaluate":
+		var args evaluateArguments
+		if err := json.Unmarshal(msg.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return srv.evaluate(args)
+	case "continue":
+		return map[string]bool{"allThreadsContinued": true}, srv.resumeWith(stepNone)
+	case "next":
+		return nil, srv.resumeWith(stepOver)
+	case "stepIn":
+		return nil, srv.resumeWith(stepIn)
+	case "stepOut":
+		return nil, srv.resumeWith(stepOut)
+	case "pause":
+		srv.mu.Lock()
+		srv.pausing = true
+		srv.mu.Unlock()
+		return nil, nil
+	case "disconnect":
+		srv.disconnect()
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported command %v", msg.Command)
+}
+
+// start runs the program once it has been launched and the client is done
+// setting breakpoints.
+func (srv *Server) start() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.launch == nil || !srv.configured || srv.running {
+		return
+	}
+	srv.running = true
+	if srv.launch.StopOnEntry {
+		srv.step, srv.stepReason = stepIn, "entry"
+	}
+	srv.interp = runtime.NewInterpreter(runtime.Options{
+		Stdout: output{conn: srv.conn, category: "stdout"},
+		Stderr: output{conn: srv.conn, category: "stderr"},
+		Hook:   srv.hook,
+	})
+	if srv.setup != nil {
+		srv.setup(srv.interp)
+	}
+	go srv.run(srv.interp.Scope(), *srv.launch)
+}
+
+func (srv *Server) run(scope *runtime.Scope, args launchArguments) {
+	argv := make([]runtime.Value, len(args.Args))
+	for i, arg := range args.Args {
+		argv[i], _ = runtime.ToValue(scope, arg)
+	}
+	argvTable, _ := runtime.ToValue(scope, argv)
+	scope.Set("ARGV", argvTable)
+
+	finished := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { finished <- err }()
+		_, err = runtime.EvalFile(scope, args.Program)
+	}()
+	err := <-finished
+
+	srv.mu.Lock()
+	quit, raised, onUncaught := srv.quit, srv.raised, srv.onUncaught
+	srv.mu.Unlock()
+	if quit {
+		return
+	}
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+		msg := runtime.Print(scope, err)
+		if onUncaught && raised != nil {
+			srv.pause(*raised, "exception", msg)
+			srv.mu.Lock()
+			quit = srv.quit
+			srv.mu.Unlock()
+			if quit {
+				return
+			}
+		}
+		srv.conn.event("output", outputEvent{Category: "stderr", Output: msg + "\n"})
+	}
+	srv.conn.event("exited", map[string]int{"exitCode": exitCode})
+	srv.conn.event("terminated", nil)
+}
+
+// hook is called by the runtime as the program runs and pauses it by blocking
+// until the client resumes it.
+func (srv *Server) hook(ev runtime.Event) {
+	if atomic.LoadInt32(&srv.evaluating) > 0 {
+		return
+	}
+	srv.gate.Lock()
+	defer srv.gate.Unlock()
+	srv.mu.Lock()
+	reason, text := "", ""
+	switch ev.Kind {
+	case runtime.EventCall:
+		if srv.funcs[ev.Name] {
+			srv.step, srv.stepReason = stepIn, "function breakpoint"
+		}
+	case runtime.EventRaise:
+		srv.record(ev)
+		srv.raised = &ev
+		if srv.onSpill {
+			reason, text = "exception", runtime.Print(ev.Scope, ev.Err)
+		}
+	case runtime.EventStatement:
+		srv.record(ev)
+		depth := len(ev.Trace)
+		if srv.lines[srv.abs(ev.File)][ev.Pos[0]] {
+			reason = "breakpoint"
+		} else if srv.pausing {
+			reason = "pause"
+		} else if srv.step == stepIn ||
+			(srv.step == stepOver && depth <= srv.depth) ||
+			(srv.step == stepOut && depth < srv.depth) {
+			reason = srv.stepReason
+		}
+	}
+	quit := srv.quit
+	srv.mu.Unlock()
+	if !quit && reason != "" {
+		srv.pause(ev, reason, text)
+		srv.mu.Lock()
+		quit = srv.quit
+		srv.mu.Unlock()
+	}
+	if quit {
+		goruntime.Goexit()
+	}
+}
+
+// record keeps the statement that is running at each depth so that the
+// frames of callers know which line they are on.
+func (srv *Server) record(ev runtime.Event) {
+	depth := len(ev.Trace)
+	for len(srv.calls) < depth {
+		srv.calls = append(srv.calls, frame{})
+	}
+	srv.calls = srv.calls[:depth]
+	if depth > 0 {
+		srv.calls[depth-1] = frame{file: ev.File, line: ev.Pos[0], col: ev.Pos[1], scope: ev.Scope}
+	}
+}
+
+func (srv *Server) pause(ev runtime.Event, reason, text string) {
+	srv.mu.Lock()
+	srv.step, srv.pausing = stepNone, false
+	srv.stopped = &stop{frames: srv.frames(ev), depth: len(ev.Trace)}
+	srv.mu.Unlock()
+	srv.conn.event("stopped", stoppedEvent{Reason: reason, Text: text, ThreadID: threadID, AllThreadsStopped: true})
+	<-srv.resume
+}
+
+// frames builds the call stack from the runtime trace, innermost first. The
+// trace only knows where each func was defined so the line each caller is on
+// comes from the statements that were recorded.
+func (srv *Server) frames(ev runtime.Event) []frame {
+	current := frame{name: "<main>", file: ev.File, line: ev.Pos[0], col: ev.Pos[1], scope: ev.Scope}
+	if len(ev.Trace) == 0 {
+		return []frame{current}
+	}
+	frames := []frame{}
+	for i := len(ev.Trace) - 1; i >= 0; i-- {
+		file, line, name := parseTrace(ev.Trace[i])
+		f := frame{file: file, line: line}
+		if i == len(ev.Trace)-1 {
+			f = current
+		} else if i < len(srv.calls) && srv.calls[i].scope != nil {
+			f = srv.calls[i]
+		}
+		f.name = name
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func parseTrace(entry string) (string, int, string) {
+	name := ""
+	if i := strings.LastIndex(entry, " in "); i >= 0 {
+		entry, name = entry[:i], entry[i+4:]
+	}
+	if i := strings.LastIndex(entry, ":"); i >= 0 {
+		line, _ := strconv.Atoi(entry[i+1:])
+		return entry[:i], line, name
+	}
+	return entry, 0, name
+}
+
+// resumeWith lets the paused program run on after the response is sent.
+func (srv *Server) resumeWith(step stepKind) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.stopped == nil {
+		return errNotPaused
+	}
+	srv.step, srv.stepReason, srv.depth = step, "step", srv.stopped.depth
+	srv.stopped = nil
+	srv.then = func() { srv.resume <- struct{}{} }
+	return nil
+}
+
+func (srv *Server) disconnect() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.quit = true
+	if srv.stopped != nil {
+		srv.stopped = nil
+		srv.then = func() { srv.resume <- struct{}{} }
+	}
+}
+
+func (srv *Server) setBreakpoints(args setBreakpointsArguments) map[string]interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	lines := map[int]bool{}
+	breakpoints := []breakpoint{}
+	for _, bp := range args.Breakpoints {
+		lines[bp.Line] = true
+		breakpoints = append(breakpoints, breakpoint{Verified: true, Line: bp.Line, Source: &args.Source})
+	}
+	srv.lines[srv.abs(args.Source.Path)] = lines
+	return map[string]interface{}{"breakpoints": breakpoints}
+}
+
+func (srv *Server) setFunctionBreakpoints(args setFunctionBreakpointsArguments) map[string]interface{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.funcs = map[string]bool{}
+	breakpoints := []breakpoint{}
+	for _, bp := range args.Breakpoints {
+		srv.funcs[bp.Name] = true
+		breakpoints = append(breakpoints, breakpoint{Verified: true})
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}
+}
+
+func (srv *Server) stackTrace() (interface{}, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.stopped == nil {
+		return nil, errNotPaused
+	}
+	frames := []stackFrame{}
+	for i, f := range srv.stopped.frames {
+		frames = append(frames, stackFrame{
+			ID:     i,
+			Name:   f.name,
+			Source: &source{Name: filepath.Base(f.file), Path: srv.abs(f.file)},
+			Line:   f.line,
+			Column: f.col,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// scopes follows the scope of a frame out to the global scope.
+func (srv *Server) scopes(id int) (interface{}, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	f, err := srv.frame(id)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []scope{}
+	for s := f.scope; s != nil; s = s.Outer() {
+		name := "Outer"
+		if s.Outer() == nil {
+			name = "Globals"
+		} else if s == f.scope {
+			name = "Locals"
+		}
+		scopes = append(scopes, scope{Name: name, VariablesReference: srv.ref(s)})
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+// variables lists the names defined in a scope or what a table or instance
+// holds.
+func (srv *Server) variables(ref int) (interface{}, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.stopped == nil {
+		return nil, errNotPaused
+	} else if ref <= 0 || ref > len(srv.stopped.refs) {
+		return nil, fmt.Errorf("unknown variables reference %v", ref)
+	}
+	vars := []variable{}
+	switch target := srv.stopped.refs[ref-1].(type) {
+	case *runtime.Scope:
+		for _, name := range target.Vars() {
+			vars = append(vars, srv.variable(name, target.Get(name)))
+		}
+	default:
+		names, vals := runtime.Fields(srv.interp.Scope(), target)
+		for i, name := range names {
+			vars = append(vars, srv.variable(name, vals[i]))
+		}
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (srv *Server) evaluate(args evaluateArguments) (interface{}, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	id := 0
+	if args.FrameID != nil {
+		id = *args.FrameID
+	}
+	f, err := srv.frame(id)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&srv.evaluating, 1)
+	defer atomic.AddInt32(&srv.evaluating, -1)
+	val, err := runtime.Eval(f.scope, args.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("%v", runtime.Print(f.scope, err))
+	}
+	v := srv.variable("", val)
+	return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+}
+
+func (srv *Server) frame(id int) (frame, error) {
+	if srv.stopped == nil {
+		return frame{}, errNotPaused
+	} else if id < 0 || id >= len(srv.stopped.frames) {
+		return frame{}, fmt.Errorf("unknown frame %v", id)
+	}
+	f := srv.stopped.frames[id]
+	if f.scope == nil {
+		f.scope = srv.interp.Scope()
+	}
+	return f, nil
+}
+
+// variable describes a value, tables and instances get a reference so that
+// the client can expand them. Printing a value can call its tostring so the
+// hook is kept quiet while it does.
+func (srv *Server) variable(name string, val runtime.Value) variable {
+	atomic.AddInt32(&srv.evaluating, 1)
+	defer atomic.AddInt32(&srv.evaluating, -1)
+	s := srv.interp.Scope()
+	v := variable{Name: name, Value: "nil", Type: "nil"}
+	if cval, ok := val.(runtime.CVal); ok {
+		v.Value, v.Type = runtime.Print(s, val), cval.Type()
+	}
+	if names, _ := runtime.Fields(s, val); len(names) > 0 {
+		v.VariablesReference = srv.ref(val)
+	}
+	return v
+}
+
+func (srv *Server) ref(target interface{}) int {
+	srv.stopped.refs = append(srv.stopped.refs, target)
+	return len(srv.stopped.refs)
+}
+
+// abs keeps the absolute path of every file that has been seen so that
+// breakpoints can be matched without asking the os on every statement.
+func (srv *Server) abs(path string) string {
+	if abs, ok := srv.files[path]; ok {
+		return abs
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	srv.files[path] = abs
+	return abs
+}
+
+func (out output) Write(p []byte) (int, error) {
+	return len(p), out.conn.event("output", outputEvent{Category: out.category, Output: string(p)})
+}
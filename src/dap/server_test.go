@@ -0,0 +1,219 @@
+package dap
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSrc = `func add(a, b)
+  c = a + b
+  return c
+end
+t = {1, 2, name: "x"}
+y = add(1, 2)
+print(y)
+spill("boom")
+`
+
+// client drives a server the way an editor would, reading everything the
+// server sends on its own goroutine so that events never block it.
+type client struct {
+	t    *testing.T
+	conn *conn
+	in   io.Closer
+	msgs chan *message
+	done chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, conn: newConn(clientIn, clientOut), in: clientOut, msgs: make(chan *message, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- Serve(serverIn, serverOut, nil)
+		serverOut.Close()
+	}()
+	go func() {
+		for {
+			msg, err := c.conn.read()
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) request(command string, args interface{}) *message {
+	data, _ := json.Marshal(args)
+	assert.Nil(c.t, c.conn.write(&message{Type: "request", Command: command, Arguments: data}))
+	msg := c.expect("response", command)
+	assert.True(c.t, msg.Success != nil && *msg.Success, "%v failed: %v", command, msg.Message)
+	return msg
+}
+
+// expect skips messages until one of the given type and name arrives.
+func (c *client) expect(typ, name string) *message {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("connection closed waiting for %v %v", typ, name)
+			} else if msg.Type == typ && (msg.Command == name || msg.Event == name) {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for %v %v", typ, name)
+		}
+	}
+}
+
+func decode(t *testing.T, msg *message, v interface{}) {
+	data, err := json.Marshal(msg.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, v))
+}
+
+func (c *client) stopped(reason string) {
+	var ev stoppedEvent
+	decode(c.t, c.expect("event", "stopped"), &ev)
+	assert.Equal(c.t, reason, ev.Reason)
+}
+
+func (c *client) frames() []stackFrame {
+	var body struct{ StackFrames []stackFrame }
+	decode(c.t, c.request("stackTrace", map[string]int{"threadId": threadID}), &body)
+	return body.StackFrames
+}
+
+func (c *client) variables(ref int) map[string]variable {
+	var body struct{ Variables []variable }
+	decode(c.t, c.request("variables", map[string]int{"variablesReference": ref}), &body)
+	vars := map[string]variable{}
+	for _, v := range body.Variables {
+		vars[v.Name] = v
+	}
+	return vars
+}
+
+func writeSrc(t *testing.T, src string) string {
+	file, err := ioutil.TempFile("", "*.sqrt")
+	assert.Nil(t, err)
+	file.WriteString(src)
+	file.Close()
+	return file.Name()
+}
+
+func TestServer(t *testing.T) {
+	path := writeSrc(t, testSrc)
+	defer os.Remove(path)
+	c := newClient(t)
+
+	init := c.request("initialize", map[string]string{"adapterID": "squirt"})
+	assert.Contains(t, init.Body, "supportsConfigurationDoneRequest")
+	c.expect("event", "initialized")
+	c.request("launch", map[string]interface{}{"program": path})
+	bps := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 2}},
+	})
+	var bpBody struct{ Breakpoints []breakpoint }
+	decode(t, bps, &bpBody)
+	if assert.Len(t, bpBody.Breakpoints, 1) {
+		assert.True(t, bpBody.Breakpoints[0].Verified)
+	}
+	c.request("configurationDone", nil)
+	c.stopped("breakpoint")
+
+	frames := c.frames()
+	if assert.Len(t, frames, 2) {
+		assert.Equal(t, "add", frames[0].Name)
+		assert.Equal(t, 2, frames[0].Line)
+		assert.Equal(t, "<main>", frames[1].Name)
+		assert.Equal(t, 6, frames[1].Line)
+	}
+
+	var scopeBody struct{ Scopes []scope }
+	decode(t, c.request("scopes", map[string]int{"frameId": 0}), &scopeBody)
+	if assert.True(t, len(scopeBody.Scopes) >= 2) {
+		assert.Equal(t, "Locals", scopeBody.Scopes[0].Name)
+		last := scopeBody.Scopes[len(scopeBody.Scopes)-1]
+		assert.Equal(t, "Globals", last.Name)
+
+		locals := c.variables(scopeBody.Scopes[0].VariablesReference)
+		assert.Equal(t, "1", locals["a"].Value)
+		assert.Equal(t, "Number", locals["b"].Type)
+		assert.NotContains(t, locals, "t")
+
+		globals := c.variables(last.VariablesReference)
+		assert.NotContains(t, globals, "print")
+		tbl := globals["t"]
+		assert.Equal(t, "Table", tbl.Type)
+		if assert.NotZero(t, tbl.VariablesReference) {
+			fields := c.variables(tbl.VariablesReference)
+			assert.Equal(t, "1", fields["0"].Value)
+			assert.Equal(t, "2", fields["1"].Value)
+			assert.Equal(t, "x", fields["name"].Value)
+		}
+	}
+
+	var eval struct{ Result string }
+	decode(t, c.request("evaluate", map[string]interface{}{"expression": "a + b", "frameId": 0}), &eval)
+	assert.Equal(t, "3", eval.Result)
+
+	c.request("next", map[string]int{"threadId": threadID})
+	c.stopped("step")
+	assert.Equal(t, 3, c.frames()[0].Line)
+
+	c.request("stepOut", map[string]int{"threadId": threadID})
+	c.stopped("step")
+	frames = c.frames()
+	assert.Len(t, frames, 1)
+	assert.Equal(t, 7, frames[0].Line)
+
+	c.request("continue", map[string]int{"threadId": threadID})
+	var out outputEvent
+	decode(t, c.expect("event", "output"), &out)
+	assert.Equal(t, "3\n", out.Output)
+	c.stopped("exception")
+	assert.Equal(t, 8, c.frames()[0].Line)
+
+	c.request("continue", map[string]int{"threadId": threadID})
+	var exited struct{ ExitCode int }
+	decode(t, c.expect("event", "exited"), &exited)
+	assert.Equal(t, 1, exited.ExitCode)
+	c.expect("event", "terminated")
+	c.request("disconnect", nil)
+	assert.Nil(t, <-c.done)
+}
+
+func TestPause(t *testing.T) {
+	path := writeSrc(t, "i = 0\nwhile true do\n  i = i + 1\nend\n")
+	defer os.Remove(path)
+	c := newClient(t)
+
+	c.request("initialize", nil)
+	c.request("setFunctionBreakpoints", map[string]interface{}{"breakpoints": []interface{}{}})
+	c.request("launch", map[string]interface{}{"program": path, "stopOnEntry": true})
+	c.request("configurationDone", nil)
+	c.stopped("entry")
+	assert.Equal(t, 1, c.frames()[0].Line)
+
+	c.request("continue", map[string]int{"threadId": threadID})
+	c.request("pause", map[string]int{"threadId": threadID})
+	c.stopped("pause")
+	assert.Contains(t, []int{2, 3}, c.frames()[0].Line)
+
+	c.request("disconnect", nil)
+	assert.Nil(t, <-c.done)
+	c.in.Close()
+}
@@ -0,0 +1,85 @@
+package debug
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	sq "github.com/tanema/squirt/src/runtime"
+)
+
+const debugSrc = `func add(a, b)
+  c = a + b
+  return c
+end
+x = 1
+y = add(x, 2)
+print(y)
+spill("boom")
+`
+
+type script []string
+
+func (s *script) Readline() (string, error) {
+	if len(*s) == 0 {
+		return "", io.EOF
+	}
+	line := (*s)[0]
+	*s = (*s)[1:]
+	return line, nil
+}
+
+func run(t *testing.T, cmds ...string) (string, string, error) {
+	file, err := ioutil.TempFile("", "*.sqrt")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(debugSrc)
+	file.Close()
+
+	var out, prog strings.Builder
+	prompt := script(cmds)
+	d := New(&prompt, &out)
+	interp := sq.NewInterpreter(sq.Options{Stdout: &prog, Hook: d.Hook})
+	err = d.Run(interp.Scope(), file.Name())
+	return out.String(), prog.String(), err
+}
+
+func TestDebugger(t *testing.T) {
+	t.Run("stepping", func(t *testing.T) {
+		out, prog, err := run(t, "n", "n", "p x + 10", "s", "s", "locals", "bt", "o", "c", "c")
+		assert.NotNil(t, err)
+		assert.Equal(t, "3\n", prog)
+		assert.Contains(t, out, ":5\n")
+		assert.Contains(t, out, "\n11\n")
+		assert.Contains(t, out, ":2\n")
+		assert.Contains(t, out, "a = 1\n")
+		assert.Contains(t, out, "c = 3\n")
+		assert.Contains(t, out, ":1 in add\n")
+		assert.Contains(t, out, ":7\n")
+		assert.Contains(t, out, "uncaught error")
+	})
+
+	t.Run("breakpoints", func(t *testing.T) {
+		out, _, _ := run(t, "b add", "b 7", "breaks", "c", "c", "c")
+		assert.Equal(t, 4, strings.Count(out, "paused at"))
+		assert.Contains(t, out, "add\n")
+		assert.Contains(t, out, ":2\n")
+		assert.Contains(t, out, ":7\n")
+	})
+
+	t.Run("catch none", func(t *testing.T) {
+		out, _, err := run(t, "catch none", "c")
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, strings.Count(out, "paused at"))
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		_, prog, err := run(t, "q")
+		assert.Nil(t, err)
+		assert.Equal(t, "", prog)
+	})
+}
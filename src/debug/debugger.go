@@ -0,0 +1,286 @@
+package debug
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tanema/squirt/src/excerpt"
+	sq "github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// Prompt reads the commands given to the debugger, a readline.Instance is
+	// one.
+	Prompt interface {
+		Readline() (string, error)
+	}
+
+	// Debugger pauses a program at breakpoints and reads commands to step
+	// through it and inspect what it is doing. It is a runtime.Hook so the
+	// program has to be run by an interpreter that was given Debugger.Hook.
+	Debugger struct {
+		prompt     Prompt
+		out        io.Writer
+		mu         sync.Mutex
+		file       string
+		lines      map[string]bool
+		funcs      map[string]bool
+		step       stepKind
+		depth      int
+		onSpill    bool
+		onUncaught bool
+		raised     *sq.Event
+		evaluating int32
+		quit       bool
+	}
+
+	stepKind int
+)
+
+const (
+	stepNone stepKind = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+const help = `commands:
+  c, continue          run until the next breakpoint
+  s, step              step into the next statement
+  n, next              step over calls to the next statement
+  o, out               step out of the current func
+  b, break LOC         break at file:line, line or a func name
+  d, delete LOC        remove a breakpoint
+  breaks               list breakpoints
+  catch spill|uncaught|none
+                       break on every spill, only on uncaught errors or never
+  bt, backtrace        show the call stack
+  l, list              show the source around the current statement
+  locals               list the names defined in the current scope
+  p, print EXPR        evaluate an expression in the current scope
+  q, quit              stop the program
+  h, help              show this message`
+
+// New creates a debugger that reads commands from prompt and writes to out.
+// It breaks on uncaught errors until told otherwise.
+func New(prompt Prompt, out io.Writer) *Debugger {
+	return &Debugger{
+		prompt:     prompt,
+		out:        out,
+		lines:      map[string]bool{},
+		funcs:      map[string]bool{},
+		onUncaught: true,
+	}
+}
+
+// Run runs a file paused before its first statement. When the program raises
+// an error that is not caught the debugger pauses where it was raised before
+// returning the error.
+func (d *Debugger) Run(scope *sq.Scope, path string) error {
+	d.file = absPath(path)
+	d.step = stepIn
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { done <- err }()
+		_, err = sq.EvalFile(scope, path)
+	}()
+	err := <-done
+	if d.quit {
+		return nil
+	} else if err != nil && d.onUncaught && d.raised != nil {
+		fmt.Fprintln(d.out, "uncaught error, the program cannot continue")
+		d.pause(*d.raised)
+	}
+	return err
+}
+
+// Hook is the runtime.Hook that pauses the program.
+func (d *Debugger) Hook(ev sq.Event) {
+	if atomic.LoadInt32(&d.evaluating) > 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.quit {
+		runtime.Goexit()
+	}
+	switch ev.Kind {
+	case sq.EventCall:
+		if d.funcs[ev.Name] {
+			d.step = stepIn
+		}
+	case sq.EventRaise:
+		d.raised = &ev
+		if d.onSpill {
+			fmt.Fprintln(d.out, sq.Print(ev.Scope, ev.Err))
+			d.pause(ev)
+		}
+	case sq.EventStatement:
+		depth := len(ev.Trace)
+		if d.lines[location(ev.File, ev.Pos[0])] ||
+			d.step == stepIn ||
+			(d.step == stepOver && depth <= d.depth) ||
+			(d.step == stepOut && depth < d.depth) {
+			d.pause(ev)
+		}
+	}
+	if d.quit {
+		runtime.Goexit()
+	}
+}
+
+// pause reads commands until one of them resumes the program.
+func (d *Debugger) pause(ev sq.Event) {
+	d.step = stepNone
+	fmt.Fprintf(d.out, "paused at %v:%v\n", ev.File, ev.Pos[0])
+	fmt.Fprintln(d.out, excerpt.File(ev.File, ev.Pos))
+	for {
+		line, err := d.prompt.Readline()
+		if err != nil {
+			d.quit = true
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		arg := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+		switch fields[0] {
+		case "c", "continue":
+			return
+		case "s", "step":
+			d.step = stepIn
+			return
+		case "n", "next":
+			d.step, d.depth = stepOver, len(ev.Trace)
+			return
+		case "o", "out":
+			d.step, d.depth = stepOut, len(ev.Trace)
+			return
+		case "q", "quit":
+			d.quit = true
+			return
+		case "b", "break":
+			d.setBreak(arg, true)
+		case "d", "delete":
+			d.setBreak(arg, false)
+		case "breaks":
+			d.listBreaks()
+		case "catch":
+			d.catch(arg)
+		case "bt", "backtrace":
+			d.backtrace(ev)
+		case "l", "list":
+			fmt.Fprintln(d.out, excerpt.File(ev.File, ev.Pos))
+		case "locals":
+			for _, name := range ev.Scope.Locals() {
+				fmt.Fprintf(d.out, "%v = %v\n", name, sq.Print(ev.Scope, ev.Scope.Get(name)))
+			}
+		case "p", "print":
+			d.print(ev.Scope, arg)
+		case "h", "help":
+			fmt.Fprintln(d.out, help)
+		default:
+			fmt.Fprintf(d.out, "unknown command %v, try help\n", fields[0])
+		}
+	}
+}
+
+// SetBreakpoint adds a breakpoint at file:line, a line of the file being run
+// or at the first statement of a func.
+func (d *Debugger) SetBreakpoint(loc string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setBreak(loc, true)
+}
+
+func (d *Debugger) setBreak(loc string, on bool) {
+	if loc == "" {
+		fmt.Fprintln(d.out, "break needs a file:line, line or func name")
+		return
+	}
+	key := loc
+	isLine := false
+	if i := strings.LastIndex(loc, ":"); i > 0 {
+		if line, err := strconv.Atoi(loc[i+1:]); err == nil {
+			key, isLine = location(absPath(loc[:i]), line), true
+		}
+	} else if line, err := strconv.Atoi(loc); err == nil {
+		key, isLine = location(d.file, line), true
+	}
+	breaks := d.funcs
+	if isLine {
+		breaks = d.lines
+	}
+	if on {
+		breaks[key] = true
+	} else {
+		delete(breaks, key)
+	}
+}
+
+func (d *Debugger) listBreaks() {
+	all := []string{}
+	for loc := range d.lines {
+		all = append(all, loc)
+	}
+	for name := range d.funcs {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+	for _, loc := range all {
+		fmt.Fprintln(d.out, loc)
+	}
+}
+
+func (d *Debugger) catch(arg string) {
+	switch arg {
+	case "spill":
+		d.onSpill, d.onUncaught = true, true
+	case "uncaught":
+		d.onSpill, d.onUncaught = false, true
+	case "none":
+		d.onSpill, d.onUncaught = false, false
+	default:
+		fmt.Fprintln(d.out, "catch needs spill, uncaught or none")
+	}
+}
+
+func (d *Debugger) backtrace(ev sq.Event) {
+	fmt.Fprintf(d.out, "#0 %v:%v\n", ev.File, ev.Pos[0])
+	for i := len(ev.Trace) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "#%v %v\n", len(ev.Trace)-i, ev.Trace[i])
+	}
+}
+
+// print evaluates an expression without calling the hook for it so that it
+// does not stop at breakpoints.
+func (d *Debugger) print(scope *sq.Scope, expr string) {
+	atomic.AddInt32(&d.evaluating, 1)
+	defer atomic.AddInt32(&d.evaluating, -1)
+	val, err := sq.Eval(scope, expr)
+	if err != nil {
+		fmt.Fprintln(d.out, sq.Print(scope, err))
+	} else {
+		fmt.Fprintln(d.out, sq.Print(scope, val))
+	}
+}
+
+func location(file string, line int) string {
+	return fmt.Sprintf("%v:%v", absPath(file), line)
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -22,6 +22,13 @@ func String(str string, loc [4]int) string {
 	return excerpt(strings.NewReader(str), loc, false)
 }
 
+// Numbered puts line numbers in front of lines of code, the first line being
+// line start.
+func Numbered(code []string, start int) []string {
+	numbered := append([]string{}, code...)
+	return lineNumbers(numbered, []int{start, start + len(code) - 1}, -1)
+}
+
 func excerpt(r io.Reader, loc [4]int, lineNums bool) string {
 	code, info := snippet(r, loc)
 	code, skip := highlightLocation(code, info)
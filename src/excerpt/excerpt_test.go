@@ -150,3 +150,9 @@ func TestLineNumbersBlock(t *testing.T) {
 	expected := []string{" 2", " 3    a = 1", " 4", " 5  ->func test(param)", " 6  ->  print(param)", " 7  ->end", " 8", " 9    a = 42", "10"}
 	assert.Equal(t, expected, code)
 }
+
+func TestNumbered(t *testing.T) {
+	code := []string{"a = 1", "", "print(a)"}
+	assert.Equal(t, []string{" 9  a = 1", "10", "11  print(a)"}, Numbered(code, 9))
+	assert.Equal(t, "a = 1", code[0])
+}
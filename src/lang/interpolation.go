@@ -5,15 +5,43 @@ import (
 	"strings"
 )
 
+// Segment is a piece of an interpolated string. It is either literal text or
+// the source of an expression that should be evaluated.
+type Segment struct {
+	Text string
+	Expr bool
+}
+
 func Interpolate(in string, fn func(string) (string, error)) (string, error) {
 	if !strings.Contains(in, "${") {
 		return in, nil
 	}
+	parts := []string{}
+	for _, seg := range Segments(in) {
+		if !seg.Expr {
+			parts = append(parts, seg.Text)
+			continue
+		}
+		evaled, err := fn(seg.Text)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, evaled)
+	}
+	return strings.Join(parts, ""), nil
+}
+
+// Segments splits a string into its literal text and the expressions wrapped in
+// ${}. A string without any interpolation is returned as a single segment.
+func Segments(in string) []Segment {
+	if !strings.Contains(in, "${") {
+		return []Segment{{Text: in}}
+	}
 	str := []rune(in)
 	var found bool
 	var start int
 	var buf bytes.Buffer
-	parts := []string{}
+	segments := []Segment{}
 	for i, rn := range str {
 		switch rn {
 		case '$':
@@ -23,18 +51,14 @@ func Interpolate(in string, fn func(string) (string, error)) (string, error) {
 			}
 		case '{':
 			if found {
-				parts = append(parts, buf.String())
+				segments = append(segments, Segment{Text: buf.String()})
 				buf.Reset()
 				start = i - 1
 				continue
 			}
 		case '}':
 			if start >= 0 {
-				evaled, err := fn(buf.String())
-				if err != nil {
-					return "", err
-				}
-				parts = append(parts, evaled)
+				segments = append(segments, Segment{Text: buf.String(), Expr: true})
 				buf.Reset()
 				start = -1
 				continue
@@ -43,7 +67,5 @@ func Interpolate(in string, fn func(string) (string, error)) (string, error) {
 		buf.WriteRune(rn)
 		found = false
 	}
-	parts = append(parts, buf.String())
-	buf.Reset()
-	return strings.Join(parts, ""), nil
+	return append(segments, Segment{Text: buf.String()})
 }
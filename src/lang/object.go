@@ -9,9 +9,11 @@ const (
 	Binary     NodeKind = "binary"
 	Bool       NodeKind = "bool"
 	Break      NodeKind = "break"
+	Case       NodeKind = "case"
 	ClassDef   NodeKind = "classdef"
 	Cleanup    NodeKind = "cleanup"
 	Do         NodeKind = "do"
+	Export     NodeKind = "export"
 	ForIn      NodeKind = "forin"
 	ForNum     NodeKind = "fornum"
 	FuncCall   NodeKind = "funccall"
@@ -19,7 +21,9 @@ const (
 	Identifier NodeKind = "identifier"
 	If         NodeKind = "if"
 	IfClause   NodeKind = "ifclause"
+	Import     NodeKind = "import"
 	Index      NodeKind = "index"
+	Match      NodeKind = "match"
 	Member     NodeKind = "member"
 	Next       NodeKind = "next"
 	Nil        NodeKind = "nil"
@@ -27,6 +31,7 @@ const (
 	Range      NodeKind = "range"
 	Return     NodeKind = "return"
 	Root       NodeKind = "root"
+	Select     NodeKind = "select"
 	Spread     NodeKind = "spread"
 	String     NodeKind = "string"
 	Table      NodeKind = "table"
@@ -35,6 +40,7 @@ const (
 	Ternary    NodeKind = "ternary"
 	Unary      NodeKind = "unary"
 	While      NodeKind = "while"
+	Yield      NodeKind = "yield"
 )
 
 var invalid = Object{Kind: Invalid}
@@ -44,6 +50,7 @@ type (
 		Kind        NodeKind `json:"kind"`
 		Name        string   `json:"name,omitempty"`
 		Parent      string   `json:"parent,omitempty"`
+		Type        string   `json:"type,omitempty"`
 		NumberValue float64  `json:"number,omitempty"`
 		StringValue string   `json:"string,omitempty"`
 		BoolValue   bool     `json:"bool,omitempty"`
@@ -57,6 +64,20 @@ type (
 		Catches     []Object `json:"catches,omitempty"`
 		Private     bool     `json:"private,omitempty"`
 		Static      bool     `json:"static,omitempty"`
+		Generator   bool     `json:"generator,omitempty"` // a func that yields
 		Pos         [4]int   `json:"position"`
+
+		// Comments come before the node and EndComments come before the end of
+		// the block in the node. Blank marks an empty line before the node.
+		Comments    []Comment `json:"comments,omitempty"`
+		EndComments []Comment `json:"endComments,omitempty"`
+		Blank       bool      `json:"blank,omitempty"`
+	}
+
+	// Comment is kept with the nodes so that source can be printed again.
+	Comment struct {
+		Text   string `json:"text"`
+		Inline bool   `json:"inline,omitempty"` // on the same line as the code before it
+		Blank  bool   `json:"blank,omitempty"`  // an empty line came before the comment
 	}
 )
@@ -28,7 +28,13 @@ type parser struct {
 	prev, tk, ahead token
 	inLoop          int
 	inClass         int
+	inValue         int // in the cases of a match that is used as a value
+	inFunc          int
+	depth           int  // how many blocks deep the parse is, 1 is the top of the file
+	yields          bool // the func being parsed has a yield in it
 	locations       [][4]int
+	trivia          []Comment
+	errors          ParseErrors
 }
 
 func ParseFile(filepath string) (Object, error) {
@@ -55,16 +61,74 @@ func ParseStr(source string) (Object, error) {
 	return p.parse()
 }
 
+// parse parses the whole source. Syntax errors do not stop the parse, they are
+// collected and returned together with as much of the tree as could be parsed.
 func (p *parser) parse() (Object, error) {
 	if err := p.next(); err != nil {
 		return Object{}, err
 	}
 	p.pushLoc()
-	statements, catches, err := p.block()
-	if err != nil {
-		return Object{}, err
+	root := Object{Kind: Root}
+	for {
+		statements, catches, err := p.block()
+		if err != nil {
+			return Object{}, err
+		}
+		root.Block = append(root.Block, statements...)
+		root.Catches = append(root.Catches, catches...)
+		if p.tk.t == tkEOS {
+			break
+		}
+		p.errors = append(p.errors, p.parseError("unexpected %v", runeToStr(p.tk.t)).(ParseErr))
+		if err := p.next(); err != nil {
+			return Object{}, err
+		}
 	}
-	return Object{Kind: Root, Block: statements, Catches: catches, Pos: p.popLoc()}, nil
+	root.EndComments = p.takeComments()
+	root.Pos = p.popLoc()
+	if len(p.errors) > 0 {
+		return root, p.errors
+	}
+	return root, nil
+}
+
+// recover records a syntax error and skips ahead to where the next statement
+// is likely to start so that parsing can continue. Any other error is returned.
+func (p *parser) recover(err error, start token, depth int) error {
+	perr, ok := err.(ParseErr)
+	if !ok {
+		return err
+	}
+	p.errors = append(p.errors, perr)
+	p.locations = p.locations[:depth]
+	if p.tk.loc == start.loc && p.tk.t == start.t {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	for p.tk.t != tkEOS {
+		switch p.tk.t {
+		case tkEnd, tkFunction, tkClass, tkExport, tkImport, tkElse, tkElseif, tkCleanup, tkCase:
+			return nil
+		}
+		if p.tk.loc[0] > p.prev.loc[2] {
+			return nil
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeBlock expects the end of a block. If it is missing the error is recorded
+// and the block is treated as closed so that the node can still be used.
+func (p *parser) closeBlock() error {
+	if p.tk.t == tkEnd {
+		return p.next()
+	}
+	p.errors = append(p.errors, p.expectedErr(runeToStr(tkEnd)).(ParseErr))
+	return nil
 }
 
 func (p *parser) parseError(msg string, data ...interface{}) error {
@@ -103,17 +167,38 @@ func (p *parser) next() (err error) {
 	p.prev = p.tk
 	if p.ahead.t != tkEOS {
 		p.tk = p.ahead
-		p.ahead.t = tkEOS
+		p.ahead = token{t: tkEOS}
 	} else {
-		p.tk, err = p.scn.scan()
+		p.tk, err = p.scan()
 	}
+	p.trivia = append(p.trivia, p.tk.comments...)
 	return
 }
 
+// scan reads the next token, recording and skipping over malformed tokens.
+func (p *parser) scan() (token, error) {
+	for {
+		tk, err := p.scn.scan()
+		if perr, ok := err.(ParseErr); ok {
+			p.errors = append(p.errors, perr)
+			continue
+		}
+		return tk, err
+	}
+}
+
+// takeComments claims the comments that have been scanned so far. Comments that
+// are not claimed by the node they are in move on to the next node that does.
+func (p *parser) takeComments() []Comment {
+	comments := p.trivia
+	p.trivia = nil
+	return comments
+}
+
 func (p *parser) lookAhead() (token, error) {
 	var err error
 	if p.ahead.t == tkEOS {
-		p.ahead, err = p.scn.scan()
+		p.ahead, err = p.scan()
 	}
 	return p.ahead, err
 }
@@ -133,13 +218,35 @@ func (p *parser) expect(t rune) error {
 }
 
 func (p *parser) block() ([]Object, []Object, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	statements, err := p.statements()
+	if err != nil {
+		return statements, []Object{}, err
+	}
+	start, depth := p.tk, len(p.locations)
+	catches, err := p.consumecleanupStatement()
+	if err != nil {
+		err = p.recover(err, start, depth)
+	}
+	return statements, catches, err
+}
+
+// statements parses statements up until the end of the block they are in.
+func (p *parser) statements() ([]Object, error) {
 	var err error
 	statements := []Object{}
 	for !p.isBlockFollow() {
+		if p.tk.t == ';' {
+			if err := p.next(); err != nil {
+				return statements, err
+			}
+			continue
+		}
 		var statement Object
+		start, depth := p.tk, len(p.locations)
+		comments, blank := p.takeComments(), p.tk.blank
 		switch p.tk.t {
-		case ';':
-			continue
 		case tkIf:
 			statement, err = p.ifStatement()
 		case tkDo:
@@ -158,31 +265,125 @@ func (p *parser) block() ([]Object, []Object, error) {
 			statement, err = p.nextStatement()
 		case tkClass:
 			statement, err = p.classStatement()
+		case tkMatch:
+			statement, err = p.matchExpression(true)
+		case tkSelect:
+			statement, err = p.selectStatement()
+		case tkYield:
+			statement, err = p.yieldExpression(true)
+		case tkExport:
+			statement, err = p.exportStatement()
+		case tkImport:
+			statement, err = p.importStatement()
 		default:
 			statement, err = p.assignmentOrCallStatement()
 		}
 		if err != nil {
-			return statements, []Object{}, err
+			if err := p.recover(err, start, depth); err != nil {
+				return statements, err
+			}
+			continue
 		}
 		if err := p.nextIf(';'); err != nil {
-			return statements, []Object{}, err
+			return statements, err
 		}
+		statement.Comments, statement.Blank = comments, blank
 		statements = append(statements, statement)
 	}
-
-	catches, err := p.consumecleanupStatement()
-	return statements, catches, err
+	return statements, nil
 }
 
 func (p *parser) isBlockFollow() bool {
 	switch p.tk.t {
-	case tkEOS, tkElseif, tkElse, tkEnd, tkCleanup:
+	case tkEOS, tkElseif, tkElse, tkEnd, tkCleanup, tkCase:
 		return true
 	default:
 		return false
 	}
 }
 
+// exportStatement parses a func, class or attr that a file exports. The
+// declaration is the Value of the export.
+func (p *parser) exportStatement() (Object, error) {
+	if p.depth > 1 {
+		return invalid, p.parseError("export can only be used at the top level of a file")
+	}
+	p.pushLoc()
+	if err := p.next(); err != nil {
+		return invalid, err
+	}
+	var decl Object
+	var err error
+	switch p.tk.t {
+	case tkFunction:
+		decl, err = p.functionDeclaration()
+		if err == nil && decl.Value.Kind != Identifier {
+			err = p.parseError("an exported func needs a plain name")
+		}
+	case tkClass:
+		decl, err = p.classStatement()
+	case tkAttr:
+		decl, err = p.attrDeclaration()
+	default:
+		err = p.expectedErr("func, class or attr")
+	}
+	if err != nil {
+		return invalid, err
+	}
+	return Object{Kind: Export, Value: &decl, Pos: p.popLoc()}, nil
+}
+
+// importStatement parses import {a, b as c} from "path". Vars are the names
+// the module exports and Vals the names they are bound to.
+func (p *parser) importStatement() (Object, error) {
+	if p.depth > 1 {
+		return invalid, p.parseError("import can only be used at the top level of a file")
+	}
+	p.pushLoc()
+	if err := p.next(); err != nil {
+		return invalid, err
+	} else if err := p.expect('{'); err != nil {
+		return invalid, err
+	}
+	names, locals := []Object{}, []Object{}
+	for p.tk.t != '}' {
+		name, err := p.identifier()
+		if err != nil {
+			return invalid, err
+		}
+		local := name
+		if p.tk.t == tkName && p.tk.stringValue == "as" {
+			if err := p.next(); err != nil {
+				return invalid, err
+			} else if local, err = p.identifier(); err != nil {
+				return invalid, err
+			}
+		}
+		names, locals = append(names, name), append(locals, local)
+		if p.tk.t != ',' {
+			break
+		} else if err := p.next(); err != nil {
+			return invalid, err
+		}
+	}
+	if err := p.expect('}'); err != nil {
+		return invalid, err
+	} else if len(names) == 0 {
+		return invalid, p.parseError("import needs at least one name")
+	} else if p.tk.t != tkName || p.tk.stringValue != "from" {
+		return invalid, p.expectedErr("from")
+	} else if err := p.next(); err != nil {
+		return invalid, err
+	} else if p.tk.t != tkString {
+		return invalid, p.expectedErr("<string>")
+	}
+	path := p.tk.stringValue
+	if err := p.next(); err != nil {
+		return invalid, err
+	}
+	return Object{Kind: Import, Vars: names, Vals: locals, StringValue: path, Pos: p.popLoc()}, nil
+}
+
 func (p *parser) breakStatement() (Object, error) {
 	if p.inLoop <= 0 {
 		return invalid, p.parseError("use of a break statement outside of a loop. break can only be used to effect iteration with for, while, and repeat loops")
@@ -232,43 +433,56 @@ func (p *parser) classStatement() (Object, error) {
 	}
 
 	statements := []Object{}
-	for p.tk.t != tkEnd {
+	for p.tk.t != tkEnd && p.tk.t != tkEOS {
+		if p.tk.t == ';' {
+			if err := p.next(); err != nil {
+				return invalid, err
+			}
+			continue
+		}
 		var statement Object
+		start, depth := p.tk, len(p.locations)
+		comments, blank := p.takeComments(), p.tk.blank
 		switch p.tk.t {
-		case ';':
-			continue
 		case tkFunction:
 			statement, err = p.functionDeclaration()
-			if statement.Value.Kind != Identifier {
-				return invalid, p.parseError("non identifier func name in class definition")
+			if err == nil && statement.Value.Kind != Identifier {
+				err = p.parseError("non identifier func name in class definition")
+			} else if err == nil {
+				statement.Private, statement.Static = nameVals(statement.Value.Name)
 			}
-			statement.Private, statement.Static = nameVals(statement.Value.Name)
 		case tkClass:
 			statement, err = p.classStatement()
 		case tkAttr:
 			statement, err = p.attrDeclaration()
 		default:
-			return invalid, p.parseError("unexpected statement in class definition")
+			err = p.parseError("unexpected statement in class definition")
 		}
 		if err != nil {
-			return invalid, err
+			if err := p.recover(err, start, depth); err != nil {
+				return invalid, err
+			}
+			continue
 		}
 		if err := p.nextIf(';'); err != nil {
 			return invalid, err
 		}
+		statement.Comments, statement.Blank = comments, blank
 		statements = append(statements, statement)
 	}
 
-	if err := p.nextIf(tkEnd); err != nil {
+	endComments := p.takeComments()
+	if err := p.closeBlock(); err != nil {
 		return invalid, err
 	}
 
 	return Object{
-		Kind:   ClassDef,
-		Name:   className.Name,
-		Parent: parentClass.Name,
-		Pos:    p.popLoc(),
-		Block:  statements,
+		Kind:        ClassDef,
+		Name:        className.Name,
+		Parent:      parentClass.Name,
+		Pos:         p.popLoc(),
+		Block:       statements,
+		EndComments: endComments,
 	}, nil
 }
 
@@ -279,6 +493,7 @@ func nameVals(name string) (private, static bool) {
 }
 
 func (p *parser) attrDeclaration() (Object, error) {
+	p.pushLoc()
 	if err := p.nextIf(tkAttr); err != nil {
 		return invalid, err
 	}
@@ -286,6 +501,10 @@ func (p *parser) attrDeclaration() (Object, error) {
 	if err != nil {
 		return invalid, err
 	}
+	typ, err := p.typeAnnotation()
+	if err != nil {
+		return invalid, err
+	}
 	private, static := nameVals(name.Name)
 	var val *Object
 	var refine *Object
@@ -312,15 +531,17 @@ func (p *parser) attrDeclaration() (Object, error) {
 	return Object{
 		Kind:    AttrDef,
 		Name:    name.Name,
+		Type:    typ,
 		Value:   val,
 		Cond:    refine,
 		Private: private,
 		Static:  static,
+		Pos:     p.popLoc(),
 	}, nil
 }
 
 func (p *parser) assignmentOrCallStatement() (Object, error) {
-	var lvalue bool
+	var lvalue, annotated bool
 	var base Object
 	var err error
 	var targets = []Object{}
@@ -337,8 +558,15 @@ func (p *parser) assignmentOrCallStatement() (Object, error) {
 			return p.expectedExpression()
 		}
 
+		if p.tk.t == ':' {
+			if base.Type, err = p.typeAnnotation(); err != nil {
+				return invalid, err
+			}
+			annotated = true
+		}
+
 	prefixExprLoop:
-		for {
+		for base.Type == "" {
 			switch p.tk.t {
 			case '.', '[': //indexing values on lvalue
 				lvalue = true
@@ -368,8 +596,8 @@ func (p *parser) assignmentOrCallStatement() (Object, error) {
 	}
 
 	if len(targets) == 1 && !lvalue {
-		return targets[0], nil
-	} else if lvalue && p.isAssignShortcut(p.tk) {
+		return p.operatorExpression(targets[0], 0)
+	} else if lvalue && !annotated && p.isAssignShortcut(p.tk) {
 		if len(targets) > 1 {
 			return invalid, p.parseError("cannot use assignment shortcut '%v' with multiple destinations", runeToStr(p.tk.t))
 		}
@@ -378,8 +606,8 @@ func (p *parser) assignmentOrCallStatement() (Object, error) {
 		return invalid, p.parseError("not all assignment targets are assignable")
 	}
 
-	if p.tk.t != '=' && len(targets) == 1 {
-		return targets[0], nil
+	if p.tk.t != '=' && len(targets) == 1 && !annotated {
+		return p.operatorExpression(targets[0], 0)
 	} else if err := p.expect('='); err != nil {
 		return invalid, err
 	}
@@ -415,6 +643,28 @@ func (p *parser) identifier() (Object, error) {
 	return idnt, p.next()
 }
 
+// typeAnnotation parses the optional `: Type` that can follow a name.
+// memberName is the name after a dot which can be a reserved word like next
+// because it cannot be mistaken for a statement there.
+func (p *parser) memberName() (Object, error) {
+	if p.tk.t >= firstReserved && p.tk.t < firstReserved+reservedCount {
+		idnt := Object{Kind: Identifier, Name: p.tk.String(), Pos: p.tk.loc}
+		return idnt, p.next()
+	}
+	return p.identifier()
+}
+
+func (p *parser) typeAnnotation() (string, error) {
+	if p.tk.t != ':' {
+		return "", nil
+	}
+	if err := p.next(); err != nil {
+		return "", err
+	}
+	name, err := p.identifier()
+	return name.Name, err
+}
+
 func (p *parser) shortcutAssign(target Object) (Object, error) {
 	var value Object
 	p.pushLoc()
@@ -505,7 +755,12 @@ func (p *parser) subExpression(minPrecedence int) (Object, error) {
 	if expression.Kind == Invalid {
 		return invalid, nil
 	}
+	return p.operatorExpression(expression, minPrecedence)
+}
 
+// operatorExpression parses the operators that can follow a left hand
+// expression.
+func (p *parser) operatorExpression(expression Object, minPrecedence int) (Object, error) {
 	if p.tk.t == tkSpread {
 		pos := p.tk.loc
 		if err := p.next(); err != nil {
@@ -575,6 +830,10 @@ func (p *parser) primaryExpression() (Object, error) {
 		return p.functionDeclaration()
 	} else if p.tk.t == '{' {
 		return p.tableConstructor()
+	} else if p.tk.t == tkMatch {
+		return p.matchExpression(false)
+	} else if p.tk.t == tkYield {
+		return p.yieldExpression(false)
 	}
 	return invalid, nil
 }
@@ -645,7 +904,7 @@ func (p *parser) prefixExpressionPart(base Object) (Object, error) {
 		if err := p.next(); err != nil {
 			return invalid, err
 		}
-		identifier, err := p.identifier()
+		identifier, err := p.memberName()
 		return Object{Kind: Member, Vals: []Object{base, identifier}, Pos: p.popLoc()}, err
 	case '(': // args
 		return p.callExpression(base)
@@ -682,7 +941,9 @@ func (p *parser) callExpression(base Object) (Object, error) {
 		args = append(args, expression)
 	}
 
-	p.expect(')')
+	if err := p.expect(')'); err != nil {
+		return invalid, err
+	}
 	return Object{Kind: FuncCall, Value: &base, Vals: args, Pos: p.endLoc(startLoc)}, nil
 }
 
@@ -694,6 +955,8 @@ func (p *parser) tableConstructor() (Object, error) {
 	loc := p.prev.loc
 	table := Object{Kind: Table}
 	for {
+		var entry Object
+		comments, blank := p.takeComments(), p.tk.blank
 		if p.tk.t == '[' {
 			p.pushLoc()
 			if err := p.next(); err != nil {
@@ -710,7 +973,7 @@ func (p *parser) tableConstructor() (Object, error) {
 				return invalid, err
 			}
 			value, err := p.expectedExpression()
-			table.Vals = append(table.Vals, Object{Kind: TableKey, Key: &key, Value: &value, Pos: p.popLoc()})
+			entry = Object{Kind: TableKey, Key: &key, Value: &value, Pos: p.popLoc()}
 		} else if p.tk.t == tkName {
 			p.pushLoc()
 			tk, err := p.lookAhead()
@@ -729,13 +992,13 @@ func (p *parser) tableConstructor() (Object, error) {
 				if err != nil {
 					return invalid, err
 				}
-				table.Vals = append(table.Vals, Object{Kind: TableKey, Key: &key, Value: &value, Pos: p.popLoc()})
+				entry = Object{Kind: TableKey, Key: &key, Value: &value, Pos: p.popLoc()}
 			} else {
 				value, err := p.expectedExpression()
 				if err != nil {
 					return invalid, err
 				}
-				table.Vals = append(table.Vals, Object{Kind: TableValue, Value: &value, Pos: p.popLoc()})
+				entry = Object{Kind: TableValue, Value: &value, Pos: p.popLoc()}
 			}
 		} else {
 			p.pushLoc()
@@ -744,10 +1007,14 @@ func (p *parser) tableConstructor() (Object, error) {
 				return invalid, err
 			}
 			if value.Kind == Invalid {
+				p.popLoc()
+				table.EndComments = comments
 				break
 			}
-			table.Vals = append(table.Vals, Object{Kind: TableValue, Value: &value, Pos: p.popLoc()})
+			entry = Object{Kind: TableValue, Value: &value, Pos: p.popLoc()}
 		}
+		entry.Comments, entry.Blank = comments, blank
+		table.Vals = append(table.Vals, entry)
 		if p.tk.t == ',' || p.tk.t == ';' {
 			if err := p.next(); err != nil {
 				return invalid, err
@@ -756,6 +1023,7 @@ func (p *parser) tableConstructor() (Object, error) {
 		}
 		break
 	}
+	table.EndComments = append(table.EndComments, p.takeComments()...)
 	table.Pos = p.endLoc(loc)
 	return table, p.expect('}')
 }
@@ -799,6 +1067,9 @@ func (p *parser) functionDeclaration() (Object, error) {
 					break
 				}
 
+				if parameter.Type, err = p.typeAnnotation(); err != nil {
+					return invalid, err
+				}
 				parameters = append(parameters, parameter)
 
 				if p.tk.t == ',' {
@@ -818,22 +1089,36 @@ func (p *parser) functionDeclaration() (Object, error) {
 	} else if err := p.next(); err != nil {
 		return invalid, err
 	}
+	returns, err := p.typeAnnotation()
+	if err != nil {
+		return invalid, err
+	}
 
+	inLoop, inValue, yields := p.inLoop, p.inValue, p.yields
+	p.inLoop, p.inValue, p.yields = 0, 0, false
+	p.inFunc++
 	body, catches, err := p.block()
+	generator := p.yields
+	p.inFunc--
+	p.inLoop, p.inValue, p.yields = inLoop, inValue, yields
 	if err != nil {
 		return invalid, err
 	}
-	if err := p.expect(tkEnd); err != nil {
+	endComments := p.takeComments()
+	if err := p.closeBlock(); err != nil {
 		return invalid, err
 	}
 
 	return Object{
-		Kind:    FuncDef,
-		Value:   &name,
-		Vars:    parameters,
-		Block:   body,
-		Catches: catches,
-		Pos:     p.popLoc(),
+		Kind:        FuncDef,
+		Type:        returns,
+		Value:       &name,
+		Vars:        parameters,
+		Block:       body,
+		Catches:     catches,
+		Generator:   generator,
+		EndComments: endComments,
+		Pos:         p.popLoc(),
 	}, nil
 }
 
@@ -859,6 +1144,9 @@ func (p *parser) functionName() (Object, error) {
 }
 
 func (p *parser) returnStatement() (Object, error) {
+	if p.inValue > 0 {
+		return invalid, p.parseError("use of a return statement in a match that is used as a value")
+	}
 	var expressions = []Object{}
 	p.pushLoc()
 	if err := p.nextIf(tkReturn); err != nil {
@@ -889,6 +1177,43 @@ func (p *parser) returnStatement() (Object, error) {
 	return Object{Kind: Return, Vals: expressions, Pos: p.popLoc()}, nil
 }
 
+// yieldExpression parses a yield which makes the func it is in a generator. As a
+// statement it can yield many values but as a value it yields only one so that
+// it can be used in lists of values like args. A yield without a value has to
+// end its line so that the next line is not taken as what it yields.
+func (p *parser) yieldExpression(statement bool) (Object, error) {
+	if p.inFunc <= 0 {
+		return invalid, p.parseError("use of yield outside of a func. yield can only be used to make a func a generator")
+	}
+	p.pushLoc()
+	line := p.tk.loc[0]
+	if err := p.nextIf(tkYield); err != nil {
+		return invalid, err
+	}
+	p.yields = true
+	values := []Object{}
+	if p.tk.t != tkEnd && p.tk.loc[0] == line {
+		expression, err := p.expression()
+		if err != nil {
+			return invalid, err
+		}
+		if expression.Kind != Invalid {
+			values = append(values, expression)
+		}
+		for statement && len(values) > 0 && p.tk.t == ',' {
+			if err := p.next(); err != nil {
+				return invalid, err
+			}
+			expression, err = p.expectedExpression()
+			if err != nil {
+				return invalid, err
+			}
+			values = append(values, expression)
+		}
+	}
+	return Object{Kind: Yield, Vals: values, Pos: p.popLoc()}, nil
+}
+
 func (p *parser) whileStatement() (Object, error) {
 	p.pushLoc()
 	if err := p.nextIf(tkWhile); err != nil {
@@ -896,17 +1221,21 @@ func (p *parser) whileStatement() (Object, error) {
 	}
 	p.inLoop++
 	defer func() { p.inLoop-- }()
-	if condition, err := p.expectedExpression(); err != nil {
+	condition, err := p.expectedExpression()
+	if err != nil {
 		return invalid, err
 	} else if err = p.expect(tkDo); err != nil {
 		return invalid, err
-	} else if body, catches, err := p.block(); err != nil {
+	}
+	body, catches, err := p.block()
+	if err != nil {
 		return invalid, err
-	} else if err = p.expect(tkEnd); err != nil {
+	}
+	endComments := p.takeComments()
+	if err = p.closeBlock(); err != nil {
 		return invalid, err
-	} else {
-		return Object{Kind: While, Cond: &condition, Block: body, Catches: catches, Pos: p.popLoc()}, nil
 	}
+	return Object{Kind: While, Cond: &condition, Block: body, Catches: catches, EndComments: endComments, Pos: p.popLoc()}, nil
 }
 
 func (p *parser) ifStatement() (Object, error) {
@@ -930,11 +1259,12 @@ func (p *parser) ifStatement() (Object, error) {
 		return invalid, err
 	}
 	statement.Block = append(statement.Block, Object{
-		Kind:    IfClause,
-		Cond:    &condition,
-		Block:   body,
-		Catches: catches,
-		Pos:     p.popLoc(),
+		Kind:        IfClause,
+		Cond:        &condition,
+		Block:       body,
+		Catches:     catches,
+		EndComments: p.takeComments(),
+		Pos:         p.popLoc(),
 	})
 
 	for p.tk.t == tkElseif {
@@ -955,11 +1285,12 @@ func (p *parser) ifStatement() (Object, error) {
 			return invalid, err
 		}
 		statement.Block = append(statement.Block, Object{
-			Kind:    IfClause,
-			Cond:    &condition,
-			Block:   body,
-			Catches: catches,
-			Pos:     p.popLoc(),
+			Kind:        IfClause,
+			Cond:        &condition,
+			Block:       body,
+			Catches:     catches,
+			EndComments: p.takeComments(),
+			Pos:         p.popLoc(),
 		})
 	}
 
@@ -973,15 +1304,15 @@ func (p *parser) ifStatement() (Object, error) {
 			return invalid, err
 		}
 		statement.Block = append(statement.Block, Object{
-			Kind:    IfClause,
-			Block:   body,
-			Catches: catches,
-			Pos:     p.popLoc(),
+			Kind:        IfClause,
+			Block:       body,
+			Catches:     catches,
+			EndComments: p.takeComments(),
+			Pos:         p.popLoc(),
 		})
 	}
 
-	err = p.expect(tkEnd)
-	if err != nil {
+	if err = p.closeBlock(); err != nil {
 		return invalid, err
 	}
 	statement.Pos = p.popLoc()
@@ -1027,20 +1358,21 @@ func (p *parser) forStatement() (Object, error) {
 		if err != nil {
 			return invalid, err
 		}
-		err = p.expect(tkEnd)
-		if err != nil {
+		endComments := p.takeComments()
+		if err = p.closeBlock(); err != nil {
 			return invalid, err
 		}
 
 		return Object{
-			Kind:    ForNum,
-			Name:    variable.Name,
-			Value:   &start,
-			Step:    &step,
-			Cond:    &cond,
-			Block:   body,
-			Catches: catches,
-			Pos:     p.popLoc(),
+			Kind:        ForNum,
+			Name:        variable.Name,
+			Value:       &start,
+			Step:        &step,
+			Cond:        &cond,
+			Block:       body,
+			Catches:     catches,
+			EndComments: endComments,
+			Pos:         p.popLoc(),
 		}, nil
 	}
 
@@ -1070,18 +1402,19 @@ func (p *parser) forStatement() (Object, error) {
 	if err != nil {
 		return invalid, err
 	}
-	err = p.expect(tkEnd)
-	if err != nil {
+	endComments := p.takeComments()
+	if err = p.closeBlock(); err != nil {
 		return invalid, err
 	}
 
 	return Object{
-		Kind:    ForIn,
-		Vars:    variables,
-		Value:   &iterator,
-		Block:   body,
-		Catches: catches,
-		Pos:     p.popLoc(),
+		Kind:        ForIn,
+		Vars:        variables,
+		Value:       &iterator,
+		Block:       body,
+		Catches:     catches,
+		EndComments: endComments,
+		Pos:         p.popLoc(),
 	}, nil
 }
 
@@ -1089,13 +1422,298 @@ func (p *parser) doStatement() (Object, error) {
 	p.pushLoc()
 	if err := p.nextIf(tkDo); err != nil {
 		return invalid, err
-	} else if body, catches, err := p.block(); err != nil {
+	}
+	body, catches, err := p.block()
+	if err != nil {
 		return invalid, err
-	} else if err := p.expect(tkEnd); err != nil {
+	}
+	endComments := p.takeComments()
+	if err := p.closeBlock(); err != nil {
 		return invalid, err
-	} else {
-		return Object{Kind: Do, Block: body, Catches: catches, Pos: p.popLoc()}, nil
 	}
+	return Object{Kind: Do, Block: body, Catches: catches, EndComments: endComments, Pos: p.popLoc()}, nil
+}
+
+// matchExpression parses a match. A match used as a value cannot break out of
+// a loop or return from within its cases because they result in its value.
+func (p *parser) matchExpression(statement bool) (Object, error) {
+	p.pushLoc()
+	if err := p.nextIf(tkMatch); err != nil {
+		return invalid, err
+	}
+	subject, err := p.expectedExpression()
+	if err != nil {
+		return invalid, err
+	} else if err = p.expect(tkDo); err != nil {
+		return invalid, err
+	}
+	if !statement {
+		inLoop := p.inLoop
+		p.inLoop = 0
+		p.inValue++
+		defer func() {
+			p.inLoop = inLoop
+			p.inValue--
+		}()
+	}
+
+	cases := []Object{}
+	for p.tk.t == tkCase {
+		comments, blank := p.takeComments(), p.tk.blank
+		p.pushLoc()
+		if err := p.next(); err != nil {
+			return invalid, err
+		}
+		pattern, err := p.pattern()
+		if err != nil {
+			return invalid, err
+		}
+		var guard *Object
+		if p.tk.t == tkIf {
+			if err := p.next(); err != nil {
+				return invalid, err
+			}
+			cond, err := p.expectedExpression()
+			if err != nil {
+				return invalid, err
+			}
+			guard = &cond
+		}
+		if err := p.nextIf(tkThen); err != nil {
+			return invalid, err
+		}
+		body, err := p.statements()
+		if err != nil {
+			return invalid, err
+		}
+		cases = append(cases, Object{
+			Kind:     Case,
+			Key:      &pattern,
+			Cond:     guard,
+			Block:    body,
+			Comments: comments,
+			Blank:    blank,
+			Pos:      p.popLoc(),
+		})
+	}
+
+	start, depth := p.tk, len(p.locations)
+	catches, err := p.consumecleanupStatement()
+	if err != nil {
+		if err = p.recover(err, start, depth); err != nil {
+			return invalid, err
+		}
+	}
+	endComments := p.takeComments()
+	if err := p.closeBlock(); err != nil {
+		return invalid, err
+	}
+	return Object{
+		Kind:        Match,
+		Value:       &subject,
+		Block:       cases,
+		Catches:     catches,
+		EndComments: endComments,
+		Pos:         p.popLoc(),
+	}, nil
+}
+
+// selectStatement parses a select which waits on the channel operations of its
+// cases. A case either receives like `case msg = ch.recv()`, sends like
+// `case ch.send(msg)` or is `case _` which runs if no other case is ready.
+func (p *parser) selectStatement() (Object, error) {
+	p.pushLoc()
+	if err := p.nextIf(tkSelect); err != nil {
+		return invalid, err
+	} else if err := p.expect(tkDo); err != nil {
+		return invalid, err
+	}
+
+	cases := []Object{}
+	for p.tk.t == tkCase {
+		comments, blank := p.takeComments(), p.tk.blank
+		p.pushLoc()
+		if err := p.next(); err != nil {
+			return invalid, err
+		}
+		clause := Object{Kind: Case, Comments: comments, Blank: blank}
+		tk, err := p.lookAhead()
+		if err != nil {
+			return invalid, err
+		}
+		if p.tk.t == tkName && p.tk.stringValue == "_" {
+			if err := p.next(); err != nil {
+				return invalid, err
+			}
+		} else {
+			if p.tk.t == tkName && tk.t == '=' {
+				clause.Name = p.tk.stringValue
+				if err := p.next(); err != nil {
+					return invalid, err
+				} else if err := p.next(); err != nil {
+					return invalid, err
+				}
+			}
+			op, err := p.channelOperation(clause.Name != "")
+			if err != nil {
+				return invalid, err
+			}
+			clause.Value = &op
+		}
+		if err := p.nextIf(tkThen); err != nil {
+			return invalid, err
+		}
+		if clause.Block, err = p.statements(); err != nil {
+			return invalid, err
+		}
+		clause.Pos = p.popLoc()
+		cases = append(cases, clause)
+	}
+
+	start, depth := p.tk, len(p.locations)
+	catches, err := p.consumecleanupStatement()
+	if err != nil {
+		if err = p.recover(err, start, depth); err != nil {
+			return invalid, err
+		}
+	}
+	endComments := p.takeComments()
+	if err := p.closeBlock(); err != nil {
+		return invalid, err
+	}
+	return Object{
+		Kind:        Select,
+		Block:       cases,
+		Catches:     catches,
+		EndComments: endComments,
+		Pos:         p.popLoc(),
+	}, nil
+}
+
+// channelOperation parses the call to recv or send in a case of a select.
+func (p *parser) channelOperation(recv bool) (Object, error) {
+	op, err := p.expectedExpression()
+	if err != nil {
+		return invalid, err
+	}
+	if op.Kind == FuncCall && op.Value.Kind == Member {
+		switch name := op.Value.Vals[1].Name; {
+		case name == "recv" && len(op.Vals) == 0:
+			return op, nil
+		case name == "send" && len(op.Vals) == 1 && !recv:
+			return op, nil
+		}
+	}
+	if recv {
+		return invalid, p.parseError("a select can only assign what it receives with recv()")
+	}
+	return invalid, p.parseError("a select case has to call recv() or send(value) on a channel")
+}
+
+// pattern parses what a case in a match is compared against. Patterns are
+// literals, names that bind the value and can be typed like `e: Error`, and
+// tables that destructure the value.
+func (p *parser) pattern() (Object, error) {
+	switch p.tk.t {
+	case tkName:
+		name, err := p.identifier()
+		if err != nil {
+			return invalid, err
+		}
+		name.Type, err = p.typeAnnotation()
+		return name, err
+	case '{':
+		return p.tablePattern()
+	case '-':
+		pos := p.tk.loc
+		if err := p.next(); err != nil {
+			return invalid, err
+		} else if p.tk.t != tkNumber {
+			return invalid, p.expectedErr("<number>")
+		}
+		pos[2], pos[3] = p.tk.loc[2], p.tk.loc[3]
+		val := Object{Kind: Number, NumberValue: -p.tk.numberValue, Pos: pos}
+		return val, p.next()
+	case tkNumber, tkString, tkTrue, tkFalse, tkNil:
+		return p.primaryExpression()
+	}
+	return invalid, p.expectedErr("pattern")
+}
+
+func (p *parser) tablePattern() (Object, error) {
+	p.pushLoc()
+	if err := p.nextIf('{'); err != nil {
+		return invalid, err
+	}
+	table := Object{Kind: Table}
+	spread := false
+	for p.tk.t != '}' {
+		if spread {
+			return invalid, p.parseError("a spread can only be the last value of a pattern")
+		}
+		p.pushLoc()
+		var entry Object
+		tk, err := p.lookAhead()
+		if err != nil {
+			return invalid, err
+		}
+		if p.tk.t == '[' || (p.tk.t == tkName && tk.t == ':') {
+			key, err := p.patternKey()
+			if err != nil {
+				return invalid, err
+			} else if err := p.expect(':'); err != nil {
+				return invalid, err
+			}
+			value, err := p.pattern()
+			if err != nil {
+				return invalid, err
+			}
+			entry = Object{Kind: TableKey, Key: &key, Value: &value, Pos: p.popLoc()}
+		} else if p.tk.t == tkName && tk.t == tkSpread {
+			name, err := p.identifier()
+			if err != nil {
+				return invalid, err
+			}
+			value := Object{Kind: Spread, Value: &name, Pos: p.tk.loc}
+			if err := p.next(); err != nil {
+				return invalid, err
+			}
+			spread = true
+			entry = Object{Kind: TableValue, Value: &value, Pos: p.popLoc()}
+		} else {
+			value, err := p.pattern()
+			if err != nil {
+				return invalid, err
+			}
+			entry = Object{Kind: TableValue, Value: &value, Pos: p.popLoc()}
+		}
+		table.Vals = append(table.Vals, entry)
+		if p.tk.t != ',' {
+			break
+		} else if err := p.next(); err != nil {
+			return invalid, err
+		}
+	}
+	if err := p.expect('}'); err != nil {
+		return invalid, err
+	}
+	table.Pos = p.popLoc()
+	return table, nil
+}
+
+func (p *parser) patternKey() (Object, error) {
+	if p.tk.t == tkName {
+		return p.identifier()
+	} else if err := p.expect('['); err != nil {
+		return invalid, err
+	}
+	key, err := p.pattern()
+	if err != nil {
+		return invalid, err
+	} else if key.Kind == Identifier || key.Kind == Table {
+		return invalid, p.parseError("table pattern keys have to be literals")
+	}
+	return key, p.expect(']')
 }
 
 func (p *parser) consumecleanupStatement() ([]Object, error) {
@@ -1111,6 +1729,7 @@ func (p *parser) consumecleanupStatement() ([]Object, error) {
 }
 
 func (p *parser) cleanupStatement() (Object, error) {
+	comments := p.takeComments()
 	p.pushLoc()
 	if err := p.nextIf(tkCleanup); err != nil {
 		return invalid, err
@@ -1154,7 +1773,7 @@ func (p *parser) cleanupStatement() (Object, error) {
 		return invalid, err
 	}
 
-	return Object{Kind: Cleanup, Name: name, Block: body, Catches: catches, Vars: errorClasses, Pos: p.popLoc()}, nil
+	return Object{Kind: Cleanup, Name: name, Block: body, Catches: catches, Vars: errorClasses, Comments: comments, Pos: p.popLoc()}, nil
 }
 
 func (p *parser) isUnary(tk token) bool {
@@ -2,6 +2,7 @@ package lang
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/tanema/squirt/src/excerpt"
 )
@@ -34,3 +35,20 @@ func (err ParseErr) Error() string {
 		err.token.loc[1],
 	)
 }
+
+// Message is the description of the error without the source excerpt.
+func (err ParseErr) Message() string { return err.msg }
+
+// Pos is the location of the token that caused the error.
+func (err ParseErr) Pos() [4]int { return err.token.loc }
+
+// ParseErrors is every syntax error that was found while parsing a source.
+type ParseErrors []ParseErr
+
+func (errs ParseErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -2,7 +2,205 @@ package lang
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestParser(t *testing.T) {
 }
+
+func TestParseRecovery(t *testing.T) {
+	src := `a = 1
+b = = 2
+func foo(x)
+  y = x +
+  return y
+end
+print("ok"
+class Foo do
+  blah
+  attr name
+end
+c = 3
+`
+	root, err := ParseStr(src)
+	errs, ok := err.(ParseErrors)
+	if !assert.True(t, ok, "expected ParseErrors") {
+		return
+	}
+	lines := []int{}
+	for _, perr := range errs {
+		lines = append(lines, perr.Pos()[0])
+	}
+	assert.Equal(t, []int{2, 5, 8, 9}, lines)
+
+	kinds := []NodeKind{}
+	for _, stmt := range root.Block {
+		kinds = append(kinds, stmt.Kind)
+	}
+	assert.Equal(t, []NodeKind{Assignment, FuncDef, ClassDef, Assignment}, kinds)
+	assert.Equal(t, "name", root.Block[2].Block[0].Name)
+}
+
+func TestParseMissingEnd(t *testing.T) {
+	root, err := ParseStr("func foo()\n  print(1)\n")
+	errs, ok := err.(ParseErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "expected end but found <eof>", errs[0].Message())
+	assert.Len(t, root.Block, 1)
+	assert.Equal(t, FuncDef, root.Block[0].Kind)
+}
+
+func TestParseStrayEnd(t *testing.T) {
+	root, err := ParseStr("a = 1\nend\nb = 2\n")
+	errs, ok := err.(ParseErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+	assert.Len(t, root.Block, 2)
+}
+
+func TestParseAnnotations(t *testing.T) {
+	root, err := ParseStr(`func add(a: Number, b): Number
+  c: Number, d = a, b
+  return c
+end
+class Foo do
+  attr name: String = "dave"
+end
+`)
+	assert.Nil(t, err)
+	fn := root.Block[0]
+	assert.Equal(t, "Number", fn.Type)
+	assert.Equal(t, "Number", fn.Vars[0].Type)
+	assert.Equal(t, "", fn.Vars[1].Type)
+	assert.Equal(t, "Number", fn.Block[0].Vars[0].Type)
+	assert.Equal(t, "", fn.Block[0].Vars[1].Type)
+	assert.Equal(t, "String", root.Block[1].Block[0].Type)
+
+	_, err = ParseStr("a: Number\n")
+	assert.NotNil(t, err)
+}
+
+func TestParseMatch(t *testing.T) {
+	root, err := ParseStr(`x = match v do
+  case -1 then "minus one"
+  case n: Number if n > 0 then n
+  case {x: 0, [1]: y, rest...}
+    y
+cleanup Error do
+  nil
+end
+`)
+	assert.Nil(t, err)
+	match := root.Block[0].Vals[0]
+	assert.Equal(t, Match, match.Kind)
+	assert.Len(t, match.Block, 3)
+	assert.Len(t, match.Catches, 1)
+	assert.Equal(t, -1.0, match.Block[0].Key.NumberValue)
+	assert.Equal(t, "Number", match.Block[1].Key.Type)
+	assert.NotNil(t, match.Block[1].Cond)
+	table := match.Block[2].Key
+	assert.Equal(t, Table, table.Kind)
+	assert.Equal(t, TableKey, table.Vals[1].Kind)
+	assert.Equal(t, Spread, table.Vals[2].Value.Kind)
+
+	for _, src := range []string{
+		"x = match v do\n  case 1 then return 1\nend\n",
+		"while true do\n  x = match v do\n    case 1 then break\n  end\nend\n",
+		"match v do\n  case {rest..., a} then a\nend\n",
+		"match v do\n  case a + 1 then a\nend\n",
+	} {
+		_, err = ParseStr(src)
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestParseSelect(t *testing.T) {
+	root, err := ParseStr(`select do
+  case msg = inbox.recv() then print(msg)
+  case out.send(1)
+    print("sent")
+  case _ then print("none")
+end
+`)
+	assert.Nil(t, err)
+	sel := root.Block[0]
+	assert.Equal(t, Select, sel.Kind)
+	assert.Len(t, sel.Block, 3)
+	assert.Equal(t, "msg", sel.Block[0].Name)
+	assert.Equal(t, FuncCall, sel.Block[0].Value.Kind)
+	assert.Equal(t, "", sel.Block[1].Name)
+	assert.Nil(t, sel.Block[2].Value)
+
+	for _, src := range []string{
+		"select do\n  case msg = inbox.send(1) then print(msg)\nend\n",
+		"select do\n  case inbox.close() then print(1)\nend\n",
+		"select do\n  case x then print(1)\nend\n",
+	} {
+		_, err = ParseStr(src)
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestParseYield(t *testing.T) {
+	root, err := ParseStr(`func pairs(tbl)
+  for k, v in tbl do
+    yield k, v
+  end
+  got = yield
+  print(yield got + 1)
+  func inner()
+    return 1
+  end
+end
+gen.next()
+`)
+	assert.Nil(t, err)
+	fn := root.Block[0]
+	assert.True(t, fn.Generator)
+	assert.False(t, fn.Block[3].Generator)
+	assert.Len(t, fn.Block[0].Block[0].Vals, 2)
+	assert.Equal(t, Yield, fn.Block[1].Vals[0].Kind)
+	assert.Len(t, fn.Block[1].Vals[0].Vals, 0)
+	assert.Equal(t, Binary, fn.Block[2].Vals[0].Vals[0].Kind)
+	assert.Equal(t, "next", root.Block[1].Value.Vals[1].Name)
+
+	_, err = ParseStr("yield 1\n")
+	assert.NotNil(t, err)
+}
+
+func TestParseExportImport(t *testing.T) {
+	root, err := ParseStr(`import {double, Point as P} from "./shapes"
+export func area(w, h)
+  return w * h
+end
+export class Square do
+end
+export attr version = "1.0"
+`)
+	assert.Nil(t, err)
+	imp := root.Block[0]
+	assert.Equal(t, Import, imp.Kind)
+	assert.Equal(t, "./shapes", imp.StringValue)
+	assert.Equal(t, "Point", imp.Vars[1].Name)
+	assert.Equal(t, "double", imp.Vals[0].Name)
+	assert.Equal(t, "P", imp.Vals[1].Name)
+	assert.Equal(t, FuncDef, root.Block[1].Value.Kind)
+	assert.Equal(t, "area", root.Block[1].Value.Value.Name)
+	assert.Equal(t, "Square", root.Block[2].Value.Name)
+	assert.Equal(t, AttrDef, root.Block[3].Value.Kind)
+
+	for _, src := range []string{
+		"export x = 1\n",
+		"export func(a) end\n",
+		"func f()\n  export func g() end\nend\n",
+		"if true then import {a} from \"b\" end\n",
+		"import {} from \"b\"\n",
+		"import {a} \"b\"\n",
+		"import {a} from b\n",
+	} {
+		_, err = ParseStr(src)
+		assert.NotNil(t, err, src)
+	}
+}
@@ -0,0 +1,536 @@
+package lang
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const indentation = "  "
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+// Format prints a parsed file back out as canonical source. Parsing the result
+// gives back the same tree apart from positions.
+func Format(root Object) []byte {
+	p := &printer{}
+	p.statements(root.Block)
+	p.catches(root.Catches)
+	p.comments(root.EndComments)
+	out := bytes.Trim(p.buf.Bytes(), "\n")
+	if len(out) == 0 {
+		return out
+	}
+	return append(out, '\n')
+}
+
+// FormatFile parses and formats a source file.
+func FormatFile(path string) ([]byte, error) {
+	root, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Format(root), nil
+}
+
+func (p *printer) write(strs ...string) {
+	for _, str := range strs {
+		p.buf.WriteString(str)
+	}
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat(indentation, p.indent))
+}
+
+// comments prints comments that come before a node. Inline comments go at the
+// end of the line before and every other comment gets its own line.
+func (p *printer) comments(comments []Comment) {
+	for _, comment := range comments {
+		if comment.Inline && p.buf.Len() > 0 {
+			p.write(" ", comment.Text)
+			continue
+		}
+		if comment.Blank {
+			p.buf.WriteByte('\n')
+		}
+		p.newline()
+		p.write(comment.Text)
+	}
+}
+
+func (p *printer) statements(block []Object) {
+	for _, stmt := range block {
+		p.comments(stmt.Comments)
+		if stmt.Blank {
+			p.buf.WriteByte('\n')
+		}
+		p.newline()
+		p.statement(stmt)
+	}
+}
+
+// block prints an indented block, its cleanups and the end of it.
+func (p *printer) block(obj Object) {
+	p.indent++
+	p.statements(obj.Block)
+	p.indent--
+	p.catches(obj.Catches)
+	p.indent++
+	p.comments(obj.EndComments)
+	p.indent--
+}
+
+func (p *printer) catches(catches []Object) {
+	for _, catch := range catches {
+		p.comments(catch.Comments)
+		p.newline()
+		p.write("cleanup ")
+		if catch.Name != "" {
+			p.write(catch.Name, " = ")
+		}
+		p.list(catch.Vars)
+		p.write(" do")
+		p.indent++
+		p.statements(catch.Block)
+		p.indent--
+		p.catches(catch.Catches)
+	}
+}
+
+func (p *printer) end() {
+	p.newline()
+	p.write("end")
+}
+
+func (p *printer) statement(obj Object) {
+	switch obj.Kind {
+	case Assignment:
+		p.assignment(obj)
+	case Return:
+		p.write("return")
+		if len(obj.Vals) > 0 {
+			p.write(" ")
+			p.list(obj.Vals)
+		}
+	case Break:
+		p.write("break")
+	case Next:
+		p.write("next")
+	case If:
+		for i, clause := range obj.Block {
+			if i > 0 {
+				p.newline()
+			}
+			if i == 0 {
+				p.write("if ")
+			} else if clause.Cond != nil {
+				p.write("elseif ")
+			} else {
+				p.write("else")
+			}
+			if clause.Cond != nil {
+				p.expr(*clause.Cond)
+				p.write(" then")
+			}
+			p.block(clause)
+		}
+		p.end()
+	case Do:
+		p.write("do")
+		p.block(obj)
+		p.end()
+	case While:
+		p.write("while ")
+		p.expr(*obj.Cond)
+		p.write(" do")
+		p.block(obj)
+		p.end()
+	case ForNum:
+		p.write("for ", obj.Name, " = ")
+		p.expr(*obj.Value)
+		p.write(", ")
+		p.expr(*obj.Cond)
+		p.write(", ")
+		p.statement(*obj.Step)
+		p.write(" do")
+		p.block(obj)
+		p.end()
+	case ForIn:
+		p.write("for ")
+		p.list(obj.Vars)
+		p.write(" in ")
+		p.expr(*obj.Value)
+		p.write(" do")
+		p.block(obj)
+		p.end()
+	case ClassDef:
+		p.write("class ", obj.Name)
+		if obj.Parent != "" {
+			p.write(" isa ", obj.Parent)
+		}
+		p.write(" do")
+		p.block(obj)
+		p.end()
+	case AttrDef:
+		p.write("attr ", obj.Name)
+		if obj.Type != "" {
+			p.write(": ", obj.Type)
+		}
+		if obj.Value != nil {
+			p.write(" = ")
+			p.expr(*obj.Value)
+			if obj.Cond != nil {
+				p.write(", ")
+				p.expr(*obj.Cond)
+			}
+		}
+	case Export:
+		p.write("export ")
+		p.statement(*obj.Value)
+	case Import:
+		p.write("import {")
+		for i, name := range obj.Vars {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(name.Name)
+			if local := obj.Vals[i].Name; local != name.Name {
+				p.write(" as ", local)
+			}
+		}
+		p.write("} from ", quote(obj.StringValue))
+	case Select:
+		p.write("select do")
+		p.cases(obj, func(clause Object) {
+			if clause.Value == nil {
+				p.write("_")
+				return
+			} else if clause.Name != "" {
+				p.write(clause.Name, " = ")
+			}
+			p.expr(*clause.Value)
+		})
+	default:
+		p.expr(obj)
+	}
+}
+
+// cases prints the cases of a match or select up to the end of it. A case with
+// a single statement that was written on the same line stays on one line.
+func (p *printer) cases(obj Object, head func(Object)) {
+	p.indent++
+	for _, clause := range obj.Block {
+		p.comments(clause.Comments)
+		if clause.Blank {
+			p.buf.WriteByte('\n')
+		}
+		p.newline()
+		p.write("case ")
+		head(clause)
+		if len(clause.Block) == 1 && clause.Block[0].Pos[0] == clause.Pos[0] && len(clause.Block[0].Comments) == 0 {
+			p.write(" then ")
+			p.statement(clause.Block[0])
+			continue
+		}
+		p.indent++
+		p.statements(clause.Block)
+		p.indent--
+	}
+	p.indent--
+	p.catches(obj.Catches)
+	p.indent++
+	p.comments(obj.EndComments)
+	p.indent--
+	p.end()
+}
+
+// assignment prints an assignment, using the shortcut form for assignments
+// that the parser would have expanded from one.
+func (p *printer) assignment(obj Object) {
+	if len(obj.Vars) == 1 && len(obj.Vals) == 1 {
+		target, val := obj.Vars[0], obj.Vals[0]
+		if val.Kind == Binary && samePositionless(val.Vals[0], target) {
+			right := val.Vals[1]
+			one := right.Kind == Number && right.NumberValue == 1
+			switch {
+			case val.Name == "+" && one:
+				p.expr(target)
+				p.write("++")
+				return
+			case val.Name == "-" && one:
+				p.expr(target)
+				p.write("--")
+				return
+			case val.Name == "+" || val.Name == "-" || val.Name == "<<":
+				p.expr(target)
+				op := map[string]string{"+": " += ", "-": " -= ", "<<": " << "}[val.Name]
+				p.write(op)
+				p.expr(right)
+				return
+			}
+		}
+	}
+	p.list(obj.Vars)
+	p.write(" = ")
+	p.list(obj.Vals)
+}
+
+func (p *printer) list(objs []Object) {
+	for i, obj := range objs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(obj)
+	}
+}
+
+func (p *printer) expr(obj Object) {
+	switch obj.Kind {
+	case Identifier:
+		p.write(obj.Name)
+		if obj.Type != "" {
+			p.write(": ", obj.Type)
+		}
+	case Number:
+		p.write(formatNumber(obj.NumberValue))
+	case String:
+		p.write(quote(obj.StringValue))
+	case Bool:
+		p.write(strconv.FormatBool(obj.BoolValue))
+	case Nil:
+		p.write("nil")
+	case Table:
+		p.table(obj)
+	case FuncDef:
+		p.write("func")
+		if obj.Value != nil && obj.Value.Kind != "" {
+			p.write(" ")
+			p.expr(*obj.Value)
+		}
+		p.write("(")
+		p.list(obj.Vars)
+		p.write(")")
+		if obj.Type != "" {
+			p.write(": ", obj.Type)
+		}
+		p.block(obj)
+		p.end()
+	case Match:
+		p.write("match ")
+		p.expr(*obj.Value)
+		p.write(" do")
+		p.cases(obj, func(clause Object) {
+			p.expr(*clause.Key)
+			if clause.Cond != nil {
+				p.write(" if ")
+				p.expr(*clause.Cond)
+			}
+		})
+	case FuncCall:
+		p.prefix(*obj.Value)
+		p.write("(")
+		p.list(obj.Vals)
+		p.write(")")
+	case Member:
+		p.prefix(obj.Vals[0])
+		p.write(".", obj.Vals[1].Name)
+	case Index:
+		p.prefix(obj.Vals[0])
+		p.write("[")
+		p.expr(obj.Vals[1])
+		p.write("]")
+	case Range:
+		p.expr(obj.Vals[0])
+		p.write(":")
+		p.expr(obj.Vals[1])
+	case Spread:
+		p.wrapped(*obj.Value, !isPrefix(*obj.Value) && !isLiteral(*obj.Value))
+		p.write("...")
+	case Unary:
+		p.write(obj.Name)
+		arg := *obj.Value
+		wrap := arg.Kind == Ternary || arg.Kind == Yield ||
+			(arg.Kind == Binary && binaryPrecedence[arg.Name] <= 10) ||
+			(arg.Kind == Unary && obj.Name == "-" && arg.Name == "-")
+		p.wrapped(arg, wrap)
+	case Binary:
+		prec := binaryPrecedence[obj.Name]
+		left, right := obj.Vals[0], obj.Vals[1]
+		p.wrapped(left, needsParens(left, prec, obj.Name == "^"))
+		p.write(" ", obj.Name, " ")
+		p.wrapped(right, needsParens(right, prec, obj.Name != "^") && !(right.Kind == Unary))
+	case Yield:
+		p.write("yield")
+		if len(obj.Vals) > 0 {
+			p.write(" ")
+			p.list(obj.Vals)
+		}
+	case Ternary:
+		p.wrapped(obj.Vals[0], !isPrefix(obj.Vals[0]) && !isLiteral(obj.Vals[0]))
+		p.write(" ? ")
+		p.expr(obj.Vals[1])
+		p.write(" : ")
+		p.expr(obj.Vals[2])
+	}
+}
+
+// needsParens checks if an operand of a binary operator with the precedence
+// prec has to be wrapped to keep its meaning. Operands with the same precedence
+// need wrapping on the side the operator does not associate to.
+func needsParens(operand Object, prec int, sameNeedsWrap bool) bool {
+	switch operand.Kind {
+	case Ternary, Spread, Yield:
+		return true
+	case Unary:
+		return prec > 10
+	case Binary:
+		other := binaryPrecedence[operand.Name]
+		return other < prec || (other == prec && sameNeedsWrap)
+	}
+	return false
+}
+
+// prefix prints an expression that something is indexed or called on.
+func (p *printer) prefix(obj Object) {
+	p.wrapped(obj, !isPrefix(obj))
+}
+
+func (p *printer) wrapped(obj Object, wrap bool) {
+	if wrap {
+		p.write("(")
+	}
+	p.expr(obj)
+	if wrap {
+		p.write(")")
+	}
+}
+
+func isPrefix(obj Object) bool {
+	switch obj.Kind {
+	case Identifier, Member, Index, FuncCall:
+		return true
+	}
+	return false
+}
+
+func isLiteral(obj Object) bool {
+	switch obj.Kind {
+	case Number, String, Bool, Nil, Table, FuncDef:
+		return true
+	}
+	return false
+}
+
+// table prints a table on one line unless it was written over multiple lines
+// or has comments in it.
+func (p *printer) table(obj Object) {
+	multiline := len(obj.EndComments) > 0 || (len(obj.Vals) > 0 && obj.Pos[0] != obj.Pos[2])
+	for _, entry := range obj.Vals {
+		multiline = multiline || len(entry.Comments) > 0 || entry.Blank
+	}
+	p.write("{")
+	if !multiline {
+		for i, entry := range obj.Vals {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.tableEntry(entry)
+		}
+		p.write("}")
+		return
+	}
+	p.indent++
+	for _, entry := range obj.Vals {
+		p.comments(entry.Comments)
+		if entry.Blank {
+			p.buf.WriteByte('\n')
+		}
+		p.newline()
+		p.tableEntry(entry)
+		p.write(",")
+	}
+	p.comments(obj.EndComments)
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+func (p *printer) tableEntry(entry Object) {
+	if entry.Kind == TableKey {
+		if entry.Key.Kind == Identifier {
+			p.write(entry.Key.Name)
+		} else {
+			p.write("[")
+			p.expr(*entry.Key)
+			p.write("]")
+		}
+		p.write(": ")
+	}
+	p.expr(*entry.Value)
+}
+
+func formatNumber(num float64) string {
+	if num == math.Trunc(num) && math.Abs(num) < 1e15 {
+		return strconv.FormatFloat(num, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(num, 'g', -1, 64)
+}
+
+var quoteEscapes = map[byte]string{
+	'\a': `\a`, '\b': `\b`, '\f': `\f`, '\n': `\n`, '\r': `\r`, '\t': `\t`, '\v': `\v`, '\\': `\\`, '"': `\"`,
+}
+
+// quote writes a string literal. Strings with multiple lines are written as
+// multiline text when they can be.
+func quote(str string) string {
+	if strings.Contains(strings.Trim(str, "\n"), "\n") && !strings.ContainsAny(str, "`\r") {
+		return "`\n" + str + "\n`"
+	}
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		if esc, ok := quoteEscapes[str[i]]; ok {
+			buf.WriteString(esc)
+		} else {
+			buf.WriteByte(str[i])
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// StripPositions copies a node without any of its positions so that trees can
+// be compared by their structure.
+func StripPositions(obj Object) Object {
+	obj.Pos = [4]int{}
+	for _, ptr := range []**Object{&obj.Cond, &obj.Step, &obj.Key, &obj.Value} {
+		if *ptr != nil {
+			stripped := StripPositions(**ptr)
+			*ptr = &stripped
+		}
+	}
+	for _, list := range []*[]Object{&obj.Vars, &obj.Vals, &obj.Block, &obj.Catches} {
+		if *list == nil {
+			continue
+		}
+		stripped := make([]Object, len(*list))
+		for i, child := range *list {
+			stripped[i] = StripPositions(child)
+		}
+		*list = stripped
+	}
+	return obj
+}
+
+func samePositionless(a, b Object) bool {
+	a, b = StripPositions(a), StripPositions(b)
+	a.Comments, b.Comments, a.Blank, b.Blank = nil, nil, false, false
+	return reflect.DeepEqual(a, b)
+}
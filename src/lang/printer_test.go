@@ -0,0 +1,122 @@
+package lang
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const formatSrc = `#! /usr/bin/squirt
+// leading comment
+import {x,y   as z} from 'lib'
+a,b = 1,2 // trailing comment
+
+
+c = -(a+b)*2^-a^b
+d = (a == b) ? {1,2,x:3,["y"]:4} : !(a or b)
+e = t.foo(a...)[1:2]
+a+=2
+b++
+a << 2
+t = {
+  one: 1, // one
+
+  two: 2,
+  /* three */
+}
+class Foo isa Bar do
+  attr name = "dave", {require: true}
+  func new(a, b...)
+    self.name = 'it\'s' + "\n"
+  end
+  // end of class
+end
+for i = 0, i < 10, i++ do
+  if i == 2 then next elseif i > 5 then break else print(i) end
+end
+do
+  spill("boom")
+cleanup err = Error, ArgumentError do
+  /*
+    long comment
+  */
+  print(err)
+end
+export attr version = "1.0"
+`
+
+const formatted = `#! /usr/bin/squirt
+// leading comment
+import {x, y as z} from "lib"
+a, b = 1, 2 // trailing comment
+
+c = -(a + b) * 2 ^ -a ^ b
+d = (a == b) ? {1, 2, x: 3, ["y"]: 4} : !(a or b)
+e = t.foo(a...)[1:2]
+a += 2
+b++
+a << 2
+t = {
+  one: 1, // one
+
+  two: 2,
+  /* three */
+}
+class Foo isa Bar do
+  attr name = "dave", {require: true}
+  func new(a, b...)
+    self.name = "it's" + "\n"
+  end
+  // end of class
+end
+for i = 0, i < 10, i++ do
+  if i == 2 then
+    next
+  elseif i > 5 then
+    break
+  else
+    print(i)
+  end
+end
+do
+  spill("boom")
+cleanup err = Error, ArgumentError do
+  /*
+    long comment
+  */
+  print(err)
+end
+export attr version = "1.0"
+`
+
+func assertRoundTrip(t *testing.T, name, src string) {
+	original, err := ParseStr(src)
+	if !assert.Nil(t, err, name) {
+		return
+	}
+	out := Format(original)
+	reparsed, err := ParseStr(string(out))
+	if !assert.Nil(t, err, name+"\n"+string(out)) {
+		return
+	}
+	assert.Equal(t, StripPositions(original), StripPositions(reparsed), name)
+	assert.Equal(t, string(out), string(Format(reparsed)), name)
+}
+
+func TestFormat(t *testing.T) {
+	root, err := ParseStr(formatSrc)
+	assert.Nil(t, err)
+	assert.Equal(t, formatted, string(Format(root)))
+	assertRoundTrip(t, "format source", formatSrc)
+}
+
+func TestFormatExamples(t *testing.T) {
+	paths, _ := filepath.Glob("../../_test/examples/*.sqrt")
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assertRoundTrip(t, path, string(src))
+	}
+}
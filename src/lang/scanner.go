@@ -18,6 +18,9 @@ type scanner struct {
 	current    rune
 	lineNumber int
 	colNumber  int
+	comments   []Comment // comments found since the last token
+	newlines   int       // newlines found since the last token or comment
+	seen       bool      // a token or comment has been scanned
 }
 
 func newScanner(r io.ByteReader, isFile bool, source string) scanner {
@@ -82,7 +85,7 @@ func (s *scanner) save(c rune) error {
 	return s.buffer.WriteByte(byte(c))
 }
 
-func (s *scanner) readMultiLine(comment bool) (string, error) {
+func (s *scanner) readMultiLine() (string, error) {
 	if isNewLine(s.current) {
 		s.incrementLineNumber()
 	}
@@ -91,38 +94,10 @@ func (s *scanner) readMultiLine(comment bool) (string, error) {
 		case endOfStream:
 			return "", s.scanError("unfinished multiline text")
 		case '`':
-			if comment {
-				if err := s.save(s.current); err != nil {
-					return "", err
-				}
-				continue
-			}
 			s.advance()
 			defer s.buffer.Reset()
 			str := s.buffer.String()
-			if str[len(str)-1] == '\n' {
-				str = str[:len(str)-1]
-			}
-			return str, nil
-		case '*':
-			if !comment {
-				if err := s.save(s.current); err != nil {
-					return "", err
-				}
-				continue
-			}
-			s.advance()
-			if s.current != '/' {
-				if err := s.save(s.current); err == nil {
-					continue
-				} else if err != nil {
-					return "", err
-				}
-			}
-			s.advance()
-			defer s.buffer.Reset()
-			str := s.buffer.String()
-			if str[len(str)-1] == '\n' {
+			if len(str) > 0 && str[len(str)-1] == '\n' {
 				str = str[:len(str)-1]
 			}
 			return str, nil
@@ -143,6 +118,48 @@ func (s *scanner) readMultiLine(comment bool) (string, error) {
 	}
 }
 
+// readLongComment reads a /* */ comment, the leading / has already been
+// consumed. The comment text is returned as it was written.
+func (s *scanner) readLongComment() (string, error) {
+	defer s.buffer.Reset()
+	s.buffer.WriteString("/*")
+	s.advance()
+	for {
+		switch s.current {
+		case endOfStream:
+			return "", s.scanError("unfinished multiline text")
+		case '*':
+			if s.advance(); s.current == '/' {
+				s.advance()
+				s.buffer.WriteString("*/")
+				return s.buffer.String(), nil
+			} else if err := s.save('*'); err != nil {
+				return "", err
+			}
+		case '\r', '\n':
+			if err := s.save('\n'); err != nil {
+				return "", err
+			}
+			s.incrementLineNumber()
+		default:
+			if err := s.saveAndAdvance(); err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
+// comment records a comment so that it can be attached to the next token.
+func (s *scanner) comment(text string) {
+	s.comments = append(s.comments, Comment{
+		Text:   text,
+		Inline: s.seen && s.newlines == 0,
+		Blank:  s.seen && s.newlines > 1,
+	})
+	s.newlines = 0
+	s.seen = true
+}
+
 func (s *scanner) readDigits() (c rune, e error) {
 	for c = s.current; isDecimal(c); c = s.current {
 		if e = s.saveAndAdvance(); e != nil {
@@ -391,36 +408,56 @@ func (s *scanner) readString() (token, error) {
 func (s *scanner) skipShebangs() {
 	if s.current == '#' {
 		if s.advance(); s.current == '!' {
+			line := []byte{'#'}
 			for !isNewLine(s.current) && s.current != endOfStream {
+				line = append(line, byte(s.current))
 				s.advance()
 			}
+			s.comment(string(line))
 			return
 		}
 	}
 }
 
+// scan reads the next token and attaches any comments that came before it.
 func (s *scanner) scan() (token, error) {
-	const comment, str = true, false
-	startLine := s.lineNumber
-	startCol := s.colNumber
+	tk, err := s.scanToken()
+	if err != nil {
+		s.buffer.Reset()
+	}
+	tk.comments, s.comments = s.comments, nil
+	tk.blank = s.seen && s.newlines > 1
+	s.newlines = 0
+	s.seen = true
+	return tk, err
+}
 
+func (s *scanner) scanToken() (token, error) {
 	for {
+		startLine := s.lineNumber
+		startCol := s.colNumber
 		switch c := s.current; c {
 		case '\n', '\r':
 			s.incrementLineNumber()
+			s.newlines++
 		case ' ', '\f', '\t', '\v':
 			s.advance()
 		case '/':
 			s.advance()
 			switch s.current {
 			case '/':
+				line := []byte{'/'}
 				for !isNewLine(s.current) && s.current != endOfStream {
+					line = append(line, byte(s.current))
 					s.advance()
 				}
+				s.comment(strings.TrimRight(string(line), " \t"))
 			case '*':
-				if _, err := s.readMultiLine(true); err != nil {
+				text, err := s.readLongComment()
+				if err != nil {
 					return s.tkEOS(), err
 				}
+				s.comment(text)
 			default:
 				return token{
 					t:   '/',
@@ -429,7 +466,7 @@ func (s *scanner) scan() (token, error) {
 			}
 		case '`':
 			s.advance()
-			strVal, err := s.readMultiLine(false)
+			strVal, err := s.readMultiLine()
 			if err != nil {
 				return s.tkEOS(), err
 			}
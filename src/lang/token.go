@@ -7,25 +7,31 @@ const (
 	tkAnd rune = iota + firstReserved
 	tkAttr
 	tkBreak
+	tkCase
 	tkClass
 	tkCleanup
 	tkDo
 	tkElse
 	tkElseif
 	tkEnd
+	tkExport
 	tkFalse
 	tkFor
 	tkFunction
 	tkIf
+	tkImport
 	tkIn
 	tkIsa
+	tkMatch
 	tkNext
 	tkNil
 	tkOr
 	tkReturn
+	tkSelect
 	tkThen
 	tkTrue
 	tkWhile
+	tkYield
 
 	tkSpread
 	tkEq
@@ -42,32 +48,38 @@ const (
 	tkNumber
 	tkName
 	tkString
-	reservedCount = tkWhile - firstReserved + 1
+	reservedCount = tkYield - firstReserved + 1
 )
 
 var tokens = []string{
 	"and",
 	"attr",
 	"break",
+	"case",
 	"class",
 	"cleanup",
 	"do",
 	"else",
 	"elseif",
 	"end",
+	"export",
 	"false",
 	"for",
 	"func",
 	"if",
+	"import",
 	"in",
 	"isa",
+	"match",
 	"next",
 	"nil",
 	"or",
 	"return",
+	"select",
 	"then",
 	"true",
 	"while",
+	"yield",
 
 	"...",
 	"==",
@@ -91,6 +103,8 @@ type token struct {
 	numberValue float64
 	stringValue string
 	loc         [4]int
+	comments    []Comment
+	blank       bool // an empty line came before the token
 }
 
 func (tk *token) String() string {
@@ -0,0 +1,408 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/tanema/squirt/src/lang"
+	"github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// definition is a name that is introduced somewhere in a document
+	definition struct {
+		name   string
+		kind   int
+		member bool // accessed through an index like self.name
+		detail string
+		pos    [4]int // the location of the name itself
+		scope  [4]int // where the name is visible, the zero value is everywhere
+	}
+
+	document struct {
+		uri   string
+		text  string
+		lines []string
+		ast   lang.Object
+		defs  []definition
+		diags []diagnostic
+	}
+)
+
+var builtins = runtime.DefaultNamespace(nil)
+
+func newDocument(uri, text string, prev *document) *document {
+	doc := &document{
+		uri:   uri,
+		text:  text,
+		lines: strings.Split(text, "\n"),
+		diags: []diagnostic{},
+	}
+	ast, err := lang.ParseStr(text)
+	if errs, ok := err.(lang.ParseErrors); ok {
+		for _, perr := range errs {
+			doc.diags = append(doc.diags, toDiagnostic(perr))
+		}
+	} else if err != nil {
+		// keep the last good parse around so that navigation still works
+		doc.diags = append(doc.diags, toDiagnostic(err))
+		if prev != nil {
+			doc.ast, doc.defs = prev.ast, prev.defs
+		}
+		return doc
+	}
+	doc.ast = ast
+	doc.indexBlock(ast.Block, [4]int{}, "")
+	doc.indexBlock(ast.Catches, [4]int{}, "")
+	return doc
+}
+
+func toDiagnostic(err error) diagnostic {
+	diag := diagnostic{Severity: severityError, Source: "squirt", Message: err.Error()}
+	if perr, ok := err.(lang.ParseErr); ok {
+		diag.Range = toRange(perr.Pos())
+		diag.Message = perr.Message()
+	}
+	return diag
+}
+
+func (doc *document) define(def definition) {
+	doc.defs = append(doc.defs, def)
+}
+
+func (doc *document) indexBlock(block []lang.Object, scope [4]int, container string) {
+	for _, obj := range block {
+		doc.index(obj, scope, container)
+	}
+}
+
+func (doc *document) index(obj lang.Object, scope [4]int, container string) {
+	switch obj.Kind {
+	case lang.ClassDef:
+		doc.define(definition{
+			name:   obj.Name,
+			kind:   completionClass,
+			member: container != "",
+			detail: "#<Class " + obj.Name + ">",
+			pos:    doc.nameRange(obj.Pos, obj.Name),
+			scope:  scope,
+		})
+		doc.indexBlock(obj.Block, scope, obj.Name)
+		return
+	case lang.AttrDef:
+		doc.define(definition{
+			name:   obj.Name,
+			kind:   completionField,
+			member: true,
+			detail: "attr " + obj.Name,
+			pos:    doc.nameRange(obj.Pos, obj.Name),
+		})
+	case lang.FuncDef:
+		if obj.Value != nil && obj.Value.Kind != "" {
+			name, member := funcName(*obj.Value)
+			doc.define(definition{
+				name:   name.Name,
+				kind:   completionFunction,
+				member: member || container != "",
+				detail: signature(obj, container),
+				pos:    name.Pos,
+				scope:  scope,
+			})
+		}
+		for _, param := range obj.Vars {
+			doc.define(definition{
+				name:  strings.TrimSuffix(param.Name, "..."),
+				kind:  completionVariable,
+				pos:   param.Pos,
+				scope: obj.Pos,
+			})
+		}
+		doc.indexBlock(obj.Block, obj.Pos, "")
+		doc.indexBlock(obj.Catches, obj.Pos, "")
+		return
+	case lang.Assignment:
+		for _, target := range obj.Vars {
+			if target.Kind == lang.Identifier && doc.resolve(target.Name, target.Pos, false) == nil {
+				doc.define(definition{name: target.Name, kind: completionVariable, pos: target.Pos, scope: scope})
+			}
+		}
+	case lang.ForNum:
+		doc.define(definition{name: obj.Name, kind: completionVariable, pos: doc.nameRange(obj.Pos, obj.Name), scope: obj.Pos})
+	case lang.ForIn:
+		for _, v := range obj.Vars {
+			doc.define(definition{name: v.Name, kind: completionVariable, pos: v.Pos, scope: obj.Pos})
+		}
+	case lang.Case:
+		if obj.Key != nil {
+			doc.indexPattern(*obj.Key, obj.Pos)
+		} else if obj.Name != "" {
+			doc.define(definition{name: obj.Name, kind: completionVariable, pos: doc.nameRange(obj.Pos, obj.Name), scope: obj.Pos})
+		}
+	case lang.Cleanup:
+		if obj.Name != "" {
+			doc.define(definition{name: obj.Name, kind: completionVariable, pos: doc.nameRange(obj.Pos, obj.Name), scope: obj.Pos})
+		}
+	case lang.Export:
+		// an exported attr is a variable of the file rather than a member
+		if decl := *obj.Value; decl.Kind == lang.AttrDef {
+			doc.define(definition{name: decl.Name, kind: completionVariable, pos: doc.nameRange(decl.Pos, decl.Name), scope: scope})
+			if decl.Value != nil {
+				doc.index(*decl.Value, scope, "")
+			}
+		} else {
+			doc.index(decl, scope, container)
+		}
+		return
+	case lang.Import:
+		for _, local := range obj.Vals {
+			doc.define(definition{name: local.Name, kind: completionVariable, pos: local.Pos, scope: scope})
+		}
+		return
+	}
+
+	for _, child := range []*lang.Object{obj.Cond, obj.Step, obj.Key, obj.Value} {
+		if child != nil {
+			doc.index(*child, scope, "")
+		}
+	}
+	doc.indexBlock(obj.Vals, scope, "")
+	doc.indexBlock(obj.Block, scope, "")
+	doc.indexBlock(obj.Catches, scope, "")
+}
+
+// indexPattern defines the names that a case of a match binds.
+func (doc *document) indexPattern(pattern lang.Object, scope [4]int) {
+	switch pattern.Kind {
+	case lang.Identifier:
+		if pattern.Name != "_" {
+			doc.define(definition{name: pattern.Name, kind: completionVariable, pos: pattern.Pos, scope: scope})
+		}
+	case lang.Spread:
+		doc.indexPattern(*pattern.Value, scope)
+	case lang.Table:
+		for _, entry := range pattern.Vals {
+			doc.indexPattern(*entry.Value, scope)
+		}
+	}
+}
+
+// funcName finds the identifier that names a func, for a func like t.foo that
+// is foo, which is then a member.
+func funcName(name lang.Object) (lang.Object, bool) {
+	if name.Kind == lang.Member {
+		return name.Vals[len(name.Vals)-1], true
+	}
+	return name, false
+}
+
+// signature describes a func definition the same way the runtime prints funcs.
+func signature(obj lang.Object, container string) string {
+	name, _ := funcName(*obj.Value)
+	fn := &runtime.Func{ClassName: container, Name: name.Name}
+	for _, param := range obj.Vars {
+		fn.Params = append(fn.Params, strings.TrimSuffix(param.Name, "..."))
+		fn.Vararg = strings.HasSuffix(param.Name, "...")
+	}
+	return fn.ToString(nil)
+}
+
+// nameRange finds a name on the first line of a node for the nodes that do not
+// keep the position of their name.
+func (doc *document) nameRange(pos [4]int, name string) [4]int {
+	if pos[0] < 1 || pos[0] > len(doc.lines) {
+		return pos
+	}
+	line := doc.lines[pos[0]-1]
+	start := max(pos[1]-1, 0)
+	if start > len(line) {
+		return pos
+	}
+	for i := start; i+len(name) <= len(line); i++ {
+		if line[i:i+len(name)] == name && !isWordByte(line, i-1) && !isWordByte(line, i+len(name)) {
+			return [4]int{pos[0], i + 1, pos[0], i + len(name)}
+		}
+	}
+	return pos
+}
+
+func isWordByte(line string, i int) bool {
+	if i < 0 || i >= len(line) {
+		return false
+	}
+	c := rune(line[i])
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// wordAt finds the name under the cursor and if it is accessed as a member.
+func (doc *document) wordAt(at position) (string, lspRange, bool) {
+	if at.Line >= len(doc.lines) {
+		return "", lspRange{}, false
+	}
+	line := doc.lines[at.Line]
+	start, end := at.Character, at.Character
+	if start > len(line) {
+		start, end = len(line), len(line)
+	}
+	for start > 0 && isWordByte(line, start-1) {
+		start--
+	}
+	for isWordByte(line, end) {
+		end++
+	}
+	member := start > 0 && line[start-1] == '.'
+	rng := lspRange{Start: position{at.Line, start}, End: position{at.Line, end}}
+	return line[start:end], rng, member
+}
+
+// resolve finds the innermost definition of a name that is visible from a
+// position.
+func (doc *document) resolve(name string, at [4]int, member bool) *definition {
+	cursor := toRange(at).Start
+	var found *definition
+	for i := range doc.defs {
+		def := &doc.defs[i]
+		if def.name != name || def.member != member || !doc.visible(def, cursor) {
+			continue
+		}
+		if found == nil || narrower(def.scope, found.scope) {
+			found = def
+		}
+	}
+	return found
+}
+
+func (doc *document) visible(def *definition, at position) bool {
+	return def.member || def.scope == [4]int{} || contains(def.scope, at)
+}
+
+func narrower(a, b [4]int) bool {
+	if b == [4]int{} {
+		return a != [4]int{}
+	} else if a == [4]int{} {
+		return false
+	}
+	return a[0] > b[0] || (a[0] == b[0] && a[1] > b[1])
+}
+
+func (doc *document) definition(at position) *location {
+	name, _, member := doc.wordAt(at)
+	if name == "" {
+		return nil
+	}
+	def := doc.resolve(name, [4]int{at.Line + 1, at.Character + 1}, member)
+	if def == nil {
+		return nil
+	}
+	return &location{URI: doc.uri, Range: toRange(def.pos)}
+}
+
+func (doc *document) hover(at position) *hover {
+	name, rng, member := doc.wordAt(at)
+	if name == "" {
+		return nil
+	}
+	var detail string
+	if def := doc.resolve(name, [4]int{at.Line + 1, at.Character + 1}, member); def != nil {
+		detail = def.detail
+		if detail == "" {
+			detail = name
+		}
+	} else if val := builtins.Get(name); !member && val != nil {
+		detail = runtime.Print(builtins, val)
+	}
+	if detail == "" {
+		return nil
+	}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: "```squirt\n" + detail + "\n```"},
+		Range:    &rng,
+	}
+}
+
+func (doc *document) completion(at position) []completionItem {
+	_, _, member := doc.wordAt(at)
+	seen := map[string]bool{}
+	items := []completionItem{}
+	add := func(item completionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+	for i := range doc.defs {
+		def := &doc.defs[i]
+		if def.member == member && doc.visible(def, at) {
+			add(completionItem{Label: def.name, Kind: def.kind, Detail: def.detail})
+		}
+	}
+	if !member {
+		for _, name := range builtins.Names() {
+			val := builtins.Get(name)
+			kind := completionFunction
+			if _, isClass := val.(*runtime.Class); isClass {
+				kind = completionClass
+			}
+			add(completionItem{Label: name, Kind: kind, Detail: runtime.Print(builtins, val)})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func (doc *document) symbols() []documentSymbol {
+	return doc.symbolsIn(doc.ast.Block, "")
+}
+
+func (doc *document) symbolsIn(block []lang.Object, container string) []documentSymbol {
+	symbols := []documentSymbol{}
+	for _, obj := range block {
+		switch obj.Kind {
+		case lang.Export:
+			if decl := *obj.Value; decl.Kind == lang.AttrDef {
+				symbols = append(symbols, documentSymbol{
+					Name:           decl.Name,
+					Kind:           symbolVariable,
+					Range:          toRange(obj.Pos),
+					SelectionRange: toRange(doc.nameRange(decl.Pos, decl.Name)),
+				})
+			} else {
+				symbols = append(symbols, doc.symbolsIn([]lang.Object{decl}, container)...)
+			}
+		case lang.ClassDef:
+			symbols = append(symbols, documentSymbol{
+				Name:           obj.Name,
+				Detail:         obj.Parent,
+				Kind:           symbolClass,
+				Range:          toRange(obj.Pos),
+				SelectionRange: toRange(doc.nameRange(obj.Pos, obj.Name)),
+				Children:       doc.symbolsIn(obj.Block, obj.Name),
+			})
+		case lang.AttrDef:
+			symbols = append(symbols, documentSymbol{
+				Name:           obj.Name,
+				Kind:           symbolProperty,
+				Range:          toRange(obj.Pos),
+				SelectionRange: toRange(doc.nameRange(obj.Pos, obj.Name)),
+			})
+		case lang.FuncDef:
+			if obj.Value == nil || obj.Value.Kind == "" {
+				continue
+			}
+			name, member := funcName(*obj.Value)
+			kind := symbolFunction
+			if member || container != "" {
+				kind = symbolMethod
+			}
+			symbols = append(symbols, documentSymbol{
+				Name:           name.Name,
+				Detail:         signature(obj, container),
+				Kind:           kind,
+				Range:          toRange(obj.Pos),
+				SelectionRange: toRange(name.Pos),
+				Children:       doc.symbolsIn(obj.Block, ""),
+			})
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,139 @@
+package lsp
+
+// The subset of the Language Server Protocol that the server understands.
+
+const (
+	severityError = 1
+
+	syncFull = 1
+
+	completionFunction = 3
+	completionField    = 5
+	completionVariable = 6
+	completionClass    = 7
+
+	symbolClass    = 5
+	symbolMethod   = 6
+	symbolProperty = 7
+	symbolFunction = 12
+	symbolVariable = 13
+)
+
+type (
+	position struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+
+	lspRange struct {
+		Start position `json:"start"`
+		End   position `json:"end"`
+	}
+
+	location struct {
+		URI   string   `json:"uri"`
+		Range lspRange `json:"range"`
+	}
+
+	diagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+
+	textDocumentItem struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+		Text    string `json:"text"`
+	}
+
+	textDocumentIdentifier struct {
+		URI string `json:"uri"`
+	}
+
+	didOpenParams struct {
+		TextDocument textDocumentItem `json:"textDocument"`
+	}
+
+	didChangeParams struct {
+		TextDocument   textDocumentIdentifier `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+
+	didCloseParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+
+	positionParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+		Position     position               `json:"position"`
+	}
+
+	documentSymbolParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+
+	publishDiagnosticsParams struct {
+		URI         string       `json:"uri"`
+		Diagnostics []diagnostic `json:"diagnostics"`
+	}
+
+	markupContent struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+
+	hover struct {
+		Contents markupContent `json:"contents"`
+		Range    *lspRange     `json:"range,omitempty"`
+	}
+
+	completionItem struct {
+		Label  string `json:"label"`
+		Kind   int    `json:"kind,omitempty"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	documentSymbol struct {
+		Name           string           `json:"name"`
+		Detail         string           `json:"detail,omitempty"`
+		Kind           int              `json:"kind"`
+		Range          lspRange         `json:"range"`
+		SelectionRange lspRange         `json:"selectionRange"`
+		Children       []documentSymbol `json:"children,omitempty"`
+	}
+)
+
+// toRange converts a lang position, which has 1 based lines and inclusive 1
+// based columns, into a zero based lsp range with an exclusive end.
+func toRange(pos [4]int) lspRange {
+	start := position{Line: max(pos[0]-1, 0), Character: max(pos[1]-1, 0)}
+	end := position{Line: max(pos[2]-1, 0), Character: max(pos[3], 0)}
+	if pos[2] == 0 || end.Line < start.Line || (end.Line == start.Line && end.Character < start.Character) {
+		end = start
+	}
+	return lspRange{Start: start, End: end}
+}
+
+// contains checks if a lsp position is within a lang position
+func contains(pos [4]int, at position) bool {
+	rng := toRange(pos)
+	if at.Line < rng.Start.Line || at.Line > rng.End.Line {
+		return false
+	} else if at.Line == rng.Start.Line && at.Character < rng.Start.Character {
+		return false
+	} else if at.Line == rng.End.Line && at.Character > rng.End.Character {
+		return false
+	}
+	return true
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+type (
+	message struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id,omitempty"`
+		Method  string           `json:"method,omitempty"`
+		Params  json.RawMessage  `json:"params,omitempty"`
+		Result  interface{}      `json:"result,omitempty"`
+		Error   *rpcError        `json:"error,omitempty"`
+	}
+
+	rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// conn reads and writes json-rpc messages framed with Content-Length headers
+	conn struct {
+		r *textproto.Reader
+		w io.Writer
+	}
+)
+
+func (err *rpcError) Error() string { return err.Message }
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+func (c *conn) read() (*message, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %v", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &rpcError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %v\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (c *conn) reply(id *json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id, Result: result}
+	if err != nil {
+		rerr, ok := err.(*rpcError)
+		if !ok {
+			rerr = &rpcError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		msg.Result, msg.Error = nil, rerr
+	} else if result == nil {
+		msg.Result = json.RawMessage("null")
+	}
+	return c.write(msg)
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: data})
+}
@@ -0,0 +1,129 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Server is a language server for squirt source files. It keeps every open
+// document parsed and answers requests about them.
+type Server struct {
+	conn *conn
+	docs map[string]*document
+}
+
+// Serve runs a language server speaking json-rpc over r and w until the client
+// asks it to exit or r is closed.
+func Serve(r io.Reader, w io.Writer) error {
+	srv := &Server{conn: newConn(r, w), docs: map[string]*document{}}
+	for {
+		msg, err := srv.conn.read()
+		if err == io.EOF {
+			return nil
+		} else if rerr, ok := err.(*rpcError); ok {
+			if err := srv.conn.reply(nil, nil, rerr); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := srv.handle(msg)
+		if msg.ID == nil {
+			continue
+		} else if err := srv.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (srv *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       syncFull,
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]string{"name": "squirt"},
+		}, nil
+	case "initialized", "shutdown", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, srv.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		} else if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, srv.update(params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(srv.docs, params.TextDocument.URI)
+		return nil, srv.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+	case "textDocument/definition":
+		doc, params, err := srv.position(msg)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return doc.definition(params.Position), nil
+	case "textDocument/hover":
+		doc, params, err := srv.position(msg)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return doc.hover(params.Position), nil
+	case "textDocument/completion":
+		doc, params, err := srv.position(msg)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return doc.completion(params.Position), nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if doc, ok := srv.docs[params.TextDocument.URI]; ok {
+			return doc.symbols(), nil
+		}
+		return []documentSymbol{}, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+func (srv *Server) update(uri, text string) error {
+	doc := newDocument(uri, text, srv.docs[uri])
+	srv.docs[uri] = doc
+	return srv.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.diags,
+	})
+}
+
+func (srv *Server) position(msg *message) (*document, positionParams, error) {
+	var params positionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, params, err
+	}
+	return srv.docs[params.TextDocument.URI], params, nil
+}
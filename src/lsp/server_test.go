@@ -0,0 +1,133 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSrc = `class Animal do
+  attr name = "dave"
+
+  func speak(words...)
+    print(self.name, words)
+  end
+end
+
+func greet(who)
+  a = new(Animal)
+  a.speak(who)
+end
+
+func area(shape)
+  return match shape do
+    case {w: w, h: h} then w * h
+  end
+end
+`
+
+func request(id int, method string, params interface{}) string {
+	data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	return fmt.Sprintf("Content-Length: %v\r\n\r\n%s", len(data), data)
+}
+
+func notification(method string, params interface{}) string {
+	data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+	return fmt.Sprintf("Content-Length: %v\r\n\r\n%s", len(data), data)
+}
+
+func at(line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": "file:///test.sqrt"},
+		"position":     map[string]int{"line": line, "character": char},
+	}
+}
+
+func readAll(t *testing.T, out *bytes.Buffer) map[string]*message {
+	c := newConn(out, nil)
+	msgs := map[string]*message{}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			break
+		}
+		key := msg.Method
+		if msg.ID != nil {
+			key = string(*msg.ID)
+		}
+		msgs[key] = msg
+	}
+	return msgs
+}
+
+func result(t *testing.T, msg *message) string {
+	data, err := json.Marshal(msg.Result)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func decode(t *testing.T, msg *message, v interface{}) {
+	assert.Nil(t, json.Unmarshal([]byte(result(t, msg)), v))
+}
+
+func TestServer(t *testing.T) {
+	doc := map[string]interface{}{"uri": "file:///test.sqrt", "version": 1, "text": testSrc}
+	in := strings.Join([]string{
+		request(1, "initialize", map[string]interface{}{}),
+		notification("textDocument/didOpen", map[string]interface{}{"textDocument": doc}),
+		request(2, "textDocument/definition", at(10, 3)),
+		request(3, "textDocument/hover", at(10, 5)),
+		request(4, "textDocument/documentSymbol", map[string]interface{}{"textDocument": doc}),
+		request(5, "textDocument/completion", at(10, 2)),
+		request(6, "textDocument/definition", at(4, 16)),
+		request(7, "textDocument/definition", at(15, 27)),
+		notification("exit", nil),
+	}, "")
+	var out bytes.Buffer
+	assert.Nil(t, Serve(strings.NewReader(in), &out))
+	msgs := readAll(t, &out)
+
+	assert.Contains(t, result(t, msgs["1"]), `"definitionProvider":true`)
+	assert.Equal(t, `{"uri":"file:///test.sqrt","diagnostics":[]}`, string(msgs["textDocument/publishDiagnostics"].Params))
+
+	var loc location
+	decode(t, msgs["2"], &loc)
+	assert.Equal(t, lspRange{Start: position{9, 2}, End: position{9, 3}}, loc.Range)
+
+	var info hover
+	decode(t, msgs["3"], &info)
+	assert.Equal(t, "```squirt\n#<func Animal.speak(words...)>\n```", info.Contents.Value)
+	assert.Contains(t, result(t, msgs["4"]), `"name":"Animal"`)
+	assert.Contains(t, result(t, msgs["4"]), `"name":"greet"`)
+	assert.Contains(t, result(t, msgs["5"]), `"label":"who"`)
+	assert.Contains(t, result(t, msgs["5"]), `"label":"print"`)
+
+	decode(t, msgs["6"], &loc)
+	assert.Equal(t, lspRange{Start: position{1, 7}, End: position{1, 11}}, loc.Range)
+
+	decode(t, msgs["7"], &loc)
+	assert.Equal(t, lspRange{Start: position{15, 13}, End: position{15, 14}}, loc.Range)
+}
+
+func TestDiagnostics(t *testing.T) {
+	src := "a = = 1\nfunc foo()\n  b = = 1\nend\n"
+	doc := map[string]interface{}{"uri": "file:///test.sqrt", "version": 1, "text": src}
+	in := strings.Join([]string{
+		notification("textDocument/didOpen", map[string]interface{}{"textDocument": doc}),
+		request(1, "textDocument/documentSymbol", map[string]interface{}{"textDocument": doc}),
+	}, "")
+	var out bytes.Buffer
+	assert.Nil(t, Serve(strings.NewReader(in), &out))
+	msgs := readAll(t, &out)
+	params := publishDiagnosticsParams{}
+	assert.Nil(t, json.Unmarshal(msgs["textDocument/publishDiagnostics"].Params, &params))
+	if assert.Len(t, params.Diagnostics, 2) {
+		assert.Equal(t, "expected expression but found =", params.Diagnostics[0].Message)
+		assert.Equal(t, 2, params.Diagnostics[1].Range.Start.Line)
+	}
+	assert.Contains(t, result(t, msgs["1"]), `"name":"foo"`)
+}
@@ -0,0 +1,205 @@
+// Package mod reads squirt.mod manifests, copies the dependencies they
+// declare into squirt_modules and records what was copied in squirt.sum.
+//
+// A manifest names the package and lists its dependencies, one per line. A
+// dependency is either a local path, relative to the manifest, or a git url
+// pinned to a commit.
+//
+//	package app
+//	version 0.1.0
+//	require util ../util
+//	require strings https://github.com/someone/strings.git 3f2a1c9
+package mod
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	ManifestFile = "squirt.mod"
+	SumFile      = "squirt.sum"
+	ModulesDir   = "squirt_modules"
+)
+
+type (
+	// Manifest is a parsed squirt.mod, Dir is the directory it is in.
+	Manifest struct {
+		Dir     string
+		Name    string
+		Version string
+		Deps    []Dependency
+	}
+
+	// Dependency is a package that gets copied into squirt_modules/Name. Commit
+	// is only set for git dependencies.
+	Dependency struct {
+		Name   string
+		Source string
+		Commit string
+	}
+)
+
+// commitPattern is what the commit of a git dependency looks like.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// IsGit is true if the dependency is cloned from a git url.
+func (dep Dependency) IsGit() bool {
+	return dep.Commit != ""
+}
+
+// checkGit makes sure that git cannot take the source or commit of a git
+// dependency as one of its options.
+func (dep Dependency) checkGit() error {
+	if strings.HasPrefix(dep.Source, "-") {
+		return fmt.Errorf("invalid git source %q for %v", dep.Source, dep.Name)
+	} else if !commitPattern.MatchString(dep.Commit) {
+		return fmt.Errorf("invalid commit %q for %v, it has to be 7 to 40 lowercase hex digits", dep.Commit, dep.Name)
+	}
+	return nil
+}
+
+func (dep Dependency) String() string {
+	if dep.IsGit() {
+		return fmt.Sprintf("%v %v %v", dep.Name, dep.Source, dep.Commit)
+	}
+	return fmt.Sprintf("%v %v", dep.Name, dep.Source)
+}
+
+// Root finds the directory of the closest squirt.mod in dir or any of its
+// parents.
+func Root(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Resolve finds a required path like pkg/sub in the squirt_modules of root
+// if pkg has been vendored. Paths that .. would take out of squirt_modules are
+// not resolved.
+func Resolve(root, path string) (string, bool) {
+	modules := filepath.Join(root, ModulesDir)
+	resolved := filepath.Join(modules, path)
+	rel, err := filepath.Rel(modules, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	name := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	if info, err := os.Stat(filepath.Join(modules, name)); err != nil || !info.IsDir() {
+		return "", false
+	}
+	return resolved, true
+}
+
+// ReadManifest reads the squirt.mod in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, ManifestFile)
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(path, src)
+}
+
+// ParseManifest parses the source of the manifest at path.
+func ParseManifest(path string, src []byte) (*Manifest, error) {
+	m := &Manifest{Dir: filepath.Dir(path)}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		// a comment starts a field so that urls keep their //
+		for i, field := range fields {
+			if strings.HasPrefix(field, "//") {
+				fields = fields[:i]
+				break
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "package" && len(fields) == 2:
+			m.Name = fields[1]
+		case fields[0] == "version" && len(fields) == 2:
+			m.Version = fields[1]
+		case fields[0] == "require" && (len(fields) == 3 || len(fields) == 4):
+			dep := Dependency{Name: fields[1], Source: fields[2]}
+			if len(fields) == 4 {
+				dep.Commit = fields[3]
+			}
+			if strings.ContainsAny(dep.Name, `/\`) || dep.Name == "." || dep.Name == ".." {
+				return nil, fmt.Errorf("%v:%v: invalid dependency name %q", path, line, dep.Name)
+			} else if err := dep.checkGit(); dep.IsGit() && err != nil {
+				return nil, fmt.Errorf("%v:%v: %v", path, line, err)
+			} else if _, ok := m.Dep(dep.Name); ok {
+				return nil, fmt.Errorf("%v:%v: %v is required twice", path, line, dep.Name)
+			}
+			m.Deps = append(m.Deps, dep)
+		default:
+			return nil, fmt.Errorf("%v:%v: unknown directive %q", path, line, strings.Join(fields, " "))
+		}
+	}
+	if m.Name == "" {
+		return nil, fmt.Errorf("%v: missing package name", path)
+	}
+	return m, scanner.Err()
+}
+
+// Dep finds a dependency by name.
+func (m *Manifest) Dep(name string) (Dependency, bool) {
+	for _, dep := range m.Deps {
+		if dep.Name == name {
+			return dep, true
+		}
+	}
+	return Dependency{}, false
+}
+
+// Require adds a dependency or replaces the one with the same name.
+func (m *Manifest) Require(dep Dependency) {
+	for i := range m.Deps {
+		if m.Deps[i].Name == dep.Name {
+			m.Deps[i] = dep
+			return
+		}
+	}
+	m.Deps = append(m.Deps, dep)
+}
+
+// Bytes formats the manifest the way it is written to squirt.mod.
+func (m *Manifest) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %v\n", m.Name)
+	if m.Version != "" {
+		fmt.Fprintf(&buf, "version %v\n", m.Version)
+	}
+	if len(m.Deps) > 0 {
+		buf.WriteString("\n")
+	}
+	for _, dep := range m.Deps {
+		fmt.Fprintf(&buf, "require %v\n", dep)
+	}
+	return buf.Bytes()
+}
+
+// Write writes the manifest to squirt.mod in its Dir.
+func (m *Manifest) Write() error {
+	return ioutil.WriteFile(filepath.Join(m.Dir, ManifestFile), m.Bytes(), 0644)
+}
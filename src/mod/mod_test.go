@@ -0,0 +1,163 @@
+package mod
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(src), 0644))
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest("app/squirt.mod", []byte(`// the app
+package app
+version 0.1.0
+
+require util ../util
+require strs https://example.com/strs.git 3f2a1c9 // pinned
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, "app", m.Dir)
+	assert.Equal(t, "app", m.Name)
+	assert.Equal(t, "0.1.0", m.Version)
+	assert.Equal(t, []Dependency{
+		{Name: "util", Source: "../util"},
+		{Name: "strs", Source: "https://example.com/strs.git", Commit: "3f2a1c9"},
+	}, m.Deps)
+	assert.Equal(t, "package app\nversion 0.1.0\n\nrequire util ../util\nrequire strs https://example.com/strs.git 3f2a1c9\n", string(m.Bytes()))
+
+	m.Require(Dependency{Name: "util", Source: "../other"})
+	dep, ok := m.Dep("util")
+	assert.True(t, ok)
+	assert.Equal(t, "../other", dep.Source)
+	assert.Len(t, m.Deps, 2)
+
+	_, err = ParseManifest("squirt.mod", []byte("package app\nrequires util ../util\n"))
+	assert.EqualError(t, err, `squirt.mod:2: unknown directive "requires util ../util"`)
+	_, err = ParseManifest("squirt.mod", []byte("package app\nrequire util a\nrequire util b\n"))
+	assert.EqualError(t, err, "squirt.mod:3: util is required twice")
+	_, err = ParseManifest("squirt.mod", []byte("require util ../util\n"))
+	assert.EqualError(t, err, "squirt.mod: missing package name")
+	_, err = ParseManifest("squirt.mod", []byte("package app\nrequire strs --upload-pack=touch 3f2a1c9\n"))
+	assert.EqualError(t, err, `squirt.mod:2: invalid git source "--upload-pack=touch" for strs`)
+	_, err = ParseManifest("squirt.mod", []byte("package app\nrequire strs https://example.com/strs.git --orphan\n"))
+	assert.EqualError(t, err, `squirt.mod:2: invalid commit "--orphan" for strs, it has to be 7 to 40 lowercase hex digits`)
+
+	_, err = fetchGit(Dependency{Name: "strs", Source: "--upload-pack=touch", Commit: "3f2a1c9"}, "")
+	assert.EqualError(t, err, `invalid git source "--upload-pack=touch" for strs`)
+	_, err = fetchGit(Dependency{Name: "strs", Source: "https://example.com/strs.git", Commit: "HEAD~1"}, "")
+	assert.EqualError(t, err, `invalid commit "HEAD~1" for strs, it has to be 7 to 40 lowercase hex digits`)
+}
+
+func TestVendor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "squirt-mod")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a bare repo to clone like a remote, without a network
+	writeFiles(t, filepath.Join(dir, "strs"), map[string]string{
+		"squirt.mod":   "package strs\n",
+		"strings.sqrt": "upper = 1\n",
+	})
+	for _, args := range [][]string{
+		{"-C", filepath.Join(dir, "strs"), "init", "-q"},
+		{"-C", filepath.Join(dir, "strs"), "add", "-A"},
+		{"-C", filepath.Join(dir, "strs"), "-c", "user.name=squirt", "-c", "user.email=squirt@example.com", "commit", "-qm", "init"},
+		{"clone", "-q", "--bare", filepath.Join(dir, "strs"), filepath.Join(dir, "strs.git")},
+	} {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+	commit, err := exec.Command("git", "-C", filepath.Join(dir, "strs.git"), "rev-parse", "HEAD").Output()
+	assert.Nil(t, err)
+	head := strings.TrimSpace(string(commit))
+
+	writeFiles(t, dir, map[string]string{
+		"util/squirt.mod":          "package util\nrequire strs " + filepath.Join(dir, "strs.git") + " " + head[:7] + "\n",
+		"util/math/double.sqrt":    "double = 2\n",
+		"util/squirt_modules/x.sq": "left behind\n",
+		"app/squirt.mod":           "package app\nrequire util ../util\n",
+	})
+	app := filepath.Join(dir, "app")
+	m, err := ReadManifest(app)
+	assert.Nil(t, err)
+	assert.Nil(t, Vendor(m))
+
+	for _, path := range []string{"util/math/double.sqrt", "strs/strings.sqrt", "strs/squirt.mod"} {
+		_, err := os.Stat(filepath.Join(app, ModulesDir, filepath.FromSlash(path)))
+		assert.Nil(t, err, path)
+	}
+	_, err = os.Stat(filepath.Join(app, ModulesDir, "util", ModulesDir))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(app, ModulesDir, "strs", ".git"))
+	assert.True(t, os.IsNotExist(err))
+
+	sums, err := ReadSums(app)
+	assert.Nil(t, err)
+	if assert.Len(t, sums, 2) {
+		assert.Equal(t, "strs", sums[0].Name)
+		assert.Equal(t, head, sums[0].Version)
+		assert.Equal(t, "util", sums[1].Name)
+		assert.Equal(t, "local", sums[1].Version)
+		assert.True(t, strings.HasPrefix(sums[1].Hash, "h1:"))
+	}
+
+	root, ok := Root(filepath.Join(app, ModulesDir, "util"))
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(app, ModulesDir, "util"), root)
+	root, ok = Root(app)
+	assert.True(t, ok)
+	resolved, ok := Resolve(root, "util/math/double")
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(app, ModulesDir, "util", "math", "double"), resolved)
+	resolved, ok = Resolve(root, "strs/strings.sqrt")
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(app, ModulesDir, "strs", "strings.sqrt"), resolved)
+	_, ok = Resolve(root, "missing/file")
+	assert.False(t, ok)
+	resolved, ok = Resolve(root, "util/../strs/strings.sqrt")
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(app, ModulesDir, "strs", "strings.sqrt"), resolved)
+	for _, escape := range []string{"util/../../squirt.mod", "util/../..", "util/.."} {
+		_, ok = Resolve(root, escape)
+		assert.False(t, ok, escape)
+	}
+
+	sums[0].Hash = "h1:tampered"
+	assert.Nil(t, WriteSums(app, sums))
+	err = Vendor(m)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "checksum mismatch for strs")
+	}
+	_, err = os.Stat(filepath.Join(app, ModulesDir, "util", "math", "double.sqrt"))
+	assert.Nil(t, err, "a failed vendor leaves squirt_modules alone")
+}
+
+func TestVendorConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squirt-mod")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a/squirt.mod":   "package a\nrequire c ../c1\n",
+		"c1/c.sqrt":      "c = 1\n",
+		"c2/c.sqrt":      "c = 2\n",
+		"app/squirt.mod": "package app\nrequire a ../a\nrequire c ../c2\n",
+	})
+	m, err := ReadManifest(filepath.Join(dir, "app"))
+	assert.Nil(t, err)
+	assert.EqualError(t, Vendor(m), "app requires c ../c2 but a requires c ../c1")
+}
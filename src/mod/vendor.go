@@ -0,0 +1,263 @@
+package mod
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	// Sum is a line of squirt.sum, the hash of what was copied for a
+	// dependency. Version is the full commit of a git dependency and local for
+	// a path.
+	Sum struct {
+		Name    string
+		Source  string
+		Version string
+		Hash    string
+	}
+
+	// pending is a dependency waiting to be copied along with the directory
+	// its local path is relative to, empty when it came from a git checkout.
+	pending struct {
+		dep  Dependency
+		base string
+		from string
+	}
+)
+
+// Vendor copies the dependencies of the manifest, and their dependencies,
+// into a new squirt_modules and writes their hashes to squirt.sum. A git
+// dependency that is already in squirt.sum at the same commit has to hash
+// the same as it did before.
+func Vendor(m *Manifest) error {
+	sums, err := ReadSums(m.Dir)
+	if err != nil {
+		return err
+	}
+	known := map[string]Sum{}
+	for _, sum := range sums {
+		known[sum.Name] = sum
+	}
+	// copy into a new directory so a failure leaves squirt_modules as it was
+	modules, err := ioutil.TempDir(m.Dir, "."+ModulesDir)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(modules)
+
+	queue := []pending{}
+	for _, dep := range m.Deps {
+		queue = append(queue, pending{dep: dep, base: m.Dir, from: m.Name})
+	}
+	seen := map[string]pending{}
+	sums = nil
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		dep := next.dep
+		if prev, ok := seen[dep.Name]; ok {
+			if prev.dep.IsGit() != dep.IsGit() || prev.dep.Commit != dep.Commit ||
+				source(prev.base, prev.dep) != source(next.base, dep) {
+				return fmt.Errorf("%v requires %v but %v requires %v", prev.from, prev.dep, next.from, dep)
+			}
+			continue
+		}
+		seen[dep.Name] = next
+
+		dest := filepath.Join(modules, dep.Name)
+		sum := Sum{Name: dep.Name, Source: dep.Source, Version: "local"}
+		if dep.IsGit() {
+			if sum.Version, err = fetchGit(dep, dest); err != nil {
+				return err
+			}
+		} else if next.base == "" {
+			return fmt.Errorf("%v is cloned with git so it cannot require the local path %v", next.from, dep.Source)
+		} else if err := copyTree(source(next.base, dep), dest); err != nil {
+			return err
+		}
+		if sum.Hash, err = HashDir(dest); err != nil {
+			return err
+		}
+		if old, ok := known[dep.Name]; ok && dep.IsGit() && old.Source == sum.Source &&
+			old.Version == sum.Version && old.Hash != sum.Hash {
+			return fmt.Errorf("checksum mismatch for %v at %v\n\tsquirt.sum: %v\n\tdownloaded: %v",
+				dep.Name, sum.Version, old.Hash, sum.Hash)
+		}
+		sums = append(sums, sum)
+
+		if sub, err := ReadManifest(dest); err == nil {
+			base := source(next.base, dep)
+			if dep.IsGit() {
+				base = ""
+			}
+			for _, subDep := range sub.Deps {
+				queue = append(queue, pending{dep: subDep, base: base, from: sub.Name})
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(m.Dir, ModulesDir)); err != nil {
+		return err
+	} else if err := os.Rename(modules, filepath.Join(m.Dir, ModulesDir)); err != nil {
+		return err
+	}
+	return WriteSums(m.Dir, sums)
+}
+
+// source is where a dependency is copied from, local paths are relative to
+// the manifest that required them.
+func source(base string, dep Dependency) string {
+	if dep.IsGit() || filepath.IsAbs(dep.Source) || base == "" {
+		return dep.Source
+	}
+	return filepath.Join(base, dep.Source)
+}
+
+// fetchGit clones a dependency, checks out its commit and copies the work
+// tree into dest. It returns the full commit that was checked out.
+func fetchGit(dep Dependency, dest string) (string, error) {
+	if err := dep.checkGit(); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir("", "squirt-"+dep.Name)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := git("", "clone", "--quiet", "--no-checkout", "--", dep.Source, tmp); err != nil {
+		return "", err
+	} else if err := git(tmp, "checkout", "--quiet", dep.Commit, "--"); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("git", "-C", tmp, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse in %v: %v", dep.Name, err)
+	}
+	return strings.TrimSpace(string(out)), copyTree(tmp, dest)
+}
+
+func git(dir string, args ...string) error {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %v: %v\n%v", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// copyTree copies the files of a package, leaving out version control and
+// its own squirt_modules.
+func copyTree(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == ModulesDir {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		} else if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// HashDir hashes the names and contents of every file in dir. It is the
+// base64 sha256 of a line with the sha256 and the slash separated path of
+// each file, sorted by path.
+func HashDir(dir string) (string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	summary := sha256.New()
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(summary, "%x  %v\n", sha256.Sum256(data), filepath.ToSlash(rel))
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// ReadSums reads the squirt.sum in dir, there are none if it does not exist.
+func ReadSums(dir string) ([]Sum, error) {
+	path := filepath.Join(dir, SumFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sums := []Sum{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 4 {
+			return nil, fmt.Errorf("%v:%v: malformed line", path, line)
+		}
+		sums = append(sums, Sum{Name: fields[0], Source: fields[1], Version: fields[2], Hash: fields[3]})
+	}
+	return sums, scanner.Err()
+}
+
+// WriteSums writes squirt.sum in dir sorted by name.
+func WriteSums(dir string, sums []Sum) error {
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Name < sums[j].Name })
+	var buf bytes.Buffer
+	for _, sum := range sums {
+		fmt.Fprintf(&buf, "%v %v %v %v\n", sum.Name, sum.Source, sum.Version, sum.Hash)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, SumFile), buf.Bytes(), 0644)
+}
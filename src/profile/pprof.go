@@ -0,0 +1,175 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+// Field numbers from the pprof profile.proto.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+type (
+	// encoder writes the protobuf wire format, only varints and length
+	// delimited fields are needed for a profile.
+	encoder struct {
+		buf []byte
+	}
+
+	pprofBuilder struct {
+		strings   map[string]int64
+		table     []string
+		functions map[runtime.ProfileFrame]uint64
+		locations map[runtime.ProfileFrame]uint64
+		out       encoder
+	}
+)
+
+// WritePprof writes a gzipped pprof profile that go tool pprof can open. Each
+// sample has the calls that returned and the nanoseconds spent on its line.
+func WritePprof(w io.Writer, prof *runtime.Profile) error {
+	b := &pprofBuilder{
+		strings:   map[string]int64{},
+		functions: map[runtime.ProfileFrame]uint64{},
+		locations: map[runtime.ProfileFrame]uint64{},
+	}
+	b.str("")
+	b.valueType(profileSampleType, "calls", "count")
+	b.valueType(profileSampleType, "time", "nanoseconds")
+	for _, sample := range prof.Samples() {
+		var s encoder
+		ids := make([]uint64, len(sample.Stack))
+		for i, frame := range sample.Stack {
+			ids[i] = b.location(frame)
+		}
+		s.packed(sampleLocationID, ids)
+		s.packed(sampleValue, []uint64{uint64(sample.Calls), uint64(sample.Time.Nanoseconds())})
+		b.out.message(profileSample, s)
+	}
+	b.out.varint(profileTimeNanos, uint64(prof.Start().UnixNano()))
+	b.out.varint(profileDurationNanos, uint64(prof.Duration().Nanoseconds()))
+	b.valueType(profilePeriodType, "time", "nanoseconds")
+	b.out.varint(profilePeriod, 1)
+	b.out.varint(profileDefaultSampleType, uint64(b.str("time")))
+	for _, str := range b.table {
+		b.out.bytes(profileStringTable, []byte(str))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.out.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (b *pprofBuilder) str(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	b.strings[s] = int64(len(b.table))
+	b.table = append(b.table, s)
+	return b.strings[s]
+}
+
+func (b *pprofBuilder) valueType(field int, typ, unit string) {
+	var vt encoder
+	vt.varint(valueTypeType, uint64(b.str(typ)))
+	vt.varint(valueTypeUnit, uint64(b.str(unit)))
+	b.out.message(field, vt)
+}
+
+// location is one line of a func, the func is the same for every line.
+func (b *pprofBuilder) location(frame runtime.ProfileFrame) uint64 {
+	if id, ok := b.locations[frame]; ok {
+		return id
+	}
+	fn := runtime.ProfileFrame{Func: frame.Func, File: frame.File, FuncLine: frame.FuncLine}
+	fnID, ok := b.functions[fn]
+	if !ok {
+		fnID = uint64(len(b.functions) + 1)
+		b.functions[fn] = fnID
+		// pprof drops anything in angle brackets as template arguments
+		name := strings.Trim(fn.Func, "<>")
+		var f encoder
+		f.varint(functionID, fnID)
+		f.varint(functionName, uint64(b.str(name)))
+		f.varint(functionSystemName, uint64(b.str(name)))
+		f.varint(functionFilename, uint64(b.str(fn.File)))
+		f.varint(functionStartLine, uint64(fn.FuncLine))
+		b.out.message(profileFunction, f)
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[frame] = id
+	var line, loc encoder
+	line.varint(lineFunctionID, fnID)
+	line.varint(lineLine, uint64(frame.Line))
+	loc.varint(locationID, id)
+	loc.message(locationLine, line)
+	b.out.message(profileLocation, loc)
+	return id
+}
+
+func (e *encoder) uvarint(x uint64) {
+	for x >= 0x80 {
+		e.buf = append(e.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	e.buf = append(e.buf, byte(x))
+}
+
+func (e *encoder) varint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	e.uvarint(uint64(field) << 3)
+	e.uvarint(x)
+}
+
+func (e *encoder) bytes(field int, data []byte) {
+	e.uvarint(uint64(field)<<3 | 2)
+	e.uvarint(uint64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+func (e *encoder) message(field int, msg encoder) {
+	e.bytes(field, msg.buf)
+}
+
+func (e *encoder) packed(field int, xs []uint64) {
+	var p encoder
+	for _, x := range xs {
+		p.uvarint(x)
+	}
+	e.bytes(field, p.buf)
+}
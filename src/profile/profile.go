@@ -0,0 +1,127 @@
+// Package profile writes the runtime.Profile of a program as a pprof profile,
+// a flat text summary or a Chrome trace.
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// funcStats is the total for one func. Flat is the time spent in the func
+	// itself and cum includes the funcs it called.
+	funcStats struct {
+		name  string
+		file  string
+		line  int
+		calls int64
+		flat  time.Duration
+		cum   time.Duration
+	}
+
+	traceEvent struct {
+		Name string                 `json:"name"`
+		Cat  string                 `json:"cat"`
+		Ph   string                 `json:"ph"`
+		Ts   float64                `json:"ts"`
+		Dur  float64                `json:"dur"`
+		Pid  int                    `json:"pid"`
+		Tid  int64                  `json:"tid"`
+		Args map[string]interface{} `json:"args,omitempty"`
+	}
+)
+
+// WriteText writes the funcs that took the most time, with the share of the
+// whole run that they took and how often they were called.
+func WriteText(w io.Writer, prof *runtime.Profile) error {
+	stats := summarize(prof)
+	var total time.Duration
+	for _, s := range stats {
+		total += s.flat
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "flat\tflat%%\tcum\tcum%%\tcalls\t \tfunc\t\n")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%v\t%.2f%%\t%v\t%.2f%%\t%v\t \t%v %v:%v\t\n",
+			s.flat.Round(time.Microsecond), percent(s.flat, total),
+			s.cum.Round(time.Microsecond), percent(s.cum, total),
+			s.calls, s.name, s.file, s.line)
+	}
+	return tw.Flush()
+}
+
+func percent(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(d) / float64(total)
+}
+
+// summarize totals the samples by func, most flat time first.
+func summarize(prof *runtime.Profile) []*funcStats {
+	byFunc := map[runtime.ProfileFrame]*funcStats{}
+	stat := func(frame runtime.ProfileFrame) *funcStats {
+		key := runtime.ProfileFrame{Func: frame.Func, File: frame.File, FuncLine: frame.FuncLine}
+		if s, ok := byFunc[key]; ok {
+			return s
+		}
+		byFunc[key] = &funcStats{name: frame.Func, file: frame.File, line: frame.FuncLine}
+		return byFunc[key]
+	}
+	for _, sample := range prof.Samples() {
+		leaf := stat(sample.Stack[0])
+		leaf.flat += sample.Time
+		leaf.calls += sample.Calls
+		seen := map[*funcStats]bool{}
+		for _, frame := range sample.Stack {
+			if s := stat(frame); !seen[s] {
+				seen[s] = true
+				s.cum += sample.Time
+			}
+		}
+	}
+	stats := make([]*funcStats, 0, len(byFunc))
+	for _, s := range byFunc {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].flat != stats[j].flat {
+			return stats[i].flat > stats[j].flat
+		}
+		return stats[i].name < stats[j].name
+	})
+	return stats
+}
+
+// WriteTrace writes the spans of a profile in the Chrome trace event format
+// that chrome://tracing and Perfetto show as a timeline. Each thread of the
+// program gets its own track.
+func WriteTrace(w io.Writer, prof *runtime.Profile) error {
+	events := []traceEvent{}
+	for _, span := range prof.Spans() {
+		events = append(events, traceEvent{
+			Name: span.Func,
+			Cat:  "squirt",
+			Ph:   "X",
+			Ts:   micros(span.Start),
+			Dur:  micros(span.Duration),
+			Pid:  1,
+			Tid:  span.Thread,
+			Args: map[string]interface{}{"file": span.File, "line": span.Line},
+		})
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
@@ -0,0 +1,60 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+func profileOf(t *testing.T, src string) *runtime.Profile {
+	file, err := ioutil.TempFile("", "*.sqrt")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(src)
+	file.Close()
+
+	prof := runtime.NewProfile(true)
+	interp := runtime.NewInterpreter(runtime.Options{Profile: prof})
+	_, err = interp.EvalFile(file.Name())
+	assert.Nil(t, err)
+	prof.Stop()
+	return prof
+}
+
+func TestWriters(t *testing.T) {
+	prof := profileOf(t, "func double(n)\n  return n * 2\nend\nfor i = 0, i < 5, i++ do\n  double(i)\nend\n")
+
+	var pprof bytes.Buffer
+	assert.Nil(t, WritePprof(&pprof, prof))
+	gz, err := gzip.NewReader(&pprof)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(gz)
+	assert.Nil(t, err)
+	for _, str := range []string{"double", "main", "calls", "count", "time", "nanoseconds"} {
+		assert.True(t, bytes.Contains(data, []byte(str)), "pprof is missing %v", str)
+	}
+
+	var text strings.Builder
+	assert.Nil(t, WriteText(&text, prof))
+	lines := strings.Split(text.String(), "\n")
+	assert.Contains(t, lines[0], "flat%")
+	assert.Regexp(t, `(?m)\s5\s+double .*:1$`, strings.TrimSpace(strings.Join(lines, "\n")))
+
+	var trace bytes.Buffer
+	assert.Nil(t, WriteTrace(&trace, prof))
+	var events struct{ TraceEvents []traceEvent }
+	assert.Nil(t, json.Unmarshal(trace.Bytes(), &events))
+	if assert.Len(t, events.TraceEvents, 6) {
+		assert.Equal(t, "double", events.TraceEvents[0].Name)
+		assert.Equal(t, "X", events.TraceEvents[0].Ph)
+		assert.Equal(t, "<main>", events.TraceEvents[5].Name)
+	}
+}
@@ -3,13 +3,18 @@ package runtime
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"unicode"
 
 	"github.com/tanema/squirt/src/lang"
 )
 
 type (
+	// Attribute is an attribute of a class. The value of an attribute that is
+	// not constant can be assigned while tasks are reading it so it is guarded
+	// by mu. Methods are constant so calling them never takes the lock.
 	Attribute struct {
+		mu      sync.RWMutex
 		name    string
 		val     Value
 		private bool
@@ -49,10 +54,11 @@ func Attr(name string, val Value, refinements *Refinement) *Attribute {
 }
 
 func (attr *Attribute) call(s *Scope, self *Instance, args []Value) (Value, error) {
-	if fn, is := attr.val.(*Func); is {
+	val := attr.value()
+	if fn, is := val.(*Func); is {
 		return fn.call(s, self, args)
 	}
-	return nil, fmt.Errorf("tried to call a non callable object (%v)", typeOf(attr.val))
+	return nil, fmt.Errorf("tried to call a non callable object (%v)", typeOf(val))
 }
 
 func (attr *Attribute) get(scope *Scope, key Value, inst *Instance, allowPrivate bool) (Value, error) {
@@ -60,11 +66,11 @@ func (attr *Attribute) get(scope *Scope, key Value, inst *Instance, allowPrivate
 		if !allowPrivate && attr.refine.get != "" {
 			return inst.Op(attr.refine.get, scope)
 		}
-		if val, ok := inst.data[toString(scope, key)]; ok {
+		if val, ok := inst.lookup(toString(scope, key)); ok {
 			return val, nil
 		}
 	}
-	return attr.val, nil
+	return attr.value(), nil
 }
 
 func (attr *Attribute) set(scope *Scope, key, val Value, inst *Instance, allowPrivate bool) (Value, error) {
@@ -81,11 +87,22 @@ func (attr *Attribute) set(scope *Scope, key, val Value, inst *Instance, allowPr
 			return inst.Op(attr.refine.set, scope, val)
 		}
 		strkey := toString(scope, key)
-		inst.data[strkey] = val
-		return inst.data[strkey], nil
+		inst.setField(strkey, val)
+		return val, nil
 	}
+	attr.mu.Lock()
 	attr.val = val
-	return attr.val, nil
+	attr.mu.Unlock()
+	return val, nil
+}
+
+func (attr *Attribute) value() Value {
+	if attr.refine.constant {
+		return attr.val
+	}
+	attr.mu.RLock()
+	defer attr.mu.RUnlock()
+	return attr.val
 }
 
 func parseRefinement(scope *Scope, runtime *Runtime, cond *lang.Object) (*Refinement, error) {
@@ -96,10 +113,13 @@ func parseRefinement(scope *Scope, runtime *Runtime, cond *lang.Object) (*Refine
 	if err != nil {
 		return nil, err
 	}
+	return tableRefinement(scope, tbl)
+}
 
+func tableRefinement(scope *Scope, tbl *Table) (*Refinement, error) {
 	refine := &Refinement{}
 	for i, key := range tbl.Keys {
-		name, isStrName := key.(*Instance).data["_val"].(string)
+		name, isStrName := key.(*Instance).field("_val").(string)
 		if !isStrName {
 			continue
 		}
@@ -108,7 +128,7 @@ func parseRefinement(scope *Scope, runtime *Runtime, cond *lang.Object) (*Refine
 			refine.constant = toBool(scope, tbl.Values[i])
 		case "type":
 			if inst, is := tbl.Values[i].(*Instance); is && inst.IsA("String") {
-				refine.class = inst.data["_val"].(string)
+				refine.class = inst.field("_val").(string)
 			} else if cls, is := tbl.Values[i].(*Class); is {
 				refine.class = cls.name
 			} else {
@@ -118,7 +138,7 @@ func parseRefinement(scope *Scope, runtime *Runtime, cond *lang.Object) (*Refine
 			refine.required = toBool(scope, tbl.Values[i])
 		case "get":
 			if inst, is := tbl.Values[i].(*Instance); is && inst.IsA("String") {
-				refine.get = inst.data["_val"].(string)
+				refine.get = inst.field("_val").(string)
 			} else if fn, is := tbl.Values[i].(*Func); is {
 				refine.get = fn.Name
 			} else {
@@ -126,7 +146,7 @@ func parseRefinement(scope *Scope, runtime *Runtime, cond *lang.Object) (*Refine
 			}
 		case "set":
 			if inst, is := tbl.Values[i].(*Instance); is && inst.IsA("String") {
-				refine.set = inst.data["_val"].(string)
+				refine.set = inst.field("_val").(string)
 			} else if fn, is := tbl.Values[i].(*Func); is {
 				refine.set = fn.Name
 			} else {
@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type (
+	// channel passes values between tasks.
+	channel struct {
+		c chan Value
+	}
+
+	// selectCase is one of the channel operations that a select waits on.
+	selectCase struct {
+		ch   *channel
+		send bool
+		val  Value
+	}
+)
+
+var ChannelClass = CreateClass("Channel", nil,
+	Attr("_ch", nil, nil),
+	FnAttr("new", func(s *Scope, self CVal, args []Value) (Value, error) {
+		size := 0
+		if len(args) > 0 {
+			size = int(toNumber(args[0]))
+		}
+		if size < 0 {
+			return createErr(s, ArgumentError, "channel size cannot be negative")
+		}
+		self.(*Instance).setField("_ch", &channel{c: make(chan Value, size)})
+		return nil, nil
+	}),
+	FnAttr("send", func(s *Scope, self CVal, args []Value) (Value, error) {
+		ch, err := toChannel(self)
+		if err != nil {
+			return nil, err
+		}
+		var val Value
+		if len(args) > 0 {
+			val = args[0]
+		}
+		_, _, err = selectChannels(s, []selectCase{{ch: ch, send: true, val: val}}, true)
+		return nil, err
+	}),
+	FnAttr("recv", func(s *Scope, self CVal, args []Value) (Value, error) {
+		ch, err := toChannel(self)
+		if err != nil {
+			return nil, err
+		}
+		_, val, err := selectChannels(s, []selectCase{{ch: ch}}, true)
+		return val, err
+	}),
+	FnAttr("close", func(s *Scope, self CVal, args []Value) (val Value, err error) {
+		ch, err := toChannel(self)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if recover() != nil {
+				err = fmt.Errorf("close of closed channel")
+			}
+		}()
+		close(ch.c)
+		return nil, nil
+	}),
+	FnAttr("__len", func(s *Scope, self CVal, args []Value) (Value, error) {
+		ch, err := toChannel(self)
+		if err != nil {
+			return nil, err
+		}
+		return len(ch.c), nil
+	}),
+	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
+		return "#<Channel>", nil
+	}),
+)
+
+func toChannel(val Value) (*channel, error) {
+	if inst, ok := val.(*Instance); ok {
+		if ch, ok := inst.field("_ch").(*channel); ok {
+			return ch, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a Channel but got %v", typeOf(val))
+}
+
+// selectChannels waits until one of the cases can go ahead and reports which
+// one did along with the value it received. If block is false and no case is
+// ready it reports -1 instead of waiting. Receiving from a closed channel
+// gives nil. Waiting stops with a TimeoutError once the evaluation is
+// cancelled.
+func selectChannels(s *Scope, cases []selectCase, block bool) (chosen int, val Value, err error) {
+	reflected := make([]reflect.SelectCase, 0, len(cases)+1)
+	for i, c := range cases {
+		sc := reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch.c)}
+		if c.send {
+			sc.Dir = reflect.SelectSend
+			sc.Send = reflect.ValueOf(&cases[i].val).Elem()
+		}
+		reflected = append(reflected, sc)
+	}
+	if !block {
+		reflected = append(reflected, reflect.SelectCase{Dir: reflect.SelectDefault})
+	} else if done := s.thread.done(); done != nil {
+		reflected = append(reflected, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("send on closed channel")
+		}
+	}()
+	chosen, recv, ok := reflect.Select(reflected)
+	if chosen == len(cases) && !block {
+		return -1, nil, nil
+	} else if chosen == len(cases) {
+		return -1, nil, timeout(s)
+	} else if ok {
+		val = recv.Interface()
+	}
+	return chosen, val, nil
+}
@@ -15,7 +15,7 @@ func CreateClass(name string, parent *Class, attrs ...*Attribute) *Class {
 		attributes: map[Value]*Attribute{},
 	}
 	for _, attr := range attrs {
-		if fn, ok := attr.val.(*Func); ok {
+		if fn, ok := attr.value().(*Func); ok {
 			fn.ClassName = name
 		}
 		class.attributes[attr.name] = attr
@@ -44,9 +44,9 @@ func (class *Class) New(scope *Scope, args ...Value) (*Instance, error) {
 	} else if len(args) > 0 {
 		for _, opts := range args {
 			if inst, is := opts.(*Instance); is && inst.IsA("Table") {
-				tbl := inst.data["_tbl"].(*Table)
-				for i, key := range tbl.Keys {
-					if _, err := class.set(scope, key, tbl.Values[i], newInst, true); err != nil {
+				_, keys, vals := inst.field("_tbl").(*Table).Entries()
+				for i, key := range keys {
+					if _, err := class.set(scope, key, vals[i], newInst, true); err != nil {
 						return nil, err
 					}
 				}
@@ -80,6 +80,11 @@ func (class *Class) index(scope *Scope, key Value, inst *Instance, allowPrivate
 	return nil, fmt.Errorf("undefined attribute %v on class %v", toString(scope, key), class.name)
 }
 
+// Name is the name the class was defined with.
+func (class *Class) Name() string {
+	return class.name
+}
+
 func (class *Class) ToString(s *Scope) string {
 	return "#<Class " + class.name + ">"
 }
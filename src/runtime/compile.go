@@ -0,0 +1,1038 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+type (
+	// proto is a compiled func body. Function locals are resolved to slots at
+	// compile time unless the proto is dynamic, in which case names are looked up
+	// in a Scope the same way the tree walker does it.
+	proto struct {
+		name       string
+		className  string
+		lineno     int
+		params     []string
+		vararg     bool
+		chunk      bool
+		dynamic    bool
+		generator  bool
+		selfSlot   int
+		code       []instruction
+		pos        [][4]int
+		consts     []Value
+		names      []string
+		protos     []*proto
+		classes    []classDesc
+		handlers   []handlerDesc
+		patterns   []lang.Object
+		selects    []selectDesc
+		imports    []lang.Object
+		assigns    [][]int
+		localNames []string
+		weak       []bool
+		captured   []bool
+		upvals     []upvalDesc
+	}
+
+	upvalDesc struct {
+		name      string
+		weak      bool
+		fromLocal bool
+		index     int
+	}
+
+	classDesc struct {
+		name  string
+		attrs []classAttr
+	}
+
+	classAttr struct {
+		name   string
+		method bool
+	}
+
+	handlerDesc struct {
+		clauses []catchClause
+	}
+
+	// selectDesc describes the cases of a select. Cases that send or receive
+	// are in the order their channels are pushed and the fallback is the case
+	// that runs when no channel is ready, or -1 if the select waits.
+	selectDesc struct {
+		sends    []bool
+		targets  []int
+		fallback int
+	}
+
+	catchClause struct {
+		classes []string
+		target  int
+	}
+
+	funcState struct {
+		parent *funcState
+		p      *proto
+		locals map[string]int
+		upvals map[string]int
+		loops  []*loopState
+		blocks int
+		pos    [4]int
+	}
+
+	loopState struct {
+		blocks int
+		breaks []int
+		nexts  []int
+	}
+
+	compiler struct {
+		scope *Scope
+		fs    *funcState
+	}
+
+	varKind int
+)
+
+const (
+	varName varKind = iota
+	varLocal
+	varUpval
+)
+
+func compileChunk(scope *Scope, block, catches []lang.Object) *proto {
+	c := &compiler{scope: scope}
+	p := &proto{name: "<main>", chunk: true, dynamic: true}
+	c.fs = &funcState{p: p}
+	c.block(block, catches)
+	return p
+}
+
+func compileStatement(scope *Scope, obj lang.Object) *proto {
+	c := &compiler{scope: scope}
+	p := &proto{name: "<main>", chunk: true, dynamic: true}
+	c.fs = &funcState{p: p, pos: obj.Pos}
+	switch {
+	case obj.Kind == lang.ClassDef:
+		c.class(obj, true)
+		c.emit(opReturn, 1, 0)
+	case isExpression(obj.Kind):
+		c.expr(obj)
+		c.emit(opReturn, 1, 0)
+	default:
+		c.statement(obj)
+	}
+	return p
+}
+
+func isExpression(kind lang.NodeKind) bool {
+	switch kind {
+	case lang.FuncCall, lang.FuncDef, lang.Binary, lang.Unary, lang.Table, lang.Index,
+		lang.Member, lang.Identifier, lang.String, lang.Bool, lang.Number, lang.Nil,
+		lang.Spread, lang.Range, lang.Ternary, lang.Match, lang.Yield:
+		return true
+	}
+	return false
+}
+
+func (c *compiler) emit(op opcode, a, b int) int {
+	p := c.fs.p
+	p.code = append(p.code, instruction{op: op, a: a, b: b})
+	p.pos = append(p.pos, c.fs.pos)
+	return len(p.code) - 1
+}
+
+func (c *compiler) here() int { return len(c.fs.p.code) }
+
+func (c *compiler) patch(at int) { c.fs.p.code[at].a = c.here() }
+
+func (c *compiler) at(obj lang.Object) func() {
+	prev := c.fs.pos
+	if obj.Pos != [4]int{} {
+		c.fs.pos = obj.Pos
+	}
+	return func() { c.fs.pos = prev }
+}
+
+func (c *compiler) name(name string) int {
+	p := c.fs.p
+	for i, n := range p.names {
+		if n == name {
+			return i
+		}
+	}
+	p.names = append(p.names, name)
+	return len(p.names) - 1
+}
+
+func (c *compiler) constant(val interface{}) int {
+	inst, _ := ToValue(c.scope, val)
+	p := c.fs.p
+	p.consts = append(p.consts, inst)
+	return len(p.consts) - 1
+}
+
+func (c *compiler) raise(msg string, data ...interface{}) {
+	c.emit(opRaise, c.name(fmt.Sprintf(msg, data...)), 0)
+}
+
+func (c *compiler) block(block, catches []lang.Object) {
+	c.try(catches, func() {
+		for _, obj := range block {
+			c.trace(opStatement, obj.Pos, 0)
+			c.statement(obj)
+		}
+	}, c.block)
+}
+
+// valueBlock compiles a block that leaves the value of its last statement on
+// the stack, or nil if the last statement is not an expression.
+func (c *compiler) valueBlock(block, catches []lang.Object) {
+	c.try(catches, func() {
+		for i, obj := range block {
+			c.trace(opStatement, obj.Pos, 0)
+			if i == len(block)-1 && isExpression(obj.Kind) {
+				done := c.at(obj)
+				c.expr(obj)
+				done()
+				return
+			}
+			c.statement(obj)
+		}
+		c.emit(opNil, 0, 0)
+	}, c.valueBlock)
+}
+
+// traced is true when a Hook or Coverage wants to hear about every statement
+// and branch. Funcs keep their locals in a Scope then so a Hook can see them.
+func (c *compiler) traced() bool {
+	opts := &c.scope.interp.opts
+	return opts.Hook != nil || opts.Coverage != nil
+}
+
+// trace emits op at pos when the program is traced.
+func (c *compiler) trace(op opcode, pos [4]int, a int) {
+	if !c.traced() {
+		return
+	}
+	prev := c.fs.pos
+	c.fs.pos = pos
+	c.emit(op, a, 0)
+	c.fs.pos = prev
+}
+
+// try compiles body so that errors raised in it are handled by catches. Each
+// cleanup body is compiled with clause.
+func (c *compiler) try(catches []lang.Object, body func(), clause func(block, catches []lang.Object)) {
+	if len(catches) == 0 {
+		body()
+		return
+	}
+
+	p := c.fs.p
+	handler := len(p.handlers)
+	p.handlers = append(p.handlers, handlerDesc{})
+	c.emit(opTry, handler, 0)
+	c.fs.blocks++
+	body()
+	c.emit(opPopBlock, 0, 0)
+	c.fs.blocks--
+	exits := []int{c.emit(opJump, 0, 0)}
+
+	clauses := []catchClause{}
+	for _, catch := range catches {
+		done := c.at(catch)
+		classes := []string{}
+		for _, cls := range catch.Vars {
+			classes = append(classes, cls.Name)
+		}
+		clauses = append(clauses, catchClause{classes: classes, target: c.here()})
+		if catch.Name == "" {
+			c.emit(opPop, 0, 0)
+			clause(catch.Block, catch.Catches)
+		} else {
+			c.bind([]string{catch.Name}, func() { clause(catch.Block, catch.Catches) })
+		}
+		exits = append(exits, c.emit(opJump, 0, 0))
+		done()
+	}
+	p.handlers[handler].clauses = clauses
+	for _, exit := range exits {
+		c.patch(exit)
+	}
+}
+
+// bind pops the values on top of the stack into names that are only visible
+// while body is compiled. The last name is on top of the stack.
+func (c *compiler) bind(names []string, body func()) {
+	if c.fs.p.dynamic {
+		for i := len(names) - 1; i >= 0; i-- {
+			c.emit(opScope, c.name(names[i]), 0)
+			c.fs.blocks++
+		}
+		body()
+		c.unbind(len(names))
+		return
+	}
+
+	type shadow struct {
+		slot int
+		ok   bool
+	}
+	prev := make([]shadow, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		slot, ok := c.fs.locals[names[i]]
+		prev[i] = shadow{slot, ok}
+		c.emit(opSetLocal, c.declare(names[i], false), 0)
+	}
+	body()
+	for i := range names {
+		if prev[i].ok {
+			c.fs.locals[names[i]] = prev[i].slot
+		} else {
+			delete(c.fs.locals, names[i])
+		}
+	}
+}
+
+// unbind pops the scopes that bind pushed for n names in a dynamic proto.
+func (c *compiler) unbind(n int) {
+	for i := 0; i < n; i++ {
+		c.emit(opPopBlock, 0, 0)
+		c.fs.blocks--
+	}
+}
+
+func (c *compiler) statement(obj lang.Object) {
+	defer c.at(obj)()
+	switch obj.Kind {
+	case lang.Assignment:
+		c.assign(obj)
+	case lang.FuncDef:
+		c.funcDef(obj, false)
+	case lang.ClassDef:
+		c.class(obj, false)
+	case lang.If:
+		c.ifStatement(obj)
+	case lang.Do:
+		c.block(obj.Block, obj.Catches)
+	case lang.ForIn:
+		c.forIn(obj)
+	case lang.ForNum:
+		c.forNum(obj)
+	case lang.While:
+		c.while(obj)
+	case lang.Match:
+		c.match(obj, false)
+	case lang.Select:
+		c.selectStatement(obj)
+	case lang.Export:
+		c.statement(exportDecl(obj))
+	case lang.Import:
+		c.fs.p.imports = append(c.fs.p.imports, obj)
+		c.emit(opImport, len(c.fs.p.imports)-1, 0)
+	case lang.Return:
+		for _, val := range obj.Vals {
+			c.expr(val)
+		}
+		c.emit(opReturn, len(obj.Vals), 0)
+	case lang.Break:
+		loop := c.fs.loops[len(c.fs.loops)-1]
+		c.unwindTo(loop.blocks)
+		loop.breaks = append(loop.breaks, c.emit(opJump, 0, 0))
+	case lang.Next:
+		loop := c.fs.loops[len(c.fs.loops)-1]
+		c.unwindTo(loop.blocks)
+		loop.nexts = append(loop.nexts, c.emit(opJump, 0, 0))
+	default:
+		if !isExpression(obj.Kind) {
+			c.raise("missed object kind %v, this means squirt is broken and it is not your code", obj.Kind)
+			return
+		}
+		c.expr(obj)
+		c.emit(opPop, 0, 0)
+	}
+}
+
+func (c *compiler) unwindTo(blocks int) {
+	if c.fs.blocks > blocks {
+		c.emit(opUnwind, blocks, 0)
+	}
+}
+
+func (c *compiler) expr(obj lang.Object) {
+	defer c.at(obj)()
+	switch obj.Kind {
+	case lang.Nil:
+		c.emit(opConst, c.constant(nil), 0)
+	case lang.Bool:
+		c.emit(opConst, c.constant(obj.BoolValue), 0)
+	case lang.Number:
+		c.emit(opConst, c.constant(obj.NumberValue), 0)
+	case lang.String:
+		c.stringLit(obj.StringValue)
+	case lang.Identifier:
+		c.load(obj.Name)
+	case lang.Table:
+		c.table(obj)
+	case lang.FuncDef:
+		c.funcDef(obj, true)
+	case lang.FuncCall:
+		c.call(obj)
+	case lang.Index, lang.Member:
+		c.expr(obj.Vals[0])
+		c.key(obj)
+		c.emit(opIndex, 0, 0)
+	case lang.Range:
+		c.expr(obj.Vals[0])
+		c.expr(obj.Vals[1])
+		c.emit(opRange, 0, 0)
+	case lang.Spread:
+		c.expr(*obj.Value)
+		c.emit(opSpread, 0, 0)
+	case lang.Match:
+		c.match(obj, true)
+	case lang.Yield:
+		for _, val := range obj.Vals {
+			c.expr(val)
+		}
+		c.emit(opYield, len(obj.Vals), 0)
+	case lang.Ternary:
+		c.expr(obj.Vals[0])
+		otherwise := c.emit(opJumpIfFalse, 0, 0)
+		c.trace(opBranch, obj.Pos, 0)
+		c.expr(obj.Vals[1])
+		end := c.emit(opJump, 0, 0)
+		c.patch(otherwise)
+		c.trace(opBranch, obj.Pos, 1)
+		c.expr(obj.Vals[2])
+		c.patch(end)
+	case lang.Unary:
+		if obj.Name == "@" {
+			protect := c.emit(opProtect, 0, 0)
+			c.fs.blocks++
+			c.expr(*obj.Value)
+			c.emit(opEndProtect, 0, 0)
+			c.fs.blocks--
+			c.patch(protect)
+			return
+		}
+		c.expr(*obj.Value)
+		c.emit(opUnary, c.name(obj.Name), 0)
+	case lang.Binary:
+		c.expr(obj.Vals[0])
+		switch obj.Name {
+		case "and", "or":
+			op := opAnd
+			if obj.Name == "or" {
+				op = opOr
+			}
+			short := c.emit(op, 0, 0)
+			c.expr(obj.Vals[1])
+			c.patch(short)
+		default:
+			c.expr(obj.Vals[1])
+			c.emit(opBinary, c.name(obj.Name), 0)
+		}
+	default:
+		c.raise("missed object kind %v, this means squirt is broken and it is not your code", obj.Kind)
+	}
+}
+
+// key pushes the key of an index or member expression. Member names are
+// constant strings rather than variable lookups.
+func (c *compiler) key(obj lang.Object) {
+	if obj.Kind == lang.Member && obj.Vals[1].Kind == lang.Identifier {
+		c.emit(opString, c.name(obj.Vals[1].Name), 0)
+	} else {
+		c.expr(obj.Vals[1])
+	}
+}
+
+func (c *compiler) stringLit(str string) {
+	segments := lang.Segments(str)
+	if len(segments) == 1 && !segments[0].Expr {
+		c.emit(opString, c.name(str), 0)
+		return
+	}
+	for _, seg := range segments {
+		if !seg.Expr {
+			c.emit(opString, c.name(seg.Text), 0)
+			continue
+		}
+		ast, err := lang.ParseStr(seg.Text)
+		if err != nil {
+			c.raise(err.Error())
+		} else if len(ast.Block) == 0 {
+			c.emit(opNil, 0, 0)
+		} else if isExpression(ast.Block[0].Kind) {
+			c.expr(ast.Block[0])
+		} else {
+			c.statement(ast.Block[0])
+			c.emit(opNil, 0, 0)
+		}
+	}
+	c.emit(opConcat, len(segments), 0)
+}
+
+func (c *compiler) table(obj lang.Object) {
+	c.emit(opNewTable, 0, 0)
+	for _, val := range obj.Vals {
+		done := c.at(val)
+		switch val.Kind {
+		case lang.TableValue:
+			c.expr(*val.Value)
+			c.emit(opTableAppend, 0, 0)
+		case lang.TableKey:
+			if val.Key.Kind == lang.Identifier {
+				c.emit(opString, c.name(val.Key.Name), 0)
+			} else {
+				c.expr(*val.Key)
+			}
+			c.expr(*val.Value)
+			c.emit(opTableKey, 0, 0)
+		}
+		done()
+	}
+	c.emit(opTableInst, 0, 0)
+}
+
+func (c *compiler) call(obj lang.Object) {
+	callee := *obj.Value
+	if callee.Kind == lang.Member || callee.Kind == lang.Index {
+		done := c.at(callee)
+		c.expr(callee.Vals[0])
+		c.key(callee)
+		c.emit(opMethod, 0, 0)
+		done()
+	} else {
+		c.emit(opNil, 0, 0)
+		c.expr(callee)
+	}
+	for _, arg := range obj.Vals {
+		c.expr(arg)
+	}
+	c.emit(opCall, len(obj.Vals), 0)
+}
+
+// assign compiles an assignment so that targets are assigned as soon as their
+// value has been evaluated. Each target pulls its value, which jumps out to
+// evaluate the next value expression if there isn't one available yet.
+func (c *compiler) assign(obj lang.Object) {
+	if len(obj.Vars) == 1 && len(obj.Vals) == 1 {
+		c.expr(obj.Vals[0])
+		c.emit(opAssign, 1, 1)
+		c.store(obj.Vars[0])
+		return
+	}
+	p := c.fs.p
+	idx := len(p.assigns)
+	p.assigns = append(p.assigns, []int{})
+	c.emit(opAssignBegin, idx, len(obj.Vars))
+	for i, target := range obj.Vars {
+		c.emit(opAssignPull, i, 0)
+		c.store(target)
+	}
+	c.emit(opPop, 0, 0)
+	end := c.emit(opJump, 0, 0)
+	for _, val := range obj.Vals {
+		p.assigns[idx] = append(p.assigns[idx], c.here())
+		c.expr(val)
+		c.emit(opAssignFeed, 0, 0)
+	}
+	c.patch(end)
+}
+
+// store pops the top of the stack into an assignable target.
+func (c *compiler) store(target lang.Object) {
+	defer c.at(target)()
+	switch target.Kind {
+	case lang.Identifier:
+		c.storeName(target.Name)
+	case lang.Member, lang.Index:
+		c.expr(target.Vals[0])
+		c.key(target)
+		c.emit(opSetIndex, 0, 0)
+	default:
+		c.emit(opPop, 0, 0)
+		c.raise("cannot assign to type %v", target.Kind)
+	}
+}
+
+func (c *compiler) funcDef(obj lang.Object, keep bool) {
+	p := c.compileFunc(obj, "")
+	c.fs.p.protos = append(c.fs.p.protos, p)
+	c.emit(opFunc, len(c.fs.p.protos)-1, 0)
+	if obj.Value == nil {
+		if !keep {
+			c.emit(opPop, 0, 0)
+		}
+		return
+	}
+	switch obj.Value.Kind {
+	case lang.Identifier, lang.Member:
+		if keep {
+			c.emit(opDup, 0, 0)
+		}
+		c.store(*obj.Value)
+	default:
+		if !keep {
+			c.emit(opPop, 0, 0)
+		}
+	}
+}
+
+func (c *compiler) class(obj lang.Object, keep bool) {
+	if obj.Parent != "" {
+		c.emit(opParent, c.name(obj.Parent), 0)
+	} else {
+		c.emit(opNil, 0, 0)
+	}
+	desc := classDesc{name: obj.Name}
+	for _, attr := range obj.Block {
+		done := c.at(attr)
+		switch attr.Kind {
+		case lang.FuncDef:
+			c.fs.p.protos = append(c.fs.p.protos, c.compileFunc(attr, obj.Name))
+			c.emit(opFunc, len(c.fs.p.protos)-1, 0)
+			desc.attrs = append(desc.attrs, classAttr{name: attr.Value.Name, method: true})
+		case lang.AttrDef:
+			if attr.Value != nil {
+				c.expr(*attr.Value)
+			} else {
+				c.emit(opNil, 0, 0)
+			}
+			if attr.Cond != nil {
+				c.expr(*attr.Cond)
+			} else {
+				c.emit(opNil, 0, 0)
+			}
+			desc.attrs = append(desc.attrs, classAttr{name: attr.Name})
+		default:
+			c.raise("unexpected object kind %v in class declr, this means squirt is broken and it is not your code", attr.Kind)
+		}
+		done()
+	}
+	c.fs.p.classes = append(c.fs.p.classes, desc)
+	c.emit(opClass, len(c.fs.p.classes)-1, 0)
+	if keep {
+		c.emit(opDup, 0, 0)
+	}
+	c.storeName(obj.Name)
+}
+
+func (c *compiler) ifStatement(obj lang.Object) {
+	exits := []int{}
+	for i, clause := range obj.Block {
+		done := c.at(clause)
+		if clause.Cond == nil {
+			c.trace(opBranch, obj.Pos, i)
+			c.block(clause.Block, clause.Catches)
+			done()
+			break
+		}
+		c.expr(*clause.Cond)
+		skip := c.emit(opJumpIfFalse, 0, 0)
+		c.trace(opBranch, obj.Pos, i)
+		c.block(clause.Block, clause.Catches)
+		exits = append(exits, c.emit(opJump, 0, 0))
+		c.patch(skip)
+		done()
+		if i == len(obj.Block)-1 {
+			c.trace(opBranch, obj.Pos, len(obj.Block))
+		}
+	}
+	for _, exit := range exits {
+		c.patch(exit)
+	}
+}
+
+// match leaves the subject on the stack while the cases are tried. opMatch
+// pushes the values a pattern binds when it matches and jumps to the next case
+// when it does not. As an expression the body of the matched case leaves its
+// value on the stack.
+func (c *compiler) match(obj lang.Object, value bool) {
+	body := c.block
+	if value {
+		body = c.valueBlock
+	}
+	c.try(obj.Catches, func() {
+		c.expr(*obj.Value)
+		exits := []int{}
+		for _, clause := range obj.Block {
+			done := c.at(clause)
+			p := c.fs.p
+			p.patterns = append(p.patterns, *clause.Key)
+			next := c.emit(opMatch, 0, len(p.patterns)-1)
+			names := patternNames(*clause.Key)
+			guard := -1
+			c.bind(names, func() {
+				if clause.Cond != nil {
+					c.expr(*clause.Cond)
+					guard = c.emit(opJumpIfFalse, 0, 0)
+				}
+				c.emit(opPop, 0, 0)
+				body(clause.Block, nil)
+			})
+			exits = append(exits, c.emit(opJump, 0, 0))
+			if guard >= 0 {
+				c.patch(guard)
+				if p.dynamic {
+					for range names {
+						c.emit(opPopBlock, 0, 0)
+					}
+				}
+			}
+			c.patch(next)
+			done()
+		}
+		c.emit(opPop, 0, 0)
+		if value {
+			c.emit(opNil, 0, 0)
+		}
+		for _, exit := range exits {
+			c.patch(exit)
+		}
+	}, body)
+}
+
+// selectStatement pushes the channel of every case and the value it sends, or
+// nil for a receive. opSelect pops them, waits for a case and jumps to it with
+// the received value on top of the stack.
+func (c *compiler) selectStatement(obj lang.Object) {
+	c.try(obj.Catches, func() {
+		p := c.fs.p
+		desc := selectDesc{fallback: -1}
+		for _, clause := range obj.Block {
+			if clause.Value == nil {
+				continue
+			}
+			done := c.at(*clause.Value)
+			op := *clause.Value
+			send := op.Value.Vals[1].Name == "send"
+			c.expr(op.Value.Vals[0])
+			if send {
+				c.expr(op.Vals[0])
+			} else {
+				c.emit(opNil, 0, 0)
+			}
+			desc.sends = append(desc.sends, send)
+			done()
+		}
+		index := len(p.selects)
+		p.selects = append(p.selects, desc)
+		c.emit(opSelect, index, 0)
+
+		exits := []int{}
+		for _, clause := range obj.Block {
+			done := c.at(clause)
+			if clause.Value == nil {
+				p.selects[index].fallback = c.here()
+			} else {
+				p.selects[index].targets = append(p.selects[index].targets, c.here())
+			}
+			if clause.Name != "" {
+				c.bind([]string{clause.Name}, func() { c.block(clause.Block, nil) })
+			} else {
+				c.emit(opPop, 0, 0)
+				c.block(clause.Block, nil)
+			}
+			exits = append(exits, c.emit(opJump, 0, 0))
+			done()
+		}
+		for _, exit := range exits {
+			c.patch(exit)
+		}
+	}, c.block)
+}
+
+func (c *compiler) loop(body func(), next int) {
+	loop := &loopState{blocks: c.fs.blocks}
+	c.fs.loops = append(c.fs.loops, loop)
+	body()
+	c.fs.loops = c.fs.loops[:len(c.fs.loops)-1]
+	for _, jmp := range loop.nexts {
+		c.fs.p.code[jmp].a = next
+	}
+	for _, jmp := range loop.breaks {
+		c.patch(jmp)
+	}
+}
+
+func (c *compiler) while(obj lang.Object) {
+	start := c.here()
+	c.expr(*obj.Cond)
+	exit := c.emit(opJumpIfFalse, 0, 0)
+	c.loop(func() {
+		c.block(obj.Block, obj.Catches)
+		c.emit(opJump, start, 0)
+	}, start)
+	c.patch(exit)
+}
+
+func (c *compiler) forNum(obj lang.Object) {
+	c.expr(*obj.Value)
+	c.storeName(obj.Name)
+	start := c.here()
+	c.expr(*obj.Cond)
+	exit := c.emit(opJumpIfFalse, 0, 0)
+	var step int
+	loop := &loopState{blocks: c.fs.blocks}
+	c.fs.loops = append(c.fs.loops, loop)
+	c.block(obj.Block, obj.Catches)
+	c.fs.loops = c.fs.loops[:len(c.fs.loops)-1]
+	step = c.here()
+	c.statement(*obj.Step)
+	c.emit(opJump, start, 0)
+	for _, jmp := range loop.nexts {
+		c.fs.p.code[jmp].a = step
+	}
+	for _, jmp := range loop.breaks {
+		c.patch(jmp)
+	}
+	c.patch(exit)
+	c.emit(opNil, 0, 0)
+	c.storeName(obj.Name)
+}
+
+// forIn keeps the iterator on the stack and as a block while the loop runs so
+// that leaving the loop in any way closes it.
+func (c *compiler) forIn(obj lang.Object) {
+	c.expr(*obj.Value)
+	c.emit(opIterPrep, 0, 0)
+	c.fs.blocks++
+	vars := len(obj.Vars)
+	start := c.here()
+	c.loop(func() {
+		next := c.emit(opIterNext, 0, vars)
+		for i := vars - 1; i >= 0; i-- {
+			c.storeName(obj.Vars[i].Name)
+		}
+		c.block(obj.Block, obj.Catches)
+		c.emit(opJump, start, 0)
+		c.patch(next)
+	}, start)
+	c.emit(opPopBlock, 0, 0)
+	c.fs.blocks--
+	c.emit(opPop, 0, 0)
+	for _, v := range obj.Vars {
+		c.emit(opNil, 0, 0)
+		c.storeName(v.Name)
+	}
+}
+
+func (c *compiler) compileFunc(obj lang.Object, className string) *proto {
+	params, vararg := evalFuncDefParams(obj)
+	var name string
+	if obj.Value != nil {
+		name = obj.Value.Name
+	}
+	p := &proto{
+		name:      name,
+		className: className,
+		lineno:    obj.Pos[0],
+		params:    params,
+		vararg:    vararg,
+		dynamic:   c.traced() || refersTo(obj, "eval"),
+		generator: obj.Generator,
+		selfSlot:  -1,
+	}
+	fs := &funcState{
+		parent: c.fs,
+		p:      p,
+		locals: map[string]int{},
+		upvals: map[string]int{},
+		pos:    obj.Pos,
+	}
+	c.fs = fs
+	defer func() { c.fs = fs.parent }()
+
+	if !p.dynamic {
+		for _, param := range params {
+			c.declare(param, true)
+		}
+		if refersTo(obj, "self") || refersTo(obj, "super") {
+			p.selfSlot = c.declare("self", false)
+			c.declare("super", false)
+			bound := c.emit(opSelf, 0, p.selfSlot)
+			for _, name := range []string{"self", "super"} {
+				c.loadOuter(name)
+				c.emit(opSetLocal, fs.locals[name], 0)
+			}
+			c.patch(bound)
+		}
+		for _, name := range assignedNames(obj.Block, obj.Catches) {
+			if _, ok := fs.locals[name]; ok {
+				continue
+			} else if kind, _ := c.resolveIn(fs.parent, name); kind != varName {
+				continue
+			}
+			c.declare(name, true)
+		}
+	}
+
+	c.block(obj.Block, obj.Catches)
+	return p
+}
+
+func (c *compiler) declare(name string, weak bool) int {
+	p := c.fs.p
+	slot := len(p.localNames)
+	p.localNames = append(p.localNames, name)
+	p.weak = append(p.weak, weak)
+	p.captured = append(p.captured, false)
+	c.fs.locals[name] = slot
+	return slot
+}
+
+// resolveIn finds where a name lives from the perspective of fs, capturing it as
+// an upvalue from the enclosing funcs if needed.
+func (c *compiler) resolveIn(fs *funcState, name string) (varKind, int) {
+	if fs == nil || fs.p.dynamic {
+		return varName, 0
+	} else if slot, ok := fs.locals[name]; ok {
+		return varLocal, slot
+	}
+	return c.capture(fs, name)
+}
+
+// capture resolves name in the funcs enclosing fs and adds it as an upvalue of
+// fs if it is a local of one of them.
+func (c *compiler) capture(fs *funcState, name string) (varKind, int) {
+	if idx, ok := fs.upvals[name]; ok {
+		return varUpval, idx
+	}
+	kind, idx := c.resolveIn(fs.parent, name)
+	var desc upvalDesc
+	switch kind {
+	case varLocal:
+		fs.parent.p.captured[idx] = true
+		desc = upvalDesc{name: name, weak: fs.parent.p.weak[idx], fromLocal: true, index: idx}
+	case varUpval:
+		desc = upvalDesc{name: name, weak: fs.parent.p.upvals[idx].weak, index: idx}
+	default:
+		return varName, 0
+	}
+	fs.p.upvals = append(fs.p.upvals, desc)
+	fs.upvals[name] = len(fs.p.upvals) - 1
+	return varUpval, fs.upvals[name]
+}
+
+func (c *compiler) load(name string) {
+	switch kind, idx := c.resolveIn(c.fs, name); kind {
+	case varLocal:
+		c.emit(opGetLocal, idx, 0)
+	case varUpval:
+		c.emit(opGetUpval, idx, 0)
+	default:
+		c.emit(opGetName, c.name(name), 0)
+	}
+}
+
+// loadOuter pushes name as it is seen from the func enclosing the one being
+// compiled, ignoring any local of the same name.
+func (c *compiler) loadOuter(name string) {
+	if kind, idx := c.capture(c.fs, name); kind == varUpval {
+		c.emit(opGetUpval, idx, 0)
+	} else {
+		c.emit(opGetName, c.name(name), 0)
+	}
+}
+
+func (c *compiler) storeName(name string) {
+	switch kind, idx := c.resolveIn(c.fs, name); kind {
+	case varLocal:
+		c.emit(opSetLocal, idx, 0)
+	case varUpval:
+		c.emit(opSetUpval, idx, 0)
+	default:
+		c.emit(opSetName, c.name(name), 0)
+	}
+}
+
+// assignedNames collects the names that a func body assigns to so that they can
+// be given local slots before the body is compiled.
+func assignedNames(block, catches []lang.Object) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	var visit func(obj lang.Object) bool
+	visit = func(obj lang.Object) bool {
+		switch obj.Kind {
+		case lang.Assignment:
+			for _, target := range obj.Vars {
+				if target.Kind == lang.Identifier {
+					add(target.Name)
+				}
+			}
+		case lang.ForIn:
+			for _, v := range obj.Vars {
+				add(v.Name)
+			}
+		case lang.ForNum:
+			add(obj.Name)
+		case lang.ClassDef:
+			add(obj.Name)
+			return false
+		case lang.FuncDef:
+			if obj.Value != nil && obj.Value.Kind == lang.Identifier {
+				add(obj.Value.Name)
+			}
+			return false
+		}
+		return true
+	}
+	for _, obj := range append(append([]lang.Object{}, block...), catches...) {
+		walk(obj, visit)
+	}
+	return names
+}
+
+// refersTo reports if an identifier with name is used anywhere in obj including
+// nested funcs and interpolated strings.
+func refersTo(obj lang.Object, name string) bool {
+	found := false
+	walk(obj, func(o lang.Object) bool {
+		if o.Kind == lang.Identifier && o.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// walk visits obj and all of its children depth first for as long as visit
+// returns true for a node.
+func walk(obj lang.Object, visit func(lang.Object) bool) {
+	if !visit(obj) {
+		return
+	}
+	if obj.Kind == lang.String && strings.Contains(obj.StringValue, "${") {
+		for _, seg := range lang.Segments(obj.StringValue) {
+			if !seg.Expr {
+				continue
+			}
+			if ast, err := lang.ParseStr(seg.Text); err == nil {
+				walk(ast, visit)
+			}
+		}
+	}
+	for _, child := range []*lang.Object{obj.Cond, obj.Step, obj.Key, obj.Value} {
+		if child != nil {
+			walk(*child, visit)
+		}
+	}
+	for _, list := range [][]lang.Object{obj.Vars, obj.Vals, obj.Block, obj.Catches} {
+		for _, child := range list {
+			walk(child, visit)
+		}
+	}
+}
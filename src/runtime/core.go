@@ -3,7 +3,6 @@ package runtime
 import (
 	"fmt"
 	"io"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -21,6 +20,7 @@ var fns = map[string]FnSig{
 	"delete":   stdDelete,
 	"tostring": stdToString,
 	"tonumber": stdParseNum,
+	"spawn":    stdSpawn,
 }
 
 var (
@@ -28,25 +28,14 @@ var (
 	RuntimeErrorClass = CreateClass("RuntimeError", ErrorClass)
 )
 
-// DefaultNamespace generate an evironment with the core function and variable declarations defined
+// DefaultNamespace generate an evironment with the core function and variable
+// declarations defined on a new Interpreter.
 func DefaultNamespace(out io.StringWriter) *Scope {
-	if out == nil {
-		out = os.Stdout
+	var stdout io.Writer
+	if out != nil {
+		stdout = stringWriter{out}
 	}
-	def := newScope(nil, nil, out)
-	for method, fn := range fns {
-		def.Set(method, Fn(method, fn))
-	}
-	def.Set("Boolean", BooleanClass)
-	def.Set("Nil", NilClass)
-	def.Set("Number", NumberClass)
-	def.Set("String", StringClass)
-	def.Set("Table", TableClass)
-
-	def.Set("Error", ErrorClass)
-	def.Set("ArgumentError", ArgumentError)
-	def.Set("RuntimeError", RuntimeErrorClass)
-	return def
+	return NewInterpreter(Options{Stdout: stdout, Engine: DefaultEngine}).Scope()
 }
 
 func stdNew(s *Scope, self CVal, args []Value) (Value, error) {
@@ -64,7 +53,7 @@ func stdSpill(s *Scope, self CVal, args []Value) (Value, error) {
 	if len(args) == 0 {
 		return createErr(s, ArgumentError, "not enough arguments to spill")
 	} else if inst, ok := args[0].(*Instance); len(args) == 1 && ok && inst.IsA("String") {
-		return nil, fmt.Errorf(inst.data["_val"].(string))
+		return nil, fmt.Errorf(inst.field("_val").(string))
 	} else if cls, ok := args[0].(*Class); ok {
 		inst, err := cls.New(s, args[1:]...)
 		if err != nil {
@@ -84,7 +73,7 @@ func stdEval(s *Scope, self CVal, a []Value) (Value, error) {
 	} else if inst, ok := a[0].(*Instance); !ok || !inst.IsA("String") {
 		return nil, fmt.Errorf("wrong value type passed to eval")
 	} else {
-		return Eval(s, inst.data["_val"].(string))
+		return s.interp.eval(s, inst.field("_val").(string))
 	}
 }
 
@@ -93,10 +82,18 @@ func stdPrint(s *Scope, self CVal, a []Value) (Value, error) {
 	for i, e := range a {
 		strList[i] = toString(s, e)
 	}
-	s.out.WriteString(strings.Join(strList, " ") + "\n")
+	io.WriteString(s, strings.Join(strList, " ")+"\n")
 	return nil, nil
 }
 
+// Write writes to the Stdout of the interpreter while holding the lock that
+// print does so that tasks do not interleave their output.
+func (scope *Scope) Write(p []byte) (int, error) {
+	scope.interp.outMu.Lock()
+	defer scope.interp.outMu.Unlock()
+	return scope.interp.opts.Stdout.Write(p)
+}
+
 func stdDelete(s *Scope, self CVal, a []Value) (Value, error) {
 	if len(a) < 2 {
 		return nil, nil
@@ -143,6 +140,20 @@ func stdToString(s *Scope, self CVal, a []Value) (Value, error) {
 	return toString(s, a[0]), nil
 }
 
+// Equal compares two values the way == does.
+func Equal(s *Scope, a, b Value) (bool, error) {
+	left, err := ToValue(s, a)
+	if err != nil {
+		return false, err
+	}
+	cval, ok := left.(CVal)
+	if !ok {
+		return a == b, nil
+	}
+	res, err := cval.Op("__eq", s, b)
+	return err == nil && toBool(s, res), err
+}
+
 func Print(s *Scope, val Value) string {
 	return toString(s, val)
 }
@@ -203,9 +214,9 @@ func toNumber(obj interface{}) float64 {
 			obj, _ = val.get()
 		case *Instance:
 			if val.class.name == "Number" {
-				return val.data["_val"].(float64)
+				return val.field("_val").(float64)
 			} else if val.class.name == "String" {
-				obj = val.data["_val"].(string)
+				obj = val.field("_val").(string)
 			} else {
 				return 0
 			}
@@ -232,6 +243,6 @@ func ToValue(s *Scope, obj interface{}) (Value, error) {
 	case map[string]Value:
 		return create(s, "Table", val)
 	default:
-		return obj, nil
+		return reflectValue(s, obj)
 	}
 }
@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+type (
+	// Coverage counts the statements and branches that ran in every file an
+	// interpreter evaluated, required files included.
+	Coverage struct {
+		mu    sync.Mutex
+		files map[string]*FileCoverage
+	}
+
+	// FileCoverage is the coverage of one file. Statements and Branches are in
+	// the order they appear in the file.
+	FileCoverage struct {
+		Path       string
+		Statements []StatementCount
+		Branches   []BranchCount
+		stmts      map[[4]int]int // position to index in Statements
+		branches   map[[4]int]int // position of an if or ternary to its first branch
+	}
+
+	// StatementCount is how many times the statement at Pos ran.
+	StatementCount struct {
+		Pos   [4]int
+		Count int64
+	}
+
+	// BranchCount is how many times one way through an if or ternary was
+	// taken. Block numbers the ifs and ternaries in a file and Branch is the
+	// clause that was taken, an if without an else has a last branch for when
+	// none of its clauses ran.
+	BranchCount struct {
+		Line   int
+		Block  int
+		Branch int
+		Count  int64
+	}
+)
+
+// NewCoverage creates an empty coverage to give to Options.Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{files: map[string]*FileCoverage{}}
+}
+
+// Files lists the coverage of every file that was evaluated, sorted by path.
+func (cov *Coverage) Files() []*FileCoverage {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	files := make([]*FileCoverage, 0, len(cov.files))
+	for _, file := range cov.files {
+		copied := *file
+		copied.Statements = append([]StatementCount{}, file.Statements...)
+		copied.Branches = append([]BranchCount{}, file.Branches...)
+		files = append(files, &copied)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// Lines gives the count of each line that starts a statement. A line with
+// more than one statement has the count of the one that ran most.
+func (file *FileCoverage) Lines() map[int]int64 {
+	lines := map[int]int64{}
+	for _, stmt := range file.Statements {
+		if count, ok := lines[stmt.Pos[0]]; !ok || stmt.Count > count {
+			lines[stmt.Pos[0]] = stmt.Count
+		}
+	}
+	return lines
+}
+
+// add finds every statement and branch in a file before it runs so that the
+// ones that never run are counted as well.
+func (cov *Coverage) add(path string, root lang.Object) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	if _, ok := cov.files[path]; ok {
+		return
+	}
+	file := &FileCoverage{Path: path, stmts: map[[4]int]int{}, branches: map[[4]int]int{}}
+	file.walk(root)
+	cov.files[path] = file
+}
+
+func (file *FileCoverage) walk(obj lang.Object) {
+	switch obj.Kind {
+	case lang.If:
+		branches := len(obj.Block)
+		if last := obj.Block[branches-1]; last.Cond != nil {
+			branches++
+		}
+		file.addBranches(obj.Pos, branches)
+	case lang.Ternary:
+		file.addBranches(obj.Pos, 2)
+	}
+	// the blocks of these hold clauses, class members or the cases of a match
+	// and not statements
+	statements := obj.Kind != lang.If && obj.Kind != lang.ClassDef &&
+		obj.Kind != lang.Match && obj.Kind != lang.Select
+	for _, child := range obj.Block {
+		if statements {
+			if _, ok := file.stmts[child.Pos]; !ok {
+				file.stmts[child.Pos] = len(file.Statements)
+				file.Statements = append(file.Statements, StatementCount{Pos: child.Pos})
+			}
+		}
+		file.walk(child)
+	}
+	for _, children := range [][]lang.Object{obj.Catches, obj.Vars, obj.Vals} {
+		for _, child := range children {
+			file.walk(child)
+		}
+	}
+	for _, child := range []*lang.Object{obj.Cond, obj.Step, obj.Key, obj.Value} {
+		if child != nil {
+			file.walk(*child)
+		}
+	}
+}
+
+func (file *FileCoverage) addBranches(pos [4]int, n int) {
+	block := 0
+	if len(file.Branches) > 0 {
+		block = file.Branches[len(file.Branches)-1].Block + 1
+	}
+	file.branches[pos] = len(file.Branches)
+	for i := 0; i < n; i++ {
+		file.Branches = append(file.Branches, BranchCount{Line: pos[0], Block: block, Branch: i})
+	}
+}
+
+func (cov *Coverage) statement(path string, pos [4]int) {
+	cov.mu.Lock()
+	if file, ok := cov.files[path]; ok {
+		if i, ok := file.stmts[pos]; ok {
+			file.Statements[i].Count++
+		}
+	}
+	cov.mu.Unlock()
+}
+
+func (cov *Coverage) branch(path string, pos [4]int, branch int) {
+	cov.mu.Lock()
+	if file, ok := cov.files[path]; ok {
+		if i, ok := file.branches[pos]; ok {
+			file.Branches[i+branch].Count++
+		}
+	}
+	cov.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const coverLibSrc = `func sign(n)
+  if n > 0 then
+    return "pos"
+  elseif n < 0 then
+    return "neg"
+  end
+  return "zero"
+end
+`
+
+func TestCoverage(t *testing.T) {
+	lib := writeSrc(t, coverLibSrc)
+	defer os.Remove(lib)
+	main := writeSrc(t, `sign = nil
+require("`+lib+`")
+print(sign(1), sign(0 - 1))
+x = false ? 1 : 2
+`)
+	defer os.Remove(main)
+
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		cov := NewCoverage()
+		interp := NewInterpreter(Options{Stdout: ioutil.Discard, Engine: engine, Coverage: cov})
+		_, err := interp.EvalFile(main)
+		assert.Nil(t, err)
+
+		files := map[string]*FileCoverage{}
+		for _, file := range cov.Files() {
+			files[file.Path] = file
+		}
+		if assert.Contains(t, files, lib) {
+			assert.Equal(t, map[int]int64{1: 1, 2: 2, 3: 1, 5: 1, 7: 0}, files[lib].Lines(), engine)
+			counts := []int64{}
+			for _, br := range files[lib].Branches {
+				assert.Equal(t, 2, br.Line)
+				counts = append(counts, br.Count)
+			}
+			assert.Equal(t, []int64{1, 1, 0}, counts, engine)
+		}
+		if assert.Contains(t, files, main) {
+			assert.Equal(t, map[int]int64{1: 1, 2: 1, 3: 1, 4: 1}, files[main].Lines(), engine)
+			assert.Equal(t, []BranchCount{
+				{Line: 4, Block: 0, Branch: 0, Count: 0},
+				{Line: 4, Block: 0, Branch: 1, Count: 1},
+			}, files[main].Branches, engine)
+		}
+	}
+}
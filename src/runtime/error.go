@@ -6,15 +6,15 @@ var ErrorClass = CreateClass("Error", nil,
 	Attr("message", "an error has occurred", nil),
 	FnAttr("new", func(s *Scope, self CVal, args []Value) (Value, error) {
 		if len(args) > 0 {
-			self.(*Instance).data["message"] = toString(s, args[0])
+			self.(*Instance).setField("message", toString(s, args[0]))
 		}
 		return nil, nil
 	}),
 	FnAttr("__eq", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
-		msg, _ := self.(*Instance).data["message"]
+		msg := self.(*Instance).field("message")
 		if other.IsA("Error") {
-			othermsg, _ := other.(*Instance).data["message"]
+			othermsg := other.(*Instance).field("message")
 			return msg.(string) == othermsg.(string), nil
 		}
 		return false, nil
@@ -24,11 +24,21 @@ var ErrorClass = CreateClass("Error", nil,
 	}),
 	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
 		inst := self.(*Instance)
-		return fmt.Sprintf("%v: %v", inst.class.name, inst.data["message"].(string)), nil
+		return fmt.Sprintf("%v: %v", inst.class.name, inst.field("message").(string)), nil
 	}),
 )
 
 func createErr(s *Scope, cls *Class, msg string) (Value, error) {
-	err, _ := cls.New(s, msg)
+	err, _ := create(s, cls.name, msg)
 	return nil, err
 }
+
+// NewError creates an error of the class called name from the scope's
+// interpreter, the same class scripts catch by that name.
+func NewError(s *Scope, name, msg string) error {
+	inst, err := create(s, name, msg)
+	if err != nil {
+		return err
+	}
+	return inst
+}
@@ -69,6 +69,15 @@ func (fn *Func) ToString(s *Scope) string {
 	return "#<func " + name + strParams + builtin + ">"
 }
 
+func varargs(s *Scope, vals []Value, definedParams int) Value {
+	if len(vals) <= definedParams {
+		tbl, _ := create(s, "Table", &Table{})
+		return tbl
+	}
+	tbl, _ := create(s, "Table", &Table{Arr: vals[definedParams:]})
+	return tbl
+}
+
 func mapParams(s *Scope, keys []string, vals []Value, vararg bool) (map[string]Value, error) {
 	params := map[string]Value{}
 	definedParams := len(keys)
@@ -86,9 +95,8 @@ func mapParams(s *Scope, keys []string, vals []Value, vararg bool) (map[string]V
 		}
 		params[keys[i]] = val
 	}
-	if vararg && len(vals) > len(params)-1 {
-		tbl, _ := TableClass.New(s, vals[len(keys)-1:]...)
-		params[keys[len(keys)-1]] = tbl
+	if vararg {
+		params[keys[definedParams]] = varargs(s, vals, definedParams)
 	}
 	return params, nil
 }
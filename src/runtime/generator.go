@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	"fmt"
+	rt "runtime"
+	"sync"
+)
+
+type (
+	// generator runs the body of a func that yields on its own goroutine. Only
+	// one side runs at a time, the body waits in yield while the caller runs and
+	// the caller waits in resume while the body runs. The body stops waiting and
+	// unwinds once its evaluation is cancelled or it is abandoned.
+	generator struct {
+		mu        sync.Mutex
+		body      func(*Scope) (Value, error)
+		scope     *Scope
+		resumes   chan resumption
+		yields    chan yielded
+		abandoned chan struct{}
+		abandon   sync.Once
+		started   bool
+		running   bool
+		done      bool
+		closed    bool // only used by the body
+	}
+
+	// generatorRef is what scripts hold of a generator. The goroutine of the
+	// body only holds the generator so once no script can reach the ref it is
+	// collected and the generator is abandoned.
+	generatorRef struct{ *generator }
+
+	resumption struct {
+		val     Value
+		closing bool
+	}
+
+	yielded struct {
+		vals []Value
+		err  error
+		done bool
+	}
+)
+
+var (
+	GeneratorExit  = CreateClass("GeneratorExit", ErrorClass)
+	GeneratorClass = CreateClass("Generator", nil,
+		Attr("_gen", nil, nil),
+		FnAttr("next", func(s *Scope, self CVal, args []Value) (Value, error) {
+			return resumeGenerator(s, self, nil, false)
+		}),
+		FnAttr("send", func(s *Scope, self CVal, args []Value) (Value, error) {
+			var val Value
+			if len(args) > 0 {
+				val = args[0]
+			}
+			return resumeGenerator(s, self, val, false)
+		}),
+		FnAttr("close", func(s *Scope, self CVal, args []Value) (Value, error) {
+			g, err := toGenerator(self)
+			if err != nil {
+				return nil, err
+			}
+			return nil, g.end(s)
+		}),
+		FnAttr("__iter", func(s *Scope, self CVal, args []Value) (Value, error) {
+			return toGenerator(self)
+		}),
+		FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
+			return "#<Generator>", nil
+		}),
+	)
+)
+
+// generatorFunc makes calling fn create a generator that runs the body of fn
+// when it is first resumed.
+func generatorFunc(fn *Func) *Func {
+	body := fn.Fn
+	fn.Fn = func(s *Scope, self CVal, args []Value) (Value, error) {
+		inst, err := create(s, "Generator")
+		if err != nil {
+			return nil, err
+		}
+		g := &generator{
+			body: func(scope *Scope) (Value, error) {
+				return body(scope, self, args)
+			},
+			resumes:   make(chan resumption),
+			yields:    make(chan yielded),
+			abandoned: make(chan struct{}),
+		}
+		g.scope = s.on(s.thread.spawn(g))
+		ref := &generatorRef{g}
+		rt.SetFinalizer(ref, func(ref *generatorRef) {
+			ref.abandon.Do(func() { close(ref.abandoned) })
+		})
+		inst.setField("_gen", ref)
+		return Return{Vals: []Value{inst}}, nil
+	}
+	return fn
+}
+
+func toGenerator(self CVal) (*generatorRef, error) {
+	if inst, ok := self.(*Instance); ok {
+		if g, ok := inst.field("_gen").(*generatorRef); ok {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("generators can only be created by calling a func that yields")
+}
+
+func resumeGenerator(s *Scope, self CVal, val Value, closing bool) (Value, error) {
+	g, err := toGenerator(self)
+	if err != nil {
+		return nil, err
+	}
+	vals, _, err := g.resume(s, val, closing)
+	if err != nil {
+		return nil, err
+	}
+	return Return{Vals: vals}, nil
+}
+
+// resume runs the body until it yields or finishes. Resuming a generator that
+// is done does nothing. If the evaluation of s is cancelled while the body runs
+// the generator is done.
+func (g *generator) resume(s *Scope, val Value, closing bool) ([]Value, bool, error) {
+	g.mu.Lock()
+	if g.done {
+		g.mu.Unlock()
+		return nil, false, nil
+	} else if g.running {
+		g.mu.Unlock()
+		return nil, false, fmt.Errorf("generator is already running")
+	} else if !g.started && closing {
+		g.done = true
+		g.mu.Unlock()
+		return nil, false, nil
+	}
+	start := !g.started
+	g.started, g.running = true, true
+	g.mu.Unlock()
+
+	var msg yielded
+	if start {
+		go g.run()
+	} else {
+		select {
+		case g.resumes <- resumption{val: val, closing: closing}:
+		case <-s.thread.done():
+			msg = yielded{err: timeout(s), done: true}
+		}
+	}
+	if !msg.done {
+		select {
+		case msg = <-g.yields:
+		case <-s.thread.done():
+			msg = yielded{err: timeout(s), done: true}
+		}
+	}
+
+	g.mu.Lock()
+	g.running, g.done = false, msg.done
+	g.mu.Unlock()
+	return msg.vals, !msg.done, msg.err
+}
+
+func (g *generator) run() {
+	_, err := g.body(g.scope)
+	select {
+	case g.yields <- yielded{err: err, done: true}:
+	case <-g.scope.thread.done():
+	case <-g.abandoned:
+	}
+}
+
+// yield hands vals to whoever resumed the generator and waits to be resumed
+// again. Closing the generator raises a GeneratorExit from the yield so that
+// the cleanup blocks in the body run.
+func (g *generator) yield(vals []Value) (Value, error) {
+	if g.closed {
+		return nil, fmt.Errorf("generator yielded after it was closed")
+	}
+	expanded := []Value{}
+	for _, val := range vals {
+		if spr, ok := val.(Spread); ok {
+			expanded = append(expanded, spr.Table.Arr...)
+		} else {
+			expanded = append(expanded, val)
+		}
+	}
+	select {
+	case g.yields <- yielded{vals: expanded}:
+	case <-g.scope.thread.done():
+		g.closed = true
+		return nil, timeout(g.scope)
+	case <-g.abandoned:
+		return g.exit()
+	}
+	select {
+	case msg := <-g.resumes:
+		if msg.closing {
+			return g.exit()
+		}
+		return msg.val, nil
+	case <-g.scope.thread.done():
+		g.closed = true
+		return nil, timeout(g.scope)
+	case <-g.abandoned:
+		return g.exit()
+	}
+}
+
+// exit raises a GeneratorExit from a yield so that the cleanup blocks in the
+// body run.
+func (g *generator) exit() (Value, error) {
+	g.closed = true
+	return createErr(g.scope, GeneratorExit, "generator was closed")
+}
+
+func (g *generator) next(n int) ([]Value, bool, error) {
+	vals, ok, err := g.resume(g.scope, nil, false)
+	return pad(vals, n), ok, err
+}
+
+func (g *generator) close() error {
+	return g.end(g.scope)
+}
+
+// end closes the generator. It is fine for the body to let the GeneratorExit
+// raise out of it but not to yield again.
+func (g *generator) end(s *Scope) error {
+	_, _, err := g.resume(s, nil, true)
+	if userErr, isRuntime := err.(RuntimeErr); isRuntime && userErr.errInst.IsA("GeneratorExit") {
+		return nil
+	} else if inst, isInst := err.(*Instance); isInst && inst.IsA("GeneratorExit") {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import "fmt"
+
+// EventKind is what the program was doing when it called a Hook.
+type EventKind int
+
+const (
+	// EventStatement is sent before each statement runs.
+	EventStatement EventKind = iota
+	// EventCall is sent when a func is called, before its params are bound.
+	EventCall
+	// EventRaise is sent where an error is raised, whether it is caught or not.
+	EventRaise
+)
+
+type (
+	// Event describes where a program is when a Hook is called. Trace has the
+	// innermost call last.
+	Event struct {
+		Kind  EventKind
+		File  string
+		Pos   [4]int
+		Name  string // the func that was called for EventCall
+		Err   error  // the error that was raised for EventRaise
+		Scope *Scope
+		Trace []string
+	}
+
+	// Hook is called as a program runs. It is called on the goroutine that is
+	// running the code so a hook that blocks pauses the program. The vm keeps
+	// every local in a Scope while a Hook is set so that Event.Scope sees the
+	// same names it would in the tree walker.
+	Hook func(Event)
+)
+
+// statement is called by the tree walker before each statement.
+func (r *Runtime) statement(scope *Scope, pos [4]int) {
+	if prof := scope.interp.opts.Profile; prof != nil {
+		prof.line(scope.thread, pos[0])
+	}
+	r.reached(scope, pos)
+}
+
+// reached reports a statement to the Coverage and Hook. The vm calls it for
+// opStatement since it profiles every instruction on its own.
+func (r *Runtime) reached(scope *Scope, pos [4]int) {
+	if cov := scope.interp.opts.Coverage; cov != nil {
+		cov.statement(r.filepath, pos)
+	}
+	r.hook(scope, EventStatement, pos, "", nil)
+}
+
+func (r *Runtime) hook(scope *Scope, kind EventKind, pos [4]int, name string, err error) {
+	if h := scope.interp.opts.Hook; h != nil {
+		h(Event{Kind: kind, File: r.filepath, Pos: pos, Name: name, Err: err, Scope: scope, Trace: stacktrace(scope)})
+	}
+}
+
+// Fields lists what a table or instance holds so that a debugger can show its
+// contents. Array values are named by their index. Anything else has no fields.
+func Fields(s *Scope, val Value) (names []string, vals []Value) {
+	inst, ok := val.(*Instance)
+	if !ok {
+		return nil, nil
+	} else if inst.class.name == "Table" {
+		arr, keys, keyVals := inst.field("_tbl").(*Table).Entries()
+		for i, v := range arr {
+			names, vals = append(names, fmt.Sprint(i)), append(vals, v)
+		}
+		for i, key := range keys {
+			names, vals = append(names, toString(s, key)), append(vals, keyVals[i])
+		}
+		return names, vals
+	}
+	keys, fieldVals := instanceFields(inst)
+	for i, key := range keys {
+		names, vals = append(names, key.(string)), append(vals, fieldVals[i])
+	}
+	return names, vals
+}
@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const hookSrc = `func add(a, b)
+  total = a + b
+  return total
+end
+for i = 0, i < 2, i++ do
+  if i == 0 then
+    add(i, 1)
+  else
+    x = (i > 0) ? "pos" : "neg"
+  end
+end
+do
+  spill("bad")
+cleanup e = RuntimeError do
+  print(e)
+end
+`
+
+func TestHookEngines(t *testing.T) {
+	path := writeSrc(t, hookSrc)
+	defer os.Remove(path)
+
+	events := map[Engine][]string{}
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		hook := func(e Event) {
+			desc := fmt.Sprintf("%v %v %v", e.Kind, e.Pos[0], e.Name)
+			if e.Kind == EventStatement && e.Pos[0] == 3 {
+				desc += fmt.Sprintf(" total=%v", toString(e.Scope, e.Scope.Get("total")))
+			}
+			events[engine] = append(events[engine], desc)
+		}
+		interp := NewInterpreter(Options{Stdout: ioutil.Discard, Engine: engine, Hook: hook})
+		_, err := interp.EvalFile(path)
+		assert.Nil(t, err)
+	}
+	assert.Contains(t, events[EngineVM], "0 3  total=1")
+	assert.Equal(t, events[EngineTree], events[EngineVM])
+}
@@ -1,18 +1,44 @@
 package runtime
 
+import (
+	"fmt"
+	"sync"
+)
+
+// Instance is a value created from a class. Tasks can share instances so data
+// is only used under mu.
 type Instance struct {
+	mu    sync.RWMutex
 	class *Class
 	data  map[Value]Value
 }
 
 func create(s *Scope, name string, args ...Value) (*Instance, error) {
-	class, err := findClass(s, name)
-	if err != nil {
-		return nil, err
+	class, ok := s.interp.class(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined class %v", name)
 	}
 	return class.New(s, args...)
 }
 
+func (i *Instance) field(key Value) Value {
+	val, _ := i.lookup(key)
+	return val
+}
+
+func (i *Instance) lookup(key Value) (Value, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	val, ok := i.data[key]
+	return val, ok
+}
+
+func (i *Instance) setField(key, val Value) {
+	i.mu.Lock()
+	i.data[key] = val
+	i.mu.Unlock()
+}
+
 func (inst *Instance) OpIndex(scope *Scope, key Value) (Value, error) {
 	attr, _ := inst.class.index(scope, key, inst, false)
 	if attr == nil {
@@ -45,6 +71,37 @@ func (i *Instance) Type() string {
 	return i.class.name
 }
 
+// Class is the class the instance was created from.
+func (i *Instance) Class() *Class {
+	return i.class
+}
+
+// Table is the table of a Table instance.
+func (i *Instance) Table() (*Table, bool) {
+	tbl, ok := i.field("_tbl").(*Table)
+	return tbl, ok && i.IsA("Table")
+}
+
+// Native is the Go value a native lib keeps on the instance with SetNative.
+func (i *Instance) Native() interface{} {
+	return i.field("_native")
+}
+
+// SetNative keeps a Go value on the instance that scripts cannot reach.
+func (i *Instance) SetNative(val interface{}) {
+	i.setField("_native", val)
+}
+
+// RespondsTo is true if the instance has a public method called name.
+func (i *Instance) RespondsTo(s *Scope, name string) bool {
+	attr, err := i.class.index(s, name, i, false)
+	if err != nil {
+		return false
+	}
+	_, isFn := attr.value().(*Func)
+	return isFn
+}
+
 func (i *Instance) IsA(other string) bool {
 	class := i.class
 	for {
@@ -69,7 +126,7 @@ func (i *Instance) Op(method string, s *Scope, args ...Value) (Value, error) {
 func (i *Instance) ToBoolean(s *Scope) bool {
 	if val, err := i.Op("tobool", s); err == nil {
 		if inst := val.(*Instance); inst.IsA("Boolean") {
-			return inst.data["_val"].(bool)
+			return inst.field("_val").(bool)
 		}
 	}
 	return true
@@ -78,7 +135,7 @@ func (i *Instance) ToBoolean(s *Scope) bool {
 func (i *Instance) ToString(s *Scope) string {
 	if val, err := i.Op("tostring", s); err == nil {
 		if inst := val.(*Instance); inst.IsA("String") {
-			return inst.data["_val"].(string)
+			return inst.field("_val").(string)
 		}
 	}
 	return "#<Instance of " + i.class.name + ">"
@@ -86,7 +143,7 @@ func (i *Instance) ToString(s *Scope) string {
 
 func (i *Instance) Error() string {
 	if i.IsA("Error") {
-		str, _ := i.data["message"].(string)
+		str, _ := i.field("message").(string)
 		return str
 	}
 	return ""
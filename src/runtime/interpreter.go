@@ -0,0 +1,334 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+type (
+	// Options configure a new Interpreter. Anything left empty falls back to
+	// the process defaults.
+	Options struct {
+		Stdout    io.Writer
+		Stderr    io.Writer
+		Stdin     io.Reader
+		LoadPaths []string  // directories searched for required files, SQUIRT_LOAD_PATHS when nil
+		Engine    Engine    // both engines report to Hook, Profile and Coverage
+		MaxSteps  int       // loop iterations and calls allowed in one evaluation, 0 is unlimited
+		MaxDepth  int       // how deep calls can go, 0 uses DefaultMaxDepth
+		Hook      Hook      // called as the program runs, see Hook
+		Profile   *Profile  // times every call and line when set
+		Coverage  *Coverage // counts the statements and branches that run when set
+		Sandboxed bool      // only allow the capabilities in Grants
+		Grants    []string  // capabilities like env, fs.read:/data or require:/app
+	}
+
+	// Interpreter owns everything a program can change outside of its own
+	// scope, the core classes, registered libs and the require cache, so
+	// that many interpreters can run in one process without seeing each other.
+	Interpreter struct {
+		opts      Options
+		classes   map[string]*Class
+		libs      map[string]lib
+		cache     map[string]Value
+		requireMu sync.Mutex
+		outMu     sync.Mutex // keeps tasks that print at the same time from mixing their output
+		global    *Scope
+		shared    map[string]*Class
+		sharedMu  sync.Mutex
+	}
+
+	// lib is a registered lib along with the capabilities it needs.
+	lib struct {
+		load  func(*Scope) (Value, error)
+		needs []string
+	}
+
+	// stringWriter lets DefaultNamespace keep taking an io.StringWriter.
+	stringWriter struct {
+		io.StringWriter
+	}
+)
+
+// coreClasses are the prototypes that every interpreter copies. Parents come
+// before the classes that inherit them.
+var coreClasses = []*Class{
+	BooleanClass, NilClass, NumberClass, StringClass, TableClass, TaskClass,
+	ChannelClass, GeneratorClass,
+	ErrorClass, ArgumentError, RuntimeErrorClass, GeneratorExit, TimeoutError,
+	StackOverflowError, PermissionError, RequireCycleError, ImportError, GoClass,
+}
+
+// CoreClasses maps the name of every class an interpreter starts with to the
+// name of its parent, which is empty for classes without one.
+func CoreClasses() map[string]string {
+	classes := map[string]string{}
+	for _, class := range coreClasses {
+		classes[class.name] = ""
+		if class.parent != nil {
+			classes[class.name] = class.parent.name
+		}
+	}
+	return classes
+}
+
+var (
+	defaultLibs   = map[string]lib{}
+	defaultLibsMu sync.Mutex
+)
+
+// RegisterLib makes a lib available to require in every interpreter that is
+// created after it is registered. Sandboxed interpreters can only require it
+// if they were granted every capability it needs.
+func RegisterLib(name string, val func(*Scope) (Value, error), needs ...string) {
+	defaultLibsMu.Lock()
+	defaultLibs[name] = lib{load: val, needs: needs}
+	defaultLibsMu.Unlock()
+}
+
+// NewInterpreter creates an interpreter with its own copy of the core classes
+// and a global scope with the core functions defined.
+func NewInterpreter(opts Options) *Interpreter {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.LoadPaths == nil {
+		opts.LoadPaths = filepath.SplitList(os.Getenv("SQUIRT_LOAD_PATHS"))
+	}
+	interp := &Interpreter{
+		opts:    opts,
+		classes: cloneClasses(coreClasses),
+		libs:    map[string]lib{},
+		cache:   map[string]Value{},
+		shared:  map[string]*Class{},
+	}
+	defaultLibsMu.Lock()
+	for name, l := range defaultLibs {
+		interp.libs[name] = l
+	}
+	defaultLibsMu.Unlock()
+
+	interp.global = newScope(nil, nil, interp)
+	for method, fn := range fns {
+		interp.global.Set(method, Fn(method, fn))
+	}
+	for name, cls := range interp.classes {
+		interp.global.Set(name, cls)
+	}
+	loadPaths := make([]Value, len(opts.LoadPaths))
+	for i, dir := range opts.LoadPaths {
+		loadPaths[i], _ = ToValue(interp.global, dir)
+	}
+	tbl, _ := ToValue(interp.global, loadPaths)
+	interp.global.Set("LOAD_PATHS", tbl)
+	return interp
+}
+
+// cloneClasses copies the class prototypes so that assigning to their static
+// attributes only changes the copy.
+func cloneClasses(protos []*Class) map[string]*Class {
+	classes := map[string]*Class{}
+	for _, proto := range protos {
+		cls := &Class{name: proto.name, attributes: make(map[Value]*Attribute, len(proto.attributes))}
+		if proto.parent != nil {
+			cls.parent = classes[proto.parent.name]
+		}
+		for key, attr := range proto.attributes {
+			cls.attributes[key] = &Attribute{
+				name:    attr.name,
+				val:     attr.value(),
+				private: attr.private,
+				static:  attr.static,
+				refine:  attr.refine,
+			}
+		}
+		classes[proto.name] = cls
+	}
+	return classes
+}
+
+// RegisterLib makes a lib available to require in this interpreter only.
+func (interp *Interpreter) RegisterLib(name string, val func(*Scope) (Value, error), needs ...string) {
+	interp.requireMu.Lock()
+	interp.libs[name] = lib{load: val, needs: needs}
+	interp.requireMu.Unlock()
+}
+
+// SharedClass gives the class called name that the libs of this interpreter
+// share. The first lib to ask for it creates it with create.
+func (interp *Interpreter) SharedClass(name string, create func() *Class) *Class {
+	interp.sharedMu.Lock()
+	defer interp.sharedMu.Unlock()
+	if class, ok := interp.shared[name]; ok {
+		return class
+	}
+	class := create()
+	interp.shared[name] = class
+	return class
+}
+
+// Scope is the global scope of the interpreter.
+func (interp *Interpreter) Scope() *Scope { return interp.global }
+
+// Stdout, Stderr and Stdin are the streams the interpreter was created with.
+func (interp *Interpreter) Stdout() io.Writer { return interp.opts.Stdout }
+func (interp *Interpreter) Stderr() io.Writer { return interp.opts.Stderr }
+func (interp *Interpreter) Stdin() io.Reader  { return interp.opts.Stdin }
+
+// EvalFile runs a file in the global scope.
+func (interp *Interpreter) EvalFile(filename string) (Value, error) {
+	return EvalFileContext(context.Background(), interp.global, filename)
+}
+
+// Eval runs the first statement of in in the global scope.
+func (interp *Interpreter) Eval(in string) (Value, error) {
+	return EvalContext(context.Background(), interp.global, in)
+}
+
+func (interp *Interpreter) evalFile(scope *Scope, filename string) (Value, error) {
+	val, _, err := interp.runFile(scope, filename)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapReturn(scope, val), nil
+}
+
+// runFile runs a file and gives what its block results in, a Return if the
+// file returned, along with the names it exports.
+func (interp *Interpreter) runFile(scope *Scope, filename string) (Value, []string, error) {
+	ast, err := lang.ParseFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if interp.opts.Coverage != nil {
+		interp.opts.Coverage.add(filename, ast)
+	}
+	r := Runtime{filepath: filename, isFile: true}
+	if err := r.pushStack(scope, "<main>", 0); err != nil {
+		return nil, nil, err
+	}
+	defer r.popStack(scope)
+	if abs, err := filepath.Abs(filename); err == nil {
+		t := scope.thread
+		t.loads = append(t.loads, abs)
+		defer func() { t.loads = t.loads[:len(t.loads)-1] }()
+	}
+	val, err := r.runAST(scope, ast)
+	return val, exportedNames(ast), err
+}
+
+// runAST runs a parsed file with the interpreter's engine.
+func (r *Runtime) runAST(scope *Scope, ast lang.Object) (Value, error) {
+	if scope.interp.opts.Engine == EngineVM {
+		return r.execChunk(scope, ast.Block, ast.Catches)
+	}
+	return r.evalBlock(scope, ast.Block, ast.Catches)
+}
+
+func (interp *Interpreter) eval(scope *Scope, in string) (Value, error) {
+	ast, err := lang.ParseStr(in)
+	if err != nil {
+		return nil, err
+	} else if len(ast.Block) == 0 {
+		return nil, nil
+	}
+	r := Runtime{filepath: "<input>"}
+	if err := r.pushStack(scope, "<main>", -1); err != nil {
+		return nil, err
+	}
+	defer r.popStack(scope)
+	var val Value
+	if interp.opts.Engine == EngineVM {
+		val, err = r.execStatement(scope, ast.Block[0])
+	} else {
+		val, err = r.eval(scope, ast.Block[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unwrapReturn(scope, val), nil
+}
+
+// Call calls a func from Go with args converted by ToValue. fn is a *Func or
+// the name of one in the global scope. A name like player.update calls the
+// update method with player as self.
+func (interp *Interpreter) Call(fn interface{}, args ...interface{}) (Value, error) {
+	return interp.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext is Call that stops with a TimeoutError once ctx is done.
+func (interp *Interpreter) CallContext(ctx context.Context, fn interface{}, args ...interface{}) (Value, error) {
+	s := interp.global.limit(ctx)
+	var self CVal
+	if name, ok := fn.(string); ok {
+		parts := strings.Split(name, ".")
+		fn = s.Get(parts[0])
+		for _, part := range parts[1:] {
+			recv, ok := fn.(CVal)
+			if !ok {
+				return nil, fmt.Errorf("cannot find %v on %v", part, typeOf(fn))
+			}
+			key, _ := ToValue(s, part)
+			self = recv
+			var err error
+			if fn, err = recv.OpIndex(s, key); err != nil {
+				return nil, err
+			}
+		}
+		if fn == nil {
+			return nil, fmt.Errorf("undefined func %v", name)
+		}
+	}
+	return interp.call(s, fn, self, args)
+}
+
+// CallMethod calls the method name of self from Go.
+func (interp *Interpreter) CallMethod(self CVal, name string, args ...interface{}) (Value, error) {
+	s := interp.global.limit(context.Background())
+	key, _ := ToValue(s, name)
+	fn, err := self.OpIndex(s, key)
+	if err != nil {
+		return nil, err
+	}
+	return interp.call(s, fn, self, args)
+}
+
+func (interp *Interpreter) call(s *Scope, fn Value, self CVal, args []interface{}) (Value, error) {
+	vals := make([]Value, len(args))
+	for i, arg := range args {
+		var err error
+		if vals[i], err = ToValue(s, arg); err != nil {
+			return nil, err
+		}
+	}
+	res, err := callValue(s, fn, self, vals)
+	if err != nil {
+		return nil, err
+	} else if spr, ok := res.(Spread); ok {
+		return ToValue(s, spr.Table.Arr)
+	}
+	return res, nil
+}
+
+// class finds the interpreter's copy of a core class.
+func (interp *Interpreter) class(name string) (*Class, bool) {
+	cls, ok := interp.classes[name]
+	return cls, ok
+}
+
+func (w stringWriter) Write(p []byte) (int, error) {
+	return w.WriteString(string(p))
+}
@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpreterIsolation(t *testing.T) {
+	var out1, out2 strings.Builder
+	one := NewInterpreter(Options{Stdout: &out1})
+	two := NewInterpreter(Options{Stdout: &out2, Engine: EngineTree})
+
+	one.RegisterLib("greeting", func(s *Scope) (Value, error) { return ToValue(s, "hello") })
+	_, err := one.Eval(`print(require("greeting"))`)
+	assert.Nil(t, err)
+	_, err = two.Eval(`require("greeting")`)
+	assert.NotNil(t, err)
+	assert.Equal(t, "hello\n", out1.String())
+	assert.Equal(t, "", out2.String())
+
+	cls, _ := one.class("String")
+	_, err = cls.attributes["_val"].set(one.Scope(), "_val", "leaked", nil, true)
+	assert.Nil(t, err)
+	other, _ := two.class("String")
+	assert.Equal(t, "", other.attributes["_val"].val)
+	assert.Equal(t, "", StringClass.attributes["_val"].val)
+	assert.True(t, two.Scope().Get("String") == other)
+}
+
+func TestInterpretersInParallel(t *testing.T) {
+	path := writeSrc(t, taskSrc)
+	defer os.Remove(path)
+	var wg sync.WaitGroup
+	outs := make([]strings.Builder, 4)
+	for i := range outs {
+		wg.Add(1)
+		go func(out *strings.Builder) {
+			defer wg.Done()
+			_, err := NewInterpreter(Options{Stdout: out}).EvalFile(path)
+			assert.Nil(t, err)
+		}(&outs[i])
+	}
+	wg.Wait()
+	for i := range outs {
+		assert.Equal(t, "550\ncaught failed 1\n", outs[i].String())
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := NewInterpreter(Options{Stdout: &strings.Builder{}})
+	_, err := interp.Eval(`do
+  func add(a, b)
+    return a + b
+  end
+  func pair()
+    return 1, 2
+  end
+  class Counter do
+    attr count = 0
+    func bump(by)
+      self.count = self.count + by
+      return self.count
+    end
+  end
+  counter = new(Counter)
+  handlers = {}
+  func on(name, fn)
+    handlers[name] = fn
+  end
+end`)
+	assert.Nil(t, err)
+
+	val, err := interp.Call("add", 2, 3)
+	assert.Nil(t, err)
+	var sum int
+	assert.Nil(t, FromValue(val, &sum))
+	assert.Equal(t, 5, sum)
+
+	val, err = interp.Call("pair")
+	assert.Nil(t, err)
+	var both []int
+	assert.Nil(t, FromValue(val, &both))
+	assert.Equal(t, []int{1, 2}, both)
+
+	_, err = interp.Call("counter.bump", 2)
+	assert.Nil(t, err)
+	val, err = interp.CallMethod(interp.Scope().Get("counter").(CVal), "bump", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "5", toString(interp.Scope(), val))
+
+	_, err = interp.Eval(`on("greet", func(name) return "hi " + name end)`)
+	assert.Nil(t, err)
+	val, err = interp.Call("handlers.greet", "bob")
+	assert.Nil(t, err)
+	var greeting string
+	assert.Nil(t, FromValue(val, &greeting))
+	assert.Equal(t, "hi bob", greeting)
+
+	var callback *Func
+	handlers, _ := interp.Eval(`handlers`)
+	key, _ := ToValue(interp.Scope(), "greet")
+	greet, _ := handlers.(CVal).OpIndex(interp.Scope(), key)
+	assert.Nil(t, FromValue(greet, &callback))
+	val, err = interp.Call(callback, "ann")
+	assert.Nil(t, err)
+	assert.Equal(t, "hi ann", toString(interp.Scope(), val))
+
+	_, err = interp.Call("missing")
+	assert.NotNil(t, err)
+}
+
+func TestRequireModule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squirt-app")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "squirt_modules", "util", "math"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "squirt.mod"), []byte("package app\nrequire util ../util\n"), 0644))
+	lib := filepath.Join(dir, "squirt_modules", "util", "math", "double.sqrt")
+	assert.Nil(t, ioutil.WriteFile(lib, []byte("func double(n) return n * 2 end\n"), 0644))
+
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(wd)
+	assert.Nil(t, os.Chdir(dir))
+
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		var out strings.Builder
+		interp := NewInterpreter(Options{Stdout: &out, Engine: engine})
+		_, err = interp.Eval(`double = nil`)
+		assert.Nil(t, err)
+		_, err = interp.Eval(`require("util/math/double")`)
+		assert.Nil(t, err)
+		_, err = interp.Eval(`print(double(21))`)
+		assert.Nil(t, err)
+		assert.Equal(t, "42\n", out.String())
+	}
+}
@@ -0,0 +1,98 @@
+package runtime
+
+import "fmt"
+
+type (
+	// iterator steps through the values that a for-in loop binds. Classes give
+	// one to a for-in loop from __iter.
+	iterator interface {
+		next(n int) ([]Value, bool, error)
+		close() error
+	}
+
+	// tableIter walks the array part of a table and then its keyed entries.
+	tableIter struct {
+		s   *Scope
+		tbl *Table
+		i   int
+	}
+
+	stringIter struct {
+		s   *Scope
+		str string
+		i   int
+	}
+
+	funcIter struct {
+		step func() ([]Value, bool, error)
+	}
+)
+
+// Iterator makes a value that a for-in loop steps through for native libs.
+// next gives the values of each step and false once there are no more.
+func Iterator(next func() ([]Value, bool, error)) Value {
+	return &funcIter{step: next}
+}
+
+func (iter *funcIter) next(n int) ([]Value, bool, error) {
+	vals, ok, err := iter.step()
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	return pad(vals, n), true, nil
+}
+
+func (iter *funcIter) close() error { return nil }
+
+func (iter *tableIter) next(n int) ([]Value, bool, error) {
+	key, val, ok := iter.tbl.entry(iter.s, iter.i)
+	if !ok {
+		return nil, false, nil
+	}
+	iter.i++
+	return pad([]Value{key, val}, n), true, nil
+}
+
+func (iter *tableIter) close() error { return nil }
+
+func (iter *stringIter) next(n int) ([]Value, bool, error) {
+	if iter.i >= len(iter.str) {
+		return nil, false, nil
+	}
+	i := iter.i
+	iter.i++
+	key, err := ToValue(iter.s, i)
+	if err != nil {
+		return nil, false, err
+	}
+	ch, err := ToValue(iter.s, string(iter.str[i]))
+	return pad([]Value{key, ch}, n), true, err
+}
+
+func (iter *stringIter) close() error { return nil }
+
+// iterate finds the iterator that a for-in loop steps through by calling
+// __iter. What __iter gives back can be an iterator or anything else that
+// defines __iter, like a Generator.
+func iterate(scope *Scope, val Value) (iterator, error) {
+	if iter, ok := val.(iterator); ok {
+		return iter, nil
+	} else if inst, is := val.(*Instance); is {
+		if iterattr, _ := inst.class.index(scope, "__iter", inst, true); iterattr != nil {
+			res, err := iterattr.call(scope, inst, nil)
+			if err != nil {
+				return nil, err
+			} else if other, is := res.(*Instance); !is || other != inst {
+				return iterate(scope, res)
+			}
+		}
+	}
+	return nil, fmt.Errorf("used for-in loop on %v which does not define __iter", typeOf(val))
+}
+
+// pad fits vals into n values, filling in nil for values that are missing.
+func pad(vals []Value, n int) []Value {
+	padded := make([]Value, n)
+	copy(padded, vals)
+	return padded
+}
@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// DefaultMaxDepth is how deep calls can go when Options.MaxDepth is not set.
+const DefaultMaxDepth = 10000
+
+// limits bound one evaluation. Tasks and generators started by the evaluation
+// share its limits so that they are stopped along with it.
+type limits struct {
+	ctx      context.Context
+	steps    int64 // left in the budget, only counted when budgeted
+	budgeted bool
+}
+
+var (
+	TimeoutError       = CreateClass("TimeoutError", ErrorClass)
+	StackOverflowError = CreateClass("StackOverflowError", ErrorClass)
+)
+
+// EvalContext is Eval that stops with a TimeoutError once ctx is done.
+func EvalContext(ctx context.Context, scope *Scope, in string) (Value, error) {
+	return scope.interp.eval(scope.limit(ctx), in)
+}
+
+// EvalFileContext is EvalFile that stops with a TimeoutError once ctx is done.
+func EvalFileContext(ctx context.Context, scope *Scope, filename string) (Value, error) {
+	return scope.interp.evalFile(scope.limit(ctx), filename)
+}
+
+// limit gives a view of the scope on a new thread that is bound by ctx and the
+// step budget of the interpreter.
+func (scope *Scope) limit(ctx context.Context) *Scope {
+	steps := scope.interp.opts.MaxSteps
+	return scope.on(&thread{limits: &limits{ctx: ctx, steps: int64(steps), budgeted: steps > 0}})
+}
+
+// spawn creates a thread that runs under the same limits as t.
+func (t *thread) spawn(gen *generator) *thread {
+	return &thread{gen: gen, limits: t.limits}
+}
+
+// done is closed once the evaluation running on the thread is cancelled.
+func (t *thread) done() <-chan struct{} {
+	if t.limits == nil {
+		return nil
+	}
+	return t.limits.ctx.Done()
+}
+
+// tick counts one step of a loop or call. It fails once the evaluation has
+// been cancelled or has used up its step budget.
+func tick(s *Scope) error {
+	l := s.thread.limits
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.ctx.Done():
+		return timeout(s)
+	default:
+	}
+	if l.budgeted && atomic.AddInt64(&l.steps, -1) < 0 {
+		_, err := createErr(s, TimeoutError, fmt.Sprintf("evaluation used up its budget of %v steps", s.interp.opts.MaxSteps))
+		return err
+	}
+	return nil
+}
+
+func timeout(s *Scope) error {
+	_, err := createErr(s, TimeoutError, fmt.Sprintf("evaluation stopped: %v", s.thread.limits.ctx.Err()))
+	return err
+}
+
+// enter checks that one more call fits within the maximum call depth.
+func enter(s *Scope) error {
+	max := s.interp.opts.MaxDepth
+	if max <= 0 {
+		max = DefaultMaxDepth
+	}
+	if len(s.thread.trace) >= max {
+		_, err := createErr(s, StackOverflowError, fmt.Sprintf("stack overflow, calls went more than %v deep", max))
+		return err
+	}
+	return tick(s)
+}
@@ -0,0 +1,103 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	rt "runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const limitSrc = `
+func down(n)
+  return down(n + 1)
+end
+do
+  down(0)
+cleanup e = StackOverflowError do
+  print("caught", e.message)
+end
+while true do
+end
+`
+
+func TestLimits(t *testing.T) {
+	path := writeSrc(t, limitSrc)
+	defer os.Remove(path)
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		var out strings.Builder
+		interp := NewInterpreter(Options{Stdout: &out, Engine: engine, MaxDepth: 100})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := EvalFileContext(ctx, interp.Scope(), path)
+		cancel()
+		assert.Equal(t, "caught stack overflow, calls went more than 100 deep\n", out.String())
+		rerr, ok := err.(RuntimeErr)
+		if assert.True(t, ok, "expected a RuntimeErr but got %v", err) {
+			assert.Equal(t, "TimeoutError", rerr.Class())
+		}
+
+		interp = NewInterpreter(Options{Stdout: &out, Engine: engine, MaxSteps: 50})
+		_, err = interp.Eval(`for i = 0, i < 100, i++ do end`)
+		rerr, ok = err.(RuntimeErr)
+		if assert.True(t, ok, "expected a RuntimeErr but got %v", err) {
+			assert.Equal(t, "TimeoutError", rerr.Class())
+		}
+		_, err = interp.Eval(`for i = 0, i < 40, i++ do end`)
+		assert.Nil(t, err)
+	}
+}
+
+func TestCancelWhileWaiting(t *testing.T) {
+	interp := NewInterpreter(Options{Stdout: &strings.Builder{}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := EvalContext(ctx, interp.Scope(), `new(Channel).recv()`)
+	rerr, ok := err.(RuntimeErr)
+	if assert.True(t, ok, "expected a RuntimeErr but got %v", err) {
+		assert.Equal(t, "TimeoutError", rerr.Class())
+	}
+}
+
+const natSrc = `func nat()
+  n = 0
+  while true do
+    yield n
+    n++
+  end
+end`
+
+// settles waits for the goroutines of stopped generators to finish.
+func settles(t *testing.T, max int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for rt.NumGoroutine() > max && time.Now().Before(deadline) {
+		rt.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, rt.NumGoroutine(), max)
+}
+
+func TestGeneratorsStop(t *testing.T) {
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		before := rt.NumGoroutine()
+		interp := NewInterpreter(Options{Stdout: &strings.Builder{}, Engine: engine})
+		_, err := interp.Eval(natSrc)
+		assert.Nil(t, err)
+		for i := 0; i < 100; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			_, err := EvalContext(ctx, interp.Scope(), "do\n  g = nat()\n  g.next()\nend")
+			assert.Nil(t, err)
+			cancel()
+		}
+		settles(t, before)
+
+		// without a context a generator stops once nothing can resume it
+		for i := 0; i < 100; i++ {
+			_, err := interp.Eval("do\n  g = nat()\n  g.next()\nend")
+			assert.Nil(t, err)
+		}
+		settles(t, before+1)
+	}
+}
@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+// matchPattern checks if val fits the pattern of a case in a match. The values
+// of the names that the pattern binds are appended to binds in the same order
+// that patternNames lists them.
+func matchPattern(scope *Scope, pattern lang.Object, val Value, binds *[]Value) (bool, error) {
+	if mem, ok := val.(Member); ok {
+		var err error
+		if val, err = mem.get(); err != nil {
+			return false, err
+		}
+	}
+
+	switch pattern.Kind {
+	case lang.Identifier:
+		if pattern.Type != "" && !isType(val, pattern.Type) {
+			return false, nil
+		} else if pattern.Name != "_" {
+			*binds = append(*binds, val)
+		}
+		return true, nil
+	case lang.Nil:
+		return isType(val, "Nil"), nil
+	case lang.Number, lang.String, lang.Bool:
+		return matchLiteral(scope, pattern, val)
+	case lang.Table:
+		inst, isInst := val.(*Instance)
+		if !isInst || !inst.IsA("Table") {
+			return false, nil
+		}
+		return matchTable(scope, pattern, inst.field("_tbl").(*Table), binds)
+	}
+	return false, fmt.Errorf("cannot match against %v", pattern.Kind)
+}
+
+// patternNames lists the names that a pattern binds when it matches.
+func patternNames(pattern lang.Object) []string {
+	switch pattern.Kind {
+	case lang.Identifier:
+		if pattern.Name != "_" {
+			return []string{pattern.Name}
+		}
+	case lang.Table:
+		names := []string{}
+		for _, entry := range pattern.Vals {
+			if entry.Value.Kind == lang.Spread {
+				names = append(names, patternNames(*entry.Value.Value)...)
+			} else {
+				names = append(names, patternNames(*entry.Value)...)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func isType(val Value, name string) bool {
+	if val == nil {
+		return name == "Nil"
+	} else if cval, ok := val.(CVal); ok {
+		return cval.IsA(name)
+	}
+	return false
+}
+
+func literal(scope *Scope, pattern lang.Object) (Value, error) {
+	switch pattern.Kind {
+	case lang.Number:
+		return ToValue(scope, pattern.NumberValue)
+	case lang.String:
+		return ToValue(scope, pattern.StringValue)
+	case lang.Bool:
+		return ToValue(scope, pattern.BoolValue)
+	}
+	return ToValue(scope, nil)
+}
+
+func matchLiteral(scope *Scope, pattern lang.Object, val Value) (bool, error) {
+	lit, err := literal(scope, pattern)
+	if err != nil {
+		return false, err
+	}
+	inst := lit.(*Instance)
+	if !isType(val, inst.Type()) {
+		return false, nil
+	}
+	eq, err := inst.Op("__eq", scope, val)
+	if err != nil {
+		return false, err
+	}
+	return toBool(scope, eq), nil
+}
+
+// matchTable destructures a table. Positional patterns have to match the array
+// part of the table exactly unless the pattern ends in a spread which collects
+// the values and keys that were not matched into a new table.
+func matchTable(scope *Scope, pattern lang.Object, shared *Table, binds *[]Value) (bool, error) {
+	arr, keys, vals := shared.Entries()
+	tbl := &Table{Arr: arr, Keys: keys, Values: vals}
+	var rest *lang.Object
+	positional := 0
+	used := map[int]bool{}
+	for _, entry := range pattern.Vals {
+		if entry.Kind == lang.TableKey {
+			var key Value
+			var err error
+			if entry.Key.Kind == lang.Identifier {
+				key, err = ToValue(scope, entry.Key.Name)
+			} else {
+				key, err = literal(scope, *entry.Key)
+			}
+			if err != nil {
+				return false, err
+			}
+			var val Value
+			if i, isInt := isIntKey(key); isInt {
+				if i >= len(tbl.Arr) {
+					return false, nil
+				}
+				val = tbl.Arr[i]
+			} else {
+				var at int
+				if at, val = tbl.findKey(scope, key); at < 0 {
+					return false, nil
+				}
+				used[at] = true
+			}
+			if ok, err := matchPattern(scope, *entry.Value, val, binds); !ok || err != nil {
+				return false, err
+			}
+			continue
+		} else if entry.Value.Kind == lang.Spread {
+			rest = entry.Value.Value
+			continue
+		}
+		if positional >= len(tbl.Arr) {
+			return false, nil
+		}
+		if ok, err := matchPattern(scope, *entry.Value, tbl.Arr[positional], binds); !ok || err != nil {
+			return false, err
+		}
+		positional++
+	}
+
+	if rest == nil {
+		return positional == len(tbl.Arr), nil
+	}
+	remaining := &Table{Arr: append([]Value{}, tbl.Arr[positional:]...)}
+	for i, key := range tbl.Keys {
+		if !used[i] {
+			remaining.Keys = append(remaining.Keys, key)
+			remaining.Values = append(remaining.Values, tbl.Values[i])
+		}
+	}
+	restVal, err := create(scope, "Table", remaining)
+	if err != nil {
+		return false, err
+	}
+	return matchPattern(scope, *rest, restVal, binds)
+}
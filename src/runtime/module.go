@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+// ImportError is raised when an import names something a file does not
+// export.
+var ImportError = CreateClass("ImportError", ErrorClass)
+
+// exportedNames are the names a file exports with the export keyword, in the
+// order they are declared.
+func exportedNames(ast lang.Object) []string {
+	names := []string{}
+	for _, obj := range ast.Block {
+		if obj.Kind != lang.Export {
+			continue
+		}
+		switch decl := *obj.Value; decl.Kind {
+		case lang.FuncDef:
+			names = append(names, decl.Value.Name)
+		case lang.ClassDef, lang.AttrDef:
+			names = append(names, decl.Name)
+		}
+	}
+	return names
+}
+
+// exportDecl is the statement that defines an exported declaration in the
+// file scope. An attr is assigned like any other name.
+func exportDecl(obj lang.Object) lang.Object {
+	decl := *obj.Value
+	if decl.Kind != lang.AttrDef {
+		return decl
+	}
+	val := lang.Object{Kind: lang.Nil, Pos: decl.Pos}
+	if decl.Value != nil {
+		val = *decl.Value
+	}
+	return lang.Object{
+		Kind: lang.Assignment,
+		Vars: []lang.Object{{Kind: lang.Identifier, Name: decl.Name, Pos: decl.Pos}},
+		Vals: []lang.Object{val},
+		Pos:  decl.Pos,
+	}
+}
+
+// newModule is what requiring a file with exports results in, an instance
+// with a constant attribute for each export.
+func newModule(scope *Scope, names []string) *Instance {
+	class := &Class{name: "Module", attributes: map[Value]*Attribute{}}
+	for _, name := range names {
+		class.attributes[name] = &Attribute{name: name, val: scope.Get(name), refine: &Refinement{constant: true}}
+	}
+	return &Instance{class: class, data: map[Value]Value{}}
+}
+
+// isModule is true if val was made by newModule.
+func isModule(val Value) (*Instance, bool) {
+	inst, ok := val.(*Instance)
+	return inst, ok && inst.class.name == "Module" && inst.class.parent == nil
+}
+
+// importNames requires the file of an import statement and binds each of the
+// names it imports.
+func importNames(s *Scope, obj lang.Object) error {
+	val, err := RequirePath(s, obj.StringValue)
+	if err != nil {
+		return err
+	}
+	module, ok := isModule(val)
+	if !ok {
+		_, err := createErr(s, ImportError, fmt.Sprintf("%v has no exports", obj.StringValue))
+		return err
+	}
+	for i, name := range obj.Vars {
+		attr, ok := module.class.attributes[name.Name]
+		if !ok {
+			_, err := createErr(s, ImportError, fmt.Sprintf("%v does not export %v", obj.StringValue, name.Name))
+			return err
+		}
+		s.Set(obj.Vals[i].Name, attr.value())
+	}
+	return nil
+}
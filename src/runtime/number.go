@@ -6,15 +6,15 @@ var NumberClass = CreateClass("Number", nil,
 	Attr("_val", float64(0), nil),
 	FnAttr("new", func(s *Scope, self CVal, args []Value) (Value, error) {
 		if len(args) > 0 {
-			self.(*Instance).data["_val"] = toNumber(args[0])
+			self.(*Instance).setField("_val", toNumber(args[0]))
 		}
 		return nil, nil
 	}),
 	FnAttr("__add", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return me + you, nil
 		}
 		return nil, fmt.Errorf("cannot add number and %v", other.Type())
@@ -22,8 +22,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__sub", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return me - you, nil
 		}
 		return nil, fmt.Errorf("cannot subtract number and %v", other.Type())
@@ -31,8 +31,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__shiftright", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) >> int(you), nil
 		}
 		return nil, fmt.Errorf("cannot shift number and-%v", other.Type())
@@ -40,8 +40,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__shiftleft", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) << int(you), nil
 		}
 		return nil, fmt.Errorf("cannot shift number and %v", other.Type())
@@ -49,8 +49,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__and", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) & int(you), nil
 		}
 		return nil, fmt.Errorf("cannot and number and %v", other.Type())
@@ -58,8 +58,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__xor", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) ^ int(you), nil
 		}
 		return nil, fmt.Errorf("cannot xor number and %v", other.Type())
@@ -67,8 +67,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__or", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) | int(you), nil
 		}
 		return nil, fmt.Errorf("cannot or number and %v", other.Type())
@@ -76,8 +76,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__mul", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return me * you, nil
 		}
 		return nil, fmt.Errorf("cannot mul number and %v", other.Type())
@@ -85,8 +85,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__div", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return me / you, nil // TODO divide by 0
 		}
 		return nil, fmt.Errorf("cannot div number and %v", other.Type())
@@ -94,8 +94,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__mod", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) % int(you), nil
 		}
 		return nil, fmt.Errorf("cannot mod number and %v", other.Type())
@@ -103,8 +103,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__exp", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return int(me) ^ int(you), nil
 		}
 		return nil, fmt.Errorf("cannot exp number and %v", other.Type())
@@ -112,8 +112,8 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__compare", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			if me < you {
 				return -1, nil
 			} else if me == you {
@@ -126,18 +126,18 @@ var NumberClass = CreateClass("Number", nil,
 	FnAttr("__eq", func(s *Scope, self CVal, args []Value) (Value, error) {
 		other := args[0].(CVal)
 		if other.IsA("Number") {
-			me := self.(*Instance).data["_val"].(float64)
-			you := other.(*Instance).data["_val"].(float64)
+			me := self.(*Instance).field("_val").(float64)
+			you := other.(*Instance).field("_val").(float64)
 			return me == you, nil
 		}
 		return nil, fmt.Errorf("cannot mod number and %v", other.Type())
 	}),
 	FnAttr("tobool", func(s *Scope, self CVal, args []Value) (Value, error) {
-		me := self.(*Instance).data["_val"].(float64)
+		me := self.(*Instance).field("_val").(float64)
 		return me != 0, nil
 	}),
 	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
-		me := self.(*Instance).data["_val"].(float64)
+		me := self.(*Instance).field("_val").(float64)
 		return fmt.Sprintf("%g", me), nil
 	}),
 )
@@ -0,0 +1,80 @@
+package runtime
+
+import "fmt"
+
+type opcode uint8
+
+const (
+	opNil         opcode = iota // push nil
+	opConst                     // push consts[a]
+	opString                    // push a new String of names[a]
+	opConcat                    // pop a values and push them joined as a String
+	opGetLocal                  // push local a
+	opSetLocal                  // pop into local a
+	opGetUpval                  // push upvalue a
+	opSetUpval                  // pop into upvalue a
+	opGetName                   // push names[a] from the scope
+	opSetName                   // pop into names[a] on the scope
+	opPop                       // discard the top of the stack
+	opDup                       // duplicate the top of the stack
+	opFunc                      // push a new func from protos[a]
+	opParent                    // push the class named names[a]
+	opClass                     // pop the class parts described by classes[a] and push the class
+	opNewTable                  // push a new empty table
+	opTableAppend               // pop a value and append it to the table on top of the stack
+	opTableKey                  // pop a key and value and assign them on the table on top of the stack
+	opTableInst                 // pop a table and push it as a Table instance
+	opIndex                     // pop key, source and push source[key]
+	opSetIndex                  // pop key, source, value and assign source[key] = value
+	opMethod                    // pop key, source and push source, source[key]
+	opCall                      // pop a args, the callee and self then push the result
+	opBinary                    // pop right, left and push the result of the operator names[a]
+	opUnary                     // pop a value and push the result of the operator names[a]
+	opAnd                       // jump to a leaving the left value if it is false
+	opOr                        // jump to a leaving the left value if it is true
+	opJump                      // jump to a
+	opJumpIfFalse               // pop a value and jump to a if it is false
+	opSpread                    // pop a table and push it as a spread
+	opRange                     // pop end, start and push a range
+	opAssign                    // pop a values and push them distributed over b targets
+	opAssignBegin               // push the state of an assignment of assigns[a] over b targets
+	opAssignPull                // push the value for target a or evaluate the next value
+	opAssignFeed                // pop a value into the assignment and resume pulling
+	opReturn                    // pop a values and return them
+	opTry                       // push the error handler handlers[a]
+	opProtect                   // push a protected call that will resume at a on error
+	opEndProtect                // pop the protected call and pack the value on top of the stack
+	opPopBlock                  // pop the innermost handler or scope
+	opUnwind                    // pop handlers and scopes until there are a left
+	opScope                     // pop a value into names[a] on a new child scope
+	opSelf                      // bind self and super into locals b and b+1 and jump to a if called with self
+	opIterPrep                  // pop a table and push an iterator over it
+	opIterNext                  // push the next b values of the iterator or jump to a when done
+	opRaise                     // raise names[a] as an error
+	opMatch                     // push the binds of patterns[b] on the value on top of the stack or jump to a
+	opSelect                    // pop the channels of selects[a], wait for one and jump to its case with the received value
+	opYield                     // pop a values, hand them to the caller of the generator and push what it resumes with
+	opImport                    // require the file of imports[a] and bind the names it imports
+	opStatement                 // report the statement that is about to run to the Hook and Coverage
+	opBranch                    // report that branch a of the if or ternary was taken to the Coverage
+)
+
+var opNames = [...]string{
+	"NIL", "CONST", "STRING", "CONCAT", "GETLOCAL", "SETLOCAL", "GETUPVAL", "SETUPVAL",
+	"GETNAME", "SETNAME", "POP", "DUP", "FUNC", "PARENT", "CLASS", "NEWTABLE",
+	"TABLEAPPEND", "TABLEKEY", "TABLEINST", "INDEX", "SETINDEX", "METHOD", "CALL",
+	"BINARY", "UNARY", "AND", "OR", "JUMP", "JUMPIFFALSE", "SPREAD", "RANGE", "ASSIGN",
+	"ASSIGNBEGIN", "ASSIGNPULL", "ASSIGNFEED",
+	"RETURN", "TRY", "PROTECT", "ENDPROTECT", "POPBLOCK", "UNWIND", "SCOPE", "SELF",
+	"ITERPREP", "ITERNEXT", "RAISE", "MATCH", "SELECT", "YIELD", "IMPORT",
+	"STATEMENT", "BRANCH",
+}
+
+type instruction struct {
+	op   opcode
+	a, b int
+}
+
+func (in instruction) String() string {
+	return fmt.Sprintf("%-12v %v %v", opNames[in.op], in.a, in.b)
+}
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var PermissionError = CreateClass("PermissionError", ErrorClass)
+
+// Permit fails with a PermissionError if the interpreter is sandboxed and was
+// not granted the capability. Native funcs call it before doing anything that
+// a sandbox restricts.
+func (scope *Scope) Permit(capability string) error {
+	interp := scope.interp
+	if !interp.opts.Sandboxed {
+		return nil
+	}
+	for _, grant := range interp.opts.Grants {
+		if grants(grant, capability) {
+			return nil
+		}
+	}
+	_, err := createErr(scope, PermissionError, fmt.Sprintf("%v is not permitted", capability))
+	return err
+}
+
+// permitLib fails with a PermissionError if the interpreter is sandboxed and
+// was granted neither the capability a lib needs nor anything below it. A lib
+// that needs fs can be required with only fs.read:/data granted since its funcs
+// Permit every path they use.
+func (scope *Scope) permitLib(capability string) error {
+	interp := scope.interp
+	if !interp.opts.Sandboxed {
+		return nil
+	}
+	for _, grant := range interp.opts.Grants {
+		if grants(grant, capability) || grants(capability, grant) {
+			return nil
+		}
+	}
+	_, err := createErr(scope, PermissionError, fmt.Sprintf("%v is not permitted", capability))
+	return err
+}
+
+// grants reports if a grant covers a capability. A grant covers the
+// capabilities named below it, fs covers fs.read, and a grant with a path
+// covers everything inside of that path once symlinks are followed.
+func grants(grant, capability string) bool {
+	gname, gpath := splitCapability(grant)
+	name, path := splitCapability(capability)
+	if gname != name && !strings.HasPrefix(name, gname+".") {
+		return false
+	} else if gpath == "" {
+		return true
+	} else if path == "" {
+		return false
+	}
+	gpath, gerr := realPath(gpath)
+	path, err := realPath(path)
+	if gerr != nil || err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(gpath, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath is the absolute path with its symlinks resolved. A path that does
+// not exist yet, like a file about to be written, has the symlinks of its
+// nearest parent that does exist resolved.
+func realPath(path string) (string, error) {
+	sep := string(filepath.Separator)
+	if !filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		path = wd + sep + path
+	}
+	// the path is not cleaned first since .. after a symlink leaves the
+	// directory the symlink points to
+	parts := strings.Split(path, sep)
+	for i := len(parts); i > 0; i-- {
+		dir := strings.Join(parts[:i], sep)
+		if dir == "" {
+			dir = sep
+		}
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{real}, parts[i:]...)...), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return filepath.Clean(path), nil
+}
+
+func splitCapability(capability string) (string, string) {
+	parts := strings.SplitN(capability, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrants(t *testing.T) {
+	assert.True(t, grants("env", "env"))
+	assert.True(t, grants("fs", "fs.read:/data/a.txt"))
+	assert.True(t, grants("fs.read:/data", "fs.read:/data/a.txt"))
+	assert.True(t, grants("fs.read:/data", "fs.read:/data"))
+	assert.False(t, grants("fs.read:/data", "fs.read:/database"))
+	assert.False(t, grants("fs.read:/data", "fs.read:/data/../etc/passwd"))
+	assert.False(t, grants("fs.read:/data", "fs.write:/data/a.txt"))
+	assert.False(t, grants("fs.read:/data", "fs.read"))
+	assert.False(t, grants("env", "envy"))
+}
+
+func TestSandbox(t *testing.T) {
+	var out strings.Builder
+	interp := NewInterpreter(Options{Stdout: &out, Sandboxed: true, Grants: []string{"net"}})
+	interp.RegisterLib("env", func(s *Scope) (Value, error) { return ToValue(s, "secret") }, "env")
+	interp.RegisterLib("http", func(s *Scope) (Value, error) { return ToValue(s, "http") }, "net")
+	_, err := interp.Eval(`print(require("http"))`)
+	assert.Nil(t, err)
+	_, err = interp.Eval(`print(@require("env"))`)
+	assert.Nil(t, err)
+	assert.Equal(t, "http\nPermissionError: env is not permitted\n", out.String())
+
+	files := func(s *Scope) (Value, error) { return ToValue(s, "files") }
+	for grant, allowed := range map[string]bool{"fs": true, "fs.read:/data": true, "env": false, "fsx": false} {
+		interp := NewInterpreter(Options{Sandboxed: true, Grants: []string{grant}})
+		interp.RegisterLib("files", files, "fs")
+		_, err := interp.Eval(`require("files")`)
+		assert.Equal(t, allowed, err == nil, grant)
+	}
+
+	dir, err := ioutil.TempDir("", "sandbox")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lib.sqrt")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`return 1`), 0644))
+	_, err = interp.Eval(`require("` + path + `")`)
+	rerr, ok := err.(RuntimeErr)
+	if assert.True(t, ok, "expected a RuntimeErr but got %v", err) {
+		assert.Equal(t, "PermissionError", rerr.Class())
+	}
+	allowed := NewInterpreter(Options{Sandboxed: true, Grants: []string{"require:" + dir}})
+	_, err = allowed.Eval(`require("` + path + `")`)
+	assert.Nil(t, err)
+}
+
+func TestGrantsFollowSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandbox")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	granted, outside := filepath.Join(dir, "granted"), filepath.Join(dir, "outside")
+	assert.Nil(t, os.Mkdir(granted, 0755))
+	assert.Nil(t, os.Mkdir(outside, 0755))
+	secret := filepath.Join(outside, "secret.sqrt")
+	assert.Nil(t, ioutil.WriteFile(secret, []byte(`return "secret"`), 0644))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(granted, "dir")))
+	assert.Nil(t, os.Symlink(secret, filepath.Join(granted, "file.sqrt")))
+	assert.Nil(t, os.Symlink(granted, filepath.Join(dir, "alias")))
+
+	assert.True(t, grants("fs.read:"+granted, "fs.read:"+filepath.Join(granted, "new.txt")))
+	assert.True(t, grants("fs.read:"+filepath.Join(dir, "alias"), "fs.read:"+filepath.Join(granted, "a.txt")))
+	assert.False(t, grants("fs.read:"+granted, "fs.read:"+filepath.Join(granted, "file.sqrt")))
+	assert.False(t, grants("fs.read:"+granted, "fs.read:"+filepath.Join(granted, "dir", "secret.sqrt")))
+	assert.False(t, grants("fs.write:"+granted, "fs.write:"+filepath.Join(granted, "dir", "new", "file.txt")))
+	assert.False(t, grants("fs.read:"+granted, "fs.read:"+filepath.Join(granted, "dir")+"/../outside/secret.sqrt"))
+
+	interp := NewInterpreter(Options{Sandboxed: true, Grants: []string{"require:" + granted}})
+	_, err = interp.Eval(`require("` + filepath.Join(granted, "file.sqrt") + `")`)
+	rerr, ok := err.(RuntimeErr)
+	if assert.True(t, ok, "expected a RuntimeErr but got %v", err) {
+		assert.Equal(t, "PermissionError", rerr.Class())
+	}
+}
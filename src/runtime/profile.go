@@ -0,0 +1,187 @@
+package runtime
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Profile times every call and line that an interpreter runs. Give one to
+	// Options.Profile, run the program and then read its samples. Time is wall
+	// time so a call that waits on a channel is charged for the wait.
+	Profile struct {
+		mu      sync.Mutex
+		start   time.Time
+		end     time.Time
+		trace   bool
+		threads int64
+		samples map[string]*Sample
+		spans   []Span
+	}
+
+	// ProfileFrame is a func on a profiled stack and the line it was on. Line
+	// is the call site for every frame but the innermost one.
+	ProfileFrame struct {
+		Func     string
+		File     string
+		Line     int
+		FuncLine int // where the func was defined
+	}
+
+	// Sample is the time spent on the innermost line of a stack along with how
+	// many calls returned from it. Stack has the innermost frame first.
+	Sample struct {
+		Stack []ProfileFrame
+		Calls int64
+		Time  time.Duration
+	}
+
+	// Span is one call for a timeline, its start is from the start of the
+	// profile.
+	Span struct {
+		Func     string
+		File     string
+		Line     int
+		Thread   int64
+		Start    time.Duration
+		Duration time.Duration
+	}
+
+	// profCall is a call that is running on a thread.
+	profCall struct {
+		frame ProfileFrame
+		start time.Time
+		mark  time.Time // when time was last charged to the call
+	}
+)
+
+// NewProfile starts a profile. With trace set it also keeps a span for every
+// call, which takes a lot more memory.
+func NewProfile(trace bool) *Profile {
+	return &Profile{start: time.Now(), trace: trace, samples: map[string]*Sample{}}
+}
+
+// Stop marks the end of the profile.
+func (p *Profile) Stop() {
+	p.mu.Lock()
+	p.end = time.Now()
+	p.mu.Unlock()
+}
+
+// Start is when the profile started.
+func (p *Profile) Start() time.Time { return p.start }
+
+// Duration is how long the profile ran, up until now if it was not stopped.
+func (p *Profile) Duration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.end.IsZero() {
+		return time.Since(p.start)
+	}
+	return p.end.Sub(p.start)
+}
+
+// Samples lists every stack that time was spent in.
+func (p *Profile) Samples() []Sample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	samples := make([]Sample, len(keys))
+	for i, key := range keys {
+		samples[i] = *p.samples[key]
+	}
+	return samples
+}
+
+// Spans lists every call that returned in the order they returned. It is
+// empty unless the profile was created to trace.
+func (p *Profile) Spans() []Span {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Span{}, p.spans...)
+}
+
+func (p *Profile) enter(t *thread, fn, file string, line int) {
+	now := time.Now()
+	p.charge(t, now, 0)
+	if t.id == 0 {
+		t.id = atomic.AddInt64(&p.threads, 1)
+	}
+	frame := ProfileFrame{Func: fn, File: file, Line: line, FuncLine: line}
+	t.calls = append(t.calls, profCall{frame: frame, start: now, mark: now})
+}
+
+// line moves the innermost call on to another line.
+func (p *Profile) line(t *thread, line int) {
+	if len(t.calls) == 0 || line <= 0 || t.calls[len(t.calls)-1].frame.Line == line {
+		return
+	}
+	p.charge(t, time.Now(), 0)
+	t.calls[len(t.calls)-1].frame.Line = line
+}
+
+func (p *Profile) exit(t *thread) {
+	if len(t.calls) == 0 {
+		return
+	}
+	now := time.Now()
+	p.charge(t, now, 1)
+	call := t.calls[len(t.calls)-1]
+	t.calls = t.calls[:len(t.calls)-1]
+	if len(t.calls) > 0 {
+		t.calls[len(t.calls)-1].mark = now
+	}
+	if p.trace {
+		p.mu.Lock()
+		p.spans = append(p.spans, Span{
+			Func:     call.frame.Func,
+			File:     call.frame.File,
+			Line:     call.frame.FuncLine,
+			Thread:   t.id,
+			Start:    call.start.Sub(p.start),
+			Duration: now.Sub(call.start),
+		})
+		p.mu.Unlock()
+	}
+}
+
+// charge gives the time since the innermost call was last charged to the line
+// it is on.
+func (p *Profile) charge(t *thread, now time.Time, calls int64) {
+	if len(t.calls) == 0 {
+		return
+	}
+	call := &t.calls[len(t.calls)-1]
+	elapsed := now.Sub(call.mark)
+	call.mark = now
+
+	var key strings.Builder
+	for _, c := range t.calls {
+		key.WriteString(c.frame.File)
+		key.WriteByte(':')
+		key.WriteString(strconv.Itoa(c.frame.Line))
+		key.WriteByte(' ')
+		key.WriteString(c.frame.Func)
+		key.WriteByte('\n')
+	}
+	p.mu.Lock()
+	sample, ok := p.samples[key.String()]
+	if !ok {
+		sample = &Sample{Stack: make([]ProfileFrame, len(t.calls))}
+		for i, c := range t.calls {
+			sample.Stack[len(t.calls)-1-i] = c.frame
+		}
+		p.samples[key.String()] = sample
+	}
+	sample.Time += elapsed
+	sample.Calls += calls
+	p.mu.Unlock()
+}
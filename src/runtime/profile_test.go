@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const profileSrc = `func fib(m)
+  if m < 2 then
+    return m
+  end
+  return fib(m-1) + fib(m-2)
+end
+fib(10)
+`
+
+func TestProfile(t *testing.T) {
+	path := writeSrc(t, profileSrc)
+	defer os.Remove(path)
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		prof := NewProfile(true)
+		interp := NewInterpreter(Options{Engine: engine, Profile: prof})
+		_, err := interp.EvalFile(path)
+		assert.Nil(t, err)
+		prof.Stop()
+
+		calls := map[string]int64{}
+		lines := map[int]bool{}
+		for _, sample := range prof.Samples() {
+			calls[sample.Stack[0].Func] += sample.Calls
+			if sample.Stack[0].Func == "fib" {
+				lines[sample.Stack[0].Line] = true
+				assert.Equal(t, "<main>", sample.Stack[len(sample.Stack)-1].Func)
+				assert.Equal(t, 1, sample.Stack[0].FuncLine)
+			}
+		}
+		assert.Equal(t, int64(177), calls["fib"], "engine %v", engine)
+		assert.Equal(t, int64(1), calls["<main>"], "engine %v", engine)
+		assert.True(t, lines[3] && lines[5], "engine %v lines %v", engine, lines)
+		assert.Len(t, prof.Spans(), 178)
+	}
+}
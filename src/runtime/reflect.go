@@ -0,0 +1,563 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	rt "runtime"
+	"sort"
+	"strings"
+)
+
+// goIter walks a wrapped slice, map or struct the way a table is walked.
+type goIter struct {
+	s    *Scope
+	rv   reflect.Value
+	keys []reflect.Value
+	i    int
+}
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	valueType = reflect.TypeOf((*Value)(nil)).Elem()
+	cvalType  = reflect.TypeOf((*CVal)(nil)).Elem()
+)
+
+// GoClass wraps Go structs, pointers, slices and maps so that scripts can index
+// them. Exported fields and methods of structs are attributes, slices are
+// indexed by number and maps by key.
+var GoClass = CreateClass("GoValue", nil,
+	Attr("_go", nil, nil),
+	FnAttr("__index", func(s *Scope, self CVal, args []Value) (Value, error) {
+		return goIndex(s, goRef(self), args[0])
+	}),
+	FnAttr("__assignindex", func(s *Scope, self CVal, args []Value) (Value, error) {
+		return args[1], goAssignIndex(s, goRef(self), args[0], args[1])
+	}),
+	FnAttr("__len", func(s *Scope, self CVal, args []Value) (Value, error) {
+		switch rv := reflect.Indirect(goRef(self)); rv.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return rv.Len(), nil
+		default:
+			return nil, fmt.Errorf("cannot take the length of %v", rv.Type())
+		}
+	}),
+	FnAttr("__iter", func(s *Scope, self CVal, args []Value) (Value, error) {
+		rv := reflect.Indirect(goRef(self))
+		iter := &goIter{s: s, rv: rv}
+		if rv.Kind() == reflect.Map {
+			iter.keys = rv.MapKeys()
+			sort.Slice(iter.keys, func(i, j int) bool {
+				return fmt.Sprint(iter.keys[i].Interface()) < fmt.Sprint(iter.keys[j].Interface())
+			})
+		}
+		return iter, nil
+	}),
+	FnAttr("__eq", func(s *Scope, self CVal, args []Value) (Value, error) {
+		other, ok := args[0].(*Instance)
+		if !ok || !other.IsA("GoValue") {
+			return false, nil
+		}
+		a, b := goRef(self), goRef(other)
+		if a.Type() != b.Type() {
+			return false, nil
+		}
+		// slices, maps and funcs are equal if they are the same one, arrays and
+		// structs are compared by what they hold like they are in Go
+		switch a.Kind() {
+		case reflect.Slice:
+			return a.Pointer() == b.Pointer() && a.Len() == b.Len(), nil
+		case reflect.Map, reflect.Func, reflect.Chan, reflect.Ptr, reflect.UnsafePointer:
+			return a.Pointer() == b.Pointer(), nil
+		case reflect.Array, reflect.Struct, reflect.Interface:
+			return reflect.DeepEqual(a.Interface(), b.Interface()), nil
+		}
+		return a.Interface() == b.Interface(), nil
+	}),
+	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
+		rv := goRef(self)
+		if str, ok := rv.Interface().(fmt.Stringer); ok {
+			return str.String(), nil
+		} else if err, ok := rv.Interface().(error); ok {
+			return err.Error(), nil
+		}
+		return fmt.Sprint(reflect.Indirect(rv).Interface()), nil
+	}),
+)
+
+// reflectValue converts the Go values that ToValue does not know about. Values
+// that the runtime uses itself are passed through as they are.
+func reflectValue(s *Scope, obj interface{}) (Value, error) {
+	switch obj.(type) {
+	case CVal, iterator, Member, Return, Spread, Range, Break, Next, undefined:
+		return obj, nil
+	}
+	switch rv := reflect.ValueOf(obj); rv.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Int, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return create(s, "Number", rv.Convert(reflect.TypeOf(float64(0))).Interface())
+	case reflect.String:
+		return create(s, "String", rv.String())
+	case reflect.Bool:
+		return create(s, "Boolean", rv.Bool())
+	case reflect.Func:
+		return goFunc(rv, funcName(rv)), nil
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return create(s, "Nil")
+		}
+		return wrapGo(s, rv)
+	case reflect.Struct:
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		return wrapGo(s, ptr)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return wrapGo(s, rv)
+	}
+	return obj, nil
+}
+
+func wrapGo(s *Scope, rv reflect.Value) (Value, error) {
+	inst, err := create(s, "GoValue")
+	if err != nil {
+		return nil, err
+	}
+	inst.setField("_go", rv)
+	return inst, nil
+}
+
+func goRef(self CVal) reflect.Value {
+	return self.(*Instance).field("_go").(reflect.Value)
+}
+
+// refValue converts a field or element. Structs that can be addressed are
+// wrapped by their address so that assigning to them changes the original.
+func refValue(s *Scope, rv reflect.Value) (Value, error) {
+	if rv.Kind() == reflect.Struct && rv.CanAddr() {
+		return wrapGo(s, rv.Addr())
+	}
+	return ToValue(s, rv.Interface())
+}
+
+func goIndex(s *Scope, rv reflect.Value, key Value) (Value, error) {
+	if name, ok := stringKey(key); ok {
+		if method := rv.MethodByName(name); method.IsValid() {
+			return goFunc(method, name), nil
+		}
+	}
+	switch ind := reflect.Indirect(rv); ind.Kind() {
+	case reflect.Struct:
+		name, _ := stringKey(key)
+		if field, ok := structField(ind.Type(), name); ok {
+			return refValue(s, ind.FieldByIndex(field.Index))
+		}
+		return nil, fmt.Errorf("undefined field %v on %v", toString(s, key), ind.Type())
+	case reflect.Slice, reflect.Array:
+		if i, ok := isIntKey(key); ok && i < ind.Len() {
+			return refValue(s, ind.Index(i))
+		}
+		return nil, nil
+	case reflect.Map:
+		k, err := fromValue(key, ind.Type().Key())
+		if err != nil {
+			return nil, err
+		} else if val := ind.MapIndex(k); val.IsValid() {
+			return ToValue(s, val.Interface())
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot index %v", rv.Type())
+}
+
+func goAssignIndex(s *Scope, rv reflect.Value, key, val Value) error {
+	switch ind := reflect.Indirect(rv); ind.Kind() {
+	case reflect.Struct:
+		name, _ := stringKey(key)
+		field, ok := structField(ind.Type(), name)
+		if !ok {
+			return fmt.Errorf("undefined field %v on %v", toString(s, key), ind.Type())
+		}
+		return setGo(ind.FieldByIndex(field.Index), val)
+	case reflect.Slice, reflect.Array:
+		i, ok := isIntKey(key)
+		if !ok || i >= ind.Len() {
+			return fmt.Errorf("index %v out of range for %v", toString(s, key), ind.Type())
+		}
+		return setGo(ind.Index(i), val)
+	case reflect.Map:
+		if ind.IsNil() {
+			return fmt.Errorf("cannot assign to a nil %v", ind.Type())
+		}
+		k, err := fromValue(key, ind.Type().Key())
+		if err != nil {
+			return err
+		}
+		v, err := fromValue(val, ind.Type().Elem())
+		if err != nil {
+			return err
+		}
+		ind.SetMapIndex(k, v)
+		return nil
+	}
+	return fmt.Errorf("cannot assign index on %v", rv.Type())
+}
+
+func setGo(dst reflect.Value, val Value) error {
+	if !dst.CanSet() {
+		return fmt.Errorf("cannot assign to %v", dst.Type())
+	}
+	v, err := fromValue(val, dst.Type())
+	if err != nil {
+		return err
+	}
+	dst.Set(v)
+	return nil
+}
+
+func stringKey(key Value) (string, bool) {
+	if inst, ok := key.(*Instance); ok && inst.IsA("String") {
+		return inst.field("_val").(string), true
+	} else if str, ok := key.(string); ok {
+		return str, true
+	}
+	return "", false
+}
+
+// goFunc makes a Go func callable from scripts. Arguments are converted to the
+// types of its parameters and an error it returns is raised as an Error.
+func goFunc(fn reflect.Value, name string) *Func {
+	typ := fn.Type()
+	params := make([]string, typ.NumIn())
+	for i := range params {
+		params[i] = typ.In(i).String()
+	}
+	if typ.IsVariadic() {
+		params[len(params)-1] = typ.In(len(params) - 1).Elem().String()
+	}
+	f := Fn(name, func(s *Scope, self CVal, args []Value) (Value, error) {
+		return callGo(s, fn, args)
+	})
+	f.Params, f.Vararg = params, typ.IsVariadic()
+	return f
+}
+
+func funcName(fn reflect.Value) string {
+	name := rt.FuncForPC(fn.Pointer()).Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func callGo(s *Scope, fn reflect.Value, args []Value) (ret Value, err error) {
+	typ := fn.Type()
+	required := typ.NumIn()
+	if typ.IsVariadic() {
+		required--
+	}
+	in := make([]reflect.Value, 0, len(args))
+	for i, arg := range args {
+		var argType reflect.Type
+		if i < required {
+			argType = typ.In(i)
+		} else if typ.IsVariadic() {
+			argType = typ.In(required).Elem()
+		} else {
+			break
+		}
+		v, err := fromValue(arg, argType)
+		if err != nil {
+			return raise(s, "ArgumentError", fmt.Sprintf("argument %v: %v", i+1, err))
+		}
+		in = append(in, v)
+	}
+	for len(in) < required {
+		in = append(in, reflect.Zero(typ.In(len(in))))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			ret, err = raise(s, "Error", fmt.Sprint(r))
+		}
+	}()
+	out := fn.Call(in)
+	if n := len(out); n > 0 && typ.Out(n-1) == errorType {
+		if goErr := out[n-1]; !goErr.IsNil() {
+			return raise(s, "Error", goErr.Interface().(error).Error())
+		}
+		out = out[:n-1]
+	}
+	vals := make([]Value, len(out))
+	for i, o := range out {
+		if vals[i], err = ToValue(s, o.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return Return{Vals: vals}, nil
+}
+
+// raise is createErr for the error classes that cannot be referred to while
+// the package is initialized.
+func raise(s *Scope, class, msg string) (Value, error) {
+	inst, err := create(s, class, msg)
+	if err != nil {
+		return nil, err
+	}
+	return nil, inst
+}
+
+// fromValue converts a value into a Go value of typ.
+func fromValue(val Value, typ reflect.Type) (reflect.Value, error) {
+	if mem, ok := val.(Member); ok {
+		var err error
+		if val, err = mem.get(); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	if typ == valueType {
+		return reflect.ValueOf(&val).Elem(), nil
+	} else if cval, ok := val.(CVal); ok && typ == cvalType {
+		return reflect.ValueOf(&cval).Elem(), nil
+	}
+	inst, isInst := val.(*Instance)
+	if val == nil || (isInst && inst.IsA("Nil")) {
+		return reflect.Zero(typ), nil
+	} else if !isInst {
+		if reflect.TypeOf(val).AssignableTo(typ) {
+			return reflect.ValueOf(val), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use %v as %v", typeOf(val), typ)
+	} else if inst.IsA("GoValue") {
+		rv := goRef(inst)
+		if rv.Type().AssignableTo(typ) {
+			return rv, nil
+		} else if rv.Kind() == reflect.Ptr && rv.Elem().Type().AssignableTo(typ) {
+			return rv.Elem(), nil
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Interface:
+		if native := goValue(inst); native != nil && reflect.TypeOf(native).AssignableTo(typ) {
+			return reflect.ValueOf(native), nil
+		}
+	case reflect.Bool:
+		if inst.IsA("Boolean") {
+			return reflect.ValueOf(inst.field("_val")).Convert(typ), nil
+		}
+	case reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Int, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		if inst.IsA("Number") {
+			return reflect.ValueOf(inst.field("_val")).Convert(typ), nil
+		}
+	case reflect.String:
+		if inst.IsA("String") {
+			return reflect.ValueOf(inst.field("_val")).Convert(typ), nil
+		}
+	case reflect.Ptr:
+		elem, err := fromValue(inst, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		if inst.IsA("Table") {
+			arr, keys, vals := inst.field("_tbl").(*Table).Entries()
+			return tableToGo(arr, keys, vals, typ)
+		} else if typ.Kind() == reflect.Map || typ.Kind() == reflect.Struct {
+			keys, vals := instanceFields(inst)
+			return tableToGo(nil, keys, vals, typ)
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %v as %v", inst.Type(), typ)
+}
+
+// tableToGo decodes the array part and keyed entries of a table into a slice,
+// map or struct.
+func tableToGo(arr, keys, vals []Value, typ reflect.Type) (reflect.Value, error) {
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		var out reflect.Value
+		if typ.Kind() == reflect.Slice {
+			out = reflect.MakeSlice(typ, len(arr), len(arr))
+		} else {
+			out = reflect.New(typ).Elem()
+		}
+		for i := 0; i < len(arr) && i < out.Len(); i++ {
+			elem, err := fromValue(arr[i], typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.Index(i).Set(elem)
+		}
+		return out, nil
+	case reflect.Map:
+		out := reflect.MakeMap(typ)
+		for i, key := range keys {
+			k, err := fromValue(key, typ.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v, err := fromValue(vals[i], typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			out.SetMapIndex(k, v)
+		}
+		return out, nil
+	}
+	out := reflect.New(typ).Elem()
+	for i, key := range keys {
+		name, ok := stringKey(key)
+		if !ok {
+			continue
+		}
+		field, ok := structField(typ, name)
+		if !ok {
+			continue
+		}
+		v, err := fromValue(vals[i], field.Type)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field %v: %v", field.Name, err)
+		}
+		out.FieldByIndex(field.Index).Set(v)
+	}
+	return out, nil
+}
+
+// structField finds the exported field that a key stands for. A squirt tag
+// names the key of a field, otherwise the field name is matched ignoring case.
+// Fields tagged with - are left out.
+func structField(typ reflect.Type, name string) (reflect.StructField, bool) {
+	var found reflect.StructField
+	ok := false
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := strings.Split(field.Tag.Get("squirt"), ",")[0]
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		} else if tag == name {
+			return field, true
+		} else if tag == "" && !ok && strings.EqualFold(field.Name, name) {
+			found, ok = field, true
+		}
+	}
+	return found, ok
+}
+
+// fieldName is the key that a field is indexed by.
+func fieldName(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("squirt"), ",")[0]; tag != "" {
+		return tag
+	}
+	return field.Name
+}
+
+// instanceFields lists the public instance attributes of an instance along
+// with their values, attributes that hold funcs are left out.
+func instanceFields(inst *Instance) (keys, vals []Value) {
+	seen := map[string]bool{}
+	for class := inst.class; class != nil; class = class.parent {
+		for _, attr := range class.attributes {
+			if _, isFn := attr.value().(*Func); attr.static || attr.private || isFn || seen[attr.name] {
+				continue
+			}
+			seen[attr.name] = true
+			val, ok := inst.lookup(attr.name)
+			if !ok {
+				val = attr.value()
+			}
+			keys, vals = append(keys, attr.name), append(vals, val)
+		}
+	}
+	return keys, vals
+}
+
+// FromValue decodes a value into the Go value that target points to. Numbers,
+// strings and booleans decode into Go's basic types, tables into slices, maps
+// and structs and instances into maps and structs by their attributes.
+// Struct fields can name the key they decode from with a squirt tag.
+func FromValue(val Value, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("FromValue needs a non nil pointer but got %T", target)
+	}
+	v, err := fromValue(val, rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(v)
+	return nil
+}
+
+// goValue converts a value into the plain Go value it stands for. Tables with
+// keys become maps and other tables become slices.
+func goValue(val Value) interface{} {
+	inst, ok := val.(*Instance)
+	if !ok {
+		return val
+	}
+	switch inst.class.name {
+	case "Nil":
+		return nil
+	case "Number", "String", "Boolean":
+		return inst.field("_val")
+	case "GoValue":
+		return goRef(inst).Interface()
+	case "Table":
+		arr, keys, vals := inst.field("_tbl").(*Table).Entries()
+		if len(keys) == 0 {
+			goArr := make([]interface{}, len(arr))
+			for i, v := range arr {
+				goArr[i] = goValue(v)
+			}
+			return goArr
+		}
+		m := map[string]interface{}{}
+		for i, v := range arr {
+			m[fmt.Sprint(i)] = goValue(v)
+		}
+		for i, key := range keys {
+			m[fmt.Sprint(goValue(key))] = goValue(vals[i])
+		}
+		return m
+	}
+	return inst
+}
+
+func (iter *goIter) next(n int) ([]Value, bool, error) {
+	var key, val reflect.Value
+	switch iter.rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if iter.i >= iter.rv.Len() {
+			return nil, false, nil
+		}
+		key, val = reflect.ValueOf(iter.i), iter.rv.Index(iter.i)
+	case reflect.Map:
+		if iter.i >= len(iter.keys) {
+			return nil, false, nil
+		}
+		key = iter.keys[iter.i]
+		val = iter.rv.MapIndex(key)
+	case reflect.Struct:
+		typ := iter.rv.Type()
+		for ; iter.i < typ.NumField(); iter.i++ {
+			if field := typ.Field(iter.i); field.PkgPath == "" && fieldName(field) != "-" {
+				break
+			}
+		}
+		if iter.i >= typ.NumField() {
+			return nil, false, nil
+		}
+		key, val = reflect.ValueOf(fieldName(typ.Field(iter.i))), iter.rv.Field(iter.i)
+	default:
+		return nil, false, fmt.Errorf("cannot iterate over %v", iter.rv.Type())
+	}
+	iter.i++
+	k, err := ToValue(iter.s, key.Interface())
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := refValue(iter.s, val)
+	return pad([]Value{k, v}, n), true, err
+}
+
+func (iter *goIter) close() error { return nil }
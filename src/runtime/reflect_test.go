@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type account struct {
+	Owner   string
+	Balance float64
+	Tags    []string
+	Limits  map[string]int
+	secret  string
+}
+
+func (a *account) Deposit(amount float64) float64 {
+	a.Balance += amount
+	return a.Balance
+}
+
+func (a *account) Withdraw(amount float64) error {
+	if amount > a.Balance {
+		return fmt.Errorf("insufficient funds")
+	}
+	a.Balance -= amount
+	return nil
+}
+
+const reflectSrc = `
+print(acct.Owner, acct.Deposit(5), acct.Balance)
+acct.Owner = "bob"
+acct.Tags[1] = "gold"
+acct.Limits.daily = 50
+print(#acct.Tags, acct.Tags[0], acct.Tags[1], acct.Limits.daily)
+for i, tag in acct.Tags do
+  print(i, tag)
+end
+err = @acct.Withdraw(100)
+print(err)
+print(add(1, 2), join({"a", "b", "c"}))
+func secret()
+  return acct.secret
+end
+print(@secret())
+`
+
+func TestReflect(t *testing.T) {
+	path := writeSrc(t, reflectSrc)
+	defer os.Remove(path)
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		var out strings.Builder
+		interp := NewInterpreter(Options{Stdout: &out, Engine: engine})
+		acct := &account{Owner: "alice", Balance: 10, Tags: []string{"new", "basic"}, Limits: map[string]int{}, secret: "shh"}
+		for name, val := range map[string]interface{}{
+			"acct": acct,
+			"add":  func(a, b int) int { return a + b },
+			"join": func(parts []string) string { return strings.Join(parts, "-") },
+		} {
+			v, err := ToValue(interp.Scope(), val)
+			assert.Nil(t, err)
+			interp.Scope().Set(name, v)
+		}
+		_, err := interp.EvalFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, `alice 15 15
+2 new gold 50
+0 new
+1 gold
+Error: insufficient funds
+3 a-b-c
+RuntimeError: undefined field secret on runtime.account
+`, out.String(), "with the %v engine", engine)
+		assert.Equal(t, "bob", acct.Owner)
+		assert.Equal(t, []string{"new", "gold"}, acct.Tags)
+		assert.Equal(t, map[string]int{"daily": 50}, acct.Limits)
+	}
+}
+
+func TestReflectEq(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	shared := []int{1}
+	for name, val := range map[string]interface{}{
+		"a":     [2][]int{{1, 2}, {3}},
+		"b":     [2][]int{{1, 2}, {3}},
+		"c":     [2][]int{{1, 2}, {4}},
+		"s":     struct{ Tags []string }{[]string{"x"}},
+		"t":     struct{ Tags []string }{[]string{"x"}},
+		"slice": shared,
+		"same":  shared,
+		"other": []int{1},
+		"m":     map[string]int{},
+	} {
+		v, err := ToValue(interp.Scope(), val)
+		assert.Nil(t, err)
+		interp.Scope().Set(name, v)
+	}
+	for src, expected := range map[string]bool{
+		"a == b":         true,
+		"a == c":         false,
+		"s == s":         true,
+		"s == t":         false,
+		"slice == same":  true,
+		"slice == other": false,
+		"m == m":         true,
+		"a == m":         false,
+	} {
+		val, err := interp.Eval(src)
+		if assert.Nil(t, err, src) {
+			assert.Equal(t, expected, val.(*Instance).field("_val"), src)
+		}
+	}
+}
+
+type settings struct {
+	Name    string
+	Retries int `squirt:"max_retries"`
+	Hosts   []string
+	Labels  map[string]float64
+	Ignored string `squirt:"-"`
+}
+
+func TestFromValue(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	val, err := interp.Eval(`{name: "api", max_retries: 3, hosts: {"a", "b"}, labels: {x: 1.5}, ignored: "no"}`)
+	assert.Nil(t, err)
+	var cfg settings
+	assert.Nil(t, FromValue(val, &cfg))
+	assert.Equal(t, settings{Name: "api", Retries: 3, Hosts: []string{"a", "b"}, Labels: map[string]float64{"x": 1.5}}, cfg)
+
+	var generic interface{}
+	assert.Nil(t, FromValue(val, &generic))
+	assert.Equal(t, map[string]interface{}{
+		"name": "api", "max_retries": float64(3), "hosts": []interface{}{"a", "b"},
+		"labels": map[string]interface{}{"x": 1.5}, "ignored": "no",
+	}, generic)
+
+	_, err = interp.Eval(`class Point do
+  attr x = 0
+  attr y = 0
+  func sum()
+    return self.x + self.y
+  end
+end`)
+	assert.Nil(t, err)
+	val, err = interp.Eval(`new(Point, {x: 3})`)
+	assert.Nil(t, err)
+	var pt struct{ X, Y int }
+	assert.Nil(t, FromValue(val, &pt))
+	assert.Equal(t, 3, pt.X)
+	assert.Equal(t, 0, pt.Y)
+
+	var n int
+	assert.NotNil(t, FromValue(val, &n))
+	assert.NotNil(t, FromValue(val, n))
+}
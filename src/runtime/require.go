@@ -2,43 +2,153 @@ package runtime
 
 import (
 	"fmt"
-)
+	"os"
+	"path/filepath"
+	"strings"
 
-var (
-	registeredLibs = map[string]func(*Scope) (Value, error){}
-	requireCache   = map[string]Value{}
+	"github.com/tanema/squirt/src/mod"
 )
 
+// RequireCycleError is raised when a file requires a file that is still
+// being required.
+var RequireCycleError = CreateClass("RequireCycleError", ErrorClass)
+
 func stdRequire(s *Scope, self CVal, a []Value) (Value, error) {
 	if len(a) == 0 {
 		return createErr(s, ArgumentError, "not enough arguments to require")
 	} else if inst, ok := a[0].(*Instance); !ok || !inst.IsA("String") {
 		return nil, fmt.Errorf("wrong value type passed to require")
 	} else {
-		return RequirePath(s, inst.data["_val"].(string))
+		return RequirePath(s, inst.field("_val").(string))
 	}
 }
 
-func RegisterLib(name string, val func(*Scope) (Value, error)) {
-	registeredLibs[name] = val
-}
-
+// RequirePath requires a registered lib by name or a file, see resolve for
+// where files are looked for. A file is run once in its own scope and
+// require results in a module of what it exported or, if it exports nothing,
+// what it returned. Later requires of the same file get the same value.
 func RequirePath(s *Scope, path string) (Value, error) {
-	if val, ok := requireCache[path]; ok {
-		println("hit")
+	interp := s.interp
+	if val, ok := interp.cached(path); ok {
 		return val, nil
-	} else if fn, ok := registeredLibs[path]; ok {
-		val, err := fn(s)
+	} else if l, ok := interp.lib(path); ok {
+		for _, capability := range l.needs {
+			if err := s.permitLib(capability); err != nil {
+				return nil, err
+			}
+		}
+		val, err := l.load(s)
 		if err != nil {
 			return nil, err
 		}
-		requireCache[path] = val
+		interp.requireMu.Lock()
+		interp.cache[path] = val
+		interp.requireMu.Unlock()
+		return val, nil
+	}
+
+	abs, err := resolve(s, path)
+	if err != nil {
+		return nil, err
+	} else if err := s.Permit("require:" + abs); err != nil {
+		return nil, err
+	} else if val, ok := interp.cached(abs); ok {
 		return val, nil
 	}
+	loads := s.thread.loads
+	for i, loading := range loads {
+		if loading == abs {
+			cycle := append(append([]string{}, loads[i:]...), abs)
+			return createErr(s, RequireCycleError, "require cycle "+strings.Join(cycle, " -> "))
+		}
+	}
+	fileScope := s.Child(map[string]Value{})
+	res, exports, err := interp.runFile(fileScope, abs)
+	if err != nil {
+		return nil, err
+	}
+	var val Value
+	if len(exports) > 0 {
+		val = newModule(fileScope, exports)
+	} else if ret, ok := res.(Return); ok {
+		val = unwrapReturn(s, ret)
+	}
+	interp.requireMu.Lock()
+	interp.cache[abs] = val
+	interp.requireMu.Unlock()
+	return val, nil
+}
+
+// resolve finds the absolute path of a required file. Paths that start with
+// ./ or ../ are relative to the file that is requiring. Others are looked for
+// next to that file, in the working directory, in each of LOAD_PATHS and
+// then in the squirt_modules of the packages the file is in. A directory is required by its
+// index.sqrt and .sqrt can be left off.
+func resolve(s *Scope, path string) (string, error) {
+	dir := "."
+	if files := s.thread.files; len(files) > 0 && files[len(files)-1] != "" {
+		dir = filepath.Dir(files[len(files)-1])
+	}
+	candidates := []string{}
+	if filepath.IsAbs(path) {
+		candidates = append(candidates, path)
+	} else if slashed := filepath.ToSlash(path); strings.HasPrefix(slashed, "./") || strings.HasPrefix(slashed, "../") {
+		candidates = append(candidates, filepath.Join(dir, path))
+	} else {
+		candidates = append(candidates, filepath.Join(dir, path), path)
+		if tbl, ok := s.Get("LOAD_PATHS").(*Instance); ok && tbl.IsA("Table") {
+			loadPaths, _, _ := tbl.field("_tbl").(*Table).Entries()
+			for _, loadPath := range loadPaths {
+				candidates = append(candidates, filepath.Join(toString(s, loadPath), path))
+			}
+		}
+		// vendored packages are all in the squirt_modules of the package at
+		// the top so a package inside one looks further up
+		for root, ok := mod.Root(dir); ok; root, ok = mod.Root(filepath.Dir(root)) {
+			if vendored, ok := mod.Resolve(root, path); ok {
+				candidates = append(candidates, vendored)
+			}
+			if filepath.Dir(root) == root {
+				break
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		if file, ok := requirable(candidate); ok {
+			return filepath.Abs(file)
+		}
+	}
+	return "", fmt.Errorf("cannot find %v to require", path)
+}
 
-	res, err := EvalFile(s.Child(map[string]Value{}), path)
-	if ret, ok := res.(Return); ok {
-		return ret.Vals, err
+// requirable finds the file a candidate path refers to.
+func requirable(path string) (string, bool) {
+	info, err := os.Stat(path)
+	if err == nil && !info.IsDir() {
+		return path, true
+	} else if err == nil {
+		if file, ok := requirable(filepath.Join(path, "index.sqrt")); ok {
+			return file, true
+		}
 	}
-	return nil, err
+	if filepath.Ext(path) == "" {
+		return requirable(path + ".sqrt")
+	}
+	return "", false
+}
+
+// cached looks up a required value. The cache is only locked while it is read
+// or written so that requiring can happen from within a require.
+func (interp *Interpreter) cached(path string) (Value, bool) {
+	interp.requireMu.Lock()
+	defer interp.requireMu.Unlock()
+	val, ok := interp.cache[path]
+	return val, ok
+}
+
+func (interp *Interpreter) lib(name string) (lib, bool) {
+	interp.requireMu.Lock()
+	defer interp.requireMu.Unlock()
+	l, ok := interp.libs[name]
+	return l, ok
 }
@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squirt-require")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{
+		"app/main.sqrt": `counter = require("./lib/counter")
+again = require("lib/counter.sqrt")
+print(counter.bump(), again.bump())
+print(require("shapes").name)
+print(require("../shared/greet")("squirt"))
+`,
+		"app/lib/counter.sqrt": `print("loading counter")
+count = 0
+func bump()
+  count++
+  return count
+end
+return {bump: bump}
+`,
+		"app/shapes/index.sqrt": `return {name: "shapes"}`,
+		"shared/greet.sqrt":     `return func(name) return "hi ${name}" end`,
+		"cycle/a.sqrt":          `require("./b")`,
+		"cycle/b.sqrt":          `require("./a")`,
+		"vendor/paths.sqrt":     `return "found in load paths"`,
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(src), 0644))
+	}
+
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		var out strings.Builder
+		interp := NewInterpreter(Options{Stdout: &out, Engine: engine, LoadPaths: []string{filepath.Join(dir, "vendor")}})
+		_, err := interp.EvalFile(filepath.Join(dir, "app", "main.sqrt"))
+		assert.Nil(t, err)
+		assert.Equal(t, "loading counter\n1 2\nshapes\nhi squirt\n", out.String())
+
+		out.Reset()
+		_, err = interp.Eval(`print(require("paths"))`)
+		assert.Nil(t, err)
+		assert.Equal(t, "found in load paths\n", out.String())
+
+		_, err = interp.Eval(`require("missing")`)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "cannot find missing to require")
+		}
+
+		_, err = interp.EvalFile(filepath.Join(dir, "cycle", "a.sqrt"))
+		if rerr, ok := err.(RuntimeErr); assert.True(t, ok) {
+			assert.Equal(t, "RequireCycleError", rerr.Class())
+			a, b := filepath.Join(dir, "cycle", "a.sqrt"), filepath.Join(dir, "cycle", "b.sqrt")
+			assert.Equal(t, "require cycle "+a+" -> "+b+" -> "+a, rerr.Message())
+		}
+	}
+
+	interp := NewInterpreter(Options{Stdout: ioutil.Discard})
+	_, err = interp.Eval(`LOAD_PATHS[#LOAD_PATHS] = "` + filepath.Join(dir, "vendor") + `"`)
+	assert.Nil(t, err)
+	val, err := interp.Eval(`require("paths")`)
+	assert.Nil(t, err)
+	assert.Equal(t, "found in load paths", Print(interp.Scope(), val))
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squirt-import")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{
+		"main.sqrt": `import {double, Point as P, version} from "./shapes"
+print(double(4), new(P, {x: 2}).x, version)
+shapes = require("./shapes")
+print(shapes.double(5), shapes.version)
+`,
+		"shapes.sqrt": `export func double(n)
+  return n * 2
+end
+export class Point do
+  attr x = 0
+end
+export attr version = "1.0"
+hidden = 3
+`,
+		"plain.sqrt": `return {a: 1}`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644))
+	}
+
+	for _, engine := range []Engine{EngineVM, EngineTree} {
+		var out strings.Builder
+		interp := NewInterpreter(Options{Stdout: &out, Engine: engine})
+		_, err := interp.EvalFile(filepath.Join(dir, "main.sqrt"))
+		assert.Nil(t, err)
+		assert.Equal(t, "8 2 1.0\n10 1.0\n", out.String())
+
+		for src, msg := range map[string]string{
+			`import {hidden} from "` + filepath.Join(dir, "shapes") + `"`: "shapes does not export hidden",
+			`import {a} from "` + filepath.Join(dir, "plain") + `"`:       "plain has no exports",
+		} {
+			_, err = interp.Eval(src)
+			if rerr, ok := err.(RuntimeErr); assert.True(t, ok, "%v: %v", src, err) {
+				assert.Equal(t, "ImportError", rerr.Class())
+				assert.Contains(t, rerr.Message(), msg)
+			}
+		}
+		for _, src := range []string{
+			`require("` + filepath.Join(dir, "shapes") + `").version = "2.0"`,
+			`hidden = require("` + filepath.Join(dir, "shapes") + `").hidden`,
+		} {
+			_, err = interp.Eval(src)
+			assert.NotNil(t, err, src)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -40,78 +41,100 @@ type (
 type Runtime struct {
 	isFile   bool
 	filepath string
-	trace    []string
 }
 
-func EvalFile(scope *Scope, filename string) (Value, error) {
-	ast, err := lang.ParseFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	r := Runtime{filepath: filename, isFile: true}
-	r.pushStack("<main>", 0)
-	defer r.popStack()
-	val, err := r.evalBlock(scope, ast.Block, []lang.Object{})
-	if err != nil {
-		return nil, err
+// Engine selects how a parsed program is executed.
+type Engine int
+
+const (
+	// EngineVM compiles programs to bytecode and runs them on the vm.
+	EngineVM Engine = iota
+	// EngineTree walks the ast directly.
+	EngineTree
+)
+
+// DefaultEngine is the engine of the interpreters made by DefaultNamespace.
+var DefaultEngine = EngineVM
+
+func (e Engine) String() string {
+	if e == EngineTree {
+		return "tree"
 	}
+	return "vm"
+}
+
+// EvalFile runs a file in scope with the engine of the scope's interpreter.
+func EvalFile(scope *Scope, filename string) (Value, error) {
+	return EvalFileContext(context.Background(), scope, filename)
+}
+
+// Eval runs the first statement of in in scope with the engine of the scope's
+// interpreter.
+func Eval(scope *Scope, in string) (Value, error) {
+	return EvalContext(context.Background(), scope, in)
+}
+
+func unwrapReturn(scope *Scope, val Value) Value {
 	if ret, ok := val.(Return); ok {
 		if len(ret.Vals) == 1 {
-			val = ret.Vals[0]
+			return ret.Vals[0]
 		} else if len(ret.Vals) > 1 {
 			val, _ = ToValue(scope, ret.Vals)
-		} else {
-			val = nil
+			return val
 		}
+		return nil
 	}
-	return val, nil
-
+	return val
 }
 
-func Eval(scope *Scope, in string) (Value, error) {
-	ast, err := lang.ParseStr(in)
-	if err != nil {
-		return nil, err
+// pushStack records a call on the trace of the thread. It fails when the call
+// would go too deep or the evaluation has to stop.
+func (r *Runtime) pushStack(scope *Scope, name string, lineno int) error {
+	if err := enter(scope); err != nil {
+		return err
 	}
-	r := Runtime{filepath: "<input>"}
-	r.pushStack("<main>", -1)
-	defer r.popStack()
-	val, err := r.eval(scope, ast.Block[0])
-	if err != nil {
-		return nil, err
+	t := scope.thread
+	t.trace = append(t.trace, fmt.Sprintf("%v:%v in %v", r.filepath, lineno, name))
+	if r.isFile {
+		t.files = append(t.files, r.filepath)
+	} else {
+		t.files = append(t.files, "")
 	}
-	if ret, ok := val.(Return); ok {
-		if len(ret.Vals) == 1 {
-			val = ret.Vals[0]
-		} else if len(ret.Vals) > 1 {
-			val, _ = ToValue(scope, ret.Vals)
-		} else {
-			val = nil
-		}
+	if prof := scope.interp.opts.Profile; prof != nil {
+		prof.enter(t, name, r.filepath, lineno)
 	}
-	return val, nil
+	r.hook(scope, EventCall, [4]int{lineno}, name, nil)
+	return nil
 }
 
-func (r *Runtime) pushStack(name string, lineno int) {
-	r.trace = append(r.trace, fmt.Sprintf("%v:%v in %v", r.filepath, lineno, name))
+func (r *Runtime) popStack(scope *Scope) {
+	t := scope.thread
+	t.trace = t.trace[:len(t.trace)-1]
+	t.files = t.files[:len(t.files)-1]
+	if prof := scope.interp.opts.Profile; prof != nil {
+		prof.exit(t)
+	}
 }
 
-func (r *Runtime) popStack() {
-	r.trace = r.trace[:len(r.trace)-1]
+// stacktrace copies the trace of the thread a scope is used on.
+func stacktrace(scope *Scope) []string {
+	return append([]string{}, scope.thread.trace...)
 }
 
 func (r *Runtime) runtimeError(scope *Scope, obj lang.Object, msg string, data ...interface{}) error {
 	msg = fmt.Sprintf(msg, data...)
 	inst, _ := create(scope, "RuntimeError", msg)
-	return RuntimeErr{
+	err := RuntimeErr{
 		isFile:     r.isFile,
 		file:       r.filepath,
 		source:     obj,
 		errorClass: "RuntimeError",
 		msg:        msg,
 		errInst:    inst,
-		stacktrace: r.trace,
+		stacktrace: stacktrace(scope),
 	}
+	r.hook(scope, EventRaise, obj.Pos, "", err)
+	return err
 }
 
 func (r *Runtime) wrapErr(scope *Scope, obj lang.Object, err error) error {
@@ -120,37 +143,53 @@ func (r *Runtime) wrapErr(scope *Scope, obj lang.Object, err error) error {
 	} else if _, isRuntime := err.(RuntimeErr); isRuntime {
 		return err
 	} else if inst, isinst := err.(*Instance); isinst && inst.IsA("Error") {
-		msg, _ := inst.data["message"].(string)
-		return RuntimeErr{
+		msg, _ := inst.field("message").(string)
+		rerr := RuntimeErr{
 			isFile:     r.isFile,
 			file:       r.filepath,
 			source:     obj,
 			errorClass: inst.class.name,
 			errInst:    inst,
 			msg:        msg,
-			stacktrace: r.trace,
+			stacktrace: stacktrace(scope),
 		}
+		r.hook(scope, EventRaise, obj.Pos, "", rerr)
+		return rerr
 	}
 	return r.runtimeError(scope, obj, err.Error())
 }
 
 func (r *Runtime) evalBlock(scope *Scope, block, catches []lang.Object) (Value, error) {
 	for _, obj := range block {
+		r.statement(scope, obj.Pos)
 		result, err := r.eval(scope, obj)
 		if err != nil {
-			if userErr, isRuntime := err.(RuntimeErr); isRuntime {
-				for _, catch := range catches {
-					for _, errClass := range catch.Vars {
-						if userErr.errInst.IsA(errClass.Name) {
-							if catch.Name != "" {
-								scope = scope.Child(map[string]Value{catch.Name: userErr.errInst})
-							}
-							return r.evalBlock(scope, catch.Block, catch.Catches)
-						}
-					}
-				}
-			}
-			return nil, err
+			return r.catch(scope, err, catches, r.evalBlock)
+		}
+		switch result.(type) {
+		case Break, Return, Next:
+			return result, nil
+		}
+	}
+	return nil, nil
+}
+
+// evalValueBlock evaluates a block like evalBlock but results in the value of
+// the last statement if it is an expression.
+func (r *Runtime) evalValueBlock(scope *Scope, block, catches []lang.Object) (Value, error) {
+	for i, obj := range block {
+		var result Value
+		var err error
+		r.statement(scope, obj.Pos)
+		if i == len(block)-1 && isExpression(obj.Kind) {
+			result, err = r.evalValue(scope, obj)
+		} else {
+			result, err = r.eval(scope, obj)
+		}
+		if err != nil {
+			return r.catch(scope, err, catches, r.evalValueBlock)
+		} else if i == len(block)-1 && isExpression(obj.Kind) {
+			return result, nil
 		}
 		switch result.(type) {
 		case Break, Return, Next:
@@ -160,6 +199,24 @@ func (r *Runtime) evalBlock(scope *Scope, block, catches []lang.Object) (Value,
 	return nil, nil
 }
 
+// catch runs the first cleanup that handles err with eval or returns err if
+// there is none.
+func (r *Runtime) catch(scope *Scope, err error, catches []lang.Object, eval func(*Scope, []lang.Object, []lang.Object) (Value, error)) (Value, error) {
+	if userErr, isRuntime := err.(RuntimeErr); isRuntime {
+		for _, catch := range catches {
+			for _, errClass := range catch.Vars {
+				if userErr.errInst.IsA(errClass.Name) {
+					if catch.Name != "" {
+						scope = scope.Child(map[string]Value{catch.Name: userErr.errInst})
+					}
+					return eval(scope, catch.Block, catch.Catches)
+				}
+			}
+		}
+	}
+	return nil, err
+}
+
 func (r *Runtime) eval(scope *Scope, object lang.Object) (result Value, err error) {
 	switch object.Kind {
 	case lang.Assignment:
@@ -190,6 +247,8 @@ func (r *Runtime) eval(scope *Scope, object lang.Object) (result Value, err erro
 		return r.evalIndexStatement(scope, object.Vals[0], object.Vals[1], true)
 	case lang.Return:
 		return r.evalReturnStatement(scope, object)
+	case lang.Yield:
+		return r.evalYield(scope, object)
 	case lang.Identifier:
 		return scope.Get(object.Name), nil
 	case lang.String:
@@ -212,6 +271,14 @@ func (r *Runtime) eval(scope *Scope, object lang.Object) (result Value, err erro
 		return r.evalClassDef(scope, object)
 	case lang.Ternary:
 		return r.evalTernaryStatement(scope, object)
+	case lang.Match:
+		return r.evalMatch(scope, object)
+	case lang.Select:
+		return r.evalSelect(scope, object)
+	case lang.Export:
+		return r.eval(scope, exportDecl(object))
+	case lang.Import:
+		return nil, r.wrapErr(scope, object, importNames(scope, object))
 	default:
 		return nil, r.runtimeError(scope, object, "missed object kind %v, this means squirt is broken and it is not your code", object.Kind)
 	}
@@ -236,49 +303,105 @@ func (r *Runtime) evalStringLit(scope *Scope, str string) (Value, error) {
 }
 
 func (r *Runtime) evalAssign(scope *Scope, assign lang.Object) error {
+	last := len(assign.Vars) - 1
 	varInx := 0
 	roundup := []Value{}
 
 	for i, v := range assign.Vals {
-		val, err := r.eval(scope, v)
+		val, err := r.evalValue(scope, v)
 		if err != nil {
 			return err
 		}
 		vals := []Value{val}
 		if res, ok := val.(Spread); ok {
-			vals = append(res.Table.Arr)
+			vals = res.Table.Arr
 		}
 
 		for j, val := range vals {
 			valsLeft := (len(assign.Vals) - i - 1) + (len(vals) - j)
-			if varInx == len(assign.Vars)-1 && (len(roundup) > 0 || valsLeft > 1) {
+			if varInx == last && (len(roundup) > 0 || valsLeft > 1) {
 				roundup = append(roundup, val)
-				if j == len(vals)-1 && i == len(assign.Vals)-1 {
-					val, _ = create(scope, "Table", roundup...)
-				} else {
-					continue
-				}
+				continue
 			}
-			target := assign.Vars[varInx]
-			switch target.Kind {
-			case lang.Identifier:
-				scope.Set(target.Name, val)
-			case lang.Member, lang.Index:
-				inx, err := r.evalIndexStatement(scope, target.Vals[0], target.Vals[1], target.Kind == lang.Member)
-				if err != nil {
-					return r.wrapErr(scope, assign, err)
-				}
-				_, err = inx.set(val)
-				return r.wrapErr(scope, assign, err)
-			default:
-				return r.runtimeError(scope, assign, "cannot assign to type %v", target.Kind)
+			if err := r.assignTarget(scope, assign, assign.Vars[varInx], val); err != nil {
+				return err
 			}
 			varInx++
 		}
 	}
+
+	if len(roundup) > 0 {
+		tbl, _ := create(scope, "Table", roundup...)
+		return r.assignTarget(scope, assign, assign.Vars[last], tbl)
+	}
+	for ; varInx <= last; varInx++ {
+		if err := r.assignTarget(scope, assign, assign.Vars[varInx], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runtime) assignTarget(scope *Scope, assign, target lang.Object, val Value) error {
+	switch target.Kind {
+	case lang.Identifier:
+		scope.Set(target.Name, val)
+	case lang.Member, lang.Index:
+		inx, err := r.evalIndexStatement(scope, target.Vals[0], target.Vals[1], target.Kind == lang.Member)
+		if err != nil {
+			return r.wrapErr(scope, assign, err)
+		}
+		_, err = inx.set(val)
+		return r.wrapErr(scope, assign, err)
+	default:
+		return r.runtimeError(scope, assign, "cannot assign to type %v", target.Kind)
+	}
 	return nil
 }
 
+// evalValue evaluates an expression and resolves it if it is a member.
+func (r *Runtime) evalValue(scope *Scope, obj lang.Object) (Value, error) {
+	val, err := r.eval(scope, obj)
+	if err != nil {
+		return nil, err
+	}
+	if mem, ok := val.(Member); ok {
+		val, err = mem.get()
+		return val, r.wrapErr(scope, obj, err)
+	}
+	return val, nil
+}
+
+// distribute spreads the values of an assignment over its targets the same way
+// evalAssign does. If targets and values match they are assigned 1:1, if there
+// are less targets than values the last target gets a table of the remaining
+// values, and if there are more targets than values the remaining targets are
+// left nil. Spread values are unpacked before being assigned.
+func distribute(scope *Scope, vals []Value, targets int) []Value {
+	out := make([]Value, targets)
+	varInx := 0
+	roundup := []Value{}
+	for i, val := range vals {
+		group := []Value{val}
+		if spr, ok := val.(Spread); ok {
+			group = spr.Table.Arr
+		}
+		for j, val := range group {
+			valsLeft := (len(vals) - i - 1) + (len(group) - j)
+			if varInx == targets-1 && (len(roundup) > 0 || valsLeft > 1) {
+				roundup = append(roundup, val)
+				continue
+			}
+			out[varInx] = val
+			varInx++
+		}
+	}
+	if len(roundup) > 0 {
+		out[targets-1], _ = create(scope, "Table", roundup...)
+	}
+	return out
+}
+
 func (r *Runtime) evalFuncCall(scope *Scope, call lang.Object) (Value, error) {
 	fnCall, err := r.eval(scope, *call.Value)
 	if err != nil {
@@ -305,14 +428,23 @@ func (r *Runtime) evalFuncCall(scope *Scope, call lang.Object) (Value, error) {
 		self, _ = mem.source.(CVal)
 	}
 
+	res, err := callValue(scope, fnCall, self, args)
+	return res, r.wrapErr(scope, call, err)
+}
+
+// Call calls a func or an instance with __call from Go on the thread of the
+// scope so that errors it raises have the stack of the code that called Go.
+func Call(scope *Scope, fn Value, args ...Value) (Value, error) {
+	return callValue(scope, fn, nil, args)
+}
+
+func callValue(scope *Scope, fnCall Value, self CVal, args []Value) (Value, error) {
 	if fn, is := fnCall.(*Func); is {
-		res, err := fn.call(scope, self, args)
-		return res, r.wrapErr(scope, call, err)
+		return fn.call(scope, self, args)
 	} else if inst, is := fnCall.(*Instance); is {
-		res, err := inst.Op("__call", scope, args...)
-		return res, r.wrapErr(scope, call, err)
+		return inst.Op("__call", scope, args...)
 	}
-	return nil, r.runtimeError(scope, call, "tried to call a non callable object %v", typeOf(fnCall))
+	return nil, fmt.Errorf("tried to call a non callable object %v", typeOf(fnCall))
 }
 
 func evalFuncDefParams(fnSt lang.Object) (params []string, vararg bool) {
@@ -339,8 +471,10 @@ func (r *Runtime) evalFuncDef(scope *Scope, fnSt lang.Object) (Value, error) {
 		Params: paramdefs,
 		Vararg: vararg,
 		Fn: func(s *Scope, self CVal, args []Value) (Value, error) {
-			r.pushStack(fnName, fnSt.Pos[0])
-			defer r.popStack()
+			if err := r.pushStack(s, fnName, fnSt.Pos[0]); err != nil {
+				return nil, err
+			}
+			defer r.popStack(s)
 			params, err := mapParams(s, paramdefs, args, vararg)
 			if err != nil {
 				return nil, err
@@ -349,9 +483,12 @@ func (r *Runtime) evalFuncDef(scope *Scope, fnSt lang.Object) (Value, error) {
 				params["self"] = self.Self()
 				params["super"] = self.Super(scope, self, fnName, args)
 			}
-			return r.evalBlock(scope.Child(params), fnSt.Block, fnSt.Catches)
+			return r.evalBlock(scope.on(s.thread).Child(params), fnSt.Block, fnSt.Catches)
 		},
 	}
+	if fnSt.Generator {
+		fn = generatorFunc(fn)
+	}
 
 	if fnSt.Value != nil {
 		if fnSt.Value.Kind == lang.Identifier {
@@ -373,14 +510,17 @@ func (r *Runtime) evalFuncDef(scope *Scope, fnSt lang.Object) (Value, error) {
 func (r *Runtime) evalIfStatement(scope *Scope, ifSt lang.Object) (Value, error) {
 	for i, st := range ifSt.Block {
 		if i == len(ifSt.Block)-1 && st.Cond == nil {
+			r.branch(scope, ifSt.Pos, i)
 			return r.evalBlock(scope, st.Block, st.Catches)
 		} else if cond, err := r.eval(scope, *st.Cond); err != nil {
 			return nil, err
 		} else if !toBool(scope, cond) {
 			continue
 		}
+		r.branch(scope, ifSt.Pos, i)
 		return r.evalBlock(scope, st.Block, st.Catches)
 	}
+	r.branch(scope, ifSt.Pos, len(ifSt.Block))
 	return nil, nil
 }
 
@@ -388,15 +528,114 @@ func (r *Runtime) evalTernaryStatement(scope *Scope, tern lang.Object) (Value, e
 	if cond, err := r.eval(scope, tern.Vals[0]); err != nil {
 		return nil, err
 	} else if toBool(scope, cond) {
+		r.branch(scope, tern.Pos, 0)
 		return r.eval(scope, tern.Vals[1])
 	}
+	r.branch(scope, tern.Pos, 1)
 	return r.eval(scope, tern.Vals[2])
 }
 
+func (r *Runtime) branch(scope *Scope, pos [4]int, branch int) {
+	if cov := scope.interp.opts.Coverage; cov != nil {
+		cov.branch(r.filepath, pos, branch)
+	}
+}
+
+func (r *Runtime) evalMatch(scope *Scope, match lang.Object) (Value, error) {
+	result, err := r.evalCases(scope, match)
+	if err != nil {
+		return r.catch(scope, err, match.Catches, r.evalValueBlock)
+	}
+	return result, nil
+}
+
+func (r *Runtime) evalCases(scope *Scope, match lang.Object) (Value, error) {
+	subject, err := r.evalValue(scope, *match.Value)
+	if err != nil {
+		return nil, err
+	}
+	for _, clause := range match.Block {
+		binds := []Value{}
+		if ok, err := matchPattern(scope, *clause.Key, subject, &binds); err != nil {
+			return nil, r.wrapErr(scope, clause, err)
+		} else if !ok {
+			continue
+		}
+		caseScope := scope
+		if names := patternNames(*clause.Key); len(names) > 0 {
+			data := map[string]Value{}
+			for i, name := range names {
+				data[name] = binds[i]
+			}
+			caseScope = scope.Child(data)
+		}
+		if clause.Cond != nil {
+			if cond, err := r.eval(caseScope, *clause.Cond); err != nil {
+				return nil, err
+			} else if !toBool(caseScope, cond) {
+				continue
+			}
+		}
+		return r.evalValueBlock(caseScope, clause.Block, nil)
+	}
+	return nil, nil
+}
+
+func (r *Runtime) evalSelect(scope *Scope, sel lang.Object) (Value, error) {
+	result, err := r.evalSelectCases(scope, sel)
+	if err != nil {
+		return r.catch(scope, err, sel.Catches, r.evalBlock)
+	}
+	return result, nil
+}
+
+func (r *Runtime) evalSelectCases(scope *Scope, sel lang.Object) (Value, error) {
+	cases := []selectCase{}
+	clauses := []lang.Object{}
+	var fallback *lang.Object
+	for i, clause := range sel.Block {
+		if clause.Value == nil {
+			fallback = &sel.Block[i]
+			continue
+		}
+		op := *clause.Value
+		chVal, err := r.evalValue(scope, op.Value.Vals[0])
+		if err != nil {
+			return nil, err
+		}
+		ch, err := toChannel(chVal)
+		if err != nil {
+			return nil, r.wrapErr(scope, op, err)
+		}
+		sc := selectCase{ch: ch, send: op.Value.Vals[1].Name == "send"}
+		if sc.send {
+			if sc.val, err = r.evalValue(scope, op.Vals[0]); err != nil {
+				return nil, err
+			}
+		}
+		cases = append(cases, sc)
+		clauses = append(clauses, clause)
+	}
+
+	chosen, val, err := selectChannels(scope, cases, fallback == nil)
+	if err != nil {
+		return nil, r.wrapErr(scope, sel, err)
+	}
+	clause := fallback
+	if chosen >= 0 {
+		clause = &clauses[chosen]
+	}
+	caseScope := scope
+	if clause.Name != "" {
+		caseScope = scope.Child(map[string]Value{clause.Name: val})
+	}
+	return r.evalBlock(caseScope, clause.Block, nil)
+}
+
 func (r *Runtime) evalForNum(scope *Scope, forNum lang.Object) (Value, error) {
 	startVal, err := r.eval(scope, *forNum.Value)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	scope.Set(forNum.Name, startVal)
 	defer scope.Set(forNum.Name, nil)
@@ -415,15 +654,15 @@ func (r *Runtime) evalForNum(scope *Scope, forNum lang.Object) (Value, error) {
 		}
 		switch result.(type) {
 		case Break:
-			break
+			return nil, nil
 		case Return:
 			return result, nil
-		case Next:
-			continue
 		}
 
 		if _, err := r.eval(scope, *forNum.Step); err != nil {
 			return nil, err
+		} else if err := tick(scope); err != nil {
+			return nil, r.wrapErr(scope, forNum, err)
 		}
 	}
 
@@ -435,28 +674,32 @@ func (r *Runtime) evalForIn(scope *Scope, forIn lang.Object) (Value, error) {
 	if err != nil {
 		return nil, err
 	}
+	if mem, ok := data.(Member); ok {
+		if data, err = mem.get(); err != nil {
+			return nil, r.wrapErr(scope, forIn, err)
+		}
+	}
 
-	inst, is := data.(*Instance)
-	if !is || inst.class.name != "Table" {
-		return nil, r.runtimeError(scope, forIn, "used for-in loop on non table data type")
+	iter, err := iterate(scope, data)
+	if err != nil {
+		return nil, r.wrapErr(scope, forIn, err)
 	}
-	table := inst.data["_tbl"].(*Table)
+	defer iter.close()
 
-	i := 0
-	keyVar := forIn.Vars[0].Name
-	valVar := forIn.Vars[1].Name
-	defer scope.Set(keyVar, nil)
-	defer scope.Set(valVar, nil)
+	for _, v := range forIn.Vars {
+		defer scope.Set(v.Name, nil)
+	}
 
 	for {
-		if i >= len(table.Keys) {
-			break
+		vals, ok, err := iter.next(len(forIn.Vars))
+		if err != nil {
+			return nil, r.wrapErr(scope, forIn, err)
+		} else if !ok {
+			return nil, nil
+		}
+		for i, v := range forIn.Vars {
+			scope.Set(v.Name, vals[i])
 		}
-
-		key := table.Keys[i]
-		val := table.Values[i]
-		scope.Set(keyVar, key)
-		scope.Set(valVar, val)
 
 		result, err := r.evalBlock(scope, forIn.Block, forIn.Catches)
 		if err != nil {
@@ -464,16 +707,14 @@ func (r *Runtime) evalForIn(scope *Scope, forIn lang.Object) (Value, error) {
 		}
 		switch result.(type) {
 		case Break:
-			break
+			return nil, r.wrapErr(scope, forIn, iter.close())
 		case Return:
-			return result, nil
-		case Next:
-			continue
+			return result, r.wrapErr(scope, forIn, iter.close())
+		}
+		if err := tick(scope); err != nil {
+			return nil, r.wrapErr(scope, forIn, err)
 		}
-		i++
 	}
-
-	return nil, nil
 }
 
 func (r *Runtime) evalWhile(scope *Scope, while lang.Object) (Value, error) {
@@ -490,11 +731,12 @@ func (r *Runtime) evalWhile(scope *Scope, while lang.Object) (Value, error) {
 		}
 		switch result.(type) {
 		case Break:
-			break
+			return nil, nil
 		case Return:
 			return result, nil
-		case Next:
-			continue
+		}
+		if err := tick(scope); err != nil {
+			return nil, r.wrapErr(scope, while, err)
 		}
 	}
 	return nil, nil
@@ -527,26 +769,13 @@ func (r *Runtime) evalOperator(scope *Scope, obj lang.Object, leftVal, rightVal
 	leftObj, isInst, err := r.evalInstanceValue(scope, leftVal)
 	if err != nil {
 		if obj.Name == "@" {
-			if errInst, isInst := err.(*Instance); isInst {
-				return errInst, nil
-			} else if rerr, isRuntime := err.(RuntimeErr); isRuntime {
-				return create(scope, "RuntimeError", rerr.msg)
-			}
-			return create(scope, "RuntimeError", err.Error())
+			return protectErr(scope, err)
 		}
 		return nil, err
 	}
 
 	if obj.Name == "@" {
-		n, _ := ToValue(scope, nil)
-		if spr, ok := leftObj.(Spread); ok {
-			spr.Table.Arr = append([]Value{n}, spr.Table.Arr...)
-		} else if leftObj == nil {
-			leftObj = Spread{Table: &Table{Arr: []Value{n}}}
-		} else {
-			leftObj = Spread{Table: &Table{Arr: []Value{n, leftObj}}}
-		}
-		return leftObj, nil
+		return protect(scope, leftObj), nil
 	}
 
 	if !isInst {
@@ -555,16 +784,8 @@ func (r *Runtime) evalOperator(scope *Scope, obj lang.Object, leftVal, rightVal
 	left := leftObj.(*Instance)
 
 	if obj.Kind == lang.Unary {
-		switch obj.Name {
-		case "~":
-			return left.Op("__bitnot", scope)
-		case "!":
-			return ToValue(scope, !toBool(scope, left))
-		case "#":
-			return left.Op("__len", scope)
-		default:
-			return nil, r.runtimeError(scope, obj, "unsupported unary %v", obj.Name)
-		}
+		res, err := unaryOp(scope, obj.Name, left)
+		return res, r.wrapErr(scope, obj, err)
 	} else if obj.Name == "and" {
 		if !toBool(scope, left) {
 			return left, nil
@@ -585,9 +806,50 @@ func (r *Runtime) evalOperator(scope *Scope, obj lang.Object, leftVal, rightVal
 	} else if !isInst {
 		return nil, r.runtimeError(scope, obj, "right operand is an invalid value")
 	}
-	right := rightObj.(*Instance)
+	res, err := binaryOp(scope, obj.Name, left, rightObj.(*Instance))
+	return res, r.wrapErr(scope, obj, err)
+}
+
+// protect packs the result of a protected call so that the first value is the
+// (nil) error and the rest are the values of the call.
+func protect(scope *Scope, val Value) Value {
+	n, _ := ToValue(scope, nil)
+	if spr, ok := val.(Spread); ok {
+		return Spread{Table: &Table{Arr: append([]Value{n}, spr.Table.Arr...)}}
+	} else if val == nil {
+		return Spread{Table: &Table{Arr: []Value{n}}}
+	}
+	return Spread{Table: &Table{Arr: []Value{n, val}}}
+}
+
+// protectErr converts an error raised inside of a protected call into the error
+// instance that is returned in place of the values.
+func protectErr(scope *Scope, err error) (Value, error) {
+	if errInst, isInst := err.(*Instance); isInst {
+		return errInst, nil
+	} else if rerr, isRuntime := err.(RuntimeErr); isRuntime {
+		if rerr.errInst != nil {
+			return rerr.errInst, nil
+		}
+		return create(scope, "RuntimeError", rerr.msg)
+	}
+	return create(scope, "RuntimeError", err.Error())
+}
+
+func unaryOp(scope *Scope, op string, left *Instance) (Value, error) {
+	switch op {
+	case "~":
+		return left.Op("__bitnot", scope)
+	case "!":
+		return ToValue(scope, !toBool(scope, left))
+	case "#":
+		return left.Op("__len", scope)
+	}
+	return nil, fmt.Errorf("unsupported unary %v", op)
+}
 
-	switch obj.Name {
+func binaryOp(scope *Scope, op string, left, right *Instance) (Value, error) {
+	switch op {
 	case "+":
 		return left.Op("__add", scope, right)
 	case "-":
@@ -619,10 +881,10 @@ func (r *Runtime) evalOperator(scope *Scope, obj lang.Object, leftVal, rightVal
 		if !isInst || !inst.IsA("Number") {
 			return ToValue(scope, false)
 		}
-		cmpr := inst.data["_val"].(float64)
-		if (cmpr <= -1 && obj.Name[0] == '<') ||
-			(cmpr >= 1 && obj.Name[0] == '>') ||
-			(cmpr == 0 && strings.Contains(obj.Name, "=")) {
+		cmpr := inst.field("_val").(float64)
+		if (cmpr <= -1 && op[0] == '<') ||
+			(cmpr >= 1 && op[0] == '>') ||
+			(cmpr == 0 && strings.Contains(op, "=")) {
 			return ToValue(scope, true)
 		}
 		return ToValue(scope, false)
@@ -634,12 +896,8 @@ func (r *Runtime) evalOperator(scope *Scope, obj lang.Object, leftVal, rightVal
 			return nil, err
 		}
 		return ToValue(scope, !toBool(scope, val))
-	case "!":
-		return ToValue(scope, !toBool(scope, left))
-	case "#":
-		return left.Op("__len", scope)
 	}
-	return nil, r.runtimeError(scope, obj, "undefined operation %v %v %v", typeOf(left), obj.Name, typeOf(right))
+	return nil, fmt.Errorf("undefined operation %v %v %v", typeOf(left), op, typeOf(right))
 }
 
 func (r *Runtime) evalTable(scope *Scope, tbl lang.Object) (*Table, error) {
@@ -725,35 +983,75 @@ func (r *Runtime) evalReturnStatement(scope *Scope, ret lang.Object) (Return, er
 	return result, nil
 }
 
+func (r *Runtime) evalYield(scope *Scope, yield lang.Object) (Value, error) {
+	vals := []Value{}
+	for _, v := range yield.Vals {
+		val, err := r.eval(scope, v)
+		if err != nil {
+			return nil, err
+		}
+		if mem, ok := val.(Member); ok {
+			if val, err = mem.get(); err != nil {
+				return nil, r.wrapErr(scope, yield, err)
+			}
+		}
+		vals = append(vals, val)
+	}
+	val, err := scope.thread.gen.yield(vals)
+	return val, r.wrapErr(scope, yield, err)
+}
+
 func (r *Runtime) evalSpreadStatement(scope *Scope, spread lang.Object) (Spread, error) {
 	val, err := r.eval(scope, *spread.Value)
 	if err != nil {
 		return Spread{}, err
-	} else if tbl, ok := val.(*Table); !ok {
-		return Spread{}, r.runtimeError(scope, spread, "spread operator used on non table value")
-	} else {
-		return Spread{Table: tbl}, nil
 	}
+	if mem, ok := val.(Member); ok {
+		if val, err = mem.get(); err != nil {
+			return Spread{}, r.wrapErr(scope, spread, err)
+		}
+	}
+	spr, err := toSpread(val)
+	return spr, r.wrapErr(scope, spread, err)
+}
+
+func toSpread(val Value) (Spread, error) {
+	switch tbl := val.(type) {
+	case *Table:
+		arr, _, _ := tbl.Entries()
+		return Spread{Table: &Table{Arr: arr}}, nil
+	case *Instance:
+		if tbl.IsA("Table") {
+			arr, _, _ := tbl.field("_tbl").(*Table).Entries()
+			return Spread{Table: &Table{Arr: arr}}, nil
+		}
+	}
+	return Spread{}, fmt.Errorf("spread operator used on non table value")
 }
 func (r *Runtime) evalRange(scope *Scope, obj lang.Object) (Range, error) {
 	startVal, err := r.eval(scope, obj.Vals[0])
 	if err != nil {
 		return Range{}, err
 	}
-	start, isStartInt := isIntKey(startVal)
-	if !isStartInt {
-		return Range{}, r.runtimeError(scope, obj, "start in range is not a non decimal number")
-	}
 	endVal, err := r.eval(scope, obj.Vals[1])
 	if err != nil {
 		return Range{}, err
 	}
+	rng, err := toRange(startVal, endVal)
+	return rng, r.wrapErr(scope, obj, err)
+}
+
+func toRange(startVal, endVal Value) (Range, error) {
+	start, isStartInt := isIntKey(startVal)
+	if !isStartInt {
+		return Range{}, fmt.Errorf("start in range is not a non decimal number")
+	}
 	end, isEndInt := isIntKey(endVal)
 	if !isEndInt {
-		return Range{}, r.runtimeError(scope, obj, "end in range is not a non decimal number")
+		return Range{}, fmt.Errorf("end in range is not a non decimal number")
 	}
 	if end < start {
-		return Range{}, r.runtimeError(scope, obj, "range can only be positive")
+		return Range{}, fmt.Errorf("range can only be positive")
 	}
 	return Range{Start: start, End: end}, nil
 }
@@ -777,24 +1075,30 @@ func (r *Runtime) evalClassDef(scope *Scope, classdef lang.Object) (*Class, erro
 			lineno := obj.Pos[0]
 			block := obj.Block
 			catches := obj.Catches
-			attrs = append(attrs, Attr(obj.Value.Name, &Func{
+			method := &Func{
 				ClassName: classdef.Name,
 				Name:      name,
 				LineNo:    lineno,
 				Params:    paramdefs,
 				Vararg:    vararg,
 				Fn: func(s *Scope, self CVal, args []Value) (Value, error) {
-					r.pushStack(classdef.Name+"."+name, lineno)
-					defer r.popStack()
+					if err := r.pushStack(s, classdef.Name+"."+name, lineno); err != nil {
+						return nil, err
+					}
+					defer r.popStack(s)
 					params, err := mapParams(s, paramdefs, args, vararg)
 					if err != nil {
 						return nil, err
 					}
 					params["self"] = self.Self()
 					params["super"] = self.Super(scope, self, name, args)
-					return r.evalBlock(scope.Child(params), block, catches)
+					return r.evalBlock(scope.on(s.thread).Child(params), block, catches)
 				},
-			}, &Refinement{constant: true}))
+			}
+			if obj.Generator {
+				method = generatorFunc(method)
+			}
+			attrs = append(attrs, Attr(obj.Value.Name, method, &Refinement{constant: true}))
 		case lang.AttrDef:
 			var val Value
 			if obj.Value != nil {
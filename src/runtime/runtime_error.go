@@ -42,9 +42,31 @@ func (err RuntimeErr) Error() string {
 	)
 }
 
+// Class is the name of the error class that was raised.
+func (err RuntimeErr) Class() string {
+	return err.errorClass
+}
+
+// IsA is true if the error raised is an instance of the class or inherits it.
+func (err RuntimeErr) IsA(class string) bool {
+	return err.errInst != nil && err.errInst.IsA(class)
+}
+
+// Message is the message of the error without where it was raised.
+func (err RuntimeErr) Message() string {
+	return err.msg
+}
+
+// Position is the file and position of the code that raised the error. The
+// file is the source itself when the error was not raised from a file.
+func (err RuntimeErr) Position() (string, [4]int) {
+	return err.file, err.source.Pos
+}
+
 func reverseTrace(trace []string) []string {
-	for i, j := 0, len(trace)-1; i < j; i, j = i+1, j-1 {
-		trace[i], trace[j] = trace[j], trace[i]
+	reversed := make([]string, len(trace))
+	for i, line := range trace {
+		reversed[len(trace)-1-i] = line
 	}
-	return trace
+	return reversed
 }
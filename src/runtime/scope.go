@@ -1,52 +1,169 @@
 package runtime
 
-import "io"
+import (
+	"sort"
+	"sync"
+)
 
 // Scope captures all definitions and their values
 type Scope struct {
-	data  map[string]Value
-	out   io.StringWriter
-	outer *Scope
+	mu     *sync.RWMutex
+	data   map[string]Value
+	interp *Interpreter
+	outer  *Scope
+	thread *thread
 }
 
-func newScope(outer *Scope, binds map[string]Value, out io.StringWriter) *Scope {
+// thread is the state of one goroutine that is running squirt code. Every
+// scope knows the thread it is being used on so that spawned tasks keep their
+// own stack traces.
+type thread struct {
+	trace  []string
+	files  []string   // the file of each call on the trace, empty when not from a file
+	loads  []string   // the files being required, innermost last
+	gen    *generator // the generator that is running on the thread
+	limits *limits
+	id     int64      // set once a Profile first sees the thread
+	calls  []profCall // the calls a Profile is timing
+}
+
+func newScope(outer *Scope, binds map[string]Value, interp *Interpreter) *Scope {
 	if binds == nil {
 		binds = map[string]Value{}
 	}
-	return &Scope{
-		data:  binds,
-		out:   out,
-		outer: outer,
+	scope := &Scope{
+		mu:     &sync.RWMutex{},
+		data:   binds,
+		interp: interp,
+		outer:  outer,
+	}
+	if outer != nil {
+		scope.thread = outer.thread
+	} else {
+		scope.thread = &thread{}
 	}
+	return scope
 }
 
 // Child creates a new Scope that inherits this one.
 func (scope *Scope) Child(binds map[string]Value) *Scope {
-	return newScope(scope, binds, scope.out)
+	return newScope(scope, binds, scope.interp)
 }
 
-func (scope *Scope) find(key string) *Scope {
-	if _, ok := scope.data[key]; ok {
+// Interpreter is the interpreter that the scope belongs to.
+func (scope *Scope) Interpreter() *Interpreter {
+	return scope.interp
+}
+
+// on gives a view of the scope that shares its definitions but is used on
+// another thread.
+func (scope *Scope) on(t *thread) *Scope {
+	if scope.thread == t {
 		return scope
-	} else if scope.outer == nil {
-		return nil
 	}
-	return scope.outer.find(key)
+	view := *scope
+	view.thread = t
+	return &view
+}
+
+// assign sets key in the nearest scope that defines it, or defines it on this
+// scope if none do and define is true. The scopes are locked from this one out
+// to the one that has key so that tasks assigning the same new name at once
+// agree on where it lives. Locks are always taken inner first so they cannot
+// deadlock.
+func (scope *Scope) assign(key string, value Value, define bool) bool {
+	target, last := scope, scope
+	found := false
+	for s := scope; s != nil; s = s.outer {
+		s.mu.Lock()
+		last = s
+		if _, found = s.data[key]; found {
+			target = s
+			break
+		}
+	}
+	if found || define {
+		target.data[key] = value
+	}
+	for s := scope; ; s = s.outer {
+		s.mu.Unlock()
+		if s == last {
+			break
+		}
+	}
+	return found
 }
 
 // Set will set the definition of a name on the current Scope
 func (scope *Scope) Set(key string, value Value) {
-	if found := scope.find(key); found != nil {
-		found.data[key] = value
-	} else {
-		scope.data[key] = value
-	}
+	scope.assign(key, value, true)
 }
 
 // Get will retreive the value of a name recursively up the parentage of this scope
 func (scope *Scope) Get(key string) Value {
-	if found := scope.find(key); found != nil {
-		return found.data[key]
+	for s := scope; s != nil; s = s.outer {
+		s.mu.RLock()
+		val, ok := s.data[key]
+		s.mu.RUnlock()
+		if ok {
+			return val
+		}
 	}
 	return nil
 }
+
+// Names lists every name that is visible from this scope
+func (scope *Scope) Names() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for s := scope; s != nil; s = s.outer {
+		s.mu.RLock()
+		for name := range s.data {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Locals lists the names that are visible from this scope leaving out the core
+// funcs and classes that every interpreter defines.
+func (scope *Scope) Locals() []string {
+	names := []string{}
+	for _, name := range scope.Names() {
+		if !scope.isCore(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Outer is the scope that this one inherits, nil for the global scope.
+func (scope *Scope) Outer() *Scope {
+	return scope.outer
+}
+
+// Vars lists the names defined in this scope itself, not the ones it
+// inherits, leaving out the core funcs and classes.
+func (scope *Scope) Vars() []string {
+	names := []string{}
+	scope.mu.RLock()
+	for name := range scope.data {
+		if !scope.isCore(name) {
+			names = append(names, name)
+		}
+	}
+	scope.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+func (scope *Scope) isCore(name string) bool {
+	_, isFn := fns[name]
+	_, isClass := scope.interp.classes[name]
+	return isFn || isClass
+}
@@ -6,12 +6,12 @@ var StringClass = CreateClass("String", nil,
 	Attr("_val", "", nil),
 	FnAttr("new", func(s *Scope, self CVal, args []Value) (Value, error) {
 		if len(args) > 0 {
-			self.(*Instance).data["_val"] = toString(s, args[0])
+			self.(*Instance).setField("_val", toString(s, args[0]))
 		}
 		return nil, nil
 	}),
 	FnAttr("__index", func(s *Scope, self CVal, args []Value) (Value, error) {
-		val := self.(*Instance).data["_val"].(string)
+		val := self.(*Instance).field("_val").(string)
 		if rng, isRange := args[0].(Range); isRange && (rng.Start > len(val) || rng.End > len(val)) {
 			return nil, fmt.Errorf("range index out of range")
 		} else if isRange {
@@ -25,7 +25,7 @@ var StringClass = CreateClass("String", nil,
 		}
 	}),
 	FnAttr("__assignindex", func(s *Scope, self CVal, args []Value) (Value, error) {
-		val := self.(*Instance).data["_val"].(string)
+		val := self.(*Instance).field("_val").(string)
 		var start, end string
 		if rng, isRange := args[0].(Range); isRange && (rng.Start > len(val) || rng.End > len(val)) {
 			return nil, fmt.Errorf("range index out of range")
@@ -40,20 +40,23 @@ var StringClass = CreateClass("String", nil,
 			start = val[:inx]
 			end = val[inx+1:]
 		}
-		self.(*Instance).data["_val"] = start + toString(s, args[1]) + end
+		self.(*Instance).setField("_val", start+toString(s, args[1])+end)
 		return self, nil
 	}),
 	FnAttr("__add", func(s *Scope, self CVal, args []Value) (Value, error) {
-		return self.(*Instance).data["_val"].(string) + toString(s, args[0]), nil
+		return self.(*Instance).field("_val").(string) + toString(s, args[0]), nil
 	}),
 	FnAttr("__eq", func(s *Scope, self CVal, args []Value) (Value, error) {
-		return self.(*Instance).data["_val"].(string) == toString(s, args[0]), nil
+		return self.(*Instance).field("_val").(string) == toString(s, args[0]), nil
 	}),
 	FnAttr("__len", func(s *Scope, self CVal, args []Value) (Value, error) {
-		return len(self.(*Instance).data["_val"].(string)), nil
+		return len(self.(*Instance).field("_val").(string)), nil
+	}),
+	FnAttr("__iter", func(s *Scope, self CVal, args []Value) (Value, error) {
+		return &stringIter{s: s, str: self.(*Instance).field("_val").(string)}, nil
 	}),
 	FnAttr("tobool", func(s *Scope, self CVal, args []Value) (Value, error) {
-		return self.(*Instance).data["_val"].(string) != "", nil
+		return self.(*Instance).field("_val").(string) != "", nil
 	}),
 	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
 		return self, nil
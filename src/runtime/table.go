@@ -3,14 +3,33 @@ package runtime
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type (
+	// Table keeps its keyed entries in the order they were added. The index
+	// finds the position of a key by its hash and is caught up with Keys
+	// whenever a key is looked up so tables can be built by appending to Keys.
+	// Once tasks can share a table it is only read and changed under mu, which
+	// is never held while script code like __hash or __eq runs.
 	Table struct {
-		Arr    []Value
-		Keys   []Value
-		Values []Value
+		mu      sync.Mutex
+		Arr     []Value
+		Keys    []Value
+		Values  []Value
+		index   map[interface{}][]int
+		indexed int
+		version int // changes whenever a key is added or removed
 	}
+
+	// userHash is the hash a class gave with __hash. It is kept apart from the
+	// native hashes because the keys in its bucket still have to be compared
+	// with __eq.
+	userHash struct{ h interface{} }
+
+	// unhashable is the bucket for keys with an __eq but no __hash, they are
+	// compared to each other with __eq like every key used to be.
+	unhashable struct{}
 )
 
 var TableClass = CreateClass("Table", nil,
@@ -18,7 +37,7 @@ var TableClass = CreateClass("Table", nil,
 	FnAttr("new", func(s *Scope, self CVal, args []Value) (Value, error) {
 		if len(args) == 1 {
 			if tbl, ok := args[0].(*Table); ok {
-				self.(*Instance).data["_tbl"] = tbl
+				self.(*Instance).setField("_tbl", tbl)
 				return nil, nil
 			} else if tbl, ok := args[0].(map[string]Value); ok {
 				newTbl := &Table{}
@@ -27,21 +46,25 @@ var TableClass = CreateClass("Table", nil,
 					newTbl.Keys = append(newTbl.Keys, instKey)
 					newTbl.Values = append(newTbl.Values, val)
 				}
-				self.(*Instance).data["_tbl"] = newTbl
+				self.(*Instance).setField("_tbl", newTbl)
 				return nil, nil
 			} else if tbl, ok := args[0].(CVal); ok && tbl.IsA("Table") {
-				self.(*Instance).data["_tbl"] = tbl.(*Instance).data["_tbl"].(*Table)
+				self.(*Instance).setField("_tbl", tbl.(*Instance).field("_tbl").(*Table))
 				return nil, nil
 			}
 		}
-		self.(*Instance).data["_tbl"] = &Table{Arr: args}
+		self.(*Instance).setField("_tbl", &Table{Arr: args})
 		return nil, nil
 	}),
 	FnAttr("__index", func(s *Scope, self CVal, args []Value) (Value, error) {
-		tbl := self.(*Instance).data["_tbl"].(*Table)
+		tbl := self.(*Instance).field("_tbl").(*Table)
 		if rng, isRange := args[0].(Range); isRange {
-			return &Table{Arr: tbl.Arr[min(rng.Start, len(tbl.Arr)):min(rng.End, len(tbl.Arr))]}, nil
+			tbl.mu.Lock()
+			defer tbl.mu.Unlock()
+			return &Table{Arr: append([]Value{}, tbl.Arr[min(rng.Start, len(tbl.Arr)):min(rng.End, len(tbl.Arr))]...)}, nil
 		} else if i, itis := isIntKey(args[0]); itis {
+			tbl.mu.Lock()
+			defer tbl.mu.Unlock()
 			if i < len(tbl.Arr) {
 				return tbl.Arr[i], nil
 			}
@@ -51,19 +74,17 @@ var TableClass = CreateClass("Table", nil,
 		return val, nil
 	}),
 	FnAttr("__assignindex", func(s *Scope, self CVal, args []Value) (Value, error) {
-		tbl := self.(*Instance).data["_tbl"].(*Table)
+		tbl := self.(*Instance).field("_tbl").(*Table)
 		if rng, isRange := args[0].(Range); isRange {
-			tbl.ensureSize(s, rng.End-1)
-			start, end := tbl.Arr[:rng.Start], tbl.Arr[rng.End:]
+			insert := []Value{args[1]}
 			if inst, is := args[1].(*Instance); is && inst.IsA("Table") {
-				other := inst.data["_tbl"].(*Table)
-				tbl.Arr = append(start, other.Arr...)
-			} else {
-				tbl.Arr = append(start, args[1])
-			}
-			if len(end) > 0 {
-				tbl.Arr = append(tbl.Arr, end...)
+				insert, _, _ = inst.field("_tbl").(*Table).Entries()
 			}
+			tbl.mu.Lock()
+			defer tbl.mu.Unlock()
+			tbl.ensureSize(s, rng.End-1)
+			start, end := tbl.Arr[:rng.Start], append([]Value{}, tbl.Arr[rng.End:]...)
+			tbl.Arr = append(append(start, insert...), end...)
 			return args[1], nil
 		}
 		return tbl.assign(s, args[0], args[1])
@@ -72,8 +93,8 @@ var TableClass = CreateClass("Table", nil,
 		other := args[0].(CVal)
 		if other.IsA("Table") {
 			newTable := &Table{}
-			newTable.add(s, self.(*Instance).data["_tbl"].(*Table))
-			newTable.add(s, other.(*Instance).data["_tbl"].(*Table))
+			newTable.add(s, self.(*Instance).field("_tbl").(*Table))
+			newTable.add(s, other.(*Instance).field("_tbl").(*Table))
 			return newTable, nil
 		}
 		return nil, fmt.Errorf("cannot add table and %v", other.Type())
@@ -82,16 +103,16 @@ var TableClass = CreateClass("Table", nil,
 		other := args[0].(CVal)
 		if other.IsA("Table") {
 			newTable := &Table{}
-			selftbl := self.(*Instance).data["_tbl"].(*Table)
-			othertbl := other.(*Instance).data["_tbl"].(*Table)
-			for _, v := range selftbl.Arr {
+			arr, keys, vals := self.(*Instance).field("_tbl").(*Table).Entries()
+			othertbl := other.(*Instance).field("_tbl").(*Table)
+			for _, v := range arr {
 				if i := othertbl.findValue(s, v); i == -1 {
 					newTable.Arr = append(newTable.Arr, v)
 				}
 			}
-			for i, key := range selftbl.Keys {
+			for i, key := range keys {
 				if _, val := othertbl.findKey(s, key); val == nil {
-					newTable.assign(s, key, selftbl.Values[i])
+					newTable.assign(s, key, vals[i])
 				}
 			}
 			return newTable, nil
@@ -99,25 +120,35 @@ var TableClass = CreateClass("Table", nil,
 		return nil, fmt.Errorf("cannot sub table and %v", other.Type())
 	}),
 	FnAttr("__shiftleft", func(s *Scope, self CVal, args []Value) (Value, error) {
-		tbl := self.(*Instance).data["_tbl"].(*Table)
+		tbl := self.(*Instance).field("_tbl").(*Table)
+		tbl.mu.Lock()
 		tbl.Arr = append(tbl.Arr, args[0])
+		tbl.mu.Unlock()
 		return self, nil
 	}),
 	FnAttr("__eq", func(s *Scope, self CVal, args []Value) (Value, error) {
-		tbl := self.(*Instance).data["_tbl"].(*Table)
+		tbl := self.(*Instance).field("_tbl").(*Table)
 		other := args[0].(CVal)
 		if other.IsA("Table") {
-			othertbl := other.(*Instance).data["_tbl"].(*Table)
+			othertbl := other.(*Instance).field("_tbl").(*Table)
 			return tbl == othertbl, nil
 		}
 		return false, nil
 	}),
 	FnAttr("__len", func(s *Scope, self CVal, args []Value) (Value, error) {
-		return len(self.(*Instance).data["_tbl"].(*Table).Arr), nil
+		tbl := self.(*Instance).field("_tbl").(*Table)
+		tbl.mu.Lock()
+		defer tbl.mu.Unlock()
+		return len(tbl.Arr), nil
+	}),
+	FnAttr("__iter", func(s *Scope, self CVal, args []Value) (Value, error) {
+		return &tableIter{s: s, tbl: self.(*Instance).field("_tbl").(*Table)}, nil
 	}),
 	FnAttr("__del", func(s *Scope, self CVal, args []Value) (Value, error) {
-		tbl := self.(*Instance).data["_tbl"].(*Table)
+		tbl := self.(*Instance).field("_tbl").(*Table)
 		if i, is := isIntKey(args[0]); is {
+			tbl.mu.Lock()
+			defer tbl.mu.Unlock()
 			if int(i) >= len(tbl.Arr) {
 				return nil, nil
 			}
@@ -125,38 +156,60 @@ var TableClass = CreateClass("Table", nil,
 			tbl.Arr = append(tbl.Arr[:i], tbl.Arr[i+1:]...)
 			return val, nil
 		}
-
-		if i, val := tbl.findKey(s, args[0]); val != nil {
-			tbl.Keys = append(tbl.Keys[:i], tbl.Keys[i+1:]...)
-			tbl.Values = append(tbl.Values[:i], tbl.Values[i+1:]...)
-			return val, nil
-		}
-
-		return nil, nil
+		return tbl.removeKey(s, args[0]), nil
 	}),
 	FnAttr("tobool", func(s *Scope, self CVal, args []Value) (Value, error) {
 		return true, nil
 	}),
 	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
-		tbl := self.(*Instance).data["_tbl"].(*Table)
+		arr, keys, vals := self.(*Instance).field("_tbl").(*Table).Entries()
 		strList := []string{}
-		for _, e := range tbl.Arr {
+		for _, e := range arr {
 			strList = append(strList, toString(s, e))
 		}
-		for i, key := range tbl.Keys {
-			strList = append(strList, toString(s, key)+": "+toString(s, tbl.Values[i]))
+		for i, key := range keys {
+			strList = append(strList, toString(s, key)+": "+toString(s, vals[i]))
 		}
 		return "{" + strings.Join(strList, ", ") + "}", nil
 	}),
 )
 
+// Entries copies the array values, keys and keyed values of the table so
+// they can be read while tasks keep changing it.
+func (tbl *Table) Entries() (arr, keys, vals []Value) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	arr = append([]Value{}, tbl.Arr...)
+	keys = append([]Value{}, tbl.Keys...)
+	vals = append([]Value{}, tbl.Values...)
+	return arr, keys, vals
+}
+
+// entry is the key and value at position i of the array values followed by
+// the keyed entries.
+func (tbl *Table) entry(s *Scope, i int) (key, val Value, ok bool) {
+	tbl.mu.Lock()
+	defer tbl.mu.Unlock()
+	if i < len(tbl.Arr) {
+		key, _ = ToValue(s, i)
+		return key, tbl.Arr[i], true
+	} else if i -= len(tbl.Arr); i < len(tbl.Keys) {
+		return tbl.Keys[i], tbl.Values[i], true
+	}
+	return nil, nil, false
+}
+
 func (tbl *Table) add(s *Scope, other *Table) {
-	tbl.Arr = append(tbl.Arr, other.Arr...)
-	for i, key := range other.Keys {
-		tbl.assign(s, key, other.Values[i])
+	arr, keys, vals := other.Entries()
+	tbl.mu.Lock()
+	tbl.Arr = append(tbl.Arr, arr...)
+	tbl.mu.Unlock()
+	for i, key := range keys {
+		tbl.assign(s, key, vals[i])
 	}
 }
 
+// ensureSize grows the array values to size, mu has to be held.
 func (tbl *Table) ensureSize(s *Scope, size int) {
 	if size < len(tbl.Arr) {
 		return
@@ -168,49 +221,191 @@ func (tbl *Table) ensureSize(s *Scope, size int) {
 	}
 }
 
+// assign sets the value of a key. The key is looked up without holding mu so
+// if another task adds or removes a key before the value is set the key is
+// looked up again.
 func (tbl *Table) assign(s *Scope, key, val Value) (Value, error) {
 	if i, is := isIntKey(key); is {
+		tbl.mu.Lock()
+		defer tbl.mu.Unlock()
 		tbl.ensureSize(s, i)
 		tbl.Arr[i] = val
 		return val, nil
 	}
 
-	if i, v := tbl.findKey(s, key); v == nil {
-		tbl.Keys = append(tbl.Keys, key)
-		tbl.Values = append(tbl.Values, val)
-	} else {
-		tbl.Values[i] = val
+	for {
+		i, _, version := tbl.lookup(s, key)
+		tbl.mu.Lock()
+		if tbl.version == version {
+			if i < 0 {
+				tbl.Keys = append(tbl.Keys, key)
+				tbl.Values = append(tbl.Values, val)
+				tbl.version++
+			} else {
+				tbl.Values[i] = val
+			}
+			tbl.mu.Unlock()
+			return val, nil
+		}
+		tbl.mu.Unlock()
 	}
-	return val, nil
 }
 
 func (tbl *Table) findKey(s *Scope, key Value) (int, Value) {
-	for i, k := range tbl.Keys {
-		if key == k {
-			return i, tbl.Values[i]
-		}
-		if inst, ok := k.(CVal); ok {
-			if res, err := inst.Op("__eq", s, key); err == nil {
-				if toBool(s, res) {
-					return i, tbl.Values[i]
+	i, val, _ := tbl.lookup(s, key)
+	return i, val
+}
+
+// lookup finds the position and value of a key along with the version of the
+// keys that it searched. The keys in the bucket are compared after letting go
+// of mu since comparing them can call __eq.
+func (tbl *Table) lookup(s *Scope, key Value) (int, Value, int) {
+	h, exact := hashKey(s, key)
+	for {
+		tbl.reindex(s)
+		tbl.mu.Lock()
+		if tbl.indexed == len(tbl.Keys) {
+			break
+		}
+		tbl.mu.Unlock()
+	}
+	version, positions := tbl.version, append([]int{}, tbl.index[h]...)
+	keys, vals := make([]Value, len(positions)), make([]Value, len(positions))
+	for j, i := range positions {
+		keys[j], vals[j] = tbl.Keys[i], tbl.Values[i]
+	}
+	tbl.mu.Unlock()
+	for j, i := range positions {
+		if exact || sameKey(s, keys[j], key) {
+			return i, vals[j], version
+		}
+	}
+	return -1, nil, version
+}
+
+// removeKey takes out the keyed entry of key and moves the positions after it
+// down in the index instead of hashing every key again.
+func (tbl *Table) removeKey(s *Scope, key Value) Value {
+	for {
+		i, val, version := tbl.lookup(s, key)
+		if i < 0 {
+			return nil
+		}
+		tbl.mu.Lock()
+		if tbl.version != version {
+			tbl.mu.Unlock()
+			continue
+		}
+		tbl.Keys = append(tbl.Keys[:i], tbl.Keys[i+1:]...)
+		tbl.Values = append(tbl.Values[:i], tbl.Values[i+1:]...)
+		for bucket, positions := range tbl.index {
+			kept := positions[:0]
+			for _, at := range positions {
+				if at > i {
+					kept = append(kept, at-1)
+				} else if at < i {
+					kept = append(kept, at)
 				}
 			}
+			if len(kept) == 0 {
+				delete(tbl.index, bucket)
+			} else {
+				tbl.index[bucket] = kept
+			}
 		}
+		tbl.indexed--
+		tbl.version++
+		tbl.mu.Unlock()
+		return val
 	}
-	return -1, nil
 }
 
-func (tbl *Table) findValue(s *Scope, val Value) int {
-	for i, v := range tbl.Arr {
-		if val == v {
-			return i
+// reindex adds the keys that are not in the index yet. They are hashed
+// without holding mu since hashing can call __hash, and hashed again if the
+// keys were changed in the meantime.
+func (tbl *Table) reindex(s *Scope) {
+	for {
+		tbl.mu.Lock()
+		if tbl.index == nil || tbl.indexed > len(tbl.Keys) {
+			tbl.index, tbl.indexed = map[interface{}][]int{}, 0
 		}
-		if inst, ok := val.(CVal); ok {
-			if res, err := inst.Op("__eq", s, val); err == nil {
-				if toBool(s, res) {
-					return i
-				}
+		from, version := tbl.indexed, tbl.version
+		pending := append([]Value{}, tbl.Keys[from:]...)
+		tbl.mu.Unlock()
+		if len(pending) == 0 {
+			return
+		}
+
+		hashes := make([]interface{}, len(pending))
+		for i, key := range pending {
+			hashes[i], _ = hashKey(s, key)
+		}
+
+		tbl.mu.Lock()
+		caughtUp := tbl.indexed == from && tbl.version == version
+		if caughtUp {
+			for i, h := range hashes {
+				tbl.index[h] = append(tbl.index[h], from+i)
 			}
+			tbl.indexed += len(hashes)
+		}
+		tbl.mu.Unlock()
+		if caughtUp {
+			return
+		}
+	}
+}
+
+// hashKey finds the bucket of a key. Strings, numbers, booleans and nil hash
+// natively and tables, funcs and classes by their identity, these are exact
+// so two keys with the same hash are the same key. Other keys hash with
+// __hash, which has to agree with __eq, and still get compared with __eq.
+func hashKey(s *Scope, key Value) (interface{}, bool) {
+	inst, isInst := key.(*Instance)
+	if !isInst {
+		switch key.(type) {
+		case nil, *Class, *Func:
+			return key, true
+		}
+		return unhashable{}, false
+	}
+	switch val := inst.field("_val").(type) {
+	case string, float64, bool:
+		if inst.IsA("String") || inst.IsA("Number") || inst.IsA("Boolean") {
+			return val, true
+		}
+	}
+	if inst.IsA("Nil") {
+		return nil, true
+	} else if tbl, ok := inst.field("_tbl").(*Table); ok && inst.IsA("Table") {
+		return tbl, true
+	} else if hashattr, _ := inst.class.index(s, "__hash", inst, true); hashattr != nil {
+		if res, err := hashattr.call(s, inst, nil); err == nil {
+			h, _ := hashKey(s, res)
+			return userHash{h}, false
+		}
+	} else if eqattr, _ := inst.class.index(s, "__eq", inst, true); eqattr == nil {
+		return inst, true
+	}
+	return unhashable{}, false
+}
+
+func sameKey(s *Scope, k, key Value) bool {
+	if key == k {
+		return true
+	} else if inst, ok := k.(CVal); ok {
+		if res, err := inst.Op("__eq", s, key); err == nil {
+			return toBool(s, res)
+		}
+	}
+	return false
+}
+
+func (tbl *Table) findValue(s *Scope, val Value) int {
+	arr, _, _ := tbl.Entries()
+	for i, v := range arr {
+		if sameKey(s, v, val) {
+			return i
 		}
 	}
 	return -1
@@ -219,7 +414,7 @@ func (tbl *Table) findValue(s *Scope, val Value) int {
 func isIntKey(key Value) (int, bool) {
 	if inst, ok := key.(CVal); ok {
 		if inst.IsA("Number") {
-			val := inst.(*Instance).data["_val"].(float64)
+			val := inst.(*Instance).field("_val").(float64)
 			if float64(int(val)) == val && val >= 0 {
 				return int(val), true
 			}
@@ -0,0 +1,161 @@
+package runtime
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const hashSrc = `
+class Point do
+  attr x = 0
+  attr y = 0
+  func __eq(other)
+    return self.x == other.x and self.y == other.y
+  end
+  func __hash()
+    return "${self.x},${self.y}"
+  end
+end
+
+func point(x, y)
+  p = new(Point)
+  p.x = x
+  p.y = y
+  return p
+end
+
+t = {b: 1, a: 2, [1.5]: 3, [true]: 4}
+t[point(1, 2)] = "first"
+t[point(1, 2)] = "again"
+t.c = 5
+delete(t, "a")
+print(t[point(1, 2)], t[1.5], t[true], t.b, t.c, t.a)
+print(t - {b: 0})
+print(#({point(1, 2), point(3, 4)} - {point(3, 4)}))
+for k, v in t do
+  print(k, v)
+end
+`
+
+func TestTableKeys(t *testing.T) {
+	path := writeSrc(t, hashSrc)
+	defer os.Remove(path)
+	expected := `again 3 4 1 5 
+{1.5: 3, true: 4, #<Instance of Point>: again, c: 5}
+1
+b 1
+1.5 3
+true 4
+#<Instance of Point> again
+c 5
+`
+	assert.Equal(t, expected, runEngine(t, EngineVM, path))
+	assert.Equal(t, expected, runEngine(t, EngineTree, path))
+}
+
+const sharedTableSrc = `
+shared = {}
+func worker(n)
+  for j = 0, j < 200, j++ do
+    shared["k${j % 10}"] = n
+    seen = shared["k${j % 7}"]
+    shared["tmp${n}"] = j
+    delete(shared, "tmp${n}")
+    shared << j
+  end
+end
+tasks = {}
+for t = 0, t < 8, t++ do
+  tasks << spawn(worker, t)
+end
+for t = 0, t < #tasks, t++ do
+  tasks[t].await()
+end
+keys = 0
+for k, v in shared do
+  keys++
+end
+print(#shared, keys - #shared)
+`
+
+func TestTableSharedByTasks(t *testing.T) {
+	path := writeSrc(t, sharedTableSrc)
+	defer os.Remove(path)
+	assert.Equal(t, "1600 10\n", runEngine(t, EngineVM, path))
+	assert.Equal(t, "1600 10\n", runEngine(t, EngineTree, path))
+}
+
+// benchTableSize is small enough that the linear scans finish in a reasonable
+// time while still showing how they grow.
+const benchTableSize = 1000
+
+func buildTable(scope *Scope, n int) *Table {
+	tbl := &Table{}
+	for i := 0; i < n; i++ {
+		key, _ := ToValue(scope, "key"+strconv.Itoa(i))
+		tbl.assign(scope, key, i)
+	}
+	return tbl
+}
+
+// linearFind is how tables found keys before they were indexed, kept for the
+// benchmarks to compare against.
+func linearFind(s *Scope, tbl *Table, key Value) int {
+	for i, k := range tbl.Keys {
+		if sameKey(s, k, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func buildLinearTable(scope *Scope, n int) *Table {
+	tbl := &Table{}
+	for i := 0; i < n; i++ {
+		key, _ := ToValue(scope, "key"+strconv.Itoa(i))
+		if at := linearFind(scope, tbl, key); at >= 0 {
+			tbl.Values[at] = i
+		} else {
+			tbl.Keys, tbl.Values = append(tbl.Keys, key), append(tbl.Values, i)
+		}
+	}
+	return tbl
+}
+
+func BenchmarkTableAssign(b *testing.B) {
+	scope := DefaultNamespace(&strings.Builder{})
+	for i := 0; i < b.N; i++ {
+		buildTable(scope, benchTableSize)
+	}
+}
+
+func BenchmarkTableAssignLinear(b *testing.B) {
+	scope := DefaultNamespace(&strings.Builder{})
+	for i := 0; i < b.N; i++ {
+		buildLinearTable(scope, benchTableSize)
+	}
+}
+
+func BenchmarkTableLookup(b *testing.B) {
+	scope := DefaultNamespace(&strings.Builder{})
+	tbl := buildTable(scope, benchTableSize)
+	key, _ := ToValue(scope, "key"+strconv.Itoa(benchTableSize-1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tbl.findKey(scope, key)
+	}
+}
+
+func BenchmarkTableLookupLinear(b *testing.B) {
+	scope := DefaultNamespace(&strings.Builder{})
+	tbl := buildLinearTable(scope, benchTableSize)
+	key, _ := ToValue(scope, "key"+strconv.Itoa(benchTableSize-1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearFind(scope, tbl, key)
+	}
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import "fmt"
+
+// task is a func that was spawned to run on its own goroutine.
+type task struct {
+	done chan struct{}
+	val  Value
+	err  error
+}
+
+var TaskClass = CreateClass("Task", nil,
+	Attr("_task", nil, nil),
+	FnAttr("await", func(s *Scope, self CVal, args []Value) (Value, error) {
+		t, err := toTask(self)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-t.done:
+			return t.val, t.err
+		case <-s.thread.done():
+			return nil, timeout(s)
+		}
+	}),
+	FnAttr("done", func(s *Scope, self CVal, args []Value) (Value, error) {
+		t, err := toTask(self)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-t.done:
+			return true, nil
+		default:
+			return false, nil
+		}
+	}),
+	FnAttr("tostring", func(s *Scope, self CVal, args []Value) (Value, error) {
+		return "#<Task>", nil
+	}),
+)
+
+func toTask(self CVal) (*task, error) {
+	if inst, ok := self.(*Instance); ok {
+		if t, ok := inst.field("_task").(*task); ok {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("tasks can only be created with spawn")
+}
+
+// stdSpawn calls a func on a new goroutine. The func runs on its own thread so
+// errors raised in it have their own stack trace and are raised again when the
+// task is awaited.
+func stdSpawn(s *Scope, self CVal, args []Value) (Value, error) {
+	if len(args) == 0 {
+		return createErr(s, ArgumentError, "not enough arguments to spawn")
+	} else if _, ok := args[0].(*Func); !ok {
+		return createErr(s, ArgumentError, fmt.Sprintf("cannot spawn a %v", typeOf(args[0])))
+	}
+	inst, err := create(s, "Task")
+	if err != nil {
+		return nil, err
+	}
+	t := &task{done: make(chan struct{})}
+	inst.setField("_task", t)
+	scope := s.on(s.thread.spawn(nil))
+	go func() {
+		defer close(t.done)
+		t.val, t.err = callValue(scope, args[0], nil, args[1:])
+	}()
+	return inst, nil
+}
@@ -0,0 +1,133 @@
+package runtime
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const taskSrc = `
+func fib(n)
+  if n < 2 then
+    return n
+  end
+  return fib(n-1) + fib(n-2)
+end
+
+results = new(Channel, 10)
+func worker(n)
+  results.send(fib(n))
+end
+tasks = {}
+for i = 0, i < 10, i++ do
+  tasks << spawn(worker, 10)
+end
+total = 0
+for i = 0, i < #tasks, i++ do
+  tasks[i].await()
+  total += results.recv()
+end
+print(total)
+
+func fails(n)
+  spill("failed " + n)
+end
+t = spawn(fails, 1)
+do
+  t.await()
+cleanup e = Error do
+  print("caught", e.message)
+end
+`
+
+func TestTasks(t *testing.T) {
+	path := writeSrc(t, taskSrc)
+	defer os.Remove(path)
+	expected := "550\ncaught failed 1\n"
+	assert.Equal(t, expected, runEngine(t, EngineVM, path))
+	assert.Equal(t, expected, runEngine(t, EngineTree, path))
+}
+
+const sharedSrc = `
+class Counter do
+  attr count = 0
+  attr last = nil
+  attr Latest = nil
+end
+counter = new(Counter)
+names = {}
+handoff = new(Channel, 8)
+func worker(n)
+  for j = 0, j < 100, j++ do
+    counter.count = counter.count + 1
+    counter.last = n
+    Counter.Latest = counter.last
+    names["w${n}"] = j
+    names << counter.last
+  end
+  handoff.send({from: n})
+end
+tasks = {}
+for t = 0, t < 8, t++ do
+  tasks << spawn(worker, t)
+end
+for t = 0, t < #tasks, t++ do
+  tasks[t].await()
+  handoff.recv().seen = true
+end
+print(#names, names.w0, names.w7, Counter.Latest >= 0)
+`
+
+func TestTasksShareValues(t *testing.T) {
+	path := writeSrc(t, sharedSrc)
+	defer os.Remove(path)
+	expected := "800 99 99 true\n"
+	assert.Equal(t, expected, runEngine(t, EngineVM, path))
+	assert.Equal(t, expected, runEngine(t, EngineTree, path))
+}
+
+const assignSrc = `
+shared = 0
+func worker(n)
+  for j = 0, j < 200, j++ do
+    fresh = n
+    shared = fresh
+    if fresh != n then
+      return false
+    end
+  end
+  return true
+end
+tasks = {}
+for t = 0, t < 8, t++ do
+  tasks << spawn(worker, t)
+end
+ok = true
+for t = 0, t < #tasks, t++ do
+  ok = ok and tasks[t].await()
+end
+print(ok, typeof(fresh), shared >= 0)
+`
+
+func TestTasksAssignNames(t *testing.T) {
+	path := writeSrc(t, assignSrc)
+	defer os.Remove(path)
+	expected := "true nil true\n"
+	assert.Equal(t, expected, runEngine(t, EngineVM, path))
+	assert.Equal(t, expected, runEngine(t, EngineTree, path))
+}
+
+func TestTaskTrace(t *testing.T) {
+	scope := DefaultNamespace(&strings.Builder{})
+	_, err := Eval(scope, `func fails() spill("bad") end`)
+	assert.Nil(t, err)
+	task, err := Eval(scope, `spawn(fails)`)
+	assert.Nil(t, err)
+	_, err = task.(*Instance).Op("await", scope)
+	rerr, ok := err.(RuntimeErr)
+	if assert.True(t, ok, "expected a RuntimeErr but got %v", err) {
+		assert.Equal(t, []string{"<input>:1 in fails"}, rerr.stacktrace)
+	}
+}
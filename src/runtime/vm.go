@@ -0,0 +1,592 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+type (
+	// closure is a proto bound to the upvalues and scope it was defined in.
+	closure struct {
+		p      *proto
+		upvals []*cell
+		scope  *Scope
+	}
+
+	// cell boxes a local that has been captured by a nested func so that both
+	// funcs share the same variable.
+	cell struct{ v Value }
+
+	// undefined marks a local slot that has not been assigned yet.
+	undefined struct{}
+
+	frame struct {
+		cl     *closure
+		scope  *Scope
+		self   CVal
+		args   []Value
+		locals []Value
+		stack  []Value
+		blocks []block
+		pc     int
+	}
+
+	blockKind int
+
+	// block is an error handler, child scope or for-in loop that is active in
+	// a frame.
+	block struct {
+		kind   blockKind
+		index  int
+		sp     int
+		parent *Scope
+		iter   iterator
+	}
+
+	assignState struct {
+		exprs    []int
+		targets  int
+		vals     []Value
+		expanded []Value
+		resume   int
+	}
+)
+
+const (
+	blockTry blockKind = iota
+	blockProtect
+	blockScope
+	blockIter
+)
+
+var undef Value = undefined{}
+
+func (r *Runtime) execChunk(scope *Scope, block, catches []lang.Object) (Value, error) {
+	return r.exec(&closure{p: compileChunk(scope, block, catches), scope: scope}, scope, nil, nil)
+}
+
+func (r *Runtime) execStatement(scope *Scope, obj lang.Object) (Value, error) {
+	return r.exec(&closure{p: compileStatement(scope, obj), scope: scope}, scope, nil, nil)
+}
+
+func (r *Runtime) newFunc(cl *closure) *Func {
+	p := cl.p
+	traceName := p.name
+	if p.className != "" {
+		traceName = p.className + "." + p.name
+	}
+	fn := &Func{
+		ClassName: p.className,
+		Name:      p.name,
+		LineNo:    p.lineno,
+		Params:    p.params,
+		Vararg:    p.vararg,
+		Fn: func(s *Scope, self CVal, args []Value) (Value, error) {
+			if err := r.pushStack(s, traceName, p.lineno); err != nil {
+				return nil, err
+			}
+			defer r.popStack(s)
+			return r.exec(cl, s, self, args)
+		},
+	}
+	if p.generator {
+		return generatorFunc(fn)
+	}
+	return fn
+}
+
+func (r *Runtime) exec(cl *closure, s *Scope, self CVal, args []Value) (Value, error) {
+	p := cl.p
+	f := &frame{cl: cl, scope: cl.scope.on(s.thread), self: self, args: args}
+	if p.chunk {
+		return r.run(f)
+	} else if p.dynamic {
+		params, err := mapParams(s, p.params, args, p.vararg)
+		if err != nil {
+			return nil, err
+		}
+		if self != nil {
+			params["self"] = self.Self()
+			params["super"] = self.Super(cl.scope, self, p.name, args)
+		}
+		f.scope = f.scope.Child(params)
+		return r.run(f)
+	}
+
+	f.locals = make([]Value, len(p.localNames))
+	for i := range f.locals {
+		if p.captured[i] {
+			f.locals[i] = &cell{v: undef}
+		} else {
+			f.locals[i] = undef
+		}
+	}
+	definedParams := len(p.params)
+	if p.vararg {
+		definedParams--
+		f.setLocal(definedParams, varargs(s, args, definedParams))
+	}
+	for i := 0; i < definedParams && i < len(args); i++ {
+		val := args[i]
+		if mem, ok := val.(Member); ok {
+			memval, err := mem.get()
+			if err != nil {
+				return nil, err
+			}
+			val = memval
+		}
+		f.setLocal(i, val)
+	}
+	return r.run(f)
+}
+
+func (f *frame) push(val Value) { f.stack = append(f.stack, val) }
+
+func (f *frame) pop() Value {
+	val := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return val
+}
+
+func (f *frame) popN(n int) []Value {
+	vals := make([]Value, n)
+	copy(vals, f.stack[len(f.stack)-n:])
+	f.stack = f.stack[:len(f.stack)-n]
+	return vals
+}
+
+func (f *frame) top() Value { return f.stack[len(f.stack)-1] }
+
+func (f *frame) getLocal(slot int) Value {
+	val := f.locals[slot]
+	if c, ok := val.(*cell); ok {
+		val = c.v
+	}
+	return val
+}
+
+func (f *frame) setLocal(slot int, val Value) {
+	if c, ok := f.locals[slot].(*cell); ok {
+		c.v = val
+	} else {
+		f.locals[slot] = val
+	}
+}
+
+// load resolves the value of a local or upvalue. Weak variables that have not
+// been assigned yet fall back to the scope the same way the tree walker would
+// find them.
+func (f *frame) load(val Value, weak bool, name string) Value {
+	if val != undef {
+		return val
+	} else if weak {
+		return f.scope.Get(name)
+	}
+	return nil
+}
+
+// store assigns a local or upvalue. Weak variables that have not been assigned
+// yet will assign a definition in the scope if one exists.
+func (f *frame) store(cur Value, weak bool, name string, val Value, set func(Value)) {
+	if cur == undef && weak {
+		if f.scope.assign(name, val, false) {
+			return
+		}
+	}
+	set(val)
+}
+
+// popBlock leaves the innermost block. Leaving a for-in loop closes its
+// iterator which can fail if a generator does not end when it is closed.
+func (f *frame) popBlock() error {
+	b := f.blocks[len(f.blocks)-1]
+	f.blocks = f.blocks[:len(f.blocks)-1]
+	if b.kind == blockScope {
+		f.scope = b.parent
+	} else if b.kind == blockIter {
+		return b.iter.close()
+	}
+	return nil
+}
+
+func (f *frame) unwind(blocks int) error {
+	var err error
+	for len(f.blocks) > blocks {
+		if berr := f.popBlock(); err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
+// recover unwinds the handlers in the frame looking for one that can handle the
+// error. It returns the error again if nothing in this frame can handle it.
+func (r *Runtime) recover(f *frame, err error) error {
+	err = r.wrapErr(f.scope, lang.Object{Pos: f.cl.p.pos[f.pc-1]}, err)
+	for len(f.blocks) > 0 {
+		b := f.blocks[len(f.blocks)-1]
+		f.popBlock()
+		switch b.kind {
+		case blockProtect:
+			val, perr := protectErr(f.scope, err)
+			if perr != nil {
+				return perr
+			}
+			f.stack = f.stack[:b.sp]
+			f.push(val)
+			f.pc = b.index
+			return nil
+		case blockTry:
+			userErr, isRuntime := err.(RuntimeErr)
+			if !isRuntime {
+				continue
+			}
+			for _, clause := range f.cl.p.handlers[b.index].clauses {
+				for _, cls := range clause.classes {
+					if userErr.errInst.IsA(cls) {
+						f.stack = f.stack[:b.sp]
+						f.push(userErr.errInst)
+						f.pc = clause.target
+						return nil
+					}
+				}
+			}
+		}
+	}
+	return err
+}
+
+func (r *Runtime) run(f *frame) (Value, error) {
+	p := f.cl.p
+	prof := f.scope.interp.opts.Profile
+	for f.pc < len(p.code) {
+		if prof != nil {
+			prof.line(f.scope.thread, p.pos[f.pc][0])
+		}
+		in := p.code[f.pc]
+		f.pc++
+		var err error
+		switch in.op {
+		case opNil:
+			f.push(nil)
+		case opConst:
+			f.push(p.consts[in.a])
+		case opString:
+			var str Value
+			str, err = create(f.scope, "String", p.names[in.a])
+			f.push(str)
+		case opConcat:
+			parts := f.popN(in.a)
+			strs := make([]string, len(parts))
+			for i, part := range parts {
+				strs[i] = toString(f.scope, part)
+			}
+			var str Value
+			str, err = create(f.scope, "String", strings.Join(strs, ""))
+			f.push(str)
+		case opGetLocal:
+			f.push(f.load(f.getLocal(in.a), p.weak[in.a], p.localNames[in.a]))
+		case opSetLocal:
+			slot := in.a
+			f.store(f.getLocal(slot), p.weak[slot], p.localNames[slot], f.pop(), func(val Value) {
+				f.setLocal(slot, val)
+			})
+		case opGetUpval:
+			desc := p.upvals[in.a]
+			f.push(f.load(f.cl.upvals[in.a].v, desc.weak, desc.name))
+		case opSetUpval:
+			desc, c := p.upvals[in.a], f.cl.upvals[in.a]
+			f.store(c.v, desc.weak, desc.name, f.pop(), func(val Value) { c.v = val })
+		case opGetName:
+			f.push(f.scope.Get(p.names[in.a]))
+		case opSetName:
+			f.scope.Set(p.names[in.a], f.pop())
+		case opPop:
+			f.pop()
+		case opDup:
+			f.push(f.top())
+		case opFunc:
+			child := p.protos[in.a]
+			cl := &closure{p: child, scope: f.scope, upvals: make([]*cell, len(child.upvals))}
+			for i, desc := range child.upvals {
+				if desc.fromLocal {
+					cl.upvals[i] = f.locals[desc.index].(*cell)
+				} else {
+					cl.upvals[i] = f.cl.upvals[desc.index]
+				}
+			}
+			f.push(r.newFunc(cl))
+		case opParent:
+			var cls *Class
+			cls, err = findClass(f.scope, p.names[in.a])
+			f.push(cls)
+		case opClass:
+			var cls *Class
+			cls, err = f.class(p.classes[in.a])
+			f.push(cls)
+		case opNewTable:
+			f.push(&Table{})
+		case opTableAppend:
+			val := f.pop()
+			tbl := f.top().(*Table)
+			if spr, ok := val.(Spread); ok {
+				tbl.add(f.scope, spr.Table)
+			} else {
+				tbl.Arr = append(tbl.Arr, val)
+			}
+		case opTableKey:
+			val := f.pop()
+			key := f.pop()
+			_, err = f.top().(*Table).assign(f.scope, key, val)
+		case opTableInst:
+			var inst Value
+			inst, err = create(f.scope, "Table", f.pop())
+			f.push(inst)
+		case opIndex:
+			key := f.pop()
+			var val Value
+			val, err = index(f.scope, f.pop(), key)
+			f.push(val)
+		case opSetIndex:
+			key := f.pop()
+			source := f.pop()
+			val := f.pop()
+			if source == nil {
+				err = fmt.Errorf("cannot index nil")
+			} else if iface, ok := source.(CVal); ok {
+				_, err = iface.OpAssignIndex(f.scope, key, val)
+			} else {
+				err = fmt.Errorf("cannot assign index %v on %v", toString(f.scope, key), typeOf(source))
+			}
+		case opMethod:
+			key := f.pop()
+			source := f.pop()
+			var fn Value
+			fn, err = index(f.scope, source, key)
+			f.push(source)
+			f.push(fn)
+		case opCall:
+			vals := f.popN(in.a)
+			args := make([]Value, 0, len(vals))
+			for _, val := range vals {
+				if spr, ok := val.(Spread); ok {
+					args = append(args, spr.Table.Arr...)
+				} else {
+					args = append(args, val)
+				}
+			}
+			fn := f.pop()
+			self, _ := f.pop().(CVal)
+			var res Value
+			res, err = callValue(f.scope, fn, self, args)
+			f.push(res)
+		case opBinary:
+			right := f.pop()
+			left, isInst := f.pop().(*Instance)
+			if !isInst {
+				err = fmt.Errorf("left operand is an invalid value")
+			} else if rightInst, isInst := right.(*Instance); !isInst {
+				err = fmt.Errorf("right operand is an invalid value")
+			} else {
+				var res Value
+				res, err = binaryOp(f.scope, p.names[in.a], left, rightInst)
+				f.push(res)
+			}
+		case opUnary:
+			if left, isInst := f.pop().(*Instance); !isInst {
+				err = fmt.Errorf("left operand is an invalid value")
+			} else {
+				var res Value
+				res, err = unaryOp(f.scope, p.names[in.a], left)
+				f.push(res)
+			}
+		case opAnd, opOr:
+			if left, isInst := f.top().(*Instance); !isInst {
+				err = fmt.Errorf("left operand is an invalid value")
+			} else if toBool(f.scope, left) == (in.op == opOr) {
+				f.pc = in.a
+			} else {
+				f.pop()
+			}
+		case opJump:
+			if in.a < f.pc {
+				if err = tick(f.scope); err != nil {
+					break
+				}
+			}
+			f.pc = in.a
+		case opJumpIfFalse:
+			if !toBool(f.scope, f.pop()) {
+				f.pc = in.a
+			}
+		case opSpread:
+			var spr Spread
+			spr, err = toSpread(f.pop())
+			f.push(spr)
+		case opRange:
+			end := f.pop()
+			var rng Range
+			rng, err = toRange(f.pop(), end)
+			f.push(rng)
+		case opAssign:
+			vals := distribute(f.scope, f.popN(in.a), in.b)
+			for i := len(vals) - 1; i >= 0; i-- {
+				f.push(vals[i])
+			}
+		case opAssignBegin:
+			f.push(&assignState{exprs: p.assigns[in.a], targets: in.b})
+		case opAssignPull:
+			st := f.top().(*assignState)
+			last := in.a == st.targets-1
+			if len(st.vals) < len(st.exprs) && (last || len(st.expanded) <= in.a) {
+				st.resume = f.pc - 1
+				f.pc = st.exprs[len(st.vals)]
+			} else if last {
+				f.push(distribute(f.scope, st.vals, st.targets)[in.a])
+			} else if in.a < len(st.expanded) {
+				f.push(st.expanded[in.a])
+			} else {
+				f.push(nil)
+			}
+		case opAssignFeed:
+			val := f.pop()
+			st := f.top().(*assignState)
+			st.vals = append(st.vals, val)
+			if spr, ok := val.(Spread); ok {
+				st.expanded = append(st.expanded, spr.Table.Arr...)
+			} else {
+				st.expanded = append(st.expanded, val)
+			}
+			f.pc = st.resume
+		case opReturn:
+			vals := f.popN(in.a)
+			if err = f.unwind(0); err == nil {
+				return Return{Vals: vals}, nil
+			}
+		case opTry:
+			f.blocks = append(f.blocks, block{kind: blockTry, index: in.a, sp: len(f.stack)})
+		case opProtect:
+			f.blocks = append(f.blocks, block{kind: blockProtect, index: in.a, sp: len(f.stack)})
+		case opEndProtect:
+			f.popBlock()
+			f.push(protect(f.scope, f.pop()))
+		case opPopBlock:
+			err = f.popBlock()
+		case opUnwind:
+			err = f.unwind(in.a)
+		case opScope:
+			f.blocks = append(f.blocks, block{kind: blockScope, parent: f.scope})
+			f.scope = f.scope.Child(map[string]Value{p.names[in.a]: f.pop()})
+		case opSelf:
+			if f.self != nil {
+				f.setLocal(in.b, f.self.Self())
+				f.setLocal(in.b+1, f.self.Super(f.cl.scope, f.self, p.name, f.args))
+				f.pc = in.a
+			}
+		case opIterPrep:
+			var iter iterator
+			if iter, err = iterate(f.scope, f.pop()); err == nil {
+				f.push(iter)
+				f.blocks = append(f.blocks, block{kind: blockIter, iter: iter})
+			}
+		case opIterNext:
+			var vals []Value
+			var ok bool
+			if vals, ok, err = f.top().(iterator).next(in.b); err == nil && !ok {
+				f.pc = in.a
+			} else if ok {
+				f.stack = append(f.stack, vals...)
+			}
+		case opYield:
+			var val Value
+			val, err = f.scope.thread.gen.yield(f.popN(in.a))
+			f.push(val)
+		case opRaise:
+			err = fmt.Errorf(p.names[in.a])
+		case opImport:
+			err = importNames(f.scope, p.imports[in.a])
+		case opStatement:
+			r.reached(f.scope, p.pos[f.pc-1])
+		case opBranch:
+			r.branch(f.scope, p.pos[f.pc-1], in.a)
+		case opSelect:
+			desc := p.selects[in.a]
+			var cases []selectCase
+			if cases, err = selectCases(desc, f.popN(2*len(desc.sends))); err == nil {
+				var chosen int
+				var val Value
+				if chosen, val, err = selectChannels(f.scope, cases, desc.fallback < 0); err == nil {
+					f.push(val)
+					if chosen < 0 {
+						f.pc = desc.fallback
+					} else {
+						f.pc = desc.targets[chosen]
+					}
+				}
+			}
+		case opMatch:
+			binds := []Value{}
+			var ok bool
+			if ok, err = matchPattern(f.scope, p.patterns[in.b], f.top(), &binds); err == nil && !ok {
+				f.pc = in.a
+			} else if ok {
+				f.stack = append(f.stack, binds...)
+			}
+		}
+		if err != nil {
+			if err = r.recover(f, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// selectCases pairs the channels and values that were pushed for a select.
+func selectCases(desc selectDesc, vals []Value) ([]selectCase, error) {
+	cases := make([]selectCase, len(desc.sends))
+	for i, send := range desc.sends {
+		ch, err := toChannel(vals[2*i])
+		if err != nil {
+			return nil, err
+		}
+		cases[i] = selectCase{ch: ch, send: send, val: vals[2*i+1]}
+	}
+	return cases, nil
+}
+
+func index(scope *Scope, source, key Value) (Value, error) {
+	if source == nil {
+		return nil, fmt.Errorf("cannot index nil")
+	} else if iface, ok := source.(CVal); ok {
+		return iface.OpIndex(scope, key)
+	}
+	return nil, fmt.Errorf("cannot index %v on %v", toString(scope, key), typeOf(source))
+}
+
+// class pops the parent, attribute values and refinements of a class definition
+// off of the stack and creates the class.
+func (f *frame) class(desc classDesc) (*Class, error) {
+	attrs := make([]*Attribute, len(desc.attrs))
+	for i := len(desc.attrs) - 1; i >= 0; i-- {
+		attr := desc.attrs[i]
+		if attr.method {
+			attrs[i] = Attr(attr.name, f.pop(), &Refinement{constant: true})
+			continue
+		}
+		cond := f.pop()
+		val := f.pop()
+		var refine *Refinement
+		if inst, ok := cond.(*Instance); ok && inst.IsA("Table") {
+			var err error
+			if refine, err = tableRefinement(f.scope, inst.field("_tbl").(*Table)); err != nil {
+				return nil, err
+			}
+		}
+		attrs[i] = Attr(attr.name, val, refine)
+	}
+	parent, _ := f.pop().(*Class)
+	return CreateClass(desc.name, parent, attrs...), nil
+}
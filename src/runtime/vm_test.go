@@ -0,0 +1,116 @@
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/lang"
+)
+
+const engineSrc = `
+func pair()
+  return 1, 2
+end
+x, y = pair()
+a, b = 1, 2, 3
+print(x, y, a, b)
+
+func counter()
+  n = 0
+  return func()
+    n += 1
+    return n
+  end
+end
+c = counter()
+c()
+print(c())
+
+for i = 0, i < 5, i++ do
+  if i == 1 then next end
+  if i == 3 then break end
+  print("loop", i)
+end
+
+do
+  spill(ArgumentError, "boom")
+cleanup err = ArgumentError do
+  print("caught", err)
+end
+
+func fail()
+  spill("bad")
+end
+print(@fail())
+t = {3, 4}
+print(t...)
+`
+
+func writeSrc(t testing.TB, src string) string {
+	file, err := ioutil.TempFile("", "*.sqrt")
+	assert.Nil(t, err)
+	defer file.Close()
+	_, err = file.WriteString(src)
+	assert.Nil(t, err)
+	return file.Name()
+}
+
+func runEngine(t testing.TB, engine Engine, path string) string {
+	defer func(e Engine) { DefaultEngine = e }(DefaultEngine)
+	DefaultEngine = engine
+	var out strings.Builder
+	_, err := EvalFile(DefaultNamespace(&out), path)
+	assert.Nil(t, err)
+	return out.String()
+}
+
+func TestEngines(t *testing.T) {
+	path := writeSrc(t, engineSrc)
+	defer os.Remove(path)
+	expected := "1 2 1 {2, 3}\n2\nloop 0\nloop 2\ncaught ArgumentError: boom\nRuntimeError: bad\n3 4\n"
+	assert.Equal(t, expected, runEngine(t, EngineVM, path))
+	assert.Equal(t, expected, runEngine(t, EngineTree, path))
+}
+
+const fibSrc = `
+func fib(n)
+  if n < 2 then
+    return n
+  end
+  return fib(n-1) + fib(n-2)
+end
+fib(15)
+`
+
+const loopSrc = `
+total = 0
+for i = 0, i < 20000, i++ do
+  if i % 3 == 0 then next end
+  total = total + i * 2
+end
+`
+
+// benchmarkEngine parses src and creates the interpreter once so that only
+// running it is timed.
+func benchmarkEngine(b *testing.B, engine Engine, src string) {
+	ast, err := lang.ParseStr(src)
+	assert.Nil(b, err)
+	scope := NewInterpreter(Options{Engine: engine, Stdout: ioutil.Discard}).Scope()
+	r := Runtime{filepath: "<bench>"}
+	assert.Nil(b, r.pushStack(scope, "<main>", 0))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.runAST(scope, ast); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVMFib(b *testing.B)    { benchmarkEngine(b, EngineVM, fibSrc) }
+func BenchmarkTreeFib(b *testing.B)  { benchmarkEngine(b, EngineTree, fibSrc) }
+func BenchmarkVMLoop(b *testing.B)   { benchmarkEngine(b, EngineVM, loopSrc) }
+func BenchmarkTreeLoop(b *testing.B) { benchmarkEngine(b, EngineTree, loopSrc) }
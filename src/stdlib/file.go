@@ -0,0 +1,350 @@
+package stdlib
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// fileLib is the file lib of one interpreter along with the classes that
+	// it creates.
+	fileLib struct {
+		class *runtime.Class
+		ioErr *runtime.Class
+	}
+
+	// openFile is what a File instance keeps. Reads are buffered so in is
+	// dropped, after moving the file back to what was read, before a write or
+	// a seek.
+	openFile struct {
+		mu   sync.Mutex
+		f    *os.File
+		path string
+		in   *bufio.Reader
+	}
+)
+
+// fileModes are the modes of open, they work like the modes of fopen.
+var fileModes = map[string]int{
+	"r":  os.O_RDONLY,
+	"w":  os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"a":  os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	"r+": os.O_RDWR,
+	"w+": os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	"a+": os.O_RDWR | os.O_CREATE | os.O_APPEND,
+}
+
+var seekWhence = map[string]int{"set": io.SeekStart, "cur": io.SeekCurrent, "end": io.SeekEnd}
+
+// FileLib is the file lib, it opens files as instances of its File class and
+// has helpers for whole files and directories. Paths need the fs.read or
+// fs.write capability and errors from the os raise its IOError class.
+func FileLib(scope *runtime.Scope) (runtime.Value, error) {
+	lib := &fileLib{ioErr: ioErrorClass(scope)}
+	lib.class = runtime.CreateClass("File", nil,
+		runtime.FnAttr("read", lib.read),
+		runtime.FnAttr("lines", lib.lines),
+		runtime.FnAttr("write", lib.write),
+		runtime.FnAttr("seek", lib.seek),
+		runtime.FnAttr("close", lib.close),
+		runtime.FnAttr("tostring", func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+			if f, ok := self.(*runtime.Instance).Native().(*openFile); ok {
+				return "#<File " + f.path + ">", nil
+			}
+			return "#<File>", nil
+		}),
+	)
+	return runtime.ToValue(scope, map[string]runtime.Value{
+		"open":     runtime.Fn("open", lib.open),
+		"exists":   runtime.Fn("exists", lib.exists),
+		"readAll":  runtime.Fn("readAll", lib.readAll),
+		"writeAll": runtime.Fn("writeAll", lib.writeAll),
+		"list":     runtime.Fn("list", lib.list),
+		"mkdir":    runtime.Fn("mkdir", lib.mkdir),
+		"remove":   runtime.Fn("remove", lib.remove),
+		"File":     lib.class,
+		"IOError":  lib.ioErr,
+	})
+}
+
+// open opens a file with a mode, r if it is left out.
+func (lib *fileLib) open(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := pathArg(s, "open", args)
+	if err != nil {
+		return nil, err
+	}
+	mode := "r"
+	if len(args) > 1 {
+		mode = runtime.Print(s, args[1])
+	}
+	flag, ok := fileModes[mode]
+	if !ok {
+		return nil, argErr(s, "unknown file mode "+mode)
+	}
+	if mode != "w" && mode != "a" {
+		if err := s.Permit("fs.read:" + path); err != nil {
+			return nil, err
+		}
+	}
+	if mode != "r" {
+		if err := s.Permit("fs.write:" + path); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	inst, err := lib.class.New(s)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	inst.SetNative(&openFile{f: f, path: path})
+	return inst, nil
+}
+
+// read reads a number of bytes or the rest of the file, nil at the end of the
+// file.
+func (lib *fileLib) read(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	f, err := lib.file(s, self)
+	if err != nil {
+		return nil, err
+	}
+	n, err := byteCount(s, args)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	val, err := read(f.reader(), n)
+	if err != nil {
+		return nil, newIOError(s, lib.ioErr, f.path, err)
+	}
+	return val, nil
+}
+
+// lines steps through the rest of the file a line at a time in a for-in loop.
+func (lib *fileLib) lines(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	f, err := lib.file(s, self)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.Iterator(func() ([]runtime.Value, bool, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		line, err := readLine(f.reader())
+		if err != nil {
+			return nil, false, newIOError(s, lib.ioErr, f.path, err)
+		} else if line == nil {
+			return nil, false, nil
+		}
+		val, err := runtime.ToValue(s, line)
+		return []runtime.Value{val}, true, err
+	}), nil
+}
+
+// write writes each of its arguments and results in how many bytes were
+// written.
+func (lib *fileLib) write(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	f, err := lib.file(s, self)
+	if err != nil {
+		return nil, err
+	}
+	var out strings.Builder
+	for _, arg := range args {
+		out.WriteString(runtime.Print(s, arg))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.unread(); err != nil {
+		return nil, newIOError(s, lib.ioErr, f.path, err)
+	}
+	n, err := f.f.WriteString(out.String())
+	if err != nil {
+		return nil, newIOError(s, lib.ioErr, f.path, err)
+	}
+	return n, nil
+}
+
+// seek moves to an offset from the start, or from cur or end, and results in
+// the new offset from the start.
+func (lib *fileLib) seek(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	f, err := lib.file(s, self)
+	if err != nil {
+		return nil, err
+	}
+	var offset int64
+	if len(args) == 0 {
+		return nil, argErr(s, "not enough arguments to seek")
+	} else if err := runtime.FromValue(args[0], &offset); err != nil {
+		return nil, argErr(s, "seek needs a number offset")
+	}
+	whence := io.SeekStart
+	if len(args) > 1 {
+		var ok bool
+		if whence, ok = seekWhence[runtime.Print(s, args[1])]; !ok {
+			return nil, argErr(s, "seek is from set, cur or end")
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.unread(); err != nil {
+		return nil, newIOError(s, lib.ioErr, f.path, err)
+	}
+	pos, err := f.f.Seek(offset, whence)
+	if err != nil {
+		return nil, newIOError(s, lib.ioErr, f.path, err)
+	}
+	return pos, nil
+}
+
+func (lib *fileLib) close(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	f, err := lib.file(s, self)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.in = nil
+	if err := f.f.Close(); err != nil {
+		return nil, newIOError(s, lib.ioErr, f.path, err)
+	}
+	return nil, nil
+}
+
+// file is the open file of a File instance, only open makes one.
+func (lib *fileLib) file(s *runtime.Scope, self runtime.CVal) (*openFile, error) {
+	if inst, ok := self.(*runtime.Instance); ok {
+		if f, ok := inst.Native().(*openFile); ok {
+			return f, nil
+		}
+	}
+	return nil, argErr(s, "File was not opened with file.open")
+}
+
+func (f *openFile) reader() *bufio.Reader {
+	if f.in == nil {
+		f.in = bufio.NewReader(f.f)
+	}
+	return f.in
+}
+
+// unread moves the file back to the end of what has been read, the reader
+// has read further ahead than that.
+func (f *openFile) unread() error {
+	if f.in == nil {
+		return nil
+	}
+	buffered := f.in.Buffered()
+	f.in = nil
+	if buffered > 0 {
+		_, err := f.f.Seek(int64(-buffered), io.SeekCurrent)
+		return err
+	}
+	return nil
+}
+
+func (lib *fileLib) exists(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := lib.permitPath(s, "exists", "fs.read", args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	return true, nil
+}
+
+func (lib *fileLib) readAll(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := lib.permitPath(s, "readAll", "fs.read", args)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	return string(data), nil
+}
+
+func (lib *fileLib) writeAll(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := lib.permitPath(s, "writeAll", "fs.write", args)
+	if err != nil {
+		return nil, err
+	} else if len(args) < 2 {
+		return nil, argErr(s, "not enough arguments to writeAll")
+	}
+	if err := ioutil.WriteFile(path, []byte(runtime.Print(s, args[1])), 0644); err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	return nil, nil
+}
+
+// list lists the names in a directory sorted by name.
+func (lib *fileLib) list(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := lib.permitPath(s, "list", "fs.read", args)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	names := make([]runtime.Value, len(infos))
+	for i, info := range infos {
+		if names[i], err = runtime.ToValue(s, info.Name()); err != nil {
+			return nil, err
+		}
+	}
+	return runtime.ToValue(s, names)
+}
+
+// mkdir makes a directory along with any parents that it needs.
+func (lib *fileLib) mkdir(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := lib.permitPath(s, "mkdir", "fs.write", args)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	return nil, nil
+}
+
+// remove removes a file or an empty directory.
+func (lib *fileLib) remove(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	path, err := lib.permitPath(s, "remove", "fs.write", args)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, newIOError(s, lib.ioErr, path, err)
+	}
+	return nil, nil
+}
+
+func (lib *fileLib) permitPath(s *runtime.Scope, fnName, capability string, args []runtime.Value) (string, error) {
+	path, err := pathArg(s, fnName, args)
+	if err != nil {
+		return "", err
+	}
+	return path, s.Permit(capability + ":" + path)
+}
+
+func pathArg(s *runtime.Scope, fnName string, args []runtime.Value) (string, error) {
+	if len(args) == 0 {
+		return "", argErr(s, "not enough arguments to "+fnName)
+	} else if inst, ok := args[0].(*runtime.Instance); !ok || !inst.IsA("String") {
+		return "", argErr(s, fnName+" needs a string path")
+	}
+	return runtime.Print(s, args[0]), nil
+}
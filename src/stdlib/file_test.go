@@ -0,0 +1,101 @@
+package stdlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+const fileSrc = `file = require("file")
+dir = "${ROOT}/out/sub"
+file.mkdir(dir)
+file.writeAll("${dir}/a.txt", "one\ntwo\nthree\n")
+print(file.exists("${dir}/a.txt"), file.exists("${dir}/nope"), file.list(dir))
+f = file.open("${dir}/a.txt", "r+")
+print(f.read(3))
+for line in f.lines() do
+  print("line ${line}")
+end
+print(f.seek(4), f.read(3))
+f.write("!")
+f.seek(0, "end")
+print(f.write("four", 4, "\n"))
+f.close()
+print(file.readAll("${dir}/a.txt"))
+do
+  f.read()
+cleanup e = IOError do
+  print(e.cause)
+end
+do
+  file.open("${ROOT}/missing")
+cleanup e = IOError do
+  print(e.path == "${ROOT}/missing", e.cause)
+end
+file.remove("${dir}/a.txt")
+print(file.list(dir))
+`
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squirt-file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	main := filepath.Join(dir, "main.sqrt")
+	assert.Nil(t, ioutil.WriteFile(main, []byte(fileSrc), 0644))
+
+	for _, engine := range []runtime.Engine{runtime.EngineVM, runtime.EngineTree} {
+		var out strings.Builder
+		interp := runtime.NewInterpreter(runtime.Options{Engine: engine, Stdout: &out})
+		interp.RegisterLib("file", FileLib)
+		interp.Scope().Set("ROOT", dir)
+		_, err := interp.EvalFile(main)
+		assert.Nil(t, err)
+		assert.Equal(t, `true false {a.txt}
+one
+line 
+line two
+line three
+4 two
+6
+one
+two!three
+four4
+
+file already closed
+true no such file or directory
+{}
+`, out.String())
+	}
+}
+
+func TestFileSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squirt-file")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data.txt"), []byte("data"), 0644))
+
+	interp := runtime.NewInterpreter(runtime.Options{Sandboxed: true, Grants: []string{"fs.read:" + dir}})
+	interp.RegisterLib("file", FileLib, "fs")
+	interp.Scope().Set("ROOT", dir)
+	_, err = interp.Eval(`file = require("file")`)
+	assert.Nil(t, err)
+	val, err := interp.Eval(`file.readAll("${ROOT}/data.txt")`)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", runtime.Print(interp.Scope(), val))
+	for _, src := range []string{
+		`file.writeAll("${ROOT}/data.txt", "x")`,
+		`file.open("${ROOT}/data.txt", "a")`,
+		`file.readAll("/etc/hosts")`,
+	} {
+		_, err := interp.Eval(src)
+		if rerr, ok := err.(runtime.RuntimeErr); assert.True(t, ok, "%v: %v", src, err) {
+			assert.Equal(t, "PermissionError", rerr.Class(), src)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package stdlib
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+// IOLib is the io lib, it reads from the stdin of the interpreter with
+// readline and read and writes to its stdout with write. Errors raise the
+// IOError class that it has.
+func IOLib(scope *runtime.Scope) (runtime.Value, error) {
+	ioErr := ioErrorClass(scope)
+	var mu sync.Mutex
+	in := bufio.NewReader(scope.Interpreter().Stdin())
+	return runtime.ToValue(scope, map[string]runtime.Value{
+		"readline": runtime.Fn("readline", func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			line, err := readLine(in)
+			if err != nil {
+				return nil, newIOError(s, ioErr, "stdin", err)
+			}
+			return line, nil
+		}),
+		"read": runtime.Fn("read", func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+			n, err := byteCount(s, args)
+			if err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			str, err := read(in, n)
+			if err != nil {
+				return nil, newIOError(s, ioErr, "stdin", err)
+			}
+			return str, nil
+		}),
+		"write": runtime.Fn("write", func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+			var out strings.Builder
+			for _, arg := range args {
+				out.WriteString(runtime.Print(s, arg))
+			}
+			if _, err := io.WriteString(s, out.String()); err != nil {
+				return nil, newIOError(s, ioErr, "stdout", err)
+			}
+			return nil, nil
+		}),
+		"IOError": ioErr,
+	})
+}
+
+// ioErrorClass is the class of the errors the io and file libs raise, path is
+// the file that failed and cause is what went wrong with it. Both libs get the
+// same class from an interpreter.
+func ioErrorClass(scope *runtime.Scope) *runtime.Class {
+	return scope.Interpreter().SharedClass("IOError", func() *runtime.Class {
+		return runtime.CreateClass("IOError", runtime.ErrorClass,
+			runtime.Attr("path", nil, nil),
+			runtime.Attr("cause", nil, nil),
+		)
+	})
+}
+
+func newIOError(s *runtime.Scope, class *runtime.Class, path string, err error) error {
+	cause := err.Error()
+	switch oserr := err.(type) {
+	case *os.PathError:
+		path, cause = oserr.Path, oserr.Err.Error()
+	case *os.LinkError:
+		path, cause = oserr.Old, oserr.Err.Error()
+	}
+	inst, cerr := class.New(s, err.Error())
+	if cerr != nil {
+		return cerr
+	}
+	for name, val := range map[string]string{"path": path, "cause": cause} {
+		str, _ := runtime.ToValue(s, val)
+		if _, cerr := inst.OpAssignIndex(s, name, str); cerr != nil {
+			return cerr
+		}
+	}
+	return inst
+}
+
+// readLine reads a line without its line ending, nil at the end of the input.
+func readLine(in *bufio.Reader) (runtime.Value, error) {
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, nil
+	} else if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// byteCount is how many bytes read was asked for, -1 for everything.
+func byteCount(s *runtime.Scope, args []runtime.Value) (int, error) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	var n int
+	if err := runtime.FromValue(args[0], &n); err != nil || n < 0 {
+		return 0, argErr(s, "read needs a number of bytes")
+	}
+	return n, nil
+}
+
+// read reads n bytes, or everything that is left if n is -1, and results in
+// nil at the end of the input.
+func read(in io.Reader, n int) (runtime.Value, error) {
+	if n < 0 {
+		data, err := ioutil.ReadAll(in)
+		return string(data), err
+	}
+	buf := make([]byte, n)
+	read, err := io.ReadFull(in, buf)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return string(buf[:read]), nil
+}
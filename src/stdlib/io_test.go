@@ -0,0 +1,57 @@
+package stdlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+func TestIO(t *testing.T) {
+	for _, engine := range []runtime.Engine{runtime.EngineVM, runtime.EngineTree} {
+		var out strings.Builder
+		interp := runtime.NewInterpreter(runtime.Options{
+			Engine: engine,
+			Stdout: &out,
+			Stdin:  strings.NewReader("squirt\r\nab\ncdef"),
+		})
+		interp.RegisterLib("io", IOLib)
+		for _, src := range []string{
+			`io = require("io")`,
+			`io.write("hello ", io.readline(), "\n")`,
+			`io.write(io.read(1), "|", io.read(), "|", typeof(io.read(1)), "|", typeof(io.readline()))`,
+		} {
+			_, err := interp.Eval(src)
+			assert.Nil(t, err, src)
+		}
+		assert.Equal(t, "hello squirt\na|b\ncdef|nil|nil", out.String())
+
+		_, err := interp.Eval(`io.read(0 - 1)`)
+		if rerr, ok := err.(runtime.RuntimeErr); assert.True(t, ok) {
+			assert.Equal(t, "ArgumentError", rerr.Class())
+		}
+	}
+}
+
+func TestIOErrorShared(t *testing.T) {
+	interp := runtime.NewInterpreter(runtime.Options{})
+	interp.RegisterLib("io", IOLib)
+	interp.RegisterLib("file", FileLib)
+	for _, src := range []string{`io = require("io")`, `file = require("file")`} {
+		_, err := interp.Eval(src)
+		assert.Nil(t, err, src)
+	}
+	class, err := interp.Eval(`io.IOError`)
+	assert.Nil(t, err)
+	val, err := interp.Eval(`do
+  file.readAll("/squirt/no/such/file")
+cleanup e = IOError do
+  return e
+end`)
+	assert.Nil(t, err)
+	if inst, ok := val.(*runtime.Instance); assert.True(t, ok) {
+		assert.Same(t, class, inst.Class())
+	}
+}
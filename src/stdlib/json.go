@@ -0,0 +1,476 @@
+package stdlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// jsonEncoder writes values as json, indent is empty for compact output.
+	jsonEncoder struct {
+		buf    bytes.Buffer
+		indent string
+		seen   map[*runtime.Table]bool
+		fail   jsonFail
+	}
+
+	// jsonDecoder reads json from src keeping the order of object keys.
+	jsonDecoder struct {
+		src  string
+		pos  int
+		fail jsonFail
+	}
+
+	jsonFail func(s *runtime.Scope, pos int, format string, args ...interface{}) error
+)
+
+// JSONLib is the json lib, it has encode, decode and the JSONError class they
+// raise. A JSONError from decode has the line and column of the bad input.
+func JSONLib(scope *runtime.Scope) (runtime.Value, error) {
+	jsonErr := runtime.CreateClass("JSONError", runtime.ErrorClass,
+		runtime.Attr("line", nil, nil),
+		runtime.Attr("column", nil, nil),
+	)
+	return runtime.ToValue(scope, map[string]runtime.Value{
+		"encode":    runtime.Fn("encode", jsonEncode(jsonErr)),
+		"decode":    runtime.Fn("decode", jsonDecode(jsonErr)),
+		"JSONError": jsonErr,
+	})
+}
+
+// jsonError creates a JSONError, src is the decoded input to find the line
+// and column of pos in or nil when encoding.
+func jsonError(class *runtime.Class, src *string) jsonFail {
+	return func(s *runtime.Scope, pos int, format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		line, column := 0, 0
+		if src != nil {
+			before := (*src)[:pos]
+			line = strings.Count(before, "\n") + 1
+			column = utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+			msg = fmt.Sprintf("%v at line %v, column %v", msg, line, column)
+		}
+		inst, err := class.New(s, msg)
+		if err != nil {
+			return err
+		}
+		if src != nil {
+			for name, val := range map[string]int{"line": line, "column": column} {
+				num, _ := runtime.ToValue(s, val)
+				if _, err := inst.OpAssignIndex(s, name, num); err != nil {
+					return err
+				}
+			}
+		}
+		return inst
+	}
+}
+
+func jsonEncode(class *runtime.Class) runtime.FnSig {
+	return func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+		if len(args) == 0 {
+			return nil, argErr(s, "not enough arguments to encode")
+		}
+		enc := &jsonEncoder{seen: map[*runtime.Table]bool{}, fail: jsonError(class, nil)}
+		if len(args) > 1 {
+			opts, ok := args[1].(*runtime.Instance)
+			if !ok || !opts.IsA("Table") {
+				return nil, argErr(s, "encode options must be a table")
+			}
+			key, _ := runtime.ToValue(s, "indent")
+			indent, err := opts.OpIndex(s, key)
+			if err != nil {
+				return nil, err
+			} else if inst, ok := indent.(*runtime.Instance); ok && inst.IsA("Number") {
+				var n int
+				if err := runtime.FromValue(inst, &n); err != nil {
+					return nil, err
+				}
+				enc.indent = strings.Repeat(" ", n)
+			} else if ok && inst.IsA("String") {
+				enc.indent = runtime.Print(s, inst)
+			}
+		}
+		if err := enc.encode(s, args[0], 0); err != nil {
+			return nil, err
+		}
+		return enc.buf.String(), nil
+	}
+}
+
+func (enc *jsonEncoder) encode(s *runtime.Scope, val runtime.Value, depth int) error {
+	// resolves the attributes the tree walker leaves unevaluated in tables
+	if err := runtime.FromValue(val, &val); err != nil {
+		return err
+	}
+	inst, ok := val.(*runtime.Instance)
+	if val == nil || (ok && inst.IsA("Nil")) {
+		enc.buf.WriteString("null")
+		return nil
+	} else if !ok {
+		return enc.fail(s, 0, "cannot encode %v as json", typeName(val))
+	}
+	switch inst.Type() {
+	case "Boolean", "Number", "String":
+		var prim interface{}
+		if err := runtime.FromValue(inst, &prim); err != nil {
+			return err
+		}
+		return enc.literal(s, prim)
+	case "Table":
+		tbl, _ := inst.Table()
+		if enc.seen[tbl] {
+			return enc.fail(s, 0, "cannot encode a table that contains itself")
+		}
+		enc.seen[tbl] = true
+		defer delete(enc.seen, tbl)
+		arr, keys, vals := tbl.Entries()
+		if len(keys) == 0 {
+			return enc.collection(s, '[', ']', len(arr), depth, func(i int) error {
+				return enc.encode(s, arr[i], depth+1)
+			})
+		}
+		// array values come before the keys of a table that has both
+		return enc.collection(s, '{', '}', len(arr)+len(keys), depth, func(i int) error {
+			if i < len(arr) {
+				return enc.member(s, strconv.Itoa(i), arr[i], depth)
+			}
+			i -= len(arr)
+			return enc.member(s, runtime.Print(s, keys[i]), vals[i], depth)
+		})
+	}
+	if inst.RespondsTo(s, "tojson") {
+		if depth > 100 {
+			return enc.fail(s, 0, "tojson of %v nests too deep", inst.Type())
+		}
+		encoded, err := inst.Op("tojson", s)
+		if err != nil {
+			return err
+		}
+		return enc.encode(s, encoded, depth+1)
+	}
+	// an instance without tojson is encoded by its public attributes
+	names, vals := runtime.Fields(s, inst)
+	order := make([]int, len(names))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+	return enc.collection(s, '{', '}', len(order), depth, func(i int) error {
+		return enc.member(s, names[order[i]], vals[order[i]], depth)
+	})
+}
+
+// collection writes the n items of an array or object, each on its own line
+// when indenting.
+func (enc *jsonEncoder) collection(s *runtime.Scope, open, close byte, n, depth int, item func(int) error) error {
+	enc.buf.WriteByte(open)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			enc.buf.WriteByte(',')
+		}
+		enc.newline(depth + 1)
+		if err := item(i); err != nil {
+			return err
+		}
+	}
+	if n > 0 {
+		enc.newline(depth)
+	}
+	enc.buf.WriteByte(close)
+	return nil
+}
+
+func (enc *jsonEncoder) member(s *runtime.Scope, key string, val runtime.Value, depth int) error {
+	if err := enc.literal(s, key); err != nil {
+		return err
+	}
+	enc.buf.WriteByte(':')
+	if enc.indent != "" {
+		enc.buf.WriteByte(' ')
+	}
+	return enc.encode(s, val, depth+1)
+}
+
+func (enc *jsonEncoder) newline(depth int) {
+	if enc.indent != "" {
+		enc.buf.WriteByte('\n')
+		enc.buf.WriteString(strings.Repeat(enc.indent, depth))
+	}
+}
+
+// literal writes a string, number or bool the way encoding/json does, without
+// escaping html.
+func (enc *jsonEncoder) literal(s *runtime.Scope, val interface{}) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(val); err != nil {
+		return enc.fail(s, 0, "cannot encode %v as json", val)
+	}
+	enc.buf.Write(bytes.TrimRight(buf.Bytes(), "\n"))
+	return nil
+}
+
+func typeName(val runtime.Value) string {
+	if cval, ok := val.(runtime.CVal); ok {
+		return cval.Type()
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+func jsonDecode(class *runtime.Class) runtime.FnSig {
+	return func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+		if len(args) == 0 {
+			return nil, argErr(s, "not enough arguments to decode")
+		} else if inst, ok := args[0].(*runtime.Instance); !ok || !inst.IsA("String") {
+			return nil, argErr(s, "decode needs a string")
+		}
+		src := runtime.Print(s, args[0])
+		dec := &jsonDecoder{src: src, fail: jsonError(class, &src)}
+		val, err := dec.value(s)
+		if err != nil {
+			return nil, err
+		}
+		dec.skipSpace()
+		if dec.pos < len(dec.src) {
+			return nil, dec.unexpected(s, "end of input")
+		}
+		return val, nil
+	}
+}
+
+func (dec *jsonDecoder) value(s *runtime.Scope) (runtime.Value, error) {
+	dec.skipSpace()
+	if dec.pos >= len(dec.src) {
+		return nil, dec.unexpected(s, "a value")
+	}
+	switch c := dec.src[dec.pos]; {
+	case c == '{':
+		return dec.object(s)
+	case c == '[':
+		return dec.array(s)
+	case c == '"':
+		str, err := dec.str(s)
+		if err != nil {
+			return nil, err
+		}
+		return runtime.ToValue(s, str)
+	case c == '-' || (c >= '0' && c <= '9'):
+		return dec.number(s)
+	case strings.HasPrefix(dec.src[dec.pos:], "true"):
+		dec.pos += 4
+		return runtime.ToValue(s, true)
+	case strings.HasPrefix(dec.src[dec.pos:], "false"):
+		dec.pos += 5
+		return runtime.ToValue(s, false)
+	case strings.HasPrefix(dec.src[dec.pos:], "null"):
+		dec.pos += 4
+		return runtime.ToValue(s, nil)
+	}
+	return nil, dec.unexpected(s, "a value")
+}
+
+func (dec *jsonDecoder) object(s *runtime.Scope) (runtime.Value, error) {
+	dec.pos++
+	tbl := &runtime.Table{}
+	keys := map[string]int{}
+	dec.skipSpace()
+	if dec.peek() == '}' {
+		dec.pos++
+		return runtime.ToValue(s, tbl)
+	}
+	for {
+		dec.skipSpace()
+		if dec.peek() != '"' {
+			return nil, dec.unexpected(s, "a string key")
+		}
+		key, err := dec.str(s)
+		if err != nil {
+			return nil, err
+		}
+		dec.skipSpace()
+		if dec.peek() != ':' {
+			return nil, dec.unexpected(s, ":")
+		}
+		dec.pos++
+		val, err := dec.value(s)
+		if err != nil {
+			return nil, err
+		}
+		// the last of a repeated key wins like in encoding/json
+		if i, ok := keys[key]; ok {
+			tbl.Values[i] = val
+		} else {
+			keyVal, _ := runtime.ToValue(s, key)
+			keys[key] = len(tbl.Keys)
+			tbl.Keys, tbl.Values = append(tbl.Keys, keyVal), append(tbl.Values, val)
+		}
+		dec.skipSpace()
+		if dec.peek() == '}' {
+			dec.pos++
+			return runtime.ToValue(s, tbl)
+		} else if dec.peek() != ',' {
+			return nil, dec.unexpected(s, ", or }")
+		}
+		dec.pos++
+	}
+}
+
+func (dec *jsonDecoder) array(s *runtime.Scope) (runtime.Value, error) {
+	dec.pos++
+	tbl := &runtime.Table{}
+	dec.skipSpace()
+	if dec.peek() == ']' {
+		dec.pos++
+		return runtime.ToValue(s, tbl)
+	}
+	for {
+		val, err := dec.value(s)
+		if err != nil {
+			return nil, err
+		}
+		tbl.Arr = append(tbl.Arr, val)
+		dec.skipSpace()
+		if dec.peek() == ']' {
+			dec.pos++
+			return runtime.ToValue(s, tbl)
+		} else if dec.peek() != ',' {
+			return nil, dec.unexpected(s, ", or ]")
+		}
+		dec.pos++
+	}
+}
+
+func (dec *jsonDecoder) str(s *runtime.Scope) (string, error) {
+	dec.pos++
+	var out strings.Builder
+	for dec.pos < len(dec.src) {
+		c := dec.src[dec.pos]
+		switch {
+		case c == '"':
+			dec.pos++
+			return out.String(), nil
+		case c < 0x20:
+			return "", dec.fail(s, dec.pos, "control character in string")
+		case c != '\\':
+			out.WriteByte(c)
+			dec.pos++
+			continue
+		}
+		if dec.pos+1 >= len(dec.src) {
+			break
+		}
+		switch esc := dec.src[dec.pos+1]; esc {
+		case '"', '\\', '/':
+			out.WriteByte(esc)
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'u':
+			r, ok := dec.hex(dec.pos + 2)
+			if !ok {
+				return "", dec.fail(s, dec.pos, "invalid unicode escape")
+			}
+			if utf16.IsSurrogate(r) {
+				if low, ok := dec.hex(dec.pos + 8); ok && dec.src[dec.pos+6:dec.pos+8] == `\u` {
+					if pair := utf16.DecodeRune(r, low); pair != utf8.RuneError {
+						r = pair
+						dec.pos += 6
+					}
+				}
+			}
+			out.WriteRune(r)
+			dec.pos += 4
+		default:
+			return "", dec.fail(s, dec.pos, "invalid escape \\%c", esc)
+		}
+		dec.pos += 2
+	}
+	return "", dec.fail(s, dec.pos, "unterminated string")
+}
+
+// hex reads the four hex digits of a unicode escape at pos.
+func (dec *jsonDecoder) hex(pos int) (rune, bool) {
+	if pos+4 > len(dec.src) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(dec.src[pos:pos+4], 16, 32)
+	return rune(n), err == nil
+}
+
+func (dec *jsonDecoder) number(s *runtime.Scope) (runtime.Value, error) {
+	start := dec.pos
+	digits := func() int {
+		n := 0
+		for dec.pos < len(dec.src) && dec.src[dec.pos] >= '0' && dec.src[dec.pos] <= '9' {
+			dec.pos++
+			n++
+		}
+		return n
+	}
+	if dec.peek() == '-' {
+		dec.pos++
+	}
+	if dec.peek() == '0' {
+		dec.pos++
+	} else if digits() == 0 {
+		return nil, dec.unexpected(s, "a digit")
+	}
+	if dec.peek() == '.' {
+		dec.pos++
+		if digits() == 0 {
+			return nil, dec.unexpected(s, "a digit")
+		}
+	}
+	if c := dec.peek(); c == 'e' || c == 'E' {
+		dec.pos++
+		if c := dec.peek(); c == '+' || c == '-' {
+			dec.pos++
+		}
+		if digits() == 0 {
+			return nil, dec.unexpected(s, "a digit")
+		}
+	}
+	num, err := strconv.ParseFloat(dec.src[start:dec.pos], 64)
+	if err != nil {
+		return nil, dec.fail(s, start, "number %v is out of range", dec.src[start:dec.pos])
+	}
+	return runtime.ToValue(s, num)
+}
+
+func (dec *jsonDecoder) skipSpace() {
+	for dec.pos < len(dec.src) && strings.IndexByte(" \t\r\n", dec.src[dec.pos]) >= 0 {
+		dec.pos++
+	}
+}
+
+func (dec *jsonDecoder) peek() byte {
+	if dec.pos < len(dec.src) {
+		return dec.src[dec.pos]
+	}
+	return 0
+}
+
+func (dec *jsonDecoder) unexpected(s *runtime.Scope, expected string) error {
+	if dec.pos >= len(dec.src) {
+		return dec.fail(s, dec.pos, "expected %v but found the end of input", expected)
+	}
+	r, _ := utf8.DecodeRuneInString(dec.src[dec.pos:])
+	return dec.fail(s, dec.pos, "expected %v but found %q", expected, r)
+}
@@ -0,0 +1,110 @@
+package stdlib
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+const jsonSrc = `json = require("json")
+class Point do
+  attr x = 1
+  attr y = 2
+  func tojson()
+    return {self.x, self.y}
+  end
+end
+class Plain do
+  attr b = 2
+  attr a = "<&>"
+end
+print(json.encode({1, "two", true, nil, {a: 1.5, b: {}}, new(Point), new(Plain)}))
+print(json.encode({name: "squirt", tags: {"a", "b"}}, {indent: 2}))
+v = json.decode(` + "`" + `{"b": [1, 2.5e2, "xé😀\n"], "a": {"z": null, "y": false}, "b": 3}` + "`" + `)
+print(v.b, v.a.y, json.encode(v))
+print(json.encode(json.decode(` + "`" + `[0, -1.5, "\"quoted\"", {}]` + "`" + `)))
+`
+
+func TestJSON(t *testing.T) {
+	for _, engine := range []runtime.Engine{runtime.EngineVM, runtime.EngineTree} {
+		f, err := ioutil.TempFile("", "squirt*.sqrt")
+		assert.Nil(t, err)
+		defer os.Remove(f.Name())
+		f.WriteString(jsonSrc)
+		f.Close()
+
+		var out strings.Builder
+		interp := runtime.NewInterpreter(runtime.Options{Engine: engine, Stdout: &out})
+		interp.RegisterLib("json", JSONLib)
+		_, err = interp.EvalFile(f.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, `[1,"two",true,null,{"a":1.5,"b":[]},[1,2],{"a":"<&>","b":2}]
+{
+  "name": "squirt",
+  "tags": [
+    "a",
+    "b"
+  ]
+}
+3 false {"b":3,"a":{"z":null,"y":false}}
+[0,-1.5,"\"quoted\"",[]]
+`, out.String())
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	interp := runtime.NewInterpreter(runtime.Options{Stdout: ioutil.Discard})
+	interp.RegisterLib("json", JSONLib)
+	_, err := interp.Eval(`json = require("json")`)
+	assert.Nil(t, err)
+
+	for src, msg := range map[string]string{
+		"{\n  \"a\": tru\n}": `expected a value but found 't' at line 2, column 8`,
+		`[1, 2`:              `expected , or ] but found the end of input at line 1, column 6`,
+		`{"a" 1}`:            `expected : but found '1' at line 1, column 6`,
+		`01`:                 `expected end of input but found '1' at line 1, column 2`,
+		`"\x"`:               `invalid escape \x at line 1, column 2`,
+		`"open`:              `unterminated string at line 1, column 6`,
+		``:                   `expected a value but found the end of input at line 1, column 1`,
+	} {
+		_, err := interp.Eval("json.decode(`" + src + "`)")
+		if rerr, ok := err.(runtime.RuntimeErr); assert.True(t, ok, "%v: %v", src, err) {
+			assert.Equal(t, "JSONError", rerr.Class(), src)
+			assert.Equal(t, msg, rerr.Message(), src)
+		}
+	}
+
+	val, err := interp.Eval(`do
+  json.decode("[\n\n  nope]")
+cleanup e = JSONError do
+  return "${e.line}:${e.column}"
+end`)
+	assert.Nil(t, err)
+	assert.Equal(t, "3:3", runtime.Print(interp.Scope(), val))
+
+	val, err = interp.Eval(`do
+  json.decode()
+cleanup e = ArgumentError do
+  return e
+end`)
+	assert.Nil(t, err)
+	if inst, ok := val.(*runtime.Instance); assert.True(t, ok) {
+		assert.Same(t, interp.Scope().Get("ArgumentError"), inst.Class())
+	}
+
+	for src, msg := range map[string]string{
+		"do\n  t = {}\n  t.me = t\n  json.encode(t)\nend": "cannot encode a table that contains itself",
+		`json.encode({print})`:                            "cannot encode Func as json",
+	} {
+		_, err := interp.Eval(src)
+		if rerr, ok := err.(runtime.RuntimeErr); assert.True(t, ok, "%v: %v", src, err) {
+			assert.Equal(t, "JSONError", rerr.Class(), src)
+			assert.Equal(t, msg, rerr.Message(), src)
+		}
+	}
+}
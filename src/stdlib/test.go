@@ -0,0 +1,285 @@
+package stdlib
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tanema/squirt/src/excerpt"
+	"github.com/tanema/squirt/src/runtime"
+)
+
+type (
+	// Tests runs the describe and it blocks of a test file as the file is
+	// evaluated and writes a line for every test that fails to Out. Give its
+	// Lib to the interpreter as the test lib, a fresh Tests for every file.
+	Tests struct {
+		Out     io.Writer
+		Run     *regexp.Regexp // only tests with a full name that matches run
+		Verbose bool           // write a line for tests that pass as well
+		Passed  int
+		Failed  int
+		Skipped int
+		names   []string
+		hooks   []testHooks // one for the file and one for each describe
+	}
+
+	testHooks struct {
+		before []runtime.Value
+		after  []runtime.Value
+	}
+)
+
+// NewTests creates Tests that write to out and only run the tests matching
+// run, every test runs if run is nil.
+func NewTests(out io.Writer, run *regexp.Regexp) *Tests {
+	return &Tests{Out: out, Run: run, hooks: []testHooks{{}}}
+}
+
+// Lib is the test lib, it has describe, it, before, after, the assert helpers
+// and the AssertionError class they raise.
+func (t *Tests) Lib(scope *runtime.Scope) (runtime.Value, error) {
+	assertErr := runtime.CreateClass("AssertionError", runtime.ErrorClass)
+	fail := func(s *runtime.Scope, format string, args ...interface{}) (runtime.Value, error) {
+		inst, err := assertErr.New(s, fmt.Sprintf(format, args...))
+		if err != nil {
+			return nil, err
+		}
+		return nil, inst
+	}
+	assert, err := runtime.ToValue(scope, map[string]runtime.Value{
+		"equal":     runtime.Fn("equal", assertEqual(fail, false)),
+		"deepEqual": runtime.Fn("deepEqual", assertEqual(fail, true)),
+		"raises":    runtime.Fn("raises", assertRaises(fail)),
+		"match":     runtime.Fn("match", assertMatch(fail)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return runtime.ToValue(scope, map[string]runtime.Value{
+		"describe":       runtime.Fn("describe", t.describe),
+		"it":             runtime.Fn("it", t.it),
+		"before":         runtime.Fn("before", t.hook(false)),
+		"after":          runtime.Fn("after", t.hook(true)),
+		"assert":         assert,
+		"AssertionError": assertErr,
+	})
+}
+
+// Error records an error raised outside of any test, such as a syntax error,
+// as a failure of the file.
+func (t *Tests) Error(path string, err error) {
+	t.Failed++
+	t.report("FAIL", path, 0, err)
+}
+
+func (t *Tests) describe(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	name, fn, err := nameAndFunc(s, "describe", args)
+	if err != nil {
+		return nil, err
+	}
+	t.names = append(t.names, name)
+	t.hooks = append(t.hooks, testHooks{})
+	defer func() {
+		t.names = t.names[:len(t.names)-1]
+		t.hooks = t.hooks[:len(t.hooks)-1]
+	}()
+	if _, err := runtime.Call(s, fn); err != nil {
+		t.Error(strings.Join(t.names, " > "), err)
+	}
+	return nil, nil
+}
+
+func (t *Tests) it(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+	name, fn, err := nameAndFunc(s, "it", args)
+	if err != nil {
+		return nil, err
+	}
+	full := strings.Join(append(append([]string{}, t.names...), name), " > ")
+	if t.Run != nil && !t.Run.MatchString(full) {
+		t.Skipped++
+		return nil, nil
+	}
+	start := time.Now()
+	for i := 0; i < len(t.hooks) && err == nil; i++ {
+		for _, hook := range t.hooks[i].before {
+			if _, err = runtime.Call(s, hook); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		_, err = runtime.Call(s, fn)
+	}
+	for i := len(t.hooks) - 1; i >= 0; i-- {
+		for _, hook := range t.hooks[i].after {
+			if _, afterErr := runtime.Call(s, hook); err == nil {
+				err = afterErr
+			}
+		}
+	}
+	if err != nil {
+		t.Failed++
+		t.report("FAIL", full, time.Since(start), err)
+	} else {
+		t.Passed++
+		if t.Verbose {
+			t.report("PASS", full, time.Since(start), nil)
+		}
+	}
+	return nil, nil
+}
+
+func (t *Tests) hook(after bool) runtime.FnSig {
+	return func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+		if len(args) == 0 {
+			return nil, argErr(s, "not enough arguments to hook")
+		}
+		hooks := &t.hooks[len(t.hooks)-1]
+		if after {
+			hooks.after = append(hooks.after, args[0])
+		} else {
+			hooks.before = append(hooks.before, args[0])
+		}
+		return nil, nil
+	}
+}
+
+// report writes the result of a test, a failure shows where the error was
+// raised.
+func (t *Tests) report(status, name string, took time.Duration, err error) {
+	if took > 0 {
+		fmt.Fprintf(t.Out, "--- %v: %v (%v)\n", status, name, took.Round(time.Microsecond))
+	} else {
+		fmt.Fprintf(t.Out, "--- %v: %v\n", status, name)
+	}
+	if err == nil {
+		return
+	}
+	rerr, ok := err.(runtime.RuntimeErr)
+	if !ok {
+		fmt.Fprintf(t.Out, "    %v\n", strings.Replace(strings.TrimSpace(err.Error()), "\n", "\n    ", -1))
+		return
+	}
+	file, pos := rerr.Position()
+	if code := excerpt.File(file, pos); code != "" {
+		fmt.Fprintf(t.Out, "    %v\n", strings.Replace(code, "\n", "\n    ", -1))
+	}
+	fmt.Fprintf(t.Out, "    %v:%v: %v: %v\n", file, pos[0], rerr.Class(), rerr.Message())
+}
+
+func nameAndFunc(s *runtime.Scope, fnName string, args []runtime.Value) (string, runtime.Value, error) {
+	if len(args) < 2 {
+		return "", nil, argErr(s, "not enough arguments to "+fnName)
+	}
+	return runtime.Print(s, args[0]), args[1], nil
+}
+
+func argErr(s *runtime.Scope, msg string) error {
+	return runtime.NewError(s, "ArgumentError", msg)
+}
+
+type failFn func(s *runtime.Scope, format string, args ...interface{}) (runtime.Value, error)
+
+func assertEqual(fail failFn, deep bool) runtime.FnSig {
+	return func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+		if len(args) < 2 {
+			return nil, argErr(s, "not enough arguments to assert")
+		}
+		equal, err := runtime.Equal(s, args[0], args[1])
+		if deep && err == nil && !equal {
+			equal, err = deepEqual(s, args[0], args[1])
+		}
+		if err != nil {
+			return nil, err
+		} else if !equal {
+			return fail(s, "expected %v to equal %v", inspect(s, args[0]), inspect(s, args[1]))
+		}
+		return nil, nil
+	}
+}
+
+// deepEqual compares tables and instances by their fields.
+func deepEqual(s *runtime.Scope, a, b runtime.Value) (bool, error) {
+	aInst, aOk := a.(*runtime.Instance)
+	bInst, bOk := b.(*runtime.Instance)
+	if !aOk || !bOk || aInst.Class() != bInst.Class() {
+		return runtime.Equal(s, a, b)
+	}
+	aNames, aVals := runtime.Fields(s, a)
+	bNames, bVals := runtime.Fields(s, b)
+	if aNames == nil && bNames == nil {
+		return runtime.Equal(s, a, b)
+	} else if !reflect.DeepEqual(aNames, bNames) {
+		return false, nil
+	}
+	for i := range aVals {
+		if equal, err := runtime.Equal(s, aVals[i], bVals[i]); err != nil || equal {
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+		if equal, err := deepEqual(s, aVals[i], bVals[i]); err != nil || !equal {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func assertRaises(fail failFn) runtime.FnSig {
+	return func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+		if len(args) < 2 {
+			return nil, argErr(s, "not enough arguments to raises")
+		}
+		class, ok := args[0].(*runtime.Class)
+		if !ok {
+			return nil, argErr(s, "raises needs an error class")
+		}
+		_, err := runtime.Call(s, args[1])
+		switch raised := err.(type) {
+		case nil:
+			return fail(s, "expected %v to be raised", class.Name())
+		case runtime.RuntimeErr:
+			if !raised.IsA(class.Name()) {
+				return fail(s, "expected %v to be raised but got %v: %v", class.Name(), raised.Class(), raised.Message())
+			}
+		case *runtime.Instance:
+			if !raised.IsA(class.Name()) {
+				return fail(s, "expected %v to be raised but got %v", class.Name(), runtime.Print(s, raised))
+			}
+		default:
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+func assertMatch(fail failFn) runtime.FnSig {
+	return func(s *runtime.Scope, self runtime.CVal, args []runtime.Value) (runtime.Value, error) {
+		if len(args) < 2 {
+			return nil, argErr(s, "not enough arguments to match")
+		}
+		pattern, err := regexp.Compile(runtime.Print(s, args[1]))
+		if err != nil {
+			return nil, argErr(s, err.Error())
+		}
+		if str := runtime.Print(s, args[0]); !pattern.MatchString(str) {
+			return fail(s, "expected %q to match %v", str, pattern)
+		}
+		return nil, nil
+	}
+}
+
+// inspect quotes strings so that a failure shows "1" apart from 1.
+func inspect(s *runtime.Scope, val runtime.Value) string {
+	if str, ok := val.(string); ok {
+		return fmt.Sprintf("%q", str)
+	} else if inst, ok := val.(*runtime.Instance); ok && inst.IsA("String") {
+		return fmt.Sprintf("%q", runtime.Print(s, val))
+	}
+	return runtime.Print(s, val)
+}
@@ -0,0 +1,81 @@
+package stdlib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/squirt/src/runtime"
+)
+
+const testSrc = `test = require("test")
+assert = test.assert
+calls = ""
+
+test.describe("math", func()
+  test.before(func() calls = "${calls}<" end)
+  test.after(func() calls = "${calls}>" end)
+  test.it("adds", func()
+    assert.equal(1 + 1, 2)
+  end)
+  test.it("fails", func()
+    assert.equal(1 + 1, 3)
+  end)
+end)
+
+test.it("deep", func()
+  assert.deepEqual({1, {a: 2}}, {1, {a: 2}})
+  assert.match("hello world", "^hel+o")
+  assert.raises(ArgumentError, func() spill(ArgumentError, "bad") end)
+end)
+test.it("deep fails", func()
+  assert.deepEqual({1, {a: 2}}, {1, {a: 3}})
+end)
+test.it("raises nothing", func()
+  assert.raises(ArgumentError, func() end)
+end)
+`
+
+func runTests(t *testing.T, engine runtime.Engine, run *regexp.Regexp) (*Tests, string, string) {
+	f, err := ioutil.TempFile("", "squirt*_test.sqrt")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(testSrc)
+	f.Close()
+
+	var out bytes.Buffer
+	tests := NewTests(&out, run)
+	interp := runtime.NewInterpreter(runtime.Options{Engine: engine, Stdout: ioutil.Discard})
+	interp.RegisterLib("test", tests.Lib)
+	_, err = interp.EvalFile(f.Name())
+	assert.Nil(t, err)
+	return tests, out.String(), runtime.Print(interp.Scope(), interp.Scope().Get("calls"))
+}
+
+func TestTests(t *testing.T) {
+	for _, engine := range []runtime.Engine{runtime.EngineVM, runtime.EngineTree} {
+		tests, out, calls := runTests(t, engine, nil)
+		assert.Equal(t, 2, tests.Passed)
+		assert.Equal(t, 3, tests.Failed)
+		assert.Equal(t, "<><>", calls)
+		assert.Contains(t, out, "--- FAIL: math > fails")
+		assert.Contains(t, out, "    assert.equal(1 + 1, 3)")
+		assert.Contains(t, out, ":12: AssertionError: expected 2 to equal 3")
+		assert.Contains(t, out, `expected {1, {a: 2}} to equal {1, {a: 3}}`)
+		assert.Contains(t, out, "expected ArgumentError to be raised")
+		assert.NotContains(t, out, "--- FAIL: deep (")
+	}
+}
+
+func TestTestsRun(t *testing.T) {
+	tests, out, calls := runTests(t, runtime.EngineVM, regexp.MustCompile("math > add"))
+	assert.Equal(t, 1, tests.Passed)
+	assert.Equal(t, 0, tests.Failed)
+	assert.Equal(t, 4, tests.Skipped)
+	assert.Equal(t, "<>", calls)
+	assert.Equal(t, "", out)
+}